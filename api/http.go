@@ -1,33 +1,109 @@
 package api
 
 import (
+	"bytes"
 	"errors"
+	"html/template"
 	"net/http"
+	"net/url"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/markbates/goth"
 
 	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/webauthn"
 )
 
 var (
 	ErrUnexpectedProvider = echo.NewHTTPError(http.StatusBadRequest, "unexpected provider")
 	ErrMissingCode        = echo.NewHTTPError(http.StatusBadRequest, "missing code")
+	ErrMissingToken       = echo.NewHTTPError(http.StatusBadRequest, OAuthError{Code: "invalid_request", Description: "missing token"})
+	ErrInvalidClient      = echo.NewHTTPError(http.StatusUnauthorized, OAuthError{Code: "invalid_client"})
+	ErrInvalidBearer      = echo.NewHTTPError(http.StatusUnauthorized, OAuthError{Code: "invalid_token"})
+	ErrUnsupportedGrant   = echo.NewHTTPError(http.StatusBadRequest, OAuthError{Code: "unsupported_grant_type"})
 )
 
+// linkCookie keeps the nonce binding an identity link to the browser which
+// started it.
+const linkCookie = "guard_link"
+
+// OAuthError is an error response defined by RFC 6749.
+type OAuthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+// TokenResponse is a successful access token response defined by RFC 6749.
+type TokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	IDToken      string `json:"id_token,omitempty"`
+}
+
+func newTokenResponse(token auth.Token) TokenResponse {
+	return TokenResponse{
+		AccessToken:  token.Access,
+		TokenType:    "Bearer",
+		ExpiresIn:    token.AccessExpires - token.IssuedAt,
+		RefreshToken: token.Refresh,
+		IDToken:      token.IDToken,
+	}
+}
+
 type HealthCheck = func() error
 
 type Factory interface {
 	auth.Factory
+	GetProvider(name string) (goth.Provider, error)
 	NewHealthCheck() HealthCheck
+	NewJWKS() auth.JWKS
+	NewDiscovery() auth.Discovery
 }
 
 func New(h *HttpAPI) *echo.Echo {
 	e := echo.New()
+	e.GET("/authorize", h.Authorize)
 	e.GET("/:provider/callback", h.Callback)
 	e.GET("/:provider", h.StartOAuth)
 	e.POST("/refresh", h.Refresh)
+	e.POST("/token", h.Token)
+	e.POST("/logout", h.Logout)
+	e.POST("/logout/all", h.LogoutAll)
+	e.POST("/revoke", h.Revoke)
+	e.POST("/introspect", h.Introspect)
+	e.POST("/signup", h.SignUp)
+	e.POST("/signin", h.SignIn)
+	e.POST("/password/change", h.ChangePassword)
+	e.POST("/password/forgot", h.ForgotPassword)
+	e.POST("/password/reset", h.ResetPassword)
+	e.POST("/magic/send", h.SendMagicLink)
+	e.POST("/magic/redeem", h.RedeemMagicLink)
+	e.POST("/mfa/enroll", h.EnrollMFA)
+	e.POST("/mfa/confirm", h.ConfirmMFA)
+	e.POST("/mfa/disable", h.DisableMFA)
+	e.POST("/mfa/verify", h.VerifyMFA)
+	e.POST("/mfa/passkey/begin", h.BeginPasskeyMFA)
+	e.POST("/mfa/passkey/finish", h.FinishPasskeyMFA)
+	e.GET("/passkeys", h.ListPasskeys)
+	e.DELETE("/passkeys/:id", h.DeletePasskey)
+	e.POST("/passkeys/register/begin", h.BeginPasskeyRegistration)
+	e.POST("/passkeys/register/finish", h.FinishPasskeyRegistration)
+	e.POST("/passkeys/signin/begin", h.BeginPasskeySignIn)
+	e.POST("/passkeys/signin/finish", h.FinishPasskeySignIn)
+	e.GET("/me", h.Me)
+	e.GET("/identities", h.ListIdentities)
+	e.POST("/identities/:provider", h.LinkIdentity)
+	e.DELETE("/identities/:provider", h.UnlinkIdentity)
+	e.POST("/identities/:provider/token", h.ProviderToken)
 	e.GET("/health", h.Health)
+	e.GET("/.well-known/jwks.json", h.JWKS)
+	e.GET("/.well-known/openid-configuration", h.Discovery)
+	e.GET("/userinfo", h.UserInfo)
+	e.POST("/userinfo", h.UserInfo)
 
 	e.HTTPErrorHandler = ErrorHandler
 	return e
@@ -49,7 +125,7 @@ func NewHttpAPI(factory Factory) *HttpAPI {
 }
 
 func (h *HttpAPI) Callback(c echo.Context) error {
-	provider, err := goth.GetProvider(c.Param("provider"))
+	provider, err := h.factory.GetProvider(c.Param("provider"))
 	if err != nil {
 		return ErrUnexpectedProvider
 	}
@@ -61,39 +137,764 @@ func (h *HttpAPI) Callback(c echo.Context) error {
 
 	params := c.Request().URL.Query()
 
-	token, err := h.factory.NewSignIner(provider).SignIn(state, params)
+	var nonce string
+	if cookie, err := c.Cookie(linkCookie); err == nil {
+		nonce = cookie.Value
+	}
+
+	result, err := h.factory.NewSignIner(provider).SignIn(state, nonce, params)
 	if err != nil {
+		if errors.Is(err, auth.ErrIdentityConflict) || errors.Is(err, auth.ErrIdentityLinked) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		if errors.Is(err, auth.ErrLinkNonce) {
+			return echo.NewHTTPError(http.StatusForbidden, err.Error())
+		}
 		return err
 	}
 
-	return c.JSON(http.StatusOK, token)
+	if result.Linked != nil {
+		setLinkCookie(c, "", -1)
+	}
+
+	return signInResponse(c, result)
+}
+
+// setLinkCookie sets the link nonce cookie, a negative max age removes it.
+func setLinkCookie(c echo.Context, nonce string, maxAge int) {
+	c.SetCookie(&http.Cookie{
+		Name:     linkCookie,
+		Value:    nonce,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   c.Scheme() == "https",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// MFAChallenge is the response of a sign in waiting for the second factor.
+type MFAChallenge struct {
+	Required bool   `json:"mfa_required"`
+	Token    string `json:"mfa_token"`
+}
+
+// signInResponse redirects the sign in started by a client, asks for the
+// second factor or returns the tokens. A provider flow started to link an
+// identity returns the identity.
+func signInResponse(c echo.Context, result auth.SignInResult) error {
+	if result.Linked != nil {
+		return c.JSON(http.StatusOK, result.Linked)
+	}
+
+	if result.Redirect != "" {
+		return c.Redirect(http.StatusFound, result.Redirect)
+	}
+
+	if result.Challenge != "" {
+		return c.JSON(http.StatusOK, MFAChallenge{Required: true, Token: result.Challenge})
+	}
+
+	return c.JSON(http.StatusOK, result.Token)
 }
 
 func (h *HttpAPI) Refresh(c echo.Context) error {
 	token := c.FormValue("refresh_token")
 
-	value, err := h.factory.NewRefresher().Refresh(token)
+	value, err := h.factory.NewRefresher().Refresh(auth.RefreshGrant{RefreshToken: token})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, value)
+}
+
+// Token is the RFC 6749 token endpoint. It exchanges authorization codes,
+// refresh tokens and client credentials.
+func (h *HttpAPI) Token(c echo.Context) error {
+	var token auth.Token
+	var err error
+
+	switch c.FormValue("grant_type") {
+	case "authorization_code":
+		clientID, secret := clientCredentials(c)
+		token, err = h.factory.NewCodeExchanger().Exchange(auth.CodeGrant{
+			Code:         c.FormValue("code"),
+			RedirectURI:  c.FormValue("redirect_uri"),
+			CodeVerifier: c.FormValue("code_verifier"),
+			ClientID:     clientID,
+			ClientSecret: secret,
+		})
+	case "refresh_token":
+		clientID, secret := clientCredentials(c)
+		token, err = h.factory.NewRefresher().Refresh(auth.RefreshGrant{
+			RefreshToken: c.FormValue("refresh_token"),
+			ClientID:     clientID,
+			ClientSecret: secret,
+		})
+	case "client_credentials":
+		clientID, secret := clientCredentials(c)
+		token, err = h.factory.NewCredentialsExchanger().Exchange(clientID, secret)
+	default:
+		return ErrUnsupportedGrant
+	}
+
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidClient) {
+			return ErrInvalidClient
+		}
+		if errors.As(err, &auth.Error{}) {
+			return echo.NewHTTPError(http.StatusBadRequest, OAuthError{
+				Code:        "invalid_grant",
+				Description: err.Error(),
+			})
+		}
+		return err
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, newTokenResponse(token))
+}
+
+func (h *HttpAPI) Logout(c echo.Context) error {
+	token := c.FormValue("refresh_token")
+
+	if err := h.factory.NewRevoker().Revoke(token); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *HttpAPI) LogoutAll(c echo.Context) error {
+	token := c.FormValue("refresh_token")
+
+	if err := h.factory.NewRevoker().RevokeAll(token); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Revoke implements RFC 7009 token revocation. Access tokens are short lived
+// and cannot be revoked, so the token is always treated as a refresh token.
+// Only the client the token was issued to revokes it, invalid tokens do not
+// cause an error response.
+func (h *HttpAPI) Revoke(c echo.Context) error {
+	token := c.FormValue("token")
+	if token == "" {
+		return ErrMissingToken
+	}
+
+	clientID, secret := clientCredentials(c)
+
+	err := h.factory.NewRevoker().RevokeClient(auth.RevokeGrant{
+		Token:        token,
+		ClientID:     clientID,
+		ClientSecret: secret,
+	})
+	switch {
+	case errors.Is(err, auth.ErrInvalidClient):
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="guard"`)
+		return ErrInvalidClient
+	case errors.Is(err, auth.ErrInvalidGrant):
+		return echo.NewHTTPError(http.StatusBadRequest, OAuthError{
+			Code:        "invalid_grant",
+			Description: err.Error(),
+		})
+	case err != nil && !errors.As(err, &auth.Error{}):
+		return err
+	}
+
+	return c.NoContent(http.StatusOK)
+}
+
+// Introspect implements RFC 7662 token introspection. The caller has to
+// authenticate with client credentials.
+func (h *HttpAPI) Introspect(c echo.Context) error {
+	if _, err := h.authenticateClient(c); err != nil {
+		return err
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return ErrMissingToken
+	}
+
+	value, err := h.factory.NewIntrospector().Introspect(token)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, value)
+}
+
+func (h *HttpAPI) authenticateClient(c echo.Context) (model.Client, error) {
+	clientID, secret := clientCredentials(c)
+
+	client, err := h.factory.NewClientAuthenticator().Authenticate(clientID, secret)
+	if err != nil {
+		if errors.As(err, &auth.Error{}) {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="guard"`)
+			return client, ErrInvalidClient
+		}
+		return client, err
+	}
+
+	return client, nil
+}
+
+// clientCredentials reads client credentials from the Authorization header or
+// from the request body as described in RFC 6749 section 2.3.1.
+func clientCredentials(c echo.Context) (string, string) {
+	clientID, secret, ok := c.Request().BasicAuth()
+	if !ok {
+		return c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+
+	if v, err := url.QueryUnescape(clientID); err == nil {
+		clientID = v
+	}
+
+	if v, err := url.QueryUnescape(secret); err == nil {
+		secret = v
+	}
+
+	return clientID, secret
+}
+
+func (h *HttpAPI) SignUp(c echo.Context) error {
+	email := c.FormValue("email")
+	password := c.FormValue("password")
+
+	token, err := h.factory.NewSignUper().SignUp(email, password)
+	if err != nil {
+		return passwordError(err)
+	}
+
+	return c.JSON(http.StatusOK, token)
+}
+
+func (h *HttpAPI) SignIn(c echo.Context) error {
+	email := c.FormValue("email")
+	password := c.FormValue("password")
+
+	result, err := h.factory.NewPasswordSignIner().SignIn(email, password)
+	if err != nil {
+		return err
+	}
+
+	return signInResponse(c, result)
+}
+
+func (h *HttpAPI) ChangePassword(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	password := c.FormValue("password")
+	newPassword := c.FormValue("new_password")
+
+	err = h.factory.NewPasswordChanger().ChangePassword(access, password, newPassword)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCredentials) || errors.Is(err, auth.ErrInvalidPassword) {
+			return passwordError(err)
+		}
+		if errors.As(err, &auth.Error{}) {
+			return invalidBearer(c)
+		}
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ForgotPassword mails a password reset link. The response does not tell
+// whether the user exists.
+func (h *HttpAPI) ForgotPassword(c echo.Context) error {
+	if err := h.factory.NewPasswordResetter().RequestReset(c.FormValue("email")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *HttpAPI) ResetPassword(c echo.Context) error {
+	token := c.FormValue("token")
+	password := c.FormValue("password")
+
+	if err := h.factory.NewPasswordResetter().Reset(token, password); err != nil {
+		return passwordError(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *HttpAPI) SendMagicLink(c echo.Context) error {
+	if err := h.factory.NewMagicLinker().SendLink(c.FormValue("email")); err != nil {
+		return passwordError(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *HttpAPI) RedeemMagicLink(c echo.Context) error {
+	result, err := h.factory.NewMagicLinker().Redeem(c.FormValue("token"))
+	if err != nil {
+		return passwordError(err)
+	}
+
+	return signInResponse(c, result)
+}
+
+func (h *HttpAPI) EnrollMFA(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	value, err := h.factory.NewMFAEnroller().Enroll(access)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, value)
+}
+
+func (h *HttpAPI) ConfirmMFA(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.factory.NewMFAEnroller().Confirm(access, c.FormValue("code")); err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *HttpAPI) DisableMFA(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.factory.NewMFAEnroller().Disable(access, c.FormValue("code")); err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// VerifyMFA completes the sign in waiting for the second factor. A sign in
+// started by a client gets the redirect with the authorization code in the
+// response body, as the request is sent by the MFA page.
+func (h *HttpAPI) VerifyMFA(c echo.Context) error {
+	token := c.FormValue("mfa_token")
+	code := c.FormValue("code")
+
+	result, err := h.factory.NewMFACompleter().Complete(token, code)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return mfaResponse(c, result)
+}
+
+func mfaResponse(c echo.Context, result auth.SignInResult) error {
+	if result.Redirect != "" {
+		return c.JSON(http.StatusOK, map[string]string{"redirect": result.Redirect})
+	}
+
+	return c.JSON(http.StatusOK, result.Token)
+}
+
+func (h *HttpAPI) BeginPasskeyMFA(c echo.Context) error {
+	options, err := h.factory.NewPasskeyCompleter().Begin(c.FormValue("mfa_token"))
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeyMFA completes the sign in waiting for the second factor with
+// the passkey assertion, the request body is the credential JSON.
+func (h *HttpAPI) FinishPasskeyMFA(c echo.Context) error {
+	var resp webauthn.AuthenticationResponse
+	if err := c.Bind(&resp); err != nil {
+		return err
+	}
+
+	result, err := h.factory.NewPasskeyCompleter().Complete(resp)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return mfaResponse(c, result)
+}
+
+func (h *HttpAPI) ListPasskeys(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	items, err := h.factory.NewPasskeyRegistrar().List(access)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, items)
+}
+
+func (h *HttpAPI) DeletePasskey(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.factory.NewPasskeyRegistrar().Delete(access, c.Param("id")); err != nil {
+		if errors.Is(err, auth.ErrInvalidPasskey) {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		return mfaError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *HttpAPI) BeginPasskeyRegistration(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	options, err := h.factory.NewPasskeyRegistrar().Begin(access)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeyRegistration stores the new passkey, the request body is the
+// credential JSON.
+func (h *HttpAPI) FinishPasskeyRegistration(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	var resp webauthn.RegistrationResponse
+	if err := c.Bind(&resp); err != nil {
+		return err
+	}
+
+	value, err := h.factory.NewPasskeyRegistrar().Finish(access, resp)
+	if err != nil {
+		return mfaError(c, err)
+	}
+
+	return c.JSON(http.StatusCreated, value)
+}
+
+func (h *HttpAPI) BeginPasskeySignIn(c echo.Context) error {
+	options, err := h.factory.NewPasskeySignIner().Begin()
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
+// FinishPasskeySignIn signs the user in with the passkey assertion, the
+// request body is the credential JSON.
+func (h *HttpAPI) FinishPasskeySignIn(c echo.Context) error {
+	var resp webauthn.AuthenticationResponse
+	if err := c.Bind(&resp); err != nil {
+		return err
+	}
+
+	result, err := h.factory.NewPasskeySignIner().Finish(resp)
+	if err != nil {
+		return err
+	}
+
+	return signInResponse(c, result)
+}
+
+// mfaError maps the two factor authentication and passkey errors to the
+// client errors, other auth errors mean an invalid bearer token.
+func mfaError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrMFADisabled):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.ErrMFAEnabled),
+		errors.Is(err, auth.ErrMFANotEnrolled),
+		errors.Is(err, auth.ErrPasskeyExists):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, auth.ErrInvalidOTP),
+		errors.Is(err, auth.ErrInvalidMFA),
+		errors.Is(err, auth.ErrInvalidPasskey),
+		errors.Is(err, auth.ErrPasskeyCloned),
+		errors.Is(err, auth.ErrSessionExpired):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.As(err, &auth.Error{}):
+		return invalidBearer(c)
+	}
+	return err
+}
+
+// passwordError maps validation errors of the password and magic link flows
+// to the client errors, other errors are handled by ErrorHandler.
+func passwordError(err error) error {
+	switch {
+	case errors.Is(err, auth.ErrUserExists):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, auth.ErrInvalidEmail),
+		errors.Is(err, auth.ErrInvalidPassword),
+		errors.Is(err, auth.ErrInvalidResetToken),
+		errors.Is(err, auth.ErrInvalidLinkToken),
+		errors.Is(err, auth.ErrSessionExpired):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return err
+}
+
+// Me returns the signed in user together with the profiles given by the
+// providers.
+func (h *HttpAPI) Me(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	value, err := h.factory.NewProfileReader().Read(access)
+	if err != nil {
+		if errors.As(err, &auth.Error{}) {
+			return invalidBearer(c)
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, value)
+}
+
+func (h *HttpAPI) ListIdentities(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	items, err := h.factory.NewIdentityManager().List(access)
+	if err != nil {
+		return identityError(c, err)
+	}
+
+	return c.JSON(http.StatusOK, items)
+}
+
+// LinkIdentity starts the provider sign in linking the provider identity to
+// the access token owner. The provider URL is returned instead of redirecting
+// as the browser does not send the bearer token on navigation. The link is
+// bound to the browser by a cookie checked by the provider callback.
+func (h *HttpAPI) LinkIdentity(c echo.Context) error {
+	provider, err := h.factory.GetProvider(c.Param("provider"))
+	if err != nil {
+		return ErrUnexpectedProvider
+	}
+
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	link, err := h.factory.NewOAuthStarter(provider).StartLink(access)
+	if err != nil {
+		return identityError(c, err)
+	}
+
+	setLinkCookie(c, link.Nonce, 0)
+	return c.JSON(http.StatusOK, map[string]string{"url": link.URL})
+}
+
+func (h *HttpAPI) UnlinkIdentity(c echo.Context) error {
+	access, err := requireBearer(c)
 	if err != nil {
 		return err
 	}
 
+	if err := h.factory.NewIdentityManager().Unlink(access, c.Param("provider")); err != nil {
+		return identityError(c, err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ProviderToken gives a confidential client the current provider access token
+// of the owner of the access token passed in the token parameter.
+func (h *HttpAPI) ProviderToken(c echo.Context) error {
+	provider, err := h.factory.GetProvider(c.Param("provider"))
+	if err != nil {
+		return ErrUnexpectedProvider
+	}
+
+	client, err := h.authenticateClient(c)
+	if err != nil {
+		return err
+	}
+
+	token := c.FormValue("token")
+	if token == "" {
+		return ErrMissingToken
+	}
+
+	value, err := h.factory.NewProviderTokener(provider).Token(token, client)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrProviderTokensDisabled), errors.Is(err, auth.ErrProviderTokenNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		case errors.Is(err, auth.ErrProviderTokenExpired):
+			return echo.NewHTTPError(http.StatusConflict, auth.ErrProviderTokenExpired.Error())
+		case errors.As(err, &auth.Error{}):
+			return echo.NewHTTPError(http.StatusBadRequest, OAuthError{
+				Code:        "invalid_grant",
+				Description: err.Error(),
+			})
+		}
+		return err
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
 	return c.JSON(http.StatusOK, value)
 }
 
+// identityError maps the identity errors to the client errors, other auth
+// errors mean an invalid bearer token.
+func identityError(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, auth.ErrIdentityNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.ErrLastIdentity):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.As(err, &auth.Error{}):
+		return invalidBearer(c)
+	}
+	return err
+}
+
+// authRequest reads the client authorization request from the query.
+func authRequest(c echo.Context) auth.AuthRequest {
+	return auth.AuthRequest{
+		ClientID:            c.QueryParam("client_id"),
+		RedirectURI:         c.QueryParam("redirect_uri"),
+		State:               c.QueryParam("state"),
+		Nonce:               c.QueryParam("nonce"),
+		CodeChallenge:       c.QueryParam("code_challenge"),
+		CodeChallengeMethod: c.QueryParam("code_challenge_method"),
+	}
+}
+
+func invalidRequest(err error) error {
+	return echo.NewHTTPError(http.StatusBadRequest, OAuthError{
+		Code:        "invalid_request",
+		Description: err.Error(),
+	})
+}
+
 func (h *HttpAPI) StartOAuth(c echo.Context) error {
-	provider, err := goth.GetProvider(c.Param("provider"))
+	provider, err := h.factory.GetProvider(c.Param("provider"))
 	if err != nil {
 		return ErrUnexpectedProvider
 	}
 
-	url, err := h.factory.NewOAuthStarter(provider).StartOAuth()
+	req := authRequest(c)
+	return h.startOAuth(c, provider, &req)
+}
+
+func (h *HttpAPI) startOAuth(c echo.Context, provider goth.Provider, req *auth.AuthRequest) error {
+	url, err := h.factory.NewOAuthStarter(provider).StartOAuth(req)
 	if err != nil {
+		if errors.As(err, &auth.Error{}) {
+			return invalidRequest(err)
+		}
 		return err
 	}
 
 	return c.Redirect(http.StatusTemporaryRedirect, url)
 }
 
+var providersPage = template.Must(template.New("providers").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign in</title></head>
+<body>
+<ul>
+{{- range .}}
+<li><a href="{{.URL}}">{{.Name}}</a></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+type providerLink struct {
+	Name string
+	URL  string
+}
+
+// Authorize is the OpenID Connect authorization endpoint. The provider is
+// given by the provider parameter or picked when the client may use a single
+// provider only, otherwise the page listing the providers is returned.
+func (h *HttpAPI) Authorize(c echo.Context) error {
+	if c.QueryParam("response_type") != "code" {
+		return echo.NewHTTPError(http.StatusBadRequest, OAuthError{Code: "unsupported_response_type"})
+	}
+
+	req := authRequest(c)
+
+	name := c.QueryParam("provider")
+	if name == "" {
+		names, err := h.factory.NewAuthorizer().Providers(req)
+		if err != nil {
+			if errors.As(err, &auth.Error{}) {
+				return invalidRequest(err)
+			}
+			return err
+		}
+
+		switch len(names) {
+		case 0:
+			return invalidRequest(auth.ErrProviderNotAllowed)
+		case 1:
+			name = names[0]
+		default:
+			links := make([]providerLink, 0, len(names))
+			for _, name := range names {
+				query, _ := url.ParseQuery(c.QueryString())
+				query.Set("provider", name)
+				links = append(links, providerLink{Name: name, URL: "?" + query.Encode()})
+			}
+
+			buf := &bytes.Buffer{}
+			if err := providersPage.Execute(buf, links); err != nil {
+				return err
+			}
+			return c.HTMLBlob(http.StatusOK, buf.Bytes())
+		}
+	}
+
+	provider, err := h.factory.GetProvider(name)
+	if err != nil {
+		return ErrUnexpectedProvider
+	}
+
+	return h.startOAuth(c, provider, &req)
+}
+
 func (h *HttpAPI) Health(c echo.Context) error {
 	hc := h.factory.NewHealthCheck()
 	if err := hc(); err != nil {
@@ -101,3 +902,57 @@ func (h *HttpAPI) Health(c echo.Context) error {
 	}
 	return c.NoContent(http.StatusOK)
 }
+
+func (h *HttpAPI) JWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.factory.NewJWKS())
+}
+
+func (h *HttpAPI) Discovery(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.factory.NewDiscovery())
+}
+
+func (h *HttpAPI) UserInfo(c echo.Context) error {
+	access, err := requireBearer(c)
+	if err != nil {
+		return err
+	}
+
+	value, err := h.factory.NewUserInfoer().UserInfo(access)
+	if err != nil {
+		if errors.As(err, &auth.Error{}) {
+			return invalidBearer(c)
+		}
+		return err
+	}
+
+	return c.JSON(http.StatusOK, value)
+}
+
+// requireBearer returns the bearer token of the request, a request without
+// the token is asked to authenticate.
+func requireBearer(c echo.Context) (string, error) {
+	access, ok := bearerToken(c)
+	if !ok {
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="guard"`)
+		return "", ErrInvalidBearer
+	}
+	return access, nil
+}
+
+// invalidBearer rejects the request with a bearer token failed to verify.
+func invalidBearer(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="guard", error="invalid_token"`)
+	return ErrInvalidBearer
+}
+
+// bearerToken reads the RFC 6750 bearer token from the Authorization header.
+func bearerToken(c echo.Context) (string, bool) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+
+	const prefix = "bearer "
+	if len(header) <= len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[len(prefix):]), true
+}
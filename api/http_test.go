@@ -1,8 +1,11 @@
 package api_test
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"html/template"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -10,12 +13,16 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/github"
 	"github.com/markbates/goth/providers/google"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 
 	"github.com/vbogretsov/guard/api"
 	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/profile"
+	"github.com/vbogretsov/guard/webauthn"
 )
 
 type factoryMock struct {
@@ -30,6 +37,98 @@ func (m *factoryMock) NewRefresher() auth.Refresher {
 	return m.Called().Get(0).(auth.Refresher)
 }
 
+func (m *factoryMock) NewCodeExchanger() auth.CodeExchanger {
+	return m.Called().Get(0).(auth.CodeExchanger)
+}
+
+func (m *factoryMock) NewCredentialsExchanger() auth.CredentialsExchanger {
+	return m.Called().Get(0).(auth.CredentialsExchanger)
+}
+
+func (m *factoryMock) NewSignUper() auth.SignUper {
+	return m.Called().Get(0).(auth.SignUper)
+}
+
+func (m *factoryMock) NewPasswordSignIner() auth.PasswordSignIner {
+	return m.Called().Get(0).(auth.PasswordSignIner)
+}
+
+func (m *factoryMock) NewPasswordChanger() auth.PasswordChanger {
+	return m.Called().Get(0).(auth.PasswordChanger)
+}
+
+func (m *factoryMock) NewPasswordResetter() auth.PasswordResetter {
+	return m.Called().Get(0).(auth.PasswordResetter)
+}
+
+func (m *factoryMock) NewMagicLinker() auth.MagicLinker {
+	return m.Called().Get(0).(auth.MagicLinker)
+}
+
+func (m *factoryMock) NewMFAEnroller() auth.MFAEnroller {
+	return m.Called().Get(0).(auth.MFAEnroller)
+}
+
+func (m *factoryMock) NewMFACompleter() auth.MFACompleter {
+	return m.Called().Get(0).(auth.MFACompleter)
+}
+
+func (m *factoryMock) NewPasskeyRegistrar() auth.PasskeyRegistrar {
+	return m.Called().Get(0).(auth.PasskeyRegistrar)
+}
+
+func (m *factoryMock) NewPasskeySignIner() auth.PasskeySignIner {
+	return m.Called().Get(0).(auth.PasskeySignIner)
+}
+
+func (m *factoryMock) NewPasskeyCompleter() auth.PasskeyCompleter {
+	return m.Called().Get(0).(auth.PasskeyCompleter)
+}
+
+func (m *factoryMock) NewIdentityManager() auth.IdentityManager {
+	return m.Called().Get(0).(auth.IdentityManager)
+}
+
+func (m *factoryMock) NewProviderTokener(provider goth.Provider) auth.ProviderTokener {
+	return m.Called(provider).Get(0).(auth.ProviderTokener)
+}
+
+func (m *factoryMock) NewProfileReader() auth.ProfileReader {
+	return m.Called().Get(0).(auth.ProfileReader)
+}
+
+func (m *factoryMock) NewRevoker() auth.Revoker {
+	return m.Called().Get(0).(auth.Revoker)
+}
+
+func (m *factoryMock) NewSweeper() auth.Sweeper {
+	return m.Called().Get(0).(auth.Sweeper)
+}
+
+func (m *factoryMock) NewDeliverer() profile.Deliverer {
+	return m.Called().Get(0).(profile.Deliverer)
+}
+
+func (m *factoryMock) NewIntrospector() auth.Introspector {
+	return m.Called().Get(0).(auth.Introspector)
+}
+
+func (m *factoryMock) NewClientAuthenticator() auth.ClientAuthenticator {
+	return m.Called().Get(0).(auth.ClientAuthenticator)
+}
+
+func (m *factoryMock) NewDiscovery() auth.Discovery {
+	return m.Called().Get(0).(auth.Discovery)
+}
+
+func (m *factoryMock) NewUserInfoer() auth.UserInfoer {
+	return m.Called().Get(0).(auth.UserInfoer)
+}
+
+func (m *factoryMock) NewAuthorizer() auth.Authorizer {
+	return m.Called().Get(0).(auth.Authorizer)
+}
+
 func (m *factoryMock) NewOAuthStarter(provider goth.Provider) auth.OAuthStarter {
 	return m.Called(provider).Get(0).(auth.OAuthStarter)
 }
@@ -38,27 +137,36 @@ func (m *factoryMock) NewHealthCheck() api.HealthCheck {
 	return m.Called().Get(0).(api.HealthCheck)
 }
 
+// GetProvider returns the providers registered in goth by the tests.
+func (m *factoryMock) GetProvider(name string) (goth.Provider, error) {
+	return goth.GetProvider(name)
+}
+
+func (m *factoryMock) NewJWKS() auth.JWKS {
+	return m.Called().Get(0).(auth.JWKS)
+}
+
 type signinerMock struct {
 	mock.Mock
 }
 
-func (m *signinerMock) SignIn(code string, params goth.Params) (auth.Token, error) {
-	args := m.Called(code, params)
+func (m *signinerMock) SignIn(code, nonce string, params goth.Params) (auth.SignInResult, error) {
+	args := m.Called(code, nonce, params)
 
 	v := args.Get(0)
 	if v == nil {
-		return auth.Token{}, args.Error(1)
+		return auth.SignInResult{}, args.Error(1)
 	}
 
-	return v.(auth.Token), args.Error(1)
+	return v.(auth.SignInResult), args.Error(1)
 }
 
 type refresherMock struct {
 	mock.Mock
 }
 
-func (m *refresherMock) Refresh(token string) (auth.Token, error) {
-	args := m.Called(token)
+func (m *refresherMock) Refresh(grant auth.RefreshGrant) (auth.Token, error) {
+	args := m.Called(grant)
 
 	v := args.Get(0)
 	if v == nil {
@@ -68,22 +176,294 @@ func (m *refresherMock) Refresh(token string) (auth.Token, error) {
 	return v.(auth.Token), args.Error(1)
 }
 
+type revokerMock struct {
+	mock.Mock
+}
+
+func (m *revokerMock) Revoke(token string) error {
+	return m.Called(token).Error(0)
+}
+
+func (m *revokerMock) RevokeAll(token string) error {
+	return m.Called(token).Error(0)
+}
+
+func (m *revokerMock) RevokeClient(grant auth.RevokeGrant) error {
+	return m.Called(grant).Error(0)
+}
+
+type introspectorMock struct {
+	mock.Mock
+}
+
+func (m *introspectorMock) Introspect(token string) (auth.Introspection, error) {
+	args := m.Called(token)
+	return args.Get(0).(auth.Introspection), args.Error(1)
+}
+
+type clientAuthenticatorMock struct {
+	mock.Mock
+}
+
+func (m *clientAuthenticatorMock) Authenticate(clientID, secret string) (model.Client, error) {
+	args := m.Called(clientID, secret)
+	return args.Get(0).(model.Client), args.Error(1)
+}
+
+type userInfoerMock struct {
+	mock.Mock
+}
+
+func (m *userInfoerMock) UserInfo(access string) (auth.UserInfo, error) {
+	args := m.Called(access)
+	return args.Get(0).(auth.UserInfo), args.Error(1)
+}
+
+type authorizerMock struct {
+	mock.Mock
+}
+
+func (m *authorizerMock) Providers(req auth.AuthRequest) ([]string, error) {
+	args := m.Called(req)
+
+	v := args.Get(0)
+	if v == nil {
+		return nil, args.Error(1)
+	}
+
+	return v.([]string), args.Error(1)
+}
+
 type oauthStarterMock struct {
 	mock.Mock
 }
 
-func (m *oauthStarterMock) StartOAuth() (string, error) {
-	args := m.Called()
+func (m *oauthStarterMock) StartOAuth(req *auth.AuthRequest) (string, error) {
+	args := m.Called(req)
 	return args.String(0), args.Error(1)
 }
 
+func (m *oauthStarterMock) StartLink(access string) (auth.LinkStart, error) {
+	args := m.Called(access)
+	return args.Get(0).(auth.LinkStart), args.Error(1)
+}
+
+type providerTokenerMock struct {
+	mock.Mock
+}
+
+func (m *providerTokenerMock) Token(access string, client model.Client) (auth.ProviderToken, error) {
+	args := m.Called(access, client)
+	return args.Get(0).(auth.ProviderToken), args.Error(1)
+}
+
+type profileReaderMock struct {
+	mock.Mock
+}
+
+func (m *profileReaderMock) Read(access string) (auth.Profile, error) {
+	args := m.Called(access)
+	return args.Get(0).(auth.Profile), args.Error(1)
+}
+
+type identityManagerMock struct {
+	mock.Mock
+}
+
+func (m *identityManagerMock) List(access string) ([]auth.IdentityInfo, error) {
+	args := m.Called(access)
+
+	value := args.Get(0)
+	if value == nil {
+		return nil, args.Error(1)
+	}
+
+	return value.([]auth.IdentityInfo), args.Error(1)
+}
+
+func (m *identityManagerMock) Unlink(access, provider string) error {
+	return m.Called(access, provider).Error(0)
+}
+
+type codeExchangerMock struct {
+	mock.Mock
+}
+
+func (m *codeExchangerMock) Exchange(grant auth.CodeGrant) (auth.Token, error) {
+	args := m.Called(grant)
+	return args.Get(0).(auth.Token), args.Error(1)
+}
+
+type credentialsExchangerMock struct {
+	mock.Mock
+}
+
+func (m *credentialsExchangerMock) Exchange(clientID, secret string) (auth.Token, error) {
+	args := m.Called(clientID, secret)
+	return args.Get(0).(auth.Token), args.Error(1)
+}
+
+type signUperMock struct {
+	mock.Mock
+}
+
+func (m *signUperMock) SignUp(email, password string) (auth.Token, error) {
+	args := m.Called(email, password)
+	return args.Get(0).(auth.Token), args.Error(1)
+}
+
+type passwordSignInerMock struct {
+	mock.Mock
+}
+
+func (m *passwordSignInerMock) SignIn(email, password string) (auth.SignInResult, error) {
+	args := m.Called(email, password)
+	return args.Get(0).(auth.SignInResult), args.Error(1)
+}
+
+type passwordChangerMock struct {
+	mock.Mock
+}
+
+func (m *passwordChangerMock) ChangePassword(access, password, newPassword string) error {
+	return m.Called(access, password, newPassword).Error(0)
+}
+
+type passwordResetterMock struct {
+	mock.Mock
+}
+
+func (m *passwordResetterMock) RequestReset(email string) error {
+	return m.Called(email).Error(0)
+}
+
+func (m *passwordResetterMock) Reset(token, password string) error {
+	return m.Called(token, password).Error(0)
+}
+
+type magicLinkerMock struct {
+	mock.Mock
+}
+
+func (m *magicLinkerMock) SendLink(email string) error {
+	return m.Called(email).Error(0)
+}
+
+func (m *magicLinkerMock) Redeem(token string) (auth.SignInResult, error) {
+	args := m.Called(token)
+	return args.Get(0).(auth.SignInResult), args.Error(1)
+}
+
+type mfaEnrollerMock struct {
+	mock.Mock
+}
+
+func (m *mfaEnrollerMock) Enroll(access string) (auth.Enrollment, error) {
+	args := m.Called(access)
+	return args.Get(0).(auth.Enrollment), args.Error(1)
+}
+
+func (m *mfaEnrollerMock) Confirm(access, code string) error {
+	return m.Called(access, code).Error(0)
+}
+
+func (m *mfaEnrollerMock) Disable(access, code string) error {
+	return m.Called(access, code).Error(0)
+}
+
+type mfaCompleterMock struct {
+	mock.Mock
+}
+
+func (m *mfaCompleterMock) Complete(challenge, code string) (auth.SignInResult, error) {
+	args := m.Called(challenge, code)
+	return args.Get(0).(auth.SignInResult), args.Error(1)
+}
+
+type passkeyRegistrarMock struct {
+	mock.Mock
+}
+
+func (m *passkeyRegistrarMock) Begin(access string) (webauthn.CreationOptions, error) {
+	args := m.Called(access)
+	return args.Get(0).(webauthn.CreationOptions), args.Error(1)
+}
+
+func (m *passkeyRegistrarMock) Finish(access string, resp webauthn.RegistrationResponse) (auth.PasskeyInfo, error) {
+	args := m.Called(access, resp)
+	return args.Get(0).(auth.PasskeyInfo), args.Error(1)
+}
+
+func (m *passkeyRegistrarMock) List(access string) ([]auth.PasskeyInfo, error) {
+	args := m.Called(access)
+
+	value := args.Get(0)
+	if value == nil {
+		return nil, args.Error(1)
+	}
+
+	return value.([]auth.PasskeyInfo), args.Error(1)
+}
+
+func (m *passkeyRegistrarMock) Delete(access, id string) error {
+	return m.Called(access, id).Error(0)
+}
+
+type passkeySignInerMock struct {
+	mock.Mock
+}
+
+func (m *passkeySignInerMock) Begin() (webauthn.RequestOptions, error) {
+	args := m.Called()
+	return args.Get(0).(webauthn.RequestOptions), args.Error(1)
+}
+
+func (m *passkeySignInerMock) Finish(resp webauthn.AuthenticationResponse) (auth.SignInResult, error) {
+	args := m.Called(resp)
+	return args.Get(0).(auth.SignInResult), args.Error(1)
+}
+
+type passkeyCompleterMock struct {
+	mock.Mock
+}
+
+func (m *passkeyCompleterMock) Begin(challenge string) (webauthn.RequestOptions, error) {
+	args := m.Called(challenge)
+	return args.Get(0).(webauthn.RequestOptions), args.Error(1)
+}
+
+func (m *passkeyCompleterMock) Complete(resp webauthn.AuthenticationResponse) (auth.SignInResult, error) {
+	args := m.Called(resp)
+	return args.Get(0).(auth.SignInResult), args.Error(1)
+}
+
 type context struct {
 	e            *echo.Echo
 	c            echo.Context
 	factory      *factoryMock
 	signiner     *signinerMock
 	refresher    *refresherMock
+	revoker      *revokerMock
+	introspector *introspectorMock
+	clients      *clientAuthenticatorMock
+	userInfoer   *userInfoerMock
+	exchanger    *codeExchangerMock
+	credentials  *credentialsExchangerMock
+	signUper     *signUperMock
+	passwords    *passwordSignInerMock
+	changer      *passwordChangerMock
+	resetter     *passwordResetterMock
+	linker       *magicLinkerMock
+	enroller     *mfaEnrollerMock
+	completer    *mfaCompleterMock
+	registrar    *passkeyRegistrarMock
+	passkeys     *passkeySignInerMock
+	pkCompleter  *passkeyCompleterMock
+	identities   *identityManagerMock
+	profiles     *profileReaderMock
+	ptokens      *providerTokenerMock
 	oauthStarter *oauthStarterMock
+	authorizer   *authorizerMock
 	handler      *api.HttpAPI
 	req          *http.Request
 	rec          *httptest.ResponseRecorder
@@ -93,13 +473,53 @@ func newctx(path string) *context {
 	factory := &factoryMock{}
 	signiner := &signinerMock{}
 	refresher := &refresherMock{}
+	revoker := &revokerMock{}
+	introspector := &introspectorMock{}
+	clients := &clientAuthenticatorMock{}
+	userInfoer := &userInfoerMock{}
+	exchanger := &codeExchangerMock{}
+	credentials := &credentialsExchangerMock{}
+	signUper := &signUperMock{}
+	passwords := &passwordSignInerMock{}
+	changer := &passwordChangerMock{}
+	resetter := &passwordResetterMock{}
+	linker := &magicLinkerMock{}
+	enroller := &mfaEnrollerMock{}
+	completer := &mfaCompleterMock{}
+	registrar := &passkeyRegistrarMock{}
+	passkeys := &passkeySignInerMock{}
+	pkCompleter := &passkeyCompleterMock{}
+	identities := &identityManagerMock{}
+	profiles := &profileReaderMock{}
+	ptokens := &providerTokenerMock{}
 	oauthStarter := &oauthStarterMock{}
+	authorizer := &authorizerMock{}
 
 	handler := api.NewHttpAPI(factory)
 
 	factory.On("NewSignIner", mock.Anything).Return(signiner)
 	factory.On("NewRefresher", mock.Anything).Return(refresher)
+	factory.On("NewRevoker", mock.Anything).Return(revoker)
+	factory.On("NewIntrospector").Return(introspector)
+	factory.On("NewClientAuthenticator").Return(clients)
+	factory.On("NewUserInfoer").Return(userInfoer)
+	factory.On("NewCodeExchanger").Return(exchanger)
+	factory.On("NewCredentialsExchanger").Return(credentials)
+	factory.On("NewSignUper").Return(signUper)
+	factory.On("NewPasswordSignIner").Return(passwords)
+	factory.On("NewPasswordChanger").Return(changer)
+	factory.On("NewPasswordResetter").Return(resetter)
+	factory.On("NewMagicLinker").Return(linker)
+	factory.On("NewMFAEnroller").Return(enroller)
+	factory.On("NewMFACompleter").Return(completer)
+	factory.On("NewPasskeyRegistrar").Return(registrar)
+	factory.On("NewPasskeySignIner").Return(passkeys)
+	factory.On("NewPasskeyCompleter").Return(pkCompleter)
+	factory.On("NewIdentityManager").Return(identities)
+	factory.On("NewProfileReader").Return(profiles)
+	factory.On("NewProviderTokener", mock.Anything).Return(ptokens)
 	factory.On("NewOAuthStarter", mock.Anything).Return(oauthStarter)
+	factory.On("NewAuthorizer").Return(authorizer)
 
 	e := api.New(handler)
 
@@ -115,7 +535,27 @@ func newctx(path string) *context {
 		factory:      factory,
 		signiner:     signiner,
 		refresher:    refresher,
+		revoker:      revoker,
+		introspector: introspector,
+		clients:      clients,
+		userInfoer:   userInfoer,
+		exchanger:    exchanger,
+		credentials:  credentials,
+		signUper:     signUper,
+		passwords:    passwords,
+		changer:      changer,
+		resetter:     resetter,
+		linker:       linker,
+		enroller:     enroller,
+		completer:    completer,
+		registrar:    registrar,
+		passkeys:     passkeys,
+		pkCompleter:  pkCompleter,
+		identities:   identities,
+		profiles:     profiles,
+		ptokens:      ptokens,
 		oauthStarter: oauthStarter,
+		authorizer:   authorizer,
 		handler:      handler,
 		req:          req,
 		rec:          rec,
@@ -152,18 +592,62 @@ func TestErrorhandler(t *testing.T) {
 func TestHttpStartOAuth(t *testing.T) {
 	goth.UseProviders(google.New("google_id", "google_secret", "http://localhost:8000/google/callback"))
 
-	t.Run("Success", func(t *testing.T) {
-		ctx := newctx("/:provider")
+	t.Run("AuthRequest", func(t *testing.T) {
+		q := make(url.Values)
+		q.Set("client_id", "client.123")
+		q.Set("redirect_uri", "http://app.local/callback")
+		q.Set("state", "state.123")
+		q.Set("code_challenge", "challenge.123")
+		q.Set("code_challenge_method", "S256")
+
+		ctx := newctx("/:provider?" + q.Encode())
 		ctx.c.SetParamNames("provider")
 		ctx.c.SetParamValues("google")
 
-		redirectURL := "redirectURL"
-		ctx.oauthStarter.On("StartOAuth").Return(redirectURL, nil)
+		req := &auth.AuthRequest{
+			ClientID:            "client.123",
+			RedirectURI:         "http://app.local/callback",
+			State:               "state.123",
+			CodeChallenge:       "challenge.123",
+			CodeChallengeMethod: "S256",
+		}
+		ctx.oauthStarter.On("StartOAuth", req).Return("redirectURL", nil)
 
 		err := ctx.handler.StartOAuth(ctx.c)
 		require.NoError(t, err)
 		require.Equal(t, http.StatusTemporaryRedirect, ctx.rec.Code)
-		require.Equal(t, redirectURL, ctx.rec.Result().Header["Location"][0])
+		require.Equal(t, "redirectURL", ctx.rec.Result().Header["Location"][0])
+	})
+
+	t.Run("NoAuthRequest", func(t *testing.T) {
+		ctx := newctx("/:provider")
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("google")
+
+		ctx.oauthStarter.On("StartOAuth", &auth.AuthRequest{}).Return("", auth.ErrInvalidClient)
+
+		err := ctx.handler.StartOAuth(ctx.c)
+
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+
+	t.Run("InvalidAuthRequest", func(t *testing.T) {
+		q := make(url.Values)
+		q.Set("redirect_uri", "http://evil.local/callback")
+
+		ctx := newctx("/:provider?" + q.Encode())
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("google")
+
+		ctx.oauthStarter.On("StartOAuth", mock.Anything).Return("", auth.ErrInvalidRedirect)
+
+		err := ctx.handler.StartOAuth(ctx.c)
+
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
 	})
 
 	t.Run("BadProvider", func(t *testing.T) {
@@ -182,7 +666,7 @@ func TestHttpStartOAuth(t *testing.T) {
 		ctx.c.SetParamValues("google")
 
 		fail := errors.New("unexpected error")
-		ctx.oauthStarter.On("StartOAuth").Return("", fail)
+		ctx.oauthStarter.On("StartOAuth", mock.Anything).Return("", fail)
 
 		err := ctx.handler.StartOAuth(ctx.c)
 		require.Error(t, err)
@@ -192,6 +676,109 @@ func TestHttpStartOAuth(t *testing.T) {
 	goth.ClearProviders()
 }
 
+func TestHttpAuthorize(t *testing.T) {
+	goth.UseProviders(
+		google.New("google_id", "google_secret", "http://localhost:8000/google/callback"),
+		github.New("github_id", "github_secret", "http://localhost:8000/github/callback"),
+	)
+
+	query := func(provider string) url.Values {
+		q := make(url.Values)
+		q.Set("response_type", "code")
+		q.Set("client_id", "client.123")
+		q.Set("redirect_uri", "http://app.local/callback")
+		q.Set("state", "state.123")
+		q.Set("nonce", "nonce.123")
+		q.Set("code_challenge", "challenge.123")
+		q.Set("code_challenge_method", "S256")
+		if provider != "" {
+			q.Set("provider", provider)
+		}
+		return q
+	}
+
+	req := auth.AuthRequest{
+		ClientID:            "client.123",
+		RedirectURI:         "http://app.local/callback",
+		State:               "state.123",
+		Nonce:               "nonce.123",
+		CodeChallenge:       "challenge.123",
+		CodeChallengeMethod: "S256",
+	}
+
+	t.Run("Provider", func(t *testing.T) {
+		ctx := newctx("/authorize?" + query("github").Encode())
+		ctx.oauthStarter.On("StartOAuth", &req).Return("redirectURL", nil)
+
+		require.NoError(t, ctx.handler.Authorize(ctx.c))
+		require.Equal(t, http.StatusTemporaryRedirect, ctx.rec.Code)
+		require.Equal(t, "redirectURL", ctx.rec.Header().Get("Location"))
+		ctx.authorizer.AssertNotCalled(t, "Providers", mock.Anything)
+	})
+
+	t.Run("SingleProvider", func(t *testing.T) {
+		ctx := newctx("/authorize?" + query("").Encode())
+		ctx.authorizer.On("Providers", req).Return([]string{"google"}, nil)
+		ctx.oauthStarter.On("StartOAuth", &req).Return("redirectURL", nil)
+
+		require.NoError(t, ctx.handler.Authorize(ctx.c))
+		require.Equal(t, http.StatusTemporaryRedirect, ctx.rec.Code)
+		ctx.factory.AssertCalled(t, "NewOAuthStarter", mock.MatchedBy(func(p goth.Provider) bool {
+			return p.Name() == "google"
+		}))
+	})
+
+	t.Run("ManyProviders", func(t *testing.T) {
+		ctx := newctx("/authorize?" + query("").Encode())
+		ctx.authorizer.On("Providers", req).Return([]string{"google", "github"}, nil)
+
+		require.NoError(t, ctx.handler.Authorize(ctx.c))
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		body := ctx.rec.Body.String()
+		require.Contains(t, body, `href="?`+template.HTMLEscapeString(query("google").Encode())+`"`)
+		require.Contains(t, body, `href="?`+template.HTMLEscapeString(query("github").Encode())+`"`)
+		ctx.oauthStarter.AssertNotCalled(t, "StartOAuth", mock.Anything)
+	})
+
+	t.Run("NoProviders", func(t *testing.T) {
+		ctx := newctx("/authorize?" + query("").Encode())
+		ctx.authorizer.On("Providers", req).Return([]string{}, nil)
+
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, ctx.handler.Authorize(ctx.c), &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+
+	t.Run("InvalidClient", func(t *testing.T) {
+		ctx := newctx("/authorize?" + query("").Encode())
+		ctx.authorizer.On("Providers", req).Return(nil, auth.ErrInvalidClient)
+
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, ctx.handler.Authorize(ctx.c), &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+	})
+
+	t.Run("UnsupportedResponseType", func(t *testing.T) {
+		q := query("google")
+		q.Set("response_type", "token")
+
+		ctx := newctx("/authorize?" + q.Encode())
+
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, ctx.handler.Authorize(ctx.c), &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+		require.Equal(t, api.OAuthError{Code: "unsupported_response_type"}, httpErr.Message)
+	})
+
+	t.Run("BadProvider", func(t *testing.T) {
+		ctx := newctx("/authorize?" + query("xxx").Encode())
+		require.ErrorIs(t, ctx.handler.Authorize(ctx.c), api.ErrUnexpectedProvider)
+	})
+
+	goth.ClearProviders()
+}
+
 func TestHttpSignIn(t *testing.T) {
 	goth.UseProviders(google.New("google_id", "google_secret", "http://localhost:8000/google/callback"))
 
@@ -212,7 +799,7 @@ func TestHttpSignIn(t *testing.T) {
 			RefreshExpires: 1600000100,
 		}
 
-		ctx.signiner.On("SignIn", mock.Anything, mock.Anything).Return(token, nil)
+		ctx.signiner.On("SignIn", mock.Anything, mock.Anything, mock.Anything).Return(auth.SignInResult{Token: token}, nil)
 
 		err := ctx.handler.Callback(ctx.c)
 		require.NoError(t, err)
@@ -222,9 +809,85 @@ func TestHttpSignIn(t *testing.T) {
 		require.Equal(t, token, value)
 	})
 
-	t.Run("InvalidProvider", func(t *testing.T) {
-		ctx := newctx("/:provider/callback")
-		ctx.c.SetParamNames("provider")
+	t.Run("RedirectWithCode", func(t *testing.T) {
+		q := make(url.Values)
+		q.Set("state", "signin123")
+
+		ctx := newctx("/:provider/callback/?" + q.Encode())
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("google")
+
+		redirect := "http://app.local/callback?code=code.123&state=state.123"
+		ctx.signiner.On("SignIn", "signin123", "", mock.Anything).Return(auth.SignInResult{Redirect: redirect}, nil)
+
+		err := ctx.handler.Callback(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusFound, ctx.rec.Code)
+		require.Equal(t, redirect, ctx.rec.Header().Get(echo.HeaderLocation))
+	})
+
+	t.Run("Linked", func(t *testing.T) {
+		q := make(url.Values)
+		q.Set("state", "signin123")
+
+		ctx := newctx("/:provider/callback/?" + q.Encode())
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("google")
+
+		ctx.req.AddCookie(&http.Cookie{Name: "guard_link", Value: "nonce.123"})
+
+		info := auth.IdentityInfo{Provider: "google", Email: "u0@mail.org", Created: 1600000000}
+		ctx.signiner.On("SignIn", "signin123", "nonce.123", mock.Anything).Return(auth.SignInResult{Linked: &info}, nil)
+
+		err := ctx.handler.Callback(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		cookies := ctx.rec.Result().Cookies()
+		require.Len(t, cookies, 1)
+		require.Equal(t, "guard_link", cookies[0].Name)
+		require.True(t, cookies[0].MaxAge < 0)
+
+		var value auth.IdentityInfo
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, info, value)
+	})
+
+	t.Run("IdentityConflict", func(t *testing.T) {
+		for _, fail := range []error{auth.ErrIdentityConflict, auth.ErrIdentityLinked} {
+			q := make(url.Values)
+			q.Set("state", "signin123")
+
+			ctx := newctx("/:provider/callback/?" + q.Encode())
+			ctx.c.SetParamNames("provider")
+			ctx.c.SetParamValues("google")
+
+			ctx.signiner.On("SignIn", "signin123", "", mock.Anything).Return(auth.SignInResult{}, fail)
+
+			err := ctx.handler.Callback(ctx.c)
+			api.ErrorHandler(err, ctx.c)
+			require.Equal(t, http.StatusConflict, ctx.rec.Code)
+		}
+	})
+
+	t.Run("LinkOtherBrowser", func(t *testing.T) {
+		q := make(url.Values)
+		q.Set("state", "signin123")
+
+		ctx := newctx("/:provider/callback/?" + q.Encode())
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("google")
+
+		ctx.signiner.On("SignIn", "signin123", "", mock.Anything).Return(auth.SignInResult{}, auth.ErrLinkNonce)
+
+		err := ctx.handler.Callback(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusForbidden, ctx.rec.Code)
+	})
+
+	t.Run("InvalidProvider", func(t *testing.T) {
+		ctx := newctx("/:provider/callback")
+		ctx.c.SetParamNames("provider")
 		ctx.c.SetParamValues("xxx")
 
 		err := ctx.handler.Callback(ctx.c)
@@ -252,7 +915,7 @@ func TestHttpSignIn(t *testing.T) {
 		ctx.c.SetParamValues("google")
 
 		fail := errors.New("unexpected error")
-		ctx.signiner.On("SignIn", mock.Anything, mock.Anything).Return(nil, fail)
+		ctx.signiner.On("SignIn", mock.Anything, mock.Anything, mock.Anything).Return(nil, fail)
 
 		err := ctx.handler.Callback(ctx.c)
 		require.Error(t, err)
@@ -281,7 +944,7 @@ func TestHttpRefresh(t *testing.T) {
 			RefreshExpires: 1600000100,
 		}
 
-		ctx.refresher.On("Refresh", refreshToken).Return(token, nil)
+		ctx.refresher.On("Refresh", auth.RefreshGrant{RefreshToken: refreshToken}).Return(token, nil)
 		ctx.req.Form = form
 
 		err := ctx.handler.Refresh(ctx.c)
@@ -301,7 +964,7 @@ func TestHttpRefresh(t *testing.T) {
 		ctx := newctx("/refresh")
 
 		fail := auth.Error{}
-		ctx.refresher.On("Refresh", refreshToken).Return(nil, fail)
+		ctx.refresher.On("Refresh", auth.RefreshGrant{RefreshToken: refreshToken}).Return(nil, fail)
 		ctx.req.Form = form
 
 		err := ctx.handler.Refresh(ctx.c)
@@ -313,7 +976,7 @@ func TestHttpRefresh(t *testing.T) {
 		ctx := newctx("/refresh")
 
 		fail := auth.Error{}
-		ctx.refresher.On("Refresh", "").Return(nil, fail)
+		ctx.refresher.On("Refresh", auth.RefreshGrant{}).Return(nil, fail)
 
 		err := ctx.handler.Refresh(ctx.c)
 		require.Error(t, err)
@@ -323,6 +986,255 @@ func TestHttpRefresh(t *testing.T) {
 	goth.ClearProviders()
 }
 
+func TestHttpLogout(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		refreshToken := "refresh.123"
+
+		form := make(url.Values)
+		form.Set("refresh_token", refreshToken)
+
+		ctx := newctx("/logout")
+		ctx.req.Form = form
+
+		ctx.revoker.On("Revoke", refreshToken).Return(nil)
+
+		err := ctx.handler.Logout(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		ctx := newctx("/logout")
+
+		fail := auth.Error{}
+		ctx.revoker.On("Revoke", "").Return(fail)
+
+		err := ctx.handler.Logout(ctx.c)
+		require.Error(t, err)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestHttpLogoutAll(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		refreshToken := "refresh.123"
+
+		form := make(url.Values)
+		form.Set("refresh_token", refreshToken)
+
+		ctx := newctx("/logout/all")
+		ctx.req.Form = form
+
+		ctx.revoker.On("RevokeAll", refreshToken).Return(nil)
+
+		err := ctx.handler.LogoutAll(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("InternalError", func(t *testing.T) {
+		ctx := newctx("/logout/all")
+
+		fail := errors.New("unexpected error")
+		ctx.revoker.On("RevokeAll", "").Return(fail)
+
+		err := ctx.handler.LogoutAll(ctx.c)
+		require.Error(t, err)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestHttpRevoke(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		refreshToken := "refresh.123"
+
+		form := make(url.Values)
+		form.Set("token", refreshToken)
+		form.Set("token_type_hint", "refresh_token")
+
+		ctx := newctx("/revoke")
+		ctx.req.Form = form
+		ctx.req.SetBasicAuth("client.123", "secret.123")
+
+		ctx.revoker.On("RevokeClient", auth.RevokeGrant{
+			Token:        refreshToken,
+			ClientID:     "client.123",
+			ClientSecret: "secret.123",
+		}).Return(nil)
+
+		err := ctx.handler.Revoke(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "xxx")
+
+		ctx := newctx("/revoke")
+		ctx.req.Form = form
+
+		ctx.revoker.On("RevokeClient", auth.RevokeGrant{Token: "xxx"}).Return(auth.Error{})
+
+		err := ctx.handler.Revoke(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+	})
+
+	t.Run("InvalidClient", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "refresh.123")
+		form.Set("client_id", "client.123")
+		form.Set("client_secret", "xxx")
+
+		ctx := newctx("/revoke")
+		ctx.req.Form = form
+
+		ctx.revoker.On("RevokeClient", auth.RevokeGrant{
+			Token:        "refresh.123",
+			ClientID:     "client.123",
+			ClientSecret: "xxx",
+		}).Return(auth.ErrInvalidClient)
+
+		err := ctx.handler.Revoke(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidClient)
+		require.NotEmpty(t, ctx.rec.Header().Get(echo.HeaderWWWAuthenticate))
+	})
+
+	t.Run("OtherClient", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "refresh.123")
+		form.Set("client_id", "client.456")
+
+		ctx := newctx("/revoke")
+		ctx.req.Form = form
+
+		ctx.revoker.On("RevokeClient", auth.RevokeGrant{
+			Token:    "refresh.123",
+			ClientID: "client.456",
+		}).Return(auth.ErrInvalidGrant)
+
+		err := ctx.handler.Revoke(ctx.c)
+		require.Error(t, err)
+
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+
+		var value api.OAuthError
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, "invalid_grant", value.Code)
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		ctx := newctx("/revoke")
+
+		err := ctx.handler.Revoke(ctx.c)
+		require.ErrorIs(t, err, api.ErrMissingToken)
+
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+
+		var value api.OAuthError
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, "invalid_request", value.Code)
+	})
+
+	t.Run("InternalError", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "xxx")
+
+		ctx := newctx("/revoke")
+		ctx.req.Form = form
+
+		fail := errors.New("unexpected error")
+		ctx.revoker.On("RevokeClient", auth.RevokeGrant{Token: "xxx"}).Return(fail)
+
+		err := ctx.handler.Revoke(ctx.c)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestHttpIntrospect(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "access.123")
+
+		ctx := newctx("/introspect")
+		ctx.req.Form = form
+		ctx.req.SetBasicAuth("client%3A1", "secret.123")
+
+		value := auth.Introspection{Active: true, Sub: "user.123", Exp: 1600000000}
+		ctx.clients.On("Authenticate", "client:1", "secret.123").Return(model.Client{ID: "client.1"}, nil)
+		ctx.introspector.On("Introspect", "access.123").Return(value, nil)
+
+		err := ctx.handler.Introspect(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var result auth.Introspection
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &result))
+		require.Equal(t, value, result)
+	})
+
+	t.Run("FormCredentials", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "refresh.123")
+		form.Set("client_id", "client.1")
+		form.Set("client_secret", "secret.123")
+
+		ctx := newctx("/introspect")
+		ctx.req.Form = form
+
+		ctx.clients.On("Authenticate", "client.1", "secret.123").Return(model.Client{ID: "client.1"}, nil)
+		ctx.introspector.On("Introspect", "refresh.123").Return(auth.Introspection{}, nil)
+
+		err := ctx.handler.Introspect(ctx.c)
+		require.NoError(t, err)
+		require.JSONEq(t, `{"active": false}`, ctx.rec.Body.String())
+	})
+
+	t.Run("InvalidClient", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "access.123")
+
+		ctx := newctx("/introspect")
+		ctx.req.Form = form
+
+		ctx.clients.On("Authenticate", "", "").Return(model.Client{}, auth.ErrInvalidClient)
+
+		err := ctx.handler.Introspect(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidClient)
+		require.NotEmpty(t, ctx.rec.Header().Get(echo.HeaderWWWAuthenticate))
+		ctx.introspector.AssertNotCalled(t, "Introspect", mock.Anything)
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		ctx := newctx("/introspect")
+		ctx.req.SetBasicAuth("client.1", "secret.123")
+
+		ctx.clients.On("Authenticate", "client.1", "secret.123").Return(model.Client{ID: "client.1"}, nil)
+
+		err := ctx.handler.Introspect(ctx.c)
+		require.ErrorIs(t, err, api.ErrMissingToken)
+	})
+
+	t.Run("InternalError", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "xxx")
+
+		ctx := newctx("/introspect")
+		ctx.req.Form = form
+		ctx.req.SetBasicAuth("client.1", "secret.123")
+
+		fail := errors.New("unexpected error")
+		ctx.clients.On("Authenticate", "client.1", "secret.123").Return(model.Client{ID: "client.1"}, nil)
+		ctx.introspector.On("Introspect", "xxx").Return(auth.Introspection{}, fail)
+
+		err := ctx.handler.Introspect(ctx.c)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
 func TestHealthCheck(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		ctx := newctx("/health")
@@ -343,3 +1255,1146 @@ func TestHealthCheck(t *testing.T) {
 		require.ErrorIs(t, err, fail)
 	})
 }
+
+func TestJWKS(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ctx := newctx("/.well-known/jwks.json")
+
+		jwks := auth.JWKS{Keys: []auth.JWK{{Kty: "OKP", Kid: "kid.123", Crv: "Ed25519", X: "x.123"}}}
+		ctx.factory.On("NewJWKS").Return(jwks)
+
+		err := ctx.handler.JWKS(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.JWKS
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, jwks, value)
+	})
+}
+
+func TestDiscovery(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ctx := newctx("/.well-known/openid-configuration")
+
+		discovery := auth.NewDiscovery("http://localhost:8000", "http://localhost:8000", nil)
+		ctx.factory.On("NewDiscovery").Return(discovery)
+
+		err := ctx.handler.Discovery(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.Discovery
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, discovery, value)
+	})
+}
+
+func TestHttpUserInfo(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ctx := newctx("/userinfo")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		info := auth.UserInfo{Sub: "user.123", Email: "u0@mail.org"}
+		ctx.userInfoer.On("UserInfo", "access.123").Return(info, nil)
+
+		err := ctx.handler.UserInfo(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.UserInfo
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, info, value)
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		ctx := newctx("/userinfo")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Basic xxx")
+
+		err := ctx.handler.UserInfo(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		require.NotEmpty(t, ctx.rec.Header().Get(echo.HeaderWWWAuthenticate))
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		ctx := newctx("/userinfo")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "bearer xxx")
+
+		ctx.userInfoer.On("UserInfo", "xxx").Return(auth.UserInfo{}, auth.Error{})
+
+		err := ctx.handler.UserInfo(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		require.Contains(t, ctx.rec.Header().Get(echo.HeaderWWWAuthenticate), "invalid_token")
+	})
+
+	t.Run("InternalError", func(t *testing.T) {
+		ctx := newctx("/userinfo")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer xxx")
+
+		fail := errors.New("unexpected error")
+		ctx.userInfoer.On("UserInfo", "xxx").Return(auth.UserInfo{}, fail)
+
+		err := ctx.handler.UserInfo(ctx.c)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestHttpToken(t *testing.T) {
+	token := auth.Token{
+		IssuedAt:       1600000000,
+		Access:         "access.123",
+		AccessExpires:  1600000300,
+		Refresh:        "refresh.123",
+		RefreshExpires: 1600086400,
+		IDToken:        "id.123",
+	}
+
+	t.Run("AuthorizationCode", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("grant_type", "authorization_code")
+		form.Set("code", "code.123")
+		form.Set("redirect_uri", "http://app.local/callback")
+		form.Set("code_verifier", "verifier.123")
+		form.Set("client_id", "client.123")
+
+		ctx := newctx("/token")
+		ctx.req.Form = form
+
+		grant := auth.CodeGrant{
+			Code:         "code.123",
+			RedirectURI:  "http://app.local/callback",
+			CodeVerifier: "verifier.123",
+			ClientID:     "client.123",
+		}
+		ctx.exchanger.On("Exchange", grant).Return(token, nil)
+
+		err := ctx.handler.Token(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+		require.Equal(t, "no-store", ctx.rec.Header().Get("Cache-Control"))
+
+		var value api.TokenResponse
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, api.TokenResponse{
+			AccessToken:  "access.123",
+			TokenType:    "Bearer",
+			ExpiresIn:    300,
+			RefreshToken: "refresh.123",
+			IDToken:      "id.123",
+		}, value)
+	})
+
+	t.Run("RefreshToken", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", "refresh.123")
+
+		ctx := newctx("/token")
+		ctx.req.Form = form
+
+		ctx.req.SetBasicAuth("client.123", "secret.123")
+
+		ctx.refresher.On("Refresh", auth.RefreshGrant{
+			RefreshToken: "refresh.123",
+			ClientID:     "client.123",
+			ClientSecret: "secret.123",
+		}).Return(token, nil)
+
+		err := ctx.handler.Token(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+	})
+
+	t.Run("RefreshTokenOtherClient", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", "refresh.123")
+		form.Set("client_id", "client.456")
+
+		ctx := newctx("/token")
+		ctx.req.Form = form
+
+		ctx.refresher.On("Refresh", auth.RefreshGrant{
+			RefreshToken: "refresh.123",
+			ClientID:     "client.456",
+		}).Return(nil, auth.ErrInvalidGrant)
+
+		err := ctx.handler.Token(ctx.c)
+		require.Error(t, err)
+
+		var httpErr *echo.HTTPError
+		require.ErrorAs(t, err, &httpErr)
+		require.Equal(t, http.StatusBadRequest, httpErr.Code)
+		require.Equal(t, api.OAuthError{Code: "invalid_grant", Description: auth.ErrInvalidGrant.Error()}, httpErr.Message)
+	})
+
+	t.Run("ClientCredentials", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("grant_type", "client_credentials")
+
+		ctx := newctx("/token")
+		ctx.req.Form = form
+		ctx.req.SetBasicAuth("client.123", "secret.123")
+
+		token := auth.Token{IssuedAt: 1600000000, Access: "access.123", AccessExpires: 1600000300}
+		ctx.credentials.On("Exchange", "client.123", "secret.123").Return(token, nil)
+
+		err := ctx.handler.Token(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+		require.JSONEq(t, `{"access_token":"access.123","token_type":"Bearer","expires_in":300}`, ctx.rec.Body.String())
+	})
+
+	t.Run("ClientCredentialsInvalidClient", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("grant_type", "client_credentials")
+		form.Set("client_id", "client.123")
+		form.Set("client_secret", "xxx")
+
+		ctx := newctx("/token")
+		ctx.req.Form = form
+
+		ctx.credentials.On("Exchange", "client.123", "xxx").Return(auth.Token{}, auth.ErrInvalidClient)
+
+		err := ctx.handler.Token(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidClient)
+	})
+
+	t.Run("InvalidGrant", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("grant_type", "authorization_code")
+		form.Set("code", "xxx")
+
+		ctx := newctx("/token")
+		ctx.req.Form = form
+
+		ctx.exchanger.On("Exchange", auth.CodeGrant{Code: "xxx"}).Return(auth.Token{}, auth.ErrInvalidGrant)
+
+		err := ctx.handler.Token(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+
+		var value api.OAuthError
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, "invalid_grant", value.Code)
+	})
+
+	t.Run("InvalidClient", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("grant_type", "authorization_code")
+		form.Set("code", "code.123")
+
+		ctx := newctx("/token")
+		ctx.req.Form = form
+		ctx.req.SetBasicAuth("client.123", "xxx")
+
+		grant := auth.CodeGrant{Code: "code.123", ClientID: "client.123", ClientSecret: "xxx"}
+		ctx.exchanger.On("Exchange", grant).Return(auth.Token{}, auth.ErrInvalidClient)
+
+		err := ctx.handler.Token(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidClient)
+	})
+
+	t.Run("UnsupportedGrant", func(t *testing.T) {
+		ctx := newctx("/token")
+
+		err := ctx.handler.Token(ctx.c)
+		require.ErrorIs(t, err, api.ErrUnsupportedGrant)
+	})
+
+	t.Run("InternalError", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("grant_type", "authorization_code")
+
+		ctx := newctx("/token")
+		ctx.req.Form = form
+
+		fail := errors.New("unexpected error")
+		ctx.exchanger.On("Exchange", auth.CodeGrant{}).Return(auth.Token{}, fail)
+
+		err := ctx.handler.Token(ctx.c)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestHttpPassword(t *testing.T) {
+	token := auth.Token{Access: "access.123", Refresh: "refresh.123"}
+
+	newForm := func(values map[string]string) url.Values {
+		form := make(url.Values)
+		for k, v := range values {
+			form.Set(k, v)
+		}
+		return form
+	}
+
+	t.Run("SignUp", func(t *testing.T) {
+		ctx := newctx("/signup")
+		ctx.req.Form = newForm(map[string]string{"email": "u0@mail.org", "password": "password.123"})
+
+		ctx.signUper.On("SignUp", "u0@mail.org", "password.123").Return(token, nil)
+
+		err := ctx.handler.SignUp(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.Token
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, token, value)
+	})
+
+	t.Run("SignUpUserExists", func(t *testing.T) {
+		ctx := newctx("/signup")
+		ctx.req.Form = newForm(map[string]string{"email": "u0@mail.org", "password": "password.123"})
+
+		ctx.signUper.On("SignUp", "u0@mail.org", "password.123").Return(auth.Token{}, auth.ErrUserExists)
+
+		err := ctx.handler.SignUp(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusConflict, ctx.rec.Code)
+	})
+
+	t.Run("SignUpInvalidPassword", func(t *testing.T) {
+		ctx := newctx("/signup")
+		ctx.req.Form = newForm(map[string]string{"email": "u0@mail.org"})
+
+		ctx.signUper.On("SignUp", "u0@mail.org", "").Return(auth.Token{}, auth.ErrInvalidPassword)
+
+		err := ctx.handler.SignUp(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+	})
+
+	t.Run("SignIn", func(t *testing.T) {
+		ctx := newctx("/signin")
+		ctx.req.Form = newForm(map[string]string{"email": "u0@mail.org", "password": "password.123"})
+
+		ctx.passwords.On("SignIn", "u0@mail.org", "password.123").Return(auth.SignInResult{Token: token}, nil)
+
+		err := ctx.handler.SignIn(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.Token
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, token, value)
+	})
+
+	t.Run("SignInMFARequired", func(t *testing.T) {
+		ctx := newctx("/signin")
+		ctx.req.Form = newForm(map[string]string{"email": "u0@mail.org", "password": "password.123"})
+
+		ctx.passwords.On("SignIn", "u0@mail.org", "password.123").Return(auth.SignInResult{Challenge: "mfa.123"}, nil)
+
+		err := ctx.handler.SignIn(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value api.MFAChallenge
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, api.MFAChallenge{Required: true, Token: "mfa.123"}, value)
+	})
+
+	t.Run("SignInInvalidCredentials", func(t *testing.T) {
+		ctx := newctx("/signin")
+		ctx.req.Form = newForm(map[string]string{"email": "u0@mail.org", "password": "xxx"})
+
+		ctx.passwords.On("SignIn", "u0@mail.org", "xxx").Return(auth.SignInResult{}, auth.ErrInvalidCredentials)
+
+		err := ctx.handler.SignIn(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusUnauthorized, ctx.rec.Code)
+	})
+
+	t.Run("ChangePassword", func(t *testing.T) {
+		ctx := newctx("/password/change")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+		ctx.req.Form = newForm(map[string]string{"password": "password.123", "new_password": "password.456"})
+
+		ctx.changer.On("ChangePassword", "access.123", "password.123", "password.456").Return(nil)
+
+		err := ctx.handler.ChangePassword(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("ChangePasswordMissingToken", func(t *testing.T) {
+		ctx := newctx("/password/change")
+
+		err := ctx.handler.ChangePassword(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		ctx.changer.AssertNotCalled(t, "ChangePassword", mock.Anything, mock.Anything, mock.Anything)
+	})
+
+	t.Run("ChangePasswordInvalidToken", func(t *testing.T) {
+		ctx := newctx("/password/change")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer xxx")
+
+		ctx.changer.On("ChangePassword", "xxx", "", "").Return(auth.Error{})
+
+		err := ctx.handler.ChangePassword(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		require.Contains(t, ctx.rec.Header().Get(echo.HeaderWWWAuthenticate), "invalid_token")
+	})
+
+	t.Run("ChangePasswordWrongPassword", func(t *testing.T) {
+		ctx := newctx("/password/change")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+		ctx.req.Form = newForm(map[string]string{"password": "xxx", "new_password": "password.456"})
+
+		ctx.changer.On("ChangePassword", "access.123", "xxx", "password.456").Return(auth.ErrInvalidCredentials)
+
+		err := ctx.handler.ChangePassword(ctx.c)
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("ForgotPassword", func(t *testing.T) {
+		ctx := newctx("/password/forgot")
+		ctx.req.Form = newForm(map[string]string{"email": "u0@mail.org"})
+
+		ctx.resetter.On("RequestReset", "u0@mail.org").Return(nil)
+
+		err := ctx.handler.ForgotPassword(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("ResetPassword", func(t *testing.T) {
+		ctx := newctx("/password/reset")
+		ctx.req.Form = newForm(map[string]string{"token": "token.123", "password": "password.456"})
+
+		ctx.resetter.On("Reset", "token.123", "password.456").Return(nil)
+
+		err := ctx.handler.ResetPassword(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("ResetPasswordInvalidToken", func(t *testing.T) {
+		ctx := newctx("/password/reset")
+		ctx.req.Form = newForm(map[string]string{"token": "xxx", "password": "password.456"})
+
+		ctx.resetter.On("Reset", "xxx", "password.456").Return(auth.ErrInvalidResetToken)
+
+		err := ctx.handler.ResetPassword(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+	})
+}
+
+func TestHttpMagicLink(t *testing.T) {
+	t.Run("Send", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("email", "u0@mail.org")
+
+		ctx := newctx("/magic/send")
+		ctx.req.Form = form
+
+		ctx.linker.On("SendLink", "u0@mail.org").Return(nil)
+
+		err := ctx.handler.SendMagicLink(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("SendInvalidEmail", func(t *testing.T) {
+		ctx := newctx("/magic/send")
+
+		ctx.linker.On("SendLink", "").Return(auth.ErrInvalidEmail)
+
+		err := ctx.handler.SendMagicLink(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+	})
+
+	t.Run("Redeem", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "token.123")
+
+		ctx := newctx("/magic/redeem")
+		ctx.req.Form = form
+
+		token := auth.Token{Access: "access.123", Refresh: "refresh.123"}
+		ctx.linker.On("Redeem", "token.123").Return(auth.SignInResult{Token: token}, nil)
+
+		err := ctx.handler.RedeemMagicLink(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.Token
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, token, value)
+	})
+
+	t.Run("RedeemInvalidToken", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("token", "xxx")
+
+		ctx := newctx("/magic/redeem")
+		ctx.req.Form = form
+
+		ctx.linker.On("Redeem", "xxx").Return(auth.SignInResult{}, auth.ErrInvalidLinkToken)
+
+		err := ctx.handler.RedeemMagicLink(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+	})
+}
+
+func TestHttpMFA(t *testing.T) {
+	t.Run("Enroll", func(t *testing.T) {
+		ctx := newctx("/mfa/enroll")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		enrollment := auth.Enrollment{
+			Secret:        "SECRET",
+			URI:           "otpauth://totp/guard:u0@mail.org?secret=SECRET",
+			RecoveryCodes: []string{"code1", "code2"},
+		}
+		ctx.enroller.On("Enroll", "access.123").Return(enrollment, nil)
+
+		err := ctx.handler.EnrollMFA(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+		require.Equal(t, "no-store", ctx.rec.Header().Get("Cache-Control"))
+
+		var value auth.Enrollment
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, enrollment, value)
+	})
+
+	t.Run("EnrollMissingToken", func(t *testing.T) {
+		ctx := newctx("/mfa/enroll")
+
+		err := ctx.handler.EnrollMFA(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		ctx.enroller.AssertNotCalled(t, "Enroll", mock.Anything)
+	})
+
+	t.Run("EnrollDisabled", func(t *testing.T) {
+		ctx := newctx("/mfa/enroll")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		ctx.enroller.On("Enroll", "access.123").Return(auth.Enrollment{}, auth.ErrMFADisabled)
+
+		err := ctx.handler.EnrollMFA(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusNotFound, ctx.rec.Code)
+	})
+
+	t.Run("EnrollInvalidToken", func(t *testing.T) {
+		ctx := newctx("/mfa/enroll")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer xxx")
+
+		ctx.enroller.On("Enroll", "xxx").Return(auth.Enrollment{}, auth.Error{})
+
+		err := ctx.handler.EnrollMFA(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		require.Contains(t, ctx.rec.Header().Get(echo.HeaderWWWAuthenticate), "invalid_token")
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("code", "123456")
+
+		ctx := newctx("/mfa/confirm")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+		ctx.req.Form = form
+
+		ctx.enroller.On("Confirm", "access.123", "123456").Return(nil)
+
+		err := ctx.handler.ConfirmMFA(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("ConfirmInvalidCode", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("code", "000000")
+
+		ctx := newctx("/mfa/confirm")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+		ctx.req.Form = form
+
+		ctx.enroller.On("Confirm", "access.123", "000000").Return(auth.ErrInvalidOTP)
+
+		err := ctx.handler.ConfirmMFA(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("code", "123456")
+
+		ctx := newctx("/mfa/disable")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+		ctx.req.Form = form
+
+		ctx.enroller.On("Disable", "access.123", "123456").Return(nil)
+
+		err := ctx.handler.DisableMFA(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("DisableNotEnrolled", func(t *testing.T) {
+		ctx := newctx("/mfa/disable")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		ctx.enroller.On("Disable", "access.123", "").Return(auth.ErrMFANotEnrolled)
+
+		err := ctx.handler.DisableMFA(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusConflict, ctx.rec.Code)
+	})
+
+	t.Run("Verify", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("mfa_token", "mfa.123")
+		form.Set("code", "123456")
+
+		ctx := newctx("/mfa/verify")
+		ctx.req.Form = form
+
+		token := auth.Token{Access: "access.123", Refresh: "refresh.123"}
+		ctx.completer.On("Complete", "mfa.123", "123456").Return(auth.SignInResult{Token: token}, nil)
+
+		err := ctx.handler.VerifyMFA(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.Token
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, token, value)
+	})
+
+	t.Run("VerifyRedirect", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("mfa_token", "mfa.123")
+		form.Set("code", "123456")
+
+		ctx := newctx("/mfa/verify")
+		ctx.req.Form = form
+
+		redirect := "https://app.org/callback?code=code.123"
+		ctx.completer.On("Complete", "mfa.123", "123456").Return(auth.SignInResult{Redirect: redirect}, nil)
+
+		err := ctx.handler.VerifyMFA(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value map[string]string
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, redirect, value["redirect"])
+	})
+
+	t.Run("VerifyInvalidChallenge", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("mfa_token", "xxx")
+
+		ctx := newctx("/mfa/verify")
+		ctx.req.Form = form
+
+		ctx.completer.On("Complete", "xxx", "").Return(auth.SignInResult{}, auth.ErrInvalidMFA)
+
+		err := ctx.handler.VerifyMFA(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+	})
+}
+
+// setJSON replaces the request of the context with a POST carrying the body.
+func (ctx *context) setJSON(t *testing.T, body interface{}) {
+	data, err := json.Marshal(body)
+	require.NoError(t, err)
+
+	ctx.req = httptest.NewRequest(http.MethodPost, ctx.req.URL.Path, bytes.NewReader(data))
+	ctx.req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	ctx.c.SetRequest(ctx.req)
+}
+
+func TestHttpPasskey(t *testing.T) {
+	resp := webauthn.AuthenticationResponse{
+		ID:    "key.123",
+		RawID: []byte{1, 2, 3},
+		Type:  "public-key",
+		Response: webauthn.AssertionResponse{
+			ClientDataJSON:    []byte(`{"type":"webauthn.get"}`),
+			AuthenticatorData: []byte{4, 5, 6},
+			Signature:         []byte{7, 8, 9},
+		},
+	}
+
+	t.Run("RegisterBegin", func(t *testing.T) {
+		ctx := newctx("/passkeys/register/begin")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		options := webauthn.CreationOptions{
+			User:        webauthn.UserEntity{ID: []byte("user.123"), Name: "u0@mail.org"},
+			Challenge:   []byte{1, 2, 3},
+			Attestation: "none",
+		}
+		ctx.registrar.On("Begin", "access.123").Return(options, nil)
+
+		err := ctx.handler.BeginPasskeyRegistration(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value webauthn.CreationOptions
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, options, value)
+	})
+
+	t.Run("RegisterBeginMissingToken", func(t *testing.T) {
+		ctx := newctx("/passkeys/register/begin")
+
+		err := ctx.handler.BeginPasskeyRegistration(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		ctx.registrar.AssertNotCalled(t, "Begin", mock.Anything)
+	})
+
+	t.Run("RegisterFinish", func(t *testing.T) {
+		reg := webauthn.RegistrationResponse{
+			ID:    "key.123",
+			RawID: []byte{1, 2, 3},
+			Type:  "public-key",
+			Response: webauthn.AttestationResponse{
+				ClientDataJSON:    []byte(`{"type":"webauthn.create"}`),
+				AttestationObject: []byte{4, 5, 6},
+			},
+		}
+
+		ctx := newctx("/passkeys/register/finish")
+		ctx.setJSON(t, reg)
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		info := auth.PasskeyInfo{ID: "key.123", Created: 1600000000}
+		ctx.registrar.On("Finish", "access.123", reg).Return(info, nil)
+
+		err := ctx.handler.FinishPasskeyRegistration(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusCreated, ctx.rec.Code)
+
+		var value auth.PasskeyInfo
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, info, value)
+	})
+
+	t.Run("RegisterFinishErrors", func(t *testing.T) {
+		for _, tc := range []struct {
+			err  error
+			code int
+		}{
+			{err: auth.ErrInvalidPasskey, code: http.StatusBadRequest},
+			{err: auth.ErrPasskeyExists, code: http.StatusConflict},
+			{err: auth.ErrSessionExpired, code: http.StatusBadRequest},
+		} {
+			ctx := newctx("/passkeys/register/finish")
+			ctx.setJSON(t, webauthn.RegistrationResponse{})
+			ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+			ctx.registrar.On("Finish", "access.123", mock.Anything).Return(auth.PasskeyInfo{}, tc.err)
+
+			err := ctx.handler.FinishPasskeyRegistration(ctx.c)
+			api.ErrorHandler(err, ctx.c)
+			require.Equal(t, tc.code, ctx.rec.Code, tc.err.Error())
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		ctx := newctx("/passkeys")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		items := []auth.PasskeyInfo{{ID: "key.1", Created: 10}, {ID: "key.2", Created: 20, LastUsed: 30}}
+		ctx.registrar.On("List", "access.123").Return(items, nil)
+
+		err := ctx.handler.ListPasskeys(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value []auth.PasskeyInfo
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, items, value)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		ctx := newctx("/passkeys/:id")
+		ctx.c.SetParamNames("id")
+		ctx.c.SetParamValues("key.1")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		ctx.registrar.On("Delete", "access.123", "key.1").Return(nil)
+
+		err := ctx.handler.DeletePasskey(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("DeleteNotFound", func(t *testing.T) {
+		ctx := newctx("/passkeys/:id")
+		ctx.c.SetParamNames("id")
+		ctx.c.SetParamValues("key.xxx")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		ctx.registrar.On("Delete", "access.123", "key.xxx").Return(auth.ErrInvalidPasskey)
+
+		err := ctx.handler.DeletePasskey(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusNotFound, ctx.rec.Code)
+	})
+
+	t.Run("SignInBegin", func(t *testing.T) {
+		ctx := newctx("/passkeys/signin/begin")
+
+		options := webauthn.RequestOptions{Challenge: []byte{1, 2, 3}, RPID: "app.org", UserVerification: webauthn.UVRequired}
+		ctx.passkeys.On("Begin").Return(options, nil)
+
+		err := ctx.handler.BeginPasskeySignIn(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value webauthn.RequestOptions
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, options, value)
+	})
+
+	t.Run("SignInFinish", func(t *testing.T) {
+		ctx := newctx("/passkeys/signin/finish")
+		ctx.setJSON(t, resp)
+
+		token := auth.Token{Access: "access.123", Refresh: "refresh.123"}
+		ctx.passkeys.On("Finish", resp).Return(auth.SignInResult{Token: token}, nil)
+
+		err := ctx.handler.FinishPasskeySignIn(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.Token
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, token, value)
+	})
+
+	t.Run("SignInFinishCloned", func(t *testing.T) {
+		ctx := newctx("/passkeys/signin/finish")
+		ctx.setJSON(t, resp)
+
+		ctx.passkeys.On("Finish", resp).Return(auth.SignInResult{}, auth.ErrPasskeyCloned)
+
+		err := ctx.handler.FinishPasskeySignIn(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusUnauthorized, ctx.rec.Code)
+	})
+
+	t.Run("MFABegin", func(t *testing.T) {
+		form := make(url.Values)
+		form.Set("mfa_token", "mfa.123")
+
+		ctx := newctx("/mfa/passkey/begin")
+		ctx.req.Form = form
+
+		options := webauthn.RequestOptions{Challenge: []byte{1, 2, 3}, RPID: "app.org", UserVerification: webauthn.UVPreferred}
+		ctx.pkCompleter.On("Begin", "mfa.123").Return(options, nil)
+
+		err := ctx.handler.BeginPasskeyMFA(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+	})
+
+	t.Run("MFABeginNotEnrolled", func(t *testing.T) {
+		ctx := newctx("/mfa/passkey/begin")
+
+		ctx.pkCompleter.On("Begin", "").Return(webauthn.RequestOptions{}, auth.ErrMFANotEnrolled)
+
+		err := ctx.handler.BeginPasskeyMFA(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusConflict, ctx.rec.Code)
+	})
+
+	t.Run("MFAFinishRedirect", func(t *testing.T) {
+		ctx := newctx("/mfa/passkey/finish")
+		ctx.setJSON(t, resp)
+
+		redirect := "https://app.org/callback?code=code.123"
+		ctx.pkCompleter.On("Complete", resp).Return(auth.SignInResult{Redirect: redirect}, nil)
+
+		err := ctx.handler.FinishPasskeyMFA(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value map[string]string
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, redirect, value["redirect"])
+	})
+
+	t.Run("MFAFinishInvalid", func(t *testing.T) {
+		ctx := newctx("/mfa/passkey/finish")
+		ctx.setJSON(t, resp)
+
+		ctx.pkCompleter.On("Complete", resp).Return(auth.SignInResult{}, auth.ErrInvalidPasskey)
+
+		err := ctx.handler.FinishPasskeyMFA(ctx.c)
+		api.ErrorHandler(err, ctx.c)
+		require.Equal(t, http.StatusBadRequest, ctx.rec.Code)
+	})
+}
+
+func TestHttpIdentities(t *testing.T) {
+	goth.UseProviders(google.New("google_id", "google_secret", "http://localhost:8000/google/callback"))
+
+	t.Run("List", func(t *testing.T) {
+		ctx := newctx("/identities")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		items := []auth.IdentityInfo{{Provider: "google", Email: "u0@mail.org", Created: 10}}
+		ctx.identities.On("List", "access.123").Return(items, nil)
+
+		err := ctx.handler.ListIdentities(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value []auth.IdentityInfo
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, items, value)
+	})
+
+	t.Run("ListMissingToken", func(t *testing.T) {
+		ctx := newctx("/identities")
+
+		err := ctx.handler.ListIdentities(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		ctx := newctx("/identities/:provider")
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("google")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		link := auth.LinkStart{URL: "https://accounts.google.com/auth", Nonce: "nonce.123"}
+		ctx.oauthStarter.On("StartLink", "access.123").Return(link, nil)
+
+		err := ctx.handler.LinkIdentity(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		cookies := ctx.rec.Result().Cookies()
+		require.Len(t, cookies, 1)
+		require.Equal(t, "guard_link", cookies[0].Name)
+		require.Equal(t, "nonce.123", cookies[0].Value)
+		require.True(t, cookies[0].HttpOnly)
+		require.Equal(t, http.SameSiteLaxMode, cookies[0].SameSite)
+
+		var value map[string]string
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, "https://accounts.google.com/auth", value["url"])
+	})
+
+	t.Run("LinkInvalidProvider", func(t *testing.T) {
+		ctx := newctx("/identities/:provider")
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("xxx")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		err := ctx.handler.LinkIdentity(ctx.c)
+		require.ErrorIs(t, err, api.ErrUnexpectedProvider)
+	})
+
+	t.Run("LinkInvalidToken", func(t *testing.T) {
+		ctx := newctx("/identities/:provider")
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("google")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer xxx")
+
+		ctx.oauthStarter.On("StartLink", "xxx").Return(auth.LinkStart{}, auth.Error{})
+
+		err := ctx.handler.LinkIdentity(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		require.Contains(t, ctx.rec.Header().Get(echo.HeaderWWWAuthenticate), "invalid_token")
+	})
+
+	t.Run("Unlink", func(t *testing.T) {
+		ctx := newctx("/identities/:provider")
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues("google")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		ctx.identities.On("Unlink", "access.123", "google").Return(nil)
+
+		err := ctx.handler.UnlinkIdentity(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusNoContent, ctx.rec.Code)
+	})
+
+	t.Run("UnlinkErrors", func(t *testing.T) {
+		for _, tc := range []struct {
+			err  error
+			code int
+		}{
+			{err: auth.ErrIdentityNotFound, code: http.StatusNotFound},
+			{err: auth.ErrLastIdentity, code: http.StatusConflict},
+			{err: auth.Error{}, code: http.StatusUnauthorized},
+		} {
+			ctx := newctx("/identities/:provider")
+			ctx.c.SetParamNames("provider")
+			ctx.c.SetParamValues("google")
+			ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+			ctx.identities.On("Unlink", "access.123", "google").Return(tc.err)
+
+			err := ctx.handler.UnlinkIdentity(ctx.c)
+			api.ErrorHandler(err, ctx.c)
+			require.Equal(t, tc.code, ctx.rec.Code, tc.err.Error())
+		}
+	})
+
+	goth.ClearProviders()
+}
+
+func TestHttpMe(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ctx := newctx("/me")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		me := auth.Profile{
+			ID:      "user.123",
+			Name:    "u0@mail.org",
+			Created: 1600000000,
+			Profiles: []auth.ProviderProfile{
+				{
+					Provider:      "google",
+					Name:          "User Zero",
+					Email:         "u0@mail.org",
+					EmailVerified: true,
+					RawData:       json.RawMessage(`{"locale":"en"}`),
+					Updated:       1600000010,
+				},
+			},
+		}
+		ctx.profiles.On("Read", "access.123").Return(me, nil)
+
+		err := ctx.handler.Me(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+
+		var value auth.Profile
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &value))
+		require.Equal(t, me, value)
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		ctx := newctx("/me")
+
+		err := ctx.handler.Me(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		ctx.profiles.AssertNotCalled(t, "Read", mock.Anything)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		ctx := newctx("/me")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer xxx")
+
+		ctx.profiles.On("Read", "xxx").Return(auth.Profile{}, auth.Error{})
+
+		err := ctx.handler.Me(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidBearer)
+		require.Contains(t, ctx.rec.Header().Get(echo.HeaderWWWAuthenticate), "invalid_token")
+	})
+
+	t.Run("Failure", func(t *testing.T) {
+		ctx := newctx("/me")
+		ctx.req.Header.Set(echo.HeaderAuthorization, "Bearer access.123")
+
+		fail := errors.New("xxx")
+		ctx.profiles.On("Read", "access.123").Return(auth.Profile{}, fail)
+
+		err := ctx.handler.Me(ctx.c)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestHttpProviderToken(t *testing.T) {
+	goth.UseProviders(google.New("google_id", "google_secret", "http://localhost:8000/google/callback"))
+
+	newRequest := func(provider string) *context {
+		form := make(url.Values)
+		form.Set("token", "access.123")
+
+		ctx := newctx("/identities/:provider/token")
+		ctx.c.SetParamNames("provider")
+		ctx.c.SetParamValues(provider)
+		ctx.req.Form = form
+		ctx.req.SetBasicAuth("client.1", "secret.123")
+
+		return ctx
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		ctx := newRequest("google")
+
+		value := auth.ProviderToken{AccessToken: "google.access", TokenType: "Bearer", ExpiresIn: 3600}
+		ctx.clients.On("Authenticate", "client.1", "secret.123").Return(model.Client{ID: "client.1"}, nil)
+		ctx.ptokens.On("Token", "access.123", model.Client{ID: "client.1"}).Return(value, nil)
+
+		err := ctx.handler.ProviderToken(ctx.c)
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, ctx.rec.Code)
+		require.Equal(t, "no-store", ctx.rec.Header().Get("Cache-Control"))
+
+		var result auth.ProviderToken
+		require.NoError(t, json.Unmarshal(ctx.rec.Body.Bytes(), &result))
+		require.Equal(t, value, result)
+	})
+
+	t.Run("InvalidProvider", func(t *testing.T) {
+		ctx := newRequest("xxx")
+
+		err := ctx.handler.ProviderToken(ctx.c)
+		require.ErrorIs(t, err, api.ErrUnexpectedProvider)
+		ctx.clients.AssertNotCalled(t, "Authenticate", mock.Anything, mock.Anything)
+	})
+
+	t.Run("InvalidClient", func(t *testing.T) {
+		ctx := newRequest("google")
+
+		ctx.clients.On("Authenticate", "client.1", "secret.123").Return(model.Client{}, auth.ErrInvalidClient)
+
+		err := ctx.handler.ProviderToken(ctx.c)
+		require.ErrorIs(t, err, api.ErrInvalidClient)
+		ctx.ptokens.AssertNotCalled(t, "Token", mock.Anything, mock.Anything)
+	})
+
+	t.Run("MissingToken", func(t *testing.T) {
+		ctx := newRequest("google")
+		ctx.req.Form = make(url.Values)
+
+		ctx.clients.On("Authenticate", "client.1", "secret.123").Return(model.Client{ID: "client.1"}, nil)
+
+		err := ctx.handler.ProviderToken(ctx.c)
+		require.ErrorIs(t, err, api.ErrMissingToken)
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		for _, tc := range []struct {
+			err  error
+			code int
+		}{
+			{err: auth.ErrProviderTokensDisabled, code: http.StatusNotFound},
+			{err: auth.ErrProviderTokenNotFound, code: http.StatusNotFound},
+			{err: fmt.Errorf("refresh failed: %w", auth.ErrProviderTokenExpired), code: http.StatusConflict},
+			{err: auth.Error{}, code: http.StatusBadRequest},
+			{err: errors.New("xxx"), code: http.StatusInternalServerError},
+		} {
+			ctx := newRequest("google")
+
+			ctx.clients.On("Authenticate", "client.1", "secret.123").Return(model.Client{ID: "client.1"}, nil)
+			ctx.ptokens.On("Token", "access.123", model.Client{ID: "client.1"}).Return(auth.ProviderToken{}, tc.err)
+
+			err := ctx.handler.ProviderToken(ctx.c)
+			api.ErrorHandler(err, ctx.c)
+			require.Equal(t, tc.code, ctx.rec.Code, tc.err.Error())
+		}
+	})
+
+	goth.ClearProviders()
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+var ErrUserNotFound = Error{msg: "user not found"}
+
+// UserDetails is the user together with its linked provider identities.
+type UserDetails struct {
+	User       model.User
+	Identities []IdentityInfo
+}
+
+// UserAdmin manages the users on behalf of the operator. The users are
+// referenced either by the ID or by the name.
+type UserAdmin interface {
+	List(offset, limit int) ([]model.User, error)
+	Show(user string) (UserDetails, error)
+	Disable(user string) (model.User, error)
+	Enable(user string) (model.User, error)
+	Delete(user string) (model.User, error)
+	RevokeTokens(user string) (model.User, error)
+}
+
+type userAdmin struct {
+	tx         repo.Transaction
+	users      repo.Users
+	tokens     repo.RefreshTokens
+	identities repo.Identities
+}
+
+func NewUserAdmin(tx repo.Transaction, users repo.Users, tokens repo.RefreshTokens, identities repo.Identities) UserAdmin {
+	return &userAdmin{
+		tx:         tx,
+		users:      users,
+		tokens:     tokens,
+		identities: identities,
+	}
+}
+
+func (c *userAdmin) find(ref string) (model.User, error) {
+	user, err := c.users.FindByID(ref)
+	if errors.Is(err, repo.ErrorNotFound) {
+		user, err = c.users.Find(ref)
+	}
+
+	if errors.Is(err, repo.ErrorNotFound) {
+		return user, ErrUserNotFound
+	}
+	if err != nil {
+		return user, fmt.Errorf("failed to find user: %w", err)
+	}
+
+	return user, nil
+}
+
+func (c *userAdmin) List(offset, limit int) ([]model.User, error) {
+	users, err := c.users.List(offset, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	return users, nil
+}
+
+func (c *userAdmin) Show(ref string) (UserDetails, error) {
+	var details UserDetails
+
+	user, err := c.find(ref)
+	if err != nil {
+		return details, err
+	}
+
+	identities, err := c.identities.FindByUser(user.ID)
+	if err != nil {
+		return details, fmt.Errorf("failed to find identities: %w", err)
+	}
+
+	details.User = user
+	details.Identities = make([]IdentityInfo, 0, len(identities))
+	for _, identity := range identities {
+		details.Identities = append(details.Identities, newIdentityInfo(identity))
+	}
+
+	return details, nil
+}
+
+// update runs fn on the user found within a transaction.
+func (c *userAdmin) update(ref string, fn func(user *model.User) error) (model.User, error) {
+	var empty model.User
+
+	if err := c.tx.Begin(); err != nil {
+		return empty, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer c.tx.Close()
+
+	user, err := c.find(ref)
+	if err != nil {
+		return empty, err
+	}
+
+	if err := fn(&user); err != nil {
+		return empty, err
+	}
+
+	if err := c.tx.Commit(); err != nil {
+		return empty, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return user, nil
+}
+
+func (c *userAdmin) revoke(user *model.User) error {
+	if err := c.tokens.DeleteByUser(user.ID); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+	return nil
+}
+
+func (c *userAdmin) setDisabled(user *model.User, disabled bool) error {
+	if err := c.users.SetDisabled(user.ID, disabled); err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	user.Disabled = disabled
+	return nil
+}
+
+// Disable disables the user and revokes its refresh tokens, the access tokens
+// issued expire on their own.
+func (c *userAdmin) Disable(ref string) (model.User, error) {
+	return c.update(ref, func(user *model.User) error {
+		if err := c.setDisabled(user, true); err != nil {
+			return err
+		}
+		return c.revoke(user)
+	})
+}
+
+func (c *userAdmin) Enable(ref string) (model.User, error) {
+	return c.update(ref, func(user *model.User) error {
+		return c.setDisabled(user, false)
+	})
+}
+
+// Delete deletes the user with all its records and refresh tokens.
+func (c *userAdmin) Delete(ref string) (model.User, error) {
+	return c.update(ref, func(user *model.User) error {
+		if err := c.revoke(user); err != nil {
+			return err
+		}
+		if err := c.users.Delete(user.ID); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+		return nil
+	})
+}
+
+func (c *userAdmin) RevokeTokens(ref string) (model.User, error) {
+	return c.update(ref, c.revoke)
+}
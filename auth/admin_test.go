@@ -0,0 +1,125 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func TestUserAdmin(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org", Created: 1600000000}
+
+	newAdmin := func() (auth.UserAdmin, *transactionMock, *usersMock, *refreshTokensMock, *identitiesMock) {
+		tx := newTransactionMock()
+		users := &usersMock{}
+		tokens := &refreshTokensMock{}
+		identities := &identitiesMock{}
+
+		users.On("FindByID", user.ID).Return(user, nil)
+		users.On("FindByID", user.Name).Return(nil, repo.ErrorNotFound)
+		users.On("Find", user.Name).Return(user, nil)
+
+		return auth.NewUserAdmin(tx, users, tokens, identities), tx, users, tokens, identities
+	}
+
+	t.Run("List", func(t *testing.T) {
+		cmd, _, users, _, _ := newAdmin()
+		users.On("List", 10, 5).Return([]model.User{user}, nil)
+
+		value, err := cmd.List(10, 5)
+		require.NoError(t, err)
+		require.Equal(t, []model.User{user}, value)
+	})
+
+	t.Run("Show", func(t *testing.T) {
+		cmd, _, _, _, identities := newAdmin()
+		identities.On("FindByUser", user.ID).Return([]model.UserIdentity{
+			{Provider: "google", Subject: "google.123", UserID: user.ID, Email: user.Name, Created: 1600000010},
+		}, nil)
+
+		details, err := cmd.Show(user.Name)
+		require.NoError(t, err)
+		require.Equal(t, auth.UserDetails{
+			User:       user,
+			Identities: []auth.IdentityInfo{{Provider: "google", Email: user.Name, Created: 1600000010}},
+		}, details)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		cmd, _, users, _, _ := newAdmin()
+		users.On("FindByID", "xxx").Return(nil, repo.ErrorNotFound)
+		users.On("Find", "xxx").Return(nil, repo.ErrorNotFound)
+
+		_, err := cmd.Show("xxx")
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+
+		_, err = cmd.Disable("xxx")
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		cmd, tx, users, tokens, _ := newAdmin()
+		users.On("SetDisabled", user.ID, true).Return(nil)
+		tokens.On("DeleteByUser", user.ID).Return(nil)
+
+		value, err := cmd.Disable(user.Name)
+		require.NoError(t, err)
+		require.True(t, value.Disabled)
+
+		users.AssertCalled(t, "SetDisabled", user.ID, true)
+		tokens.AssertCalled(t, "DeleteByUser", user.ID)
+		tx.AssertCalled(t, "Commit")
+	})
+
+	t.Run("Enable", func(t *testing.T) {
+		cmd, _, users, tokens, _ := newAdmin()
+		users.On("SetDisabled", user.ID, false).Return(nil)
+
+		value, err := cmd.Enable(user.ID)
+		require.NoError(t, err)
+		require.False(t, value.Disabled)
+
+		users.AssertCalled(t, "SetDisabled", user.ID, false)
+		tokens.AssertNotCalled(t, "DeleteByUser", user.ID)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		cmd, tx, users, tokens, _ := newAdmin()
+		tokens.On("DeleteByUser", user.ID).Return(nil)
+		users.On("Delete", user.ID).Return(nil)
+
+		value, err := cmd.Delete(user.ID)
+		require.NoError(t, err)
+		require.Equal(t, user, value)
+
+		users.AssertCalled(t, "Delete", user.ID)
+		tx.AssertCalled(t, "Commit")
+	})
+
+	t.Run("DeleteFailed", func(t *testing.T) {
+		cmd, tx, users, tokens, _ := newAdmin()
+
+		fail := errors.New("xxx")
+		tokens.On("DeleteByUser", user.ID).Return(nil)
+		users.On("Delete", user.ID).Return(fail)
+
+		_, err := cmd.Delete(user.ID)
+		require.ErrorIs(t, err, fail)
+		tx.AssertNotCalled(t, "Commit")
+		tx.AssertCalled(t, "Close")
+	})
+
+	t.Run("RevokeTokens", func(t *testing.T) {
+		cmd, _, _, tokens, _ := newAdmin()
+		tokens.On("DeleteByUser", user.ID).Return(nil)
+
+		_, err := cmd.RevokeTokens(user.Name)
+		require.NoError(t, err)
+		tokens.AssertCalled(t, "DeleteByUser", user.ID)
+	})
+}
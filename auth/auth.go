@@ -4,12 +4,16 @@ import (
 	"time"
 
 	"github.com/markbates/goth"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/profile"
 )
 
 const (
 	UserIDSize       = 32
 	RefreshTokenSize = 64
 	SessionIDSize    = 64
+	TokenIDSize      = 32
 )
 
 type Error struct {
@@ -20,6 +24,12 @@ func (e Error) Error() string {
 	return e.msg
 }
 
+var (
+	ErrSessionExpired = Error{msg: "session expired"}
+	ErrSessionUsed    = Error{msg: "session already used"}
+	ErrUserDisabled   = Error{msg: "user disabled"}
+)
+
 // TODO: use oauth2.Token
 type Token struct {
 	IssuedAt       int64
@@ -27,6 +37,31 @@ type Token struct {
 	AccessExpires  int64
 	Refresh        string
 	RefreshExpires int64
+	IDToken        string
+}
+
+// Authentication methods reported in the amr claim. The values follow RFC
+// 8176 where it defines one.
+const (
+	MethodExternal = "ext"
+	MethodPassword = "pwd"
+	MethodEmail    = "email"
+	MethodOTP      = "otp"
+	MethodPasskey  = "hwk"
+	MethodMFA      = "mfa"
+)
+
+// Grant describes whom the tokens are issued to. Tokens issued by refresh
+// keep the family, the provider, the client and the authentication methods of
+// the refresh token they were issued for. A grant without a user is issued to
+// the client itself.
+type Grant struct {
+	User     model.User
+	Provider string
+	Family   string
+	Client   model.Client
+	Methods  []string
+	Nonce    string
 }
 
 type Timer interface {
@@ -48,7 +83,29 @@ func (rt *RealTimer) Now() time.Time {
 }
 
 type Factory interface {
+	NewAuthorizer() Authorizer
 	NewOAuthStarter(provider goth.Provider) OAuthStarter
 	NewSignIner(provider goth.Provider) SignIner
 	NewRefresher() Refresher
+	NewCodeExchanger() CodeExchanger
+	NewCredentialsExchanger() CredentialsExchanger
+	NewRevoker() Revoker
+	NewSignUper() SignUper
+	NewPasswordSignIner() PasswordSignIner
+	NewPasswordChanger() PasswordChanger
+	NewPasswordResetter() PasswordResetter
+	NewMagicLinker() MagicLinker
+	NewMFAEnroller() MFAEnroller
+	NewMFACompleter() MFACompleter
+	NewPasskeyRegistrar() PasskeyRegistrar
+	NewPasskeySignIner() PasskeySignIner
+	NewPasskeyCompleter() PasskeyCompleter
+	NewIdentityManager() IdentityManager
+	NewProviderTokener(provider goth.Provider) ProviderTokener
+	NewSweeper() Sweeper
+	NewDeliverer() profile.Deliverer
+	NewIntrospector() Introspector
+	NewClientAuthenticator() ClientAuthenticator
+	NewUserInfoer() UserInfoer
+	NewProfileReader() ProfileReader
 }
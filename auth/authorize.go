@@ -0,0 +1,45 @@
+package auth
+
+import (
+	"github.com/vbogretsov/guard/repo"
+)
+
+// Authorizer resolves the providers an authorization request may sign in
+// with.
+type Authorizer interface {
+	Providers(req AuthRequest) ([]string, error)
+}
+
+type authorizer struct {
+	clients   repo.Clients
+	providers []string
+}
+
+func NewAuthorizer(clients repo.Clients, providers []string) Authorizer {
+	return &authorizer{
+		clients:   clients,
+		providers: providers,
+	}
+}
+
+// Providers checks the client and the redirect URI of the request and returns
+// the providers configured which the client is allowed to use.
+func (c *authorizer) Providers(req AuthRequest) ([]string, error) {
+	client, err := findClient(c.clients, req.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !listContains(client.Redirects, req.RedirectURI) {
+		return nil, ErrInvalidRedirect
+	}
+
+	allowed := []string{}
+	for _, name := range c.providers {
+		if client.Providers == "" || listContains(client.Providers, name) {
+			allowed = append(allowed, name)
+		}
+	}
+
+	return allowed, nil
+}
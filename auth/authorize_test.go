@@ -0,0 +1,64 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func TestAuthorizer(t *testing.T) {
+	providers := []string{"google", "github", "corp"}
+	req := auth.AuthRequest{ClientID: "client.123", RedirectURI: "http://app.local/callback"}
+
+	t.Run("AnyProvider", func(t *testing.T) {
+		clients := &clientsMock{}
+		clients.On("Find", "client.123").Return(model.Client{ID: "client.123", Redirects: req.RedirectURI}, nil)
+
+		names, err := auth.NewAuthorizer(clients, providers).Providers(req)
+		require.NoError(t, err)
+		require.Equal(t, providers, names)
+	})
+
+	t.Run("AllowedProviders", func(t *testing.T) {
+		clients := &clientsMock{}
+		clients.On("Find", "client.123").Return(model.Client{ID: "client.123", Redirects: req.RedirectURI, Providers: "corp google apple"}, nil)
+
+		names, err := auth.NewAuthorizer(clients, providers).Providers(req)
+		require.NoError(t, err)
+		require.Equal(t, []string{"google", "corp"}, names)
+	})
+
+	t.Run("UnknownClient", func(t *testing.T) {
+		clients := &clientsMock{}
+		clients.On("Find", "client.123").Return(model.Client{}, repo.ErrorNotFound)
+
+		_, err := auth.NewAuthorizer(clients, providers).Providers(req)
+		require.ErrorIs(t, err, auth.ErrInvalidClient)
+
+		_, err = auth.NewAuthorizer(clients, providers).Providers(auth.AuthRequest{RedirectURI: req.RedirectURI})
+		require.ErrorIs(t, err, auth.ErrInvalidClient)
+	})
+
+	t.Run("UnknownRedirect", func(t *testing.T) {
+		clients := &clientsMock{}
+		clients.On("Find", "client.123").Return(model.Client{ID: "client.123", Redirects: "http://app.local/other"}, nil)
+
+		_, err := auth.NewAuthorizer(clients, providers).Providers(req)
+		require.ErrorIs(t, err, auth.ErrInvalidRedirect)
+	})
+
+	t.Run("FailOnFind", func(t *testing.T) {
+		fail := errors.New("unexpected error")
+
+		clients := &clientsMock{}
+		clients.On("Find", "client.123").Return(model.Client{}, fail)
+
+		_, err := auth.NewAuthorizer(clients, providers).Providers(req)
+		require.ErrorIs(t, err, fail)
+	})
+}
@@ -1,35 +1,96 @@
 package auth
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
 	"github.com/markbates/goth"
+
 	"github.com/vbogretsov/guard/model"
 	"github.com/vbogretsov/guard/repo"
 )
 
 type OAuthStarter interface {
-	StartOAuth() (string, error)
+	StartOAuth(req *AuthRequest) (string, error)
+	StartLink(access string) (LinkStart, error)
+}
+
+// LinkStart is the provider URL of a link flow and the nonce binding the flow
+// to the browser which started it. The nonce has to be given back to finish
+// the link.
+type LinkStart struct {
+	URL   string
+	Nonce string
 }
 
 type oauthStarter struct {
 	ttl      time.Duration
 	timer    Timer
 	sessions repo.Sessions
+	clients  repo.Clients
+	verifier Verifier
 	provider goth.Provider
 }
 
-func NewOAuthStarter(ttl time.Duration, timer Timer, sessions repo.Sessions, provider goth.Provider) OAuthStarter {
+func NewOAuthStarter(ttl time.Duration, timer Timer, sessions repo.Sessions, clients repo.Clients, verifier Verifier, provider goth.Provider) OAuthStarter {
 	return &oauthStarter{
 		ttl:      ttl,
 		timer:    timer,
 		sessions: sessions,
+		clients:  clients,
+		verifier: verifier,
 		provider: provider,
 	}
 }
 
-func (c *oauthStarter) StartOAuth() (string, error) {
+// StartOAuth starts the provider sign in and returns the provider URL. The
+// sign in ends with a redirect to the client, a request without a registered
+// client and redirect is rejected.
+func (c *oauthStarter) StartOAuth(req *AuthRequest) (string, error) {
+	if req == nil {
+		return "", ErrInvalidClient
+	}
+
+	client, err := findClient(c.clients, req.ClientID)
+	if err != nil {
+		return "", err
+	}
+
+	if err := validateAuthRequest(*req, client, c.provider.Name()); err != nil {
+		return "", err
+	}
+
+	return c.begin(oauthSession{Request: req})
+}
+
+// StartLink starts the provider sign in linking the provider identity to the
+// access token owner and returns the provider URL together with the nonce
+// binding the link to the caller.
+func (c *oauthStarter) StartLink(access string) (LinkStart, error) {
+	var empty LinkStart
+
+	claims, err := c.verifier.Verify(access)
+	if err != nil {
+		return empty, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	if sub == "" {
+		return empty, Error{msg: "invalid token"}
+	}
+
+	nonce := generateRandomString(SessionIDSize)
+
+	url, err := c.begin(oauthSession{Link: sub, LinkNonce: nonce})
+	if err != nil {
+		return empty, err
+	}
+
+	return LinkStart{URL: url, Nonce: nonce}, nil
+}
+
+func (c *oauthStarter) begin(value oauthSession) (string, error) {
 	code := generateRandomString(SessionIDSize)
 
 	sess, err := c.provider.BeginAuth(code)
@@ -37,11 +98,18 @@ func (c *oauthStarter) StartOAuth() (string, error) {
 		return "", fmt.Errorf("provider begin auth failed: %w", err)
 	}
 
+	value.Provider = sess.Marshal()
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode session: %w", err)
+	}
+
 	now := c.timer.Now()
 
 	record := model.Session{
 		ID:      code,
-		Value:   sess.Marshal(),
+		Value:   string(data),
 		Created: now.Unix(),
 		Expires: now.Add(c.ttl).Unix(),
 	}
@@ -10,32 +10,62 @@ import (
 
 	"github.com/vbogretsov/guard/auth"
 	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
 )
 
 func TestStartOAuth(t *testing.T) {
+	client := model.Client{
+		ID:        "client.123",
+		Redirects: "http://app.local/callback http://app.local/other",
+		Providers: "google",
+	}
+
+	req := &auth.AuthRequest{
+		ClientID:            client.ID,
+		RedirectURI:         "http://app.local/callback",
+		State:               "state.123",
+		CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		CodeChallengeMethod: "S256",
+	}
+
+	newProvider := func() *providerMock {
+		provider := &providerMock{}
+		provider.On("Name").Return("google")
+		return provider
+	}
+
+	newClients := func() *clientsMock {
+		clients := &clientsMock{}
+		clients.On("Find", client.ID).Return(client, nil)
+		return clients
+	}
+
 	t.Run("Success", func(t *testing.T) {
 		ttl := 30 * time.Second
 		timer := &timerMock{value: time.Now()}
 		gSession := &sessionMock{}
 		sessions := &sessionsMock{}
-		provider := &providerMock{}
+		provider := newProvider()
 
 		session := model.Session{
-			Value:   "beginauth.session.value",
+			Value: `{"provider":"beginauth.session.value","request":{` +
+				`"client_id":"client.123","redirect_uri":"http://app.local/callback","state":"state.123",` +
+				`"code_challenge":"E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",` +
+				`"code_challenge_method":"S256"}}`,
 			Created: timer.Now().Unix(),
 			Expires: timer.Now().Add(ttl).Unix(),
 		}
 
 		authURL := "http://auth.url"
 
-		gSession.On("Marshal").Return(session.Value)
+		gSession.On("Marshal").Return("beginauth.session.value")
 		gSession.On("GetAuthURL").Return(authURL, nil)
 		provider.On("BeginAuth", mock.Anything).Return(gSession, nil)
 		sessions.On("Create", mock.MatchedBy(matchSession(session))).Return(nil)
 
-		cmd := auth.NewOAuthStarter(ttl, timer, sessions, provider)
+		cmd := auth.NewOAuthStarter(ttl, timer, sessions, newClients(), &verifierMock{}, provider)
 
-		result, err := cmd.StartOAuth()
+		result, err := cmd.StartOAuth(req)
 		require.NoError(t, err)
 		require.Equal(t, authURL, result)
 	})
@@ -44,15 +74,15 @@ func TestStartOAuth(t *testing.T) {
 		ttl := 30 * time.Second
 		timer := &timerMock{value: time.Now()}
 		sessions := &sessionsMock{}
-		provider := &providerMock{}
+		provider := newProvider()
 
 		fail := errors.New("xxx")
 
 		provider.On("BeginAuth", mock.Anything).Return(nil, fail)
 
-		cmd := auth.NewOAuthStarter(ttl, timer, sessions, provider)
+		cmd := auth.NewOAuthStarter(ttl, timer, sessions, newClients(), &verifierMock{}, provider)
 
-		_, err := cmd.StartOAuth()
+		_, err := cmd.StartOAuth(req)
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
@@ -62,7 +92,7 @@ func TestStartOAuth(t *testing.T) {
 		timer := &timerMock{value: time.Now()}
 		gSession := &sessionMock{}
 		sessions := &sessionsMock{}
-		provider := &providerMock{}
+		provider := newProvider()
 
 		session := model.Session{
 			Value:   "beginauth.session.value",
@@ -76,9 +106,9 @@ func TestStartOAuth(t *testing.T) {
 		gSession.On("Marshal").Return(session.Value)
 		sessions.On("Create", mock.Anything).Return(fail)
 
-		cmd := auth.NewOAuthStarter(ttl, timer, sessions, provider)
+		cmd := auth.NewOAuthStarter(ttl, timer, sessions, newClients(), &verifierMock{}, provider)
 
-		_, err := cmd.StartOAuth()
+		_, err := cmd.StartOAuth(req)
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
@@ -88,7 +118,7 @@ func TestStartOAuth(t *testing.T) {
 		timer := &timerMock{value: time.Now()}
 		gSession := &sessionMock{}
 		sessions := &sessionsMock{}
-		provider := &providerMock{}
+		provider := newProvider()
 
 		session := model.Session{
 			Value:   "beginauth.session.value",
@@ -103,10 +133,122 @@ func TestStartOAuth(t *testing.T) {
 		sessions.On("Create", mock.Anything).Return(nil)
 		gSession.On("GetAuthURL").Return("", fail)
 
-		cmd := auth.NewOAuthStarter(ttl, timer, sessions, provider)
+		cmd := auth.NewOAuthStarter(ttl, timer, sessions, newClients(), &verifierMock{}, provider)
 
-		_, err := cmd.StartOAuth()
+		_, err := cmd.StartOAuth(req)
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
+
+	t.Run("NoAuthRequest", func(t *testing.T) {
+		provider := newProvider()
+
+		cmd := auth.NewOAuthStarter(time.Minute, &timerMock{value: time.Now()}, &sessionsMock{}, &clientsMock{}, &verifierMock{}, provider)
+
+		_, err := cmd.StartOAuth(nil)
+		require.ErrorIs(t, err, auth.ErrInvalidClient)
+		provider.AssertNotCalled(t, "BeginAuth", mock.Anything)
+	})
+
+	t.Run("InvalidAuthRequest", func(t *testing.T) {
+		valid := auth.AuthRequest{
+			ClientID:            client.ID,
+			RedirectURI:         "http://app.local/other",
+			CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+			CodeChallengeMethod: "S256",
+		}
+
+		unknownRedirect := valid
+		unknownRedirect.RedirectURI = "http://evil.local/callback"
+
+		plainMethod := valid
+		plainMethod.CodeChallengeMethod = "plain"
+
+		shortChallenge := valid
+		shortChallenge.CodeChallenge = "xxx"
+
+		unknownClient := valid
+		unknownClient.ClientID = "client.456"
+
+		cases := map[string]struct {
+			req      auth.AuthRequest
+			provider string
+			err      error
+		}{
+			"UnknownClient":      {req: unknownClient, err: auth.ErrInvalidClient},
+			"ProviderNotAllowed": {req: valid, provider: "github", err: auth.ErrProviderNotAllowed},
+			"UnknownRedirect":    {req: unknownRedirect, err: auth.ErrInvalidRedirect},
+			"PlainMethod":        {req: plainMethod, err: auth.ErrInvalidChallenge},
+			"ShortChallenge":     {req: shortChallenge, err: auth.ErrInvalidChallenge},
+		}
+
+		for name, c := range cases {
+			t.Run(name, func(t *testing.T) {
+				provider := &providerMock{}
+				clients := &clientsMock{}
+
+				name := c.provider
+				if name == "" {
+					name = "google"
+				}
+
+				provider.On("Name").Return(name)
+				clients.On("Find", client.ID).Return(client, nil)
+				clients.On("Find", mock.Anything).Return(nil, repo.ErrorNotFound)
+
+				cmd := auth.NewOAuthStarter(time.Minute, &timerMock{value: time.Now()}, &sessionsMock{}, clients, &verifierMock{}, provider)
+
+				_, err := cmd.StartOAuth(&c.req)
+				require.ErrorIs(t, err, c.err)
+				provider.AssertNotCalled(t, "BeginAuth", mock.Anything)
+			})
+		}
+	})
+}
+
+func TestStartLink(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ttl := 30 * time.Second
+		timer := &timerMock{value: time.Now()}
+		gSession := &sessionMock{}
+		sessions := &sessionsMock{}
+		verifier := &verifierMock{}
+		provider := &providerMock{}
+
+		authURL := "http://auth.url"
+
+		verifier.On("Verify", "access.123").Return(map[string]interface{}{"sub": "user.123"}, nil)
+		gSession.On("Marshal").Return("beginauth.session.value")
+		gSession.On("GetAuthURL").Return(authURL, nil)
+		provider.On("BeginAuth", mock.Anything).Return(gSession, nil)
+		sessions.On("Create", mock.Anything).Return(nil)
+
+		cmd := auth.NewOAuthStarter(ttl, timer, sessions, &clientsMock{}, verifier, provider)
+
+		result, err := cmd.StartLink("access.123")
+		require.NoError(t, err)
+		require.Equal(t, authURL, result.URL)
+		require.Len(t, result.Nonce, auth.SessionIDSize)
+
+		session := model.Session{
+			Value:   `{"provider":"beginauth.session.value","link":"user.123","link_nonce":"` + result.Nonce + `"}`,
+			Created: timer.Now().Unix(),
+			Expires: timer.Now().Add(ttl).Unix(),
+		}
+		require.True(t, matchSession(session)(sessions.Calls[0].Arguments.Get(0).(model.Session)))
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		verifier := &verifierMock{}
+		provider := &providerMock{}
+
+		fail := auth.Error{}
+		verifier.On("Verify", "xxx").Return(nil, fail)
+
+		cmd := auth.NewOAuthStarter(time.Minute, &timerMock{value: time.Now()}, &sessionsMock{}, &clientsMock{}, verifier, provider)
+
+		_, err := cmd.StartLink("xxx")
+		require.ErrorIs(t, err, fail)
+		provider.AssertNotCalled(t, "BeginAuth", mock.Anything)
+	})
 }
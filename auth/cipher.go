@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"fmt"
+)
+
+// Cipher encrypts secrets stored at rest.
+type Cipher interface {
+	Encrypt(plain []byte) (string, error)
+	Decrypt(text string) ([]byte, error)
+}
+
+type aesCipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns an AES-GCM cipher, the key has to be 16, 24 or 32 bytes
+// long.
+func NewCipher(key []byte) (Cipher, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cipher key: %w", err)
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	return &aesCipher{aead: aead}, nil
+}
+
+// Encrypt returns the base64 encoded nonce followed by the sealed text.
+func (c *aesCipher) Encrypt(plain []byte) (string, error) {
+	nonce := generateRandomBytes(c.aead.NonceSize())
+	sealed := c.aead.Seal(nonce, nonce, plain, nil)
+	return base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *aesCipher) Decrypt(text string) ([]byte, error) {
+	data, err := base64.RawStdEncoding.DecodeString(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode secret: %w", err)
+	}
+
+	size := c.aead.NonceSize()
+	if len(data) < size {
+		return nil, fmt.Errorf("failed to decrypt secret: too short")
+	}
+
+	plain, err := c.aead.Open(nil, data[:size], data[size:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+
+	return plain, nil
+}
@@ -0,0 +1,61 @@
+package auth_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+)
+
+func TestCipher(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+
+	t.Run("RoundTrip", func(t *testing.T) {
+		cipher, err := auth.NewCipher(key)
+		require.NoError(t, err)
+
+		plain := []byte("secret.123")
+
+		text1, err := cipher.Encrypt(plain)
+		require.NoError(t, err)
+
+		text2, err := cipher.Encrypt(plain)
+		require.NoError(t, err)
+		require.NotEqual(t, text1, text2)
+
+		value, err := cipher.Decrypt(text1)
+		require.NoError(t, err)
+		require.Equal(t, plain, value)
+	})
+
+	t.Run("WrongKey", func(t *testing.T) {
+		cipher1, err := auth.NewCipher(key)
+		require.NoError(t, err)
+
+		cipher2, err := auth.NewCipher(bytes.Repeat([]byte{2}, 32))
+		require.NoError(t, err)
+
+		text, err := cipher1.Encrypt([]byte("secret.123"))
+		require.NoError(t, err)
+
+		_, err = cipher2.Decrypt(text)
+		require.Error(t, err)
+	})
+
+	t.Run("InvalidText", func(t *testing.T) {
+		cipher, err := auth.NewCipher(key)
+		require.NoError(t, err)
+
+		for _, text := range []string{"", "xxx", "!!!"} {
+			_, err := cipher.Decrypt(text)
+			require.Error(t, err, text)
+		}
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		_, err := auth.NewCipher([]byte("short"))
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+var reservedClaims = map[string]bool{
+	"iss":       true,
+	"sub":       true,
+	"aud":       true,
+	"exp":       true,
+	"nbf":       true,
+	"iat":       true,
+	"jti":       true,
+	"email":     true,
+	"provider":  true,
+	"client_id": true,
+	"scope":     true,
+	"amr":       true,
+	"token_use": true,
+}
+
+// Claims configures the claims added to access tokens.
+type Claims struct {
+	Issuer   string
+	Audience []string
+	Extra    ExtraClaims
+}
+
+// ExtraClaims are operator defined claims. String values are rendered as
+// text/template with the Grant as data, e.g. "{{.User.ID}}" or
+// "{{.Provider}}", other values are copied as is.
+type ExtraClaims map[string]interface{}
+
+// ParseExtraClaims parses extra claims from a JSON object.
+func ParseExtraClaims(data string) (ExtraClaims, error) {
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(data), &raw); err != nil {
+		return nil, fmt.Errorf("invalid extra claims: %w", err)
+	}
+
+	extra := ExtraClaims{}
+	for name, value := range raw {
+		if reservedClaims[name] {
+			return nil, fmt.Errorf("extra claim %s overrides a registered claim", name)
+		}
+
+		if text, ok := value.(string); ok && strings.Contains(text, "{{") {
+			tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+			if err != nil {
+				return nil, fmt.Errorf("invalid extra claim %s: %w", name, err)
+			}
+			value = tmpl
+		}
+
+		extra[name] = value
+	}
+
+	return extra, nil
+}
+
+func (ec ExtraClaims) render(grant Grant, claims map[string]interface{}) error {
+	for name, value := range ec {
+		if tmpl, ok := value.(*template.Template); ok {
+			buf := bytes.Buffer{}
+			if err := tmpl.Execute(&buf, grant); err != nil {
+				return fmt.Errorf("failed to render claim %s: %w", name, err)
+			}
+			value = buf.String()
+		}
+
+		claims[name] = value
+	}
+
+	return nil
+}
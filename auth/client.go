@@ -0,0 +1,79 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+var (
+	ErrInvalidClient      = Error{msg: "invalid client"}
+	ErrProviderNotAllowed = Error{msg: "provider not allowed"}
+)
+
+// ClientAuthenticator checks the credentials of confidential clients.
+type ClientAuthenticator interface {
+	Authenticate(clientID, secret string) (model.Client, error)
+}
+
+type clientAuthenticator struct {
+	clients repo.Clients
+}
+
+func NewClientAuthenticator(clients repo.Clients) ClientAuthenticator {
+	return &clientAuthenticator{clients: clients}
+}
+
+// Authenticate returns the client if the secret matches. Public clients have
+// no secret and never authenticate.
+func (c *clientAuthenticator) Authenticate(clientID, secret string) (model.Client, error) {
+	client, err := findClient(c.clients, clientID)
+	if err != nil {
+		return client, err
+	}
+
+	if client.SecretHash == "" || !verifySecret(client.SecretHash, secret) {
+		return model.Client{}, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// HashSecret hashes a client secret to be stored in the clients table.
+func HashSecret(secret string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash secret: %w", err)
+	}
+	return string(hash), nil
+}
+
+func verifySecret(hash, secret string) bool {
+	return secret != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(secret)) == nil
+}
+
+func findClient(clients repo.Clients, clientID string) (model.Client, error) {
+	if clientID == "" {
+		return model.Client{}, ErrInvalidClient
+	}
+
+	client, err := clients.Find(clientID)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return client, ErrInvalidClient
+		}
+		return client, fmt.Errorf("failed to find client: %w", err)
+	}
+
+	return client, nil
+}
+
+// listContains tells whether the space separated list contains the value.
+func listContains(list, value string) bool {
+	return containsString(strings.Fields(list), value)
+}
@@ -0,0 +1,94 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+type clientsMock struct {
+	mock.Mock
+}
+
+func (m *clientsMock) Find(id string) (model.Client, error) {
+	args := m.Called(id)
+
+	client := args.Get(0)
+	if client == nil {
+		return model.Client{}, args.Error(1)
+	}
+
+	return client.(model.Client), args.Error(1)
+}
+
+func (m *clientsMock) Create(client model.Client) error {
+	return m.Called(client).Error(0)
+}
+
+func (m *clientsMock) Save(client model.Client) error {
+	return m.Called(client).Error(0)
+}
+
+func (m *clientsMock) Delete(id string) error {
+	return m.Called(id).Error(0)
+}
+
+func hashSecret(t *testing.T, secret string) string {
+	hash, err := auth.HashSecret(secret)
+	require.NoError(t, err)
+	return hash
+}
+
+func TestClientAuthenticator(t *testing.T) {
+	confidential := model.Client{ID: "client.1", SecretHash: hashSecret(t, "secret.123")}
+	public := model.Client{ID: "client.2"}
+
+	clients := &clientsMock{}
+	clients.On("Find", confidential.ID).Return(confidential, nil)
+	clients.On("Find", public.ID).Return(public, nil)
+	clients.On("Find", "client.3").Return(nil, repo.ErrorNotFound)
+
+	cmd := auth.NewClientAuthenticator(clients)
+
+	t.Run("Success", func(t *testing.T) {
+		client, err := cmd.Authenticate(confidential.ID, "secret.123")
+		require.NoError(t, err)
+		require.Equal(t, confidential, client)
+	})
+
+	t.Run("WrongSecret", func(t *testing.T) {
+		_, err := cmd.Authenticate(confidential.ID, "xxx")
+		require.ErrorIs(t, err, auth.ErrInvalidClient)
+	})
+
+	t.Run("PublicClient", func(t *testing.T) {
+		_, err := cmd.Authenticate(public.ID, "")
+		require.ErrorIs(t, err, auth.ErrInvalidClient)
+	})
+
+	t.Run("UnknownClient", func(t *testing.T) {
+		_, err := cmd.Authenticate("client.3", "secret.123")
+		require.ErrorIs(t, err, auth.ErrInvalidClient)
+	})
+
+	t.Run("MissingClient", func(t *testing.T) {
+		_, err := cmd.Authenticate("", "")
+		require.ErrorIs(t, err, auth.ErrInvalidClient)
+	})
+
+	t.Run("FindFailed", func(t *testing.T) {
+		clients := &clientsMock{}
+
+		fail := errors.New("xxx")
+		clients.On("Find", "client.1").Return(nil, fail)
+
+		_, err := auth.NewClientAuthenticator(clients).Authenticate("client.1", "secret.123")
+		require.ErrorIs(t, err, fail)
+	})
+}
@@ -0,0 +1,234 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+const (
+	codeSessionPrefix = "code:"
+	codeChallengeS256 = "S256"
+	minVerifierSize   = 43
+	maxVerifierSize   = 128
+)
+
+var (
+	ErrInvalidRedirect  = Error{msg: "invalid redirect_uri"}
+	ErrInvalidChallenge = Error{msg: "invalid code_challenge"}
+	ErrInvalidGrant     = Error{msg: "invalid grant"}
+)
+
+// AuthRequest is a client request to sign in through a provider and to get
+// an authorization code back at the redirect URI. PKCE with S256 is required.
+// The nonce is copied to the id token issued for the code.
+type AuthRequest struct {
+	ClientID            string `json:"client_id"`
+	RedirectURI         string `json:"redirect_uri"`
+	State               string `json:"state,omitempty"`
+	Nonce               string `json:"nonce,omitempty"`
+	CodeChallenge       string `json:"code_challenge"`
+	CodeChallengeMethod string `json:"code_challenge_method"`
+}
+
+// oauthSession is the value of the session created when a sign in starts. The
+// link is the user the provider identity is linked to instead of signing in.
+type oauthSession struct {
+	Provider  string       `json:"provider"`
+	Request   *AuthRequest `json:"request,omitempty"`
+	Link      string       `json:"link,omitempty"`
+	LinkNonce string       `json:"link_nonce,omitempty"`
+}
+
+// authCode is the value of the session behind an authorization code.
+type authCode struct {
+	UserID   string      `json:"user_id"`
+	Provider string      `json:"provider"`
+	Methods  []string    `json:"methods,omitempty"`
+	Request  AuthRequest `json:"request"`
+}
+
+// validateAuthRequest checks the request against the client registration.
+// A client without providers listed may use any provider.
+func validateAuthRequest(req AuthRequest, client model.Client, provider string) error {
+	if !listContains(client.Redirects, req.RedirectURI) {
+		return ErrInvalidRedirect
+	}
+
+	if client.Providers != "" && !listContains(client.Providers, provider) {
+		return ErrProviderNotAllowed
+	}
+
+	if req.CodeChallengeMethod != codeChallengeS256 {
+		return ErrInvalidChallenge
+	}
+
+	if len(req.CodeChallenge) < minVerifierSize || len(req.CodeChallenge) > maxVerifierSize {
+		return ErrInvalidChallenge
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyChallenge(challenge, verifier string) bool {
+	if len(verifier) < minVerifierSize || len(verifier) > maxVerifierSize {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func redirectWithCode(req AuthRequest, code string) (string, error) {
+	u, err := url.Parse(req.RedirectURI)
+	if err != nil {
+		return "", fmt.Errorf("invalid redirect uri: %w", err)
+	}
+
+	query := u.Query()
+	query.Set("code", code)
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	u.RawQuery = query.Encode()
+
+	return u.String(), nil
+}
+
+// createCode stores a one-time authorization code for the user signed in and
+// returns the client redirect URL carrying it.
+func createCode(sessions repo.Sessions, timer Timer, ttl time.Duration, value authCode) (string, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode authorization code: %w", err)
+	}
+
+	code := generateRandomString(SessionIDSize)
+	now := timer.Now()
+
+	record := model.Session{
+		ID:      codeSessionPrefix + code,
+		Value:   string(data),
+		Created: now.Unix(),
+		Expires: now.Add(ttl).Unix(),
+	}
+
+	if err := sessions.Create(record); err != nil {
+		return "", fmt.Errorf("failed to create authorization code: %w", err)
+	}
+
+	return redirectWithCode(value.Request, code)
+}
+
+// CodeGrant is an authorization code token request. The client secret is
+// required for confidential clients only.
+type CodeGrant struct {
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	ClientID     string
+	ClientSecret string
+}
+
+type CodeExchanger interface {
+	Exchange(grant CodeGrant) (Token, error)
+}
+
+type codeExchanger struct {
+	timer    Timer
+	sessions repo.Sessions
+	users    repo.Users
+	clients  repo.Clients
+	issuer   Issuer
+}
+
+func NewCodeExchanger(timer Timer, sessions repo.Sessions, users repo.Users, clients repo.Clients, issuer Issuer) CodeExchanger {
+	return &codeExchanger{
+		timer:    timer,
+		sessions: sessions,
+		users:    users,
+		clients:  clients,
+		issuer:   issuer,
+	}
+}
+
+// Exchange consumes the authorization code and issues the token pair if the
+// redirect URI and the client match the authorization request and the code
+// verifier matches the code challenge.
+func (c *codeExchanger) Exchange(grant CodeGrant) (Token, error) {
+	var empty Token
+
+	if grant.Code == "" || strings.Contains(grant.Code, ":") {
+		return empty, ErrInvalidGrant
+	}
+
+	session, err := c.sessions.Consume(codeSessionPrefix + grant.Code)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) || errors.Is(err, repo.ErrorConsumed) {
+			return empty, ErrInvalidGrant
+		}
+		return empty, fmt.Errorf("failed to consume authorization code: %w", err)
+	}
+
+	if session.Expires < c.timer.Now().Unix() {
+		return empty, ErrInvalidGrant
+	}
+
+	var value authCode
+	if err := json.Unmarshal([]byte(session.Value), &value); err != nil {
+		return empty, fmt.Errorf("failed to decode authorization code: %w", err)
+	}
+
+	if value.Request.RedirectURI != grant.RedirectURI || value.Request.ClientID != grant.ClientID {
+		return empty, ErrInvalidGrant
+	}
+
+	if !verifyChallenge(value.Request.CodeChallenge, grant.CodeVerifier) {
+		return empty, ErrInvalidGrant
+	}
+
+	client, err := findClient(c.clients, grant.ClientID)
+	if err != nil {
+		return empty, err
+	}
+
+	if client.SecretHash != "" && !verifySecret(client.SecretHash, grant.ClientSecret) {
+		return empty, ErrInvalidClient
+	}
+
+	user, err := c.users.FindByID(value.UserID)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, ErrInvalidGrant
+		}
+		return empty, err
+	}
+
+	return c.issuer.Issue(Grant{
+		User:     user,
+		Provider: value.Provider,
+		Client:   client,
+		Methods:  value.Methods,
+		Nonce:    value.Request.Nonce,
+	})
+}
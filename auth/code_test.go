@@ -0,0 +1,220 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+// Code verifier and challenge from RFC 7636 appendix B.
+const (
+	codeVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	codeChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func codeSession(t *testing.T, expires int64) model.Session {
+	value, err := json.Marshal(map[string]interface{}{
+		"user_id":  "user.123",
+		"provider": "google",
+		"request": auth.AuthRequest{
+			ClientID:            "client.123",
+			RedirectURI:         "http://app.local/callback",
+			Nonce:               "nonce.123",
+			CodeChallenge:       codeChallenge,
+			CodeChallengeMethod: "S256",
+		},
+	})
+	require.NoError(t, err)
+
+	return model.Session{
+		ID:      "code:code.123",
+		Value:   string(value),
+		Created: 1600000000,
+		Expires: expires,
+	}
+}
+
+func TestCodeExchanger(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	client := model.Client{ID: "client.123", Redirects: "http://app.local/callback"}
+	redirect := "http://app.local/callback"
+
+	newClients := func() *clientsMock {
+		clients := &clientsMock{}
+		clients.On("Find", client.ID).Return(client, nil)
+		return clients
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000030, 0)}
+		sessions := &sessionsMock{}
+		users := &usersMock{}
+		issuer := &issuerMock{}
+
+		token := auth.Token{Access: "access.123"}
+
+		sessions.On("Consume", "code:code.123").Return(codeSession(t, 1600000060), nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+		issuer.On("Issue", auth.Grant{User: user, Provider: "google", Client: client, Nonce: "nonce.123"}).Return(token, nil)
+
+		cmd := auth.NewCodeExchanger(timer, sessions, users, newClients(), issuer)
+
+		result, err := cmd.Exchange(auth.CodeGrant{
+			Code:         "code.123",
+			RedirectURI:  redirect,
+			CodeVerifier: codeVerifier,
+			ClientID:     client.ID,
+		})
+		require.NoError(t, err)
+		require.Equal(t, token, result)
+	})
+
+	t.Run("InvalidGrant", func(t *testing.T) {
+		cases := map[string]struct {
+			code     string
+			redirect string
+			verifier string
+			clientID string
+			expires  int64
+		}{
+			"Expired":       {"code.123", redirect, codeVerifier, "client.123", 1600000010},
+			"OtherRedirect": {"code.123", "http://evil.local", codeVerifier, "client.123", 1600000060},
+			"OtherClient":   {"code.123", redirect, codeVerifier, "client.456", 1600000060},
+			"WrongVerifier": {"code.123", redirect, codeChallenge, "client.123", 1600000060},
+		}
+
+		for name, c := range cases {
+			t.Run(name, func(t *testing.T) {
+				timer := &timerMock{value: time.Unix(1600000030, 0)}
+				sessions := &sessionsMock{}
+				issuer := &issuerMock{}
+
+				sessions.On("Consume", "code:"+c.code).Return(codeSession(t, c.expires), nil)
+
+				cmd := auth.NewCodeExchanger(timer, sessions, &usersMock{}, newClients(), issuer)
+
+				_, err := cmd.Exchange(auth.CodeGrant{
+					Code:         c.code,
+					RedirectURI:  c.redirect,
+					CodeVerifier: c.verifier,
+					ClientID:     c.clientID,
+				})
+				require.ErrorIs(t, err, auth.ErrInvalidGrant)
+				issuer.AssertNotCalled(t, "Issue", mock.Anything)
+			})
+		}
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		sessions.On("Consume", "code:xxx").Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewCodeExchanger(&timerMock{value: time.Now()}, sessions, &usersMock{}, newClients(), &issuerMock{})
+
+		_, err := cmd.Exchange(auth.CodeGrant{Code: "xxx", RedirectURI: redirect, CodeVerifier: codeVerifier})
+		require.ErrorIs(t, err, auth.ErrInvalidGrant)
+	})
+
+	t.Run("Reused", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		sessions.On("Consume", "code:xxx").Return(model.Session{}, repo.ErrorConsumed)
+
+		cmd := auth.NewCodeExchanger(&timerMock{value: time.Now()}, sessions, &usersMock{}, newClients(), &issuerMock{})
+
+		_, err := cmd.Exchange(auth.CodeGrant{Code: "xxx", RedirectURI: redirect, CodeVerifier: codeVerifier})
+		require.ErrorIs(t, err, auth.ErrInvalidGrant)
+	})
+
+	t.Run("ConsumeFailed", func(t *testing.T) {
+		sessions := &sessionsMock{}
+
+		fail := errors.New("xxx")
+		sessions.On("Consume", "code:xxx").Return(nil, fail)
+
+		cmd := auth.NewCodeExchanger(&timerMock{value: time.Now()}, sessions, &usersMock{}, newClients(), &issuerMock{})
+
+		_, err := cmd.Exchange(auth.CodeGrant{Code: "xxx", RedirectURI: redirect, CodeVerifier: codeVerifier})
+		require.ErrorIs(t, err, fail)
+	})
+
+	t.Run("UserDeleted", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000030, 0)}
+		sessions := &sessionsMock{}
+		users := &usersMock{}
+
+		sessions.On("Consume", "code:code.123").Return(codeSession(t, 1600000060), nil)
+		users.On("FindByID", user.ID).Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewCodeExchanger(timer, sessions, users, newClients(), &issuerMock{})
+
+		_, err := cmd.Exchange(auth.CodeGrant{
+			Code:         "code.123",
+			RedirectURI:  redirect,
+			CodeVerifier: codeVerifier,
+			ClientID:     client.ID,
+		})
+		require.ErrorIs(t, err, auth.ErrInvalidGrant)
+	})
+
+	t.Run("PrefixedCode", func(t *testing.T) {
+		sessions := &sessionsMock{}
+
+		cmd := auth.NewCodeExchanger(&timerMock{value: time.Now()}, sessions, &usersMock{}, newClients(), &issuerMock{})
+
+		_, err := cmd.Exchange(auth.CodeGrant{Code: "code:xxx", RedirectURI: redirect, CodeVerifier: codeVerifier})
+		require.ErrorIs(t, err, auth.ErrInvalidGrant)
+		sessions.AssertNotCalled(t, "Consume", mock.Anything)
+	})
+
+	t.Run("ConfidentialClient", func(t *testing.T) {
+		confidential := client
+		confidential.SecretHash = hashSecret(t, "secret.123")
+
+		cases := map[string]struct {
+			secret string
+			err    error
+		}{
+			"Authenticated": {secret: "secret.123"},
+			"WrongSecret":   {secret: "xxx", err: auth.ErrInvalidClient},
+			"MissingSecret": {secret: "", err: auth.ErrInvalidClient},
+		}
+
+		for name, c := range cases {
+			t.Run(name, func(t *testing.T) {
+				timer := &timerMock{value: time.Unix(1600000030, 0)}
+				sessions := &sessionsMock{}
+				users := &usersMock{}
+				clients := &clientsMock{}
+				issuer := &issuerMock{}
+
+				sessions.On("Consume", "code:code.123").Return(codeSession(t, 1600000060), nil)
+				clients.On("Find", client.ID).Return(confidential, nil)
+				users.On("FindByID", user.ID).Return(user, nil)
+				issuer.On("Issue", mock.Anything).Return(auth.Token{}, nil)
+
+				cmd := auth.NewCodeExchanger(timer, sessions, users, clients, issuer)
+
+				_, err := cmd.Exchange(auth.CodeGrant{
+					Code:         "code.123",
+					RedirectURI:  redirect,
+					CodeVerifier: codeVerifier,
+					ClientID:     client.ID,
+					ClientSecret: c.secret,
+				})
+				if c.err == nil {
+					require.NoError(t, err)
+				} else {
+					require.ErrorIs(t, err, c.err)
+				}
+			})
+		}
+	})
+}
@@ -0,0 +1,28 @@
+package auth
+
+// CredentialsExchanger issues access tokens to confidential clients by the
+// client credentials grant.
+type CredentialsExchanger interface {
+	Exchange(clientID, secret string) (Token, error)
+}
+
+type credentialsExchanger struct {
+	authenticator ClientAuthenticator
+	issuer        Issuer
+}
+
+func NewCredentialsExchanger(authenticator ClientAuthenticator, issuer Issuer) CredentialsExchanger {
+	return &credentialsExchanger{
+		authenticator: authenticator,
+		issuer:        issuer,
+	}
+}
+
+func (c *credentialsExchanger) Exchange(clientID, secret string) (Token, error) {
+	client, err := c.authenticator.Authenticate(clientID, secret)
+	if err != nil {
+		return Token{}, err
+	}
+
+	return c.issuer.Issue(Grant{Client: client})
+}
@@ -0,0 +1,56 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+)
+
+func TestCredentialsExchanger(t *testing.T) {
+	confidential := model.Client{ID: "client.1", SecretHash: hashSecret(t, "secret.123"), Scopes: "users:read"}
+	public := model.Client{ID: "client.2"}
+
+	t.Run("Success", func(t *testing.T) {
+		clients := &clientsMock{}
+		issuer := &issuerMock{}
+
+		token := auth.Token{Access: "access.123"}
+
+		clients.On("Find", confidential.ID).Return(confidential, nil)
+		issuer.On("Issue", auth.Grant{Client: confidential}).Return(token, nil)
+
+		cmd := auth.NewCredentialsExchanger(auth.NewClientAuthenticator(clients), issuer)
+
+		result, err := cmd.Exchange(confidential.ID, "secret.123")
+		require.NoError(t, err)
+		require.Equal(t, token, result)
+	})
+
+	cases := map[string]struct {
+		clientID string
+		secret   string
+	}{
+		"WrongSecret":  {clientID: confidential.ID, secret: "xxx"},
+		"PublicClient": {clientID: public.ID},
+		"NoClient":     {},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			clients := &clientsMock{}
+			issuer := &issuerMock{}
+
+			clients.On("Find", confidential.ID).Return(confidential, nil)
+			clients.On("Find", public.ID).Return(public, nil)
+
+			cmd := auth.NewCredentialsExchanger(auth.NewClientAuthenticator(clients), issuer)
+
+			_, err := cmd.Exchange(c.clientID, c.secret)
+			require.ErrorIs(t, err, auth.ErrInvalidClient)
+			issuer.AssertNotCalled(t, "Issue")
+		})
+	}
+}
@@ -0,0 +1,331 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/markbates/goth"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/profile"
+	"github.com/vbogretsov/guard/repo"
+)
+
+// Policies of linking a new provider identity to the existing user with the
+// same email.
+const (
+	LinkNever    = "never"
+	LinkVerified = "verified"
+	LinkAlways   = "always"
+)
+
+var (
+	ErrIdentityConflict = Error{msg: "account already exists, sign in and link the provider"}
+	ErrIdentityLinked   = Error{msg: "identity already linked to another user"}
+	ErrIdentityNotFound = Error{msg: "identity not found"}
+	ErrLastIdentity     = Error{msg: "unable to unlink the last sign in method"}
+	ErrLinkNonce        = Error{msg: "identity link was started by another browser"}
+	ErrMissingSubject   = Error{msg: "provider returned no user id"}
+)
+
+// ValidLinkPolicy reports whether the link policy is known.
+func ValidLinkPolicy(policy string) bool {
+	switch policy {
+	case LinkNever, LinkVerified, LinkAlways:
+		return true
+	}
+	return false
+}
+
+// IdentityInfo is a linked provider identity shown to its owner.
+type IdentityInfo struct {
+	Provider string `json:"provider"`
+	Email    string `json:"email,omitempty"`
+	Created  int64  `json:"created"`
+}
+
+func newIdentityInfo(identity model.UserIdentity) IdentityInfo {
+	return IdentityInfo{
+		Provider: identity.Provider,
+		Email:    identity.Email,
+		Created:  identity.Created,
+	}
+}
+
+type IdentityResolver interface {
+	Resolve(provider string, gUser goth.User) (model.User, error)
+	Link(userID, provider string, gUser goth.User) (IdentityInfo, error)
+}
+
+type identityResolver struct {
+	tx         repo.Transaction
+	timer      Timer
+	users      repo.Users
+	identities repo.Identities
+	policy     string
+}
+
+func NewIdentityResolver(tx repo.Transaction, timer Timer, users repo.Users, identities repo.Identities, policy string) IdentityResolver {
+	return &identityResolver{
+		tx:         tx,
+		timer:      timer,
+		users:      users,
+		identities: identities,
+		policy:     policy,
+	}
+}
+
+func (c *identityResolver) canLink(gUser goth.User) bool {
+	switch c.policy {
+	case LinkAlways:
+		return true
+	case LinkVerified:
+		return profile.EmailVerified(gUser)
+	}
+	return false
+}
+
+func (c *identityResolver) create(userID, provider string, gUser goth.User) (model.UserIdentity, error) {
+	identity := model.UserIdentity{
+		Provider: provider,
+		Subject:  gUser.UserID,
+		UserID:   userID,
+		Email:    gUser.Email,
+		Created:  c.timer.Now().Unix(),
+	}
+
+	if err := c.identities.Create(identity); err != nil {
+		return identity, fmt.Errorf("failed to create identity: %w", err)
+	}
+
+	return identity, nil
+}
+
+// Resolve returns the user the provider identity is linked to. A new identity
+// is linked to the user with the same email if the link policy allows it and
+// the user has proven owning the email, otherwise a new user is created. Users
+// of the providers returning no email are named by the provider and the
+// provider user ID.
+func (c *identityResolver) Resolve(provider string, gUser goth.User) (model.User, error) {
+	var empty model.User
+
+	if gUser.UserID == "" {
+		return empty, ErrMissingSubject
+	}
+
+	if err := c.tx.Begin(); err != nil {
+		return empty, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer c.tx.Close()
+
+	identity, err := c.identities.Find(provider, gUser.UserID)
+	if err == nil {
+		return c.users.FindByID(identity.UserID)
+	}
+	if !errors.Is(err, repo.ErrorNotFound) {
+		return empty, fmt.Errorf("failed to find identity: %w", err)
+	}
+
+	name := gUser.Email
+	if name == "" {
+		name = provider + ":" + gUser.UserID
+	}
+
+	user, err := c.users.Find(name)
+	switch {
+	case err == nil:
+		// A password account is not verified on sign up, so anyone could
+		// register it in advance to receive the identity of the email owner.
+		if gUser.Email == "" || !user.Verified || !c.canLink(gUser) {
+			return empty, ErrIdentityConflict
+		}
+	case errors.Is(err, repo.ErrorNotFound):
+		user = model.User{
+			ID:       generateRandomString(UserIDSize),
+			Name:     name,
+			Verified: gUser.Email != "" && profile.EmailVerified(gUser),
+			Created:  c.timer.Now().Unix(),
+		}
+
+		if err := c.users.Create(user); err != nil {
+			return empty, err
+		}
+	default:
+		return empty, err
+	}
+
+	if _, err := c.create(user.ID, provider, gUser); err != nil {
+		return empty, err
+	}
+
+	if err := c.tx.Commit(); err != nil {
+		return empty, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return user, nil
+}
+
+// Link links the provider identity to the user signed in.
+func (c *identityResolver) Link(userID, provider string, gUser goth.User) (IdentityInfo, error) {
+	var empty IdentityInfo
+
+	if gUser.UserID == "" {
+		return empty, ErrMissingSubject
+	}
+
+	identity, err := c.identities.Find(provider, gUser.UserID)
+	if err == nil {
+		if identity.UserID != userID {
+			return empty, ErrIdentityLinked
+		}
+		return newIdentityInfo(identity), nil
+	}
+	if !errors.Is(err, repo.ErrorNotFound) {
+		return empty, fmt.Errorf("failed to find identity: %w", err)
+	}
+
+	identity, err = c.create(userID, provider, gUser)
+	if err != nil {
+		return empty, err
+	}
+
+	return newIdentityInfo(identity), nil
+}
+
+// IdentityManager lists and unlinks the provider identities of the access
+// token owner.
+type IdentityManager interface {
+	List(access string) ([]IdentityInfo, error)
+	Unlink(access, provider string) error
+}
+
+type identityManager struct {
+	tx          repo.Transaction
+	verifier    Verifier
+	identities  repo.Identities
+	profiles    repo.Profiles
+	tokens      repo.ProviderTokens
+	credentials repo.Credentials
+	passkeys    repo.Passkeys
+}
+
+func NewIdentityManager(tx repo.Transaction, verifier Verifier, identities repo.Identities, profiles repo.Profiles, tokens repo.ProviderTokens, credentials repo.Credentials, passkeys repo.Passkeys) IdentityManager {
+	return &identityManager{
+		tx:          tx,
+		verifier:    verifier,
+		identities:  identities,
+		profiles:    profiles,
+		tokens:      tokens,
+		credentials: credentials,
+		passkeys:    passkeys,
+	}
+}
+
+func (c *identityManager) subject(access string) (string, error) {
+	claims, err := c.verifier.Verify(access)
+	if err != nil {
+		return "", err
+	}
+
+	sub, _ := claims["sub"].(string)
+	return sub, nil
+}
+
+func (c *identityManager) List(access string) ([]IdentityInfo, error) {
+	sub, err := c.subject(access)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := c.identities.FindByUser(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find identities: %w", err)
+	}
+
+	infos := []IdentityInfo{}
+	for _, item := range items {
+		infos = append(infos, newIdentityInfo(item))
+	}
+
+	return infos, nil
+}
+
+// hasOtherMethod reports whether the user can sign in without the identities
+// of the provider.
+func (c *identityManager) hasOtherMethod(userID, provider string) (bool, error) {
+	items, err := c.identities.FindByUser(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to find identities: %w", err)
+	}
+
+	found := false
+	for _, item := range items {
+		if item.Provider != provider {
+			return true, nil
+		}
+		found = true
+	}
+
+	if !found {
+		return false, ErrIdentityNotFound
+	}
+
+	_, err = c.credentials.Find(userID)
+	if err == nil {
+		return true, nil
+	}
+	if !errors.Is(err, repo.ErrorNotFound) {
+		return false, fmt.Errorf("failed to find credential: %w", err)
+	}
+
+	keys, err := c.passkeys.FindByUser(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+
+	return len(keys) > 0, nil
+}
+
+// Unlink removes the identities of the provider together with their profiles
+// and tokens unless the user would be left without a way to sign in.
+func (c *identityManager) Unlink(access, provider string) error {
+	sub, err := c.subject(access)
+	if err != nil {
+		return err
+	}
+
+	if err := c.tx.Begin(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer c.tx.Close()
+
+	ok, err := c.hasOtherMethod(sub, provider)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrLastIdentity
+	}
+
+	if err := c.identities.Delete(sub, provider); err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return ErrIdentityNotFound
+		}
+		return err
+	}
+
+	if err := c.profiles.Delete(sub, provider); err != nil {
+		return fmt.Errorf("failed to delete profiles: %w", err)
+	}
+
+	if err := c.tokens.Delete(sub, provider); err != nil {
+		return fmt.Errorf("failed to delete provider tokens: %w", err)
+	}
+
+	if err := c.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,403 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+type identitiesMock struct {
+	mock.Mock
+}
+
+func (m *identitiesMock) Find(provider, subject string) (model.UserIdentity, error) {
+	args := m.Called(provider, subject)
+
+	value := args.Get(0)
+	if value == nil {
+		return model.UserIdentity{}, args.Error(1)
+	}
+
+	return value.(model.UserIdentity), args.Error(1)
+}
+
+func (m *identitiesMock) FindByUser(userID string) ([]model.UserIdentity, error) {
+	args := m.Called(userID)
+
+	value := args.Get(0)
+	if value == nil {
+		return nil, args.Error(1)
+	}
+
+	return value.([]model.UserIdentity), args.Error(1)
+}
+
+func (m *identitiesMock) Create(identity model.UserIdentity) error {
+	return m.Called(identity).Error(0)
+}
+
+func (m *identitiesMock) Delete(userID, provider string) error {
+	return m.Called(userID, provider).Error(0)
+}
+
+func TestIdentityResolver(t *testing.T) {
+	now := time.Unix(1600000050, 0)
+	user := model.User{ID: "user.123", Name: "u0@mail.org", Created: 1600000000}
+	owner := model.User{ID: user.ID, Name: user.Name, Verified: true, Created: user.Created}
+
+	gUser := goth.User{UserID: "google.123", Email: user.Name}
+	verified := goth.User{
+		UserID:  "google.123",
+		Email:   user.Name,
+		RawData: map[string]interface{}{"email_verified": true},
+	}
+
+	identity := model.UserIdentity{
+		Provider: "google",
+		Subject:  "google.123",
+		UserID:   user.ID,
+		Email:    user.Name,
+		Created:  now.Unix(),
+	}
+
+	newResolver := func(tx *transactionMock, users *usersMock, identities *identitiesMock, policy string) auth.IdentityResolver {
+		return auth.NewIdentityResolver(tx, &timerMock{value: now}, users, identities, policy)
+	}
+
+	t.Run("Linked", func(t *testing.T) {
+		users := &usersMock{}
+		identities := &identitiesMock{}
+
+		identities.On("Find", "google", "google.123").Return(identity, nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+
+		cmd := newResolver(newTransactionMock(), users, identities, auth.LinkNever)
+
+		result, err := cmd.Resolve("google", gUser)
+		require.NoError(t, err)
+		require.Equal(t, user, result)
+		users.AssertNotCalled(t, "Find", mock.Anything)
+		identities.AssertNotCalled(t, "Create", mock.Anything)
+	})
+
+	t.Run("NewUser", func(t *testing.T) {
+		tx := newTransactionMock()
+		users := &usersMock{}
+		identities := &identitiesMock{}
+
+		identities.On("Find", "google", "google.123").Return(nil, repo.ErrorNotFound)
+		users.On("Find", user.Name).Return(nil, repo.ErrorNotFound)
+		users.On("Create", mock.Anything).Return(nil)
+		identities.On("Create", mock.Anything).Return(nil)
+
+		cmd := newResolver(tx, users, identities, auth.LinkNever)
+
+		result, err := cmd.Resolve("google", gUser)
+		require.NoError(t, err)
+		require.NotEmpty(t, result.ID)
+		require.Equal(t, user.Name, result.Name)
+		require.Equal(t, now.Unix(), result.Created)
+		require.False(t, result.Verified)
+
+		created := identities.Calls[1].Arguments.Get(0).(model.UserIdentity)
+		require.Equal(t, result.ID, created.UserID)
+		require.Equal(t, "google", created.Provider)
+		require.Equal(t, "google.123", created.Subject)
+		tx.AssertCalled(t, "Commit")
+	})
+
+	t.Run("NewVerifiedUser", func(t *testing.T) {
+		users := &usersMock{}
+		identities := &identitiesMock{}
+
+		identities.On("Find", "google", "google.123").Return(nil, repo.ErrorNotFound)
+		users.On("Find", user.Name).Return(nil, repo.ErrorNotFound)
+		users.On("Create", mock.Anything).Return(nil)
+		identities.On("Create", mock.Anything).Return(nil)
+
+		cmd := newResolver(newTransactionMock(), users, identities, auth.LinkNever)
+
+		result, err := cmd.Resolve("google", verified)
+		require.NoError(t, err)
+		require.True(t, result.Verified)
+	})
+
+	t.Run("NoEmail", func(t *testing.T) {
+		users := &usersMock{}
+		identities := &identitiesMock{}
+
+		noEmail := goth.User{UserID: "twitter.123"}
+
+		identities.On("Find", "twitter", "twitter.123").Return(nil, repo.ErrorNotFound)
+		users.On("Find", "twitter:twitter.123").Return(nil, repo.ErrorNotFound)
+		users.On("Create", mock.Anything).Return(nil)
+		identities.On("Create", mock.Anything).Return(nil)
+
+		cmd := newResolver(newTransactionMock(), users, identities, auth.LinkAlways)
+
+		result, err := cmd.Resolve("twitter", noEmail)
+		require.NoError(t, err)
+		require.Equal(t, "twitter:twitter.123", result.Name)
+	})
+
+	t.Run("Policy", func(t *testing.T) {
+		for _, tc := range []struct {
+			policy string
+			user   goth.User
+			owner  model.User
+			err    error
+		}{
+			{policy: auth.LinkNever, user: verified, owner: owner, err: auth.ErrIdentityConflict},
+			{policy: auth.LinkVerified, user: gUser, owner: owner, err: auth.ErrIdentityConflict},
+			{policy: auth.LinkVerified, user: verified, owner: owner},
+			{policy: auth.LinkAlways, user: gUser, owner: owner},
+			{policy: auth.LinkVerified, user: verified, owner: user, err: auth.ErrIdentityConflict},
+			{policy: auth.LinkAlways, user: gUser, owner: user, err: auth.ErrIdentityConflict},
+		} {
+			tx := newTransactionMock()
+			users := &usersMock{}
+			identities := &identitiesMock{}
+
+			identities.On("Find", "google", "google.123").Return(nil, repo.ErrorNotFound)
+			users.On("Find", user.Name).Return(tc.owner, nil)
+			identities.On("Create", identity).Return(nil)
+
+			cmd := newResolver(tx, users, identities, tc.policy)
+
+			result, err := cmd.Resolve("google", tc.user)
+			if tc.err != nil {
+				require.ErrorIs(t, err, tc.err, tc.policy)
+				identities.AssertNotCalled(t, "Create", mock.Anything)
+				tx.AssertNotCalled(t, "Commit")
+				continue
+			}
+
+			require.NoError(t, err, tc.policy)
+			require.Equal(t, tc.owner, result)
+			users.AssertNotCalled(t, "Create", mock.Anything)
+			identities.AssertCalled(t, "Create", identity)
+		}
+	})
+
+	t.Run("VerifiedString", func(t *testing.T) {
+		users := &usersMock{}
+		identities := &identitiesMock{}
+
+		value := goth.User{
+			UserID:  "google.123",
+			Email:   user.Name,
+			RawData: map[string]interface{}{"verified_email": "true"},
+		}
+
+		identities.On("Find", "google", "google.123").Return(nil, repo.ErrorNotFound)
+		users.On("Find", user.Name).Return(owner, nil)
+		identities.On("Create", identity).Return(nil)
+
+		cmd := newResolver(newTransactionMock(), users, identities, auth.LinkVerified)
+
+		_, err := cmd.Resolve("google", value)
+		require.NoError(t, err)
+	})
+
+	t.Run("MissingSubject", func(t *testing.T) {
+		identities := &identitiesMock{}
+
+		cmd := newResolver(newTransactionMock(), &usersMock{}, identities, auth.LinkAlways)
+
+		_, err := cmd.Resolve("google", goth.User{Email: user.Name})
+		require.ErrorIs(t, err, auth.ErrMissingSubject)
+		identities.AssertNotCalled(t, "Find", mock.Anything, mock.Anything)
+	})
+
+	t.Run("CreateFailed", func(t *testing.T) {
+		tx := newTransactionMock()
+		users := &usersMock{}
+		identities := &identitiesMock{}
+
+		fail := errors.New("xxx")
+
+		identities.On("Find", "google", "google.123").Return(nil, repo.ErrorNotFound)
+		users.On("Find", user.Name).Return(nil, repo.ErrorNotFound)
+		users.On("Create", mock.Anything).Return(nil)
+		identities.On("Create", mock.Anything).Return(fail)
+
+		cmd := newResolver(tx, users, identities, auth.LinkNever)
+
+		_, err := cmd.Resolve("google", gUser)
+		require.ErrorIs(t, err, fail)
+		tx.AssertNotCalled(t, "Commit")
+		tx.AssertCalled(t, "Close")
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		identities := &identitiesMock{}
+
+		identities.On("Find", "google", "google.123").Return(nil, repo.ErrorNotFound)
+		identities.On("Create", identity).Return(nil)
+
+		cmd := newResolver(newTransactionMock(), &usersMock{}, identities, auth.LinkNever)
+
+		info, err := cmd.Link(user.ID, "google", gUser)
+		require.NoError(t, err)
+		require.Equal(t, auth.IdentityInfo{Provider: "google", Email: user.Name, Created: now.Unix()}, info)
+	})
+
+	t.Run("LinkAgain", func(t *testing.T) {
+		identities := &identitiesMock{}
+
+		identities.On("Find", "google", "google.123").Return(identity, nil)
+
+		cmd := newResolver(newTransactionMock(), &usersMock{}, identities, auth.LinkNever)
+
+		_, err := cmd.Link(user.ID, "google", gUser)
+		require.NoError(t, err)
+		identities.AssertNotCalled(t, "Create", mock.Anything)
+	})
+
+	t.Run("LinkedToOtherUser", func(t *testing.T) {
+		identities := &identitiesMock{}
+
+		identities.On("Find", "google", "google.123").Return(identity, nil)
+
+		cmd := newResolver(newTransactionMock(), &usersMock{}, identities, auth.LinkNever)
+
+		_, err := cmd.Link("user.456", "google", gUser)
+		require.ErrorIs(t, err, auth.ErrIdentityLinked)
+		identities.AssertNotCalled(t, "Create", mock.Anything)
+	})
+}
+
+func TestIdentityManager(t *testing.T) {
+	claims := map[string]interface{}{"sub": "user.123"}
+
+	google := model.UserIdentity{Provider: "google", Subject: "google.123", UserID: "user.123", Created: 10}
+	github := model.UserIdentity{Provider: "github", Subject: "github.123", UserID: "user.123", Email: "u0@mail.org", Created: 20}
+
+	newManager := func(identities *identitiesMock, profiles *profilesMock, tokens *providerTokensMock, credentials *credentialsMock, passkeys *passkeysMock) auth.IdentityManager {
+		verifier := &verifierMock{}
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		verifier.On("Verify", mock.Anything).Return(nil, auth.Error{})
+
+		return auth.NewIdentityManager(newTransactionMock(), verifier, identities, profiles, tokens, credentials, passkeys)
+	}
+
+	t.Run("List", func(t *testing.T) {
+		identities := &identitiesMock{}
+		profiles := &profilesMock{}
+		tokens := &providerTokensMock{}
+		identities.On("FindByUser", "user.123").Return([]model.UserIdentity{google, github}, nil)
+
+		cmd := newManager(identities, profiles, tokens, &credentialsMock{}, &passkeysMock{})
+
+		items, err := cmd.List("access.123")
+		require.NoError(t, err)
+		require.Equal(t, []auth.IdentityInfo{
+			{Provider: "google", Created: 10},
+			{Provider: "github", Email: "u0@mail.org", Created: 20},
+		}, items)
+
+		_, err = cmd.List("xxx")
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("UnlinkOtherIdentity", func(t *testing.T) {
+		identities := &identitiesMock{}
+		profiles := &profilesMock{}
+		tokens := &providerTokensMock{}
+		credentials := &credentialsMock{}
+
+		identities.On("FindByUser", "user.123").Return([]model.UserIdentity{google, github}, nil)
+		identities.On("Delete", "user.123", "google").Return(nil)
+		profiles.On("Delete", "user.123", "google").Return(nil)
+		tokens.On("Delete", "user.123", "google").Return(nil)
+
+		cmd := newManager(identities, profiles, tokens, credentials, &passkeysMock{})
+
+		require.NoError(t, cmd.Unlink("access.123", "google"))
+		profiles.AssertCalled(t, "Delete", "user.123", "google")
+		tokens.AssertCalled(t, "Delete", "user.123", "google")
+		credentials.AssertNotCalled(t, "Find", mock.Anything)
+	})
+
+	t.Run("UnlinkWithPassword", func(t *testing.T) {
+		identities := &identitiesMock{}
+		profiles := &profilesMock{}
+		tokens := &providerTokensMock{}
+		credentials := &credentialsMock{}
+
+		identities.On("FindByUser", "user.123").Return([]model.UserIdentity{google}, nil)
+		identities.On("Delete", "user.123", "google").Return(nil)
+		profiles.On("Delete", "user.123", "google").Return(nil)
+		tokens.On("Delete", "user.123", "google").Return(nil)
+		credentials.On("Find", "user.123").Return(model.Credential{UserID: "user.123"}, nil)
+
+		cmd := newManager(identities, profiles, tokens, credentials, &passkeysMock{})
+
+		require.NoError(t, cmd.Unlink("access.123", "google"))
+		profiles.AssertCalled(t, "Delete", "user.123", "google")
+		tokens.AssertCalled(t, "Delete", "user.123", "google")
+	})
+
+	t.Run("UnlinkWithPasskey", func(t *testing.T) {
+		identities := &identitiesMock{}
+		profiles := &profilesMock{}
+		tokens := &providerTokensMock{}
+		credentials := &credentialsMock{}
+		passkeys := &passkeysMock{}
+
+		identities.On("FindByUser", "user.123").Return([]model.UserIdentity{google}, nil)
+		identities.On("Delete", "user.123", "google").Return(nil)
+		profiles.On("Delete", "user.123", "google").Return(nil)
+		tokens.On("Delete", "user.123", "google").Return(nil)
+		credentials.On("Find", "user.123").Return(nil, repo.ErrorNotFound)
+		passkeys.On("FindByUser", "user.123").Return([]model.Passkey{{ID: "key.123"}}, nil)
+
+		cmd := newManager(identities, profiles, tokens, credentials, passkeys)
+
+		require.NoError(t, cmd.Unlink("access.123", "google"))
+		profiles.AssertCalled(t, "Delete", "user.123", "google")
+		tokens.AssertCalled(t, "Delete", "user.123", "google")
+	})
+
+	t.Run("UnlinkLast", func(t *testing.T) {
+		identities := &identitiesMock{}
+		profiles := &profilesMock{}
+		tokens := &providerTokensMock{}
+		credentials := &credentialsMock{}
+		passkeys := &passkeysMock{}
+
+		identities.On("FindByUser", "user.123").Return([]model.UserIdentity{google}, nil)
+		credentials.On("Find", "user.123").Return(nil, repo.ErrorNotFound)
+		passkeys.On("FindByUser", "user.123").Return([]model.Passkey{}, nil)
+
+		cmd := newManager(identities, profiles, tokens, credentials, passkeys)
+
+		require.ErrorIs(t, cmd.Unlink("access.123", "google"), auth.ErrLastIdentity)
+		identities.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+		profiles.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+		tokens.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	})
+
+	t.Run("UnlinkNotFound", func(t *testing.T) {
+		identities := &identitiesMock{}
+		profiles := &profilesMock{}
+		tokens := &providerTokensMock{}
+
+		identities.On("FindByUser", "user.123").Return([]model.UserIdentity{}, nil)
+
+		cmd := newManager(identities, profiles, tokens, &credentialsMock{}, &passkeysMock{})
+
+		require.ErrorIs(t, cmd.Unlink("access.123", "google"), auth.ErrIdentityNotFound)
+		identities.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+		profiles.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+		tokens.AssertNotCalled(t, "Delete", mock.Anything, mock.Anything)
+	})
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/vbogretsov/guard/repo"
+)
+
+// Introspection is a token introspection response defined by RFC 7662.
+type Introspection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Iss       string `json:"iss,omitempty"`
+}
+
+type Introspector interface {
+	Introspect(token string) (Introspection, error)
+}
+
+type introspector struct {
+	timer    Timer
+	verifier Verifier
+	tokens   repo.RefreshTokens
+}
+
+func NewIntrospector(timer Timer, verifier Verifier, tokens repo.RefreshTokens) Introspector {
+	return &introspector{
+		timer:    timer,
+		verifier: verifier,
+		tokens:   tokens,
+	}
+}
+
+// Introspect tells whether the access or the refresh token is active. Access
+// tokens are JWTs and refresh tokens never contain dots, so the token type
+// does not need a hint.
+func (c *introspector) Introspect(token string) (Introspection, error) {
+	if strings.Count(token, ".") == 2 {
+		return c.access(token)
+	}
+	return c.refresh(token)
+}
+
+func (c *introspector) access(token string) (Introspection, error) {
+	claims, err := c.verifier.VerifyUse(token, TokenUseAccess, TokenUseClient)
+	if err != nil {
+		if errors.As(err, &Error{}) {
+			return Introspection{}, nil
+		}
+		return Introspection{}, err
+	}
+
+	result := Introspection{Active: true, TokenType: "access_token"}
+	result.Sub, _ = claims["sub"].(string)
+	result.Iss, _ = claims["iss"].(string)
+	result.Scope, _ = claims["scope"].(string)
+	result.ClientID, _ = claims["client_id"].(string)
+
+	if exp, ok := claims["exp"].(float64); ok {
+		result.Exp = int64(exp)
+	}
+
+	if iat, ok := claims["iat"].(float64); ok {
+		result.Iat = int64(iat)
+	}
+
+	return result, nil
+}
+
+func (c *introspector) refresh(token string) (Introspection, error) {
+	refresh, err := c.tokens.Find(token)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return Introspection{}, nil
+		}
+		return Introspection{}, err
+	}
+
+	if refresh.Used || refresh.Expires < c.timer.Now().Unix() {
+		return Introspection{}, nil
+	}
+
+	return Introspection{
+		Active:    true,
+		TokenType: "refresh_token",
+		Sub:       refresh.UserID,
+		ClientID:  refresh.ClientID,
+		Exp:       refresh.Expires,
+		Iat:       refresh.Created,
+	}, nil
+}
@@ -0,0 +1,130 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func TestIntrospector(t *testing.T) {
+	access := "header.payload.signature"
+
+	t.Run("Access", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		verifier := &verifierMock{}
+
+		verifier.On("VerifyUse", access, []string{auth.TokenUseAccess, auth.TokenUseClient}).Return(map[string]interface{}{
+			"sub":       "user.123",
+			"iss":       "http://localhost:8000",
+			"exp":       float64(1600000060),
+			"iat":       float64(1600000000),
+			"client_id": "client.123",
+		}, nil)
+
+		cmd := auth.NewIntrospector(timer, verifier, &refreshTokensMock{})
+
+		result, err := cmd.Introspect(access)
+		require.NoError(t, err)
+		require.Equal(t, auth.Introspection{
+			Active:    true,
+			TokenType: "access_token",
+			Sub:       "user.123",
+			Iss:       "http://localhost:8000",
+			Exp:       1600000060,
+			Iat:       1600000000,
+			ClientID:  "client.123",
+		}, result)
+	})
+
+	t.Run("AccessInvalid", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		verifier := &verifierMock{}
+
+		verifier.On("VerifyUse", access, []string{auth.TokenUseAccess, auth.TokenUseClient}).Return(nil, auth.Error{})
+
+		cmd := auth.NewIntrospector(timer, verifier, &refreshTokensMock{})
+
+		result, err := cmd.Introspect(access)
+		require.NoError(t, err)
+		require.False(t, result.Active)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000030, 0)}
+		tokens := &refreshTokensMock{}
+
+		token := model.RefreshToken{
+			ID:       "refresh123",
+			UserID:   "user.123",
+			ClientID: "client.123",
+			Created:  1600000000,
+			Expires:  1600000060,
+		}
+		tokens.On("Find", token.ID).Return(token, nil)
+
+		cmd := auth.NewIntrospector(timer, &verifierMock{}, tokens)
+
+		result, err := cmd.Introspect(token.ID)
+		require.NoError(t, err)
+		require.Equal(t, auth.Introspection{
+			Active:    true,
+			TokenType: "refresh_token",
+			Sub:       "user.123",
+			ClientID:  "client.123",
+			Exp:       1600000060,
+			Iat:       1600000000,
+		}, result)
+	})
+
+	t.Run("RefreshInactive", func(t *testing.T) {
+		cases := map[string]model.RefreshToken{
+			"Used":    {ID: "refresh123", Used: true, Expires: 1600000060},
+			"Expired": {ID: "refresh123", Expires: 1600000010},
+		}
+
+		for name, token := range cases {
+			t.Run(name, func(t *testing.T) {
+				timer := &timerMock{value: time.Unix(1600000030, 0)}
+				tokens := &refreshTokensMock{}
+				tokens.On("Find", token.ID).Return(token, nil)
+
+				cmd := auth.NewIntrospector(timer, &verifierMock{}, tokens)
+
+				result, err := cmd.Introspect(token.ID)
+				require.NoError(t, err)
+				require.False(t, result.Active)
+			})
+		}
+	})
+
+	t.Run("RefreshNotFound", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		tokens := &refreshTokensMock{}
+		tokens.On("Find", "xxx").Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewIntrospector(timer, &verifierMock{}, tokens)
+
+		result, err := cmd.Introspect("xxx")
+		require.NoError(t, err)
+		require.False(t, result.Active)
+	})
+
+	t.Run("RefreshFailed", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		tokens := &refreshTokensMock{}
+
+		fail := errors.New("xxx")
+		tokens.On("Find", "xxx").Return(nil, fail)
+
+		cmd := auth.NewIntrospector(timer, &verifierMock{}, tokens)
+
+		_, err := cmd.Introspect("xxx")
+		require.ErrorIs(t, err, fail)
+	})
+}
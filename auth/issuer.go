@@ -2,6 +2,7 @@ package auth
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt"
@@ -10,50 +11,101 @@ import (
 )
 
 type Issuer interface {
-	Issue(user model.User) (Token, error)
+	Issue(grant Grant) (Token, error)
 }
 
 type issuer struct {
-	secret  []byte
+	keys    KeySet
 	timer   Timer
 	ttl     time.Duration
+	claims  Claims
 	refresh RefreshGenerator
-	method  jwt.SigningMethod
 }
 
-func NewIssuer(secret string, timer Timer, ttl time.Duration, method jwt.SigningMethod, refresh RefreshGenerator) Issuer {
+func NewIssuer(keys KeySet, timer Timer, ttl time.Duration, claims Claims, refresh RefreshGenerator) Issuer {
 	return &issuer{
-		secret:  []byte(secret),
+		keys:    keys,
 		timer:   timer,
 		ttl:     ttl,
-		method:  method,
+		claims:  claims,
 		refresh: refresh,
 	}
 }
 
-func encodeJWT(secret []byte, claims map[string]interface{}, method jwt.SigningMethod) (string, error) {
-	token := jwt.NewWithClaims(method, jwt.MapClaims(claims))
-	return token.SignedString(secret)
+func encodeJWT(key Key, claims map[string]interface{}) (string, error) {
+	token := jwt.NewWithClaims(key.Method, jwt.MapClaims(claims))
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.Private)
 }
 
-func (c *issuer) Issue(user model.User) (Token, error) {
+// Issue issues the access, refresh and id tokens to the user. Tokens issued
+// to the client have the client as the subject, carry the client scopes and
+// come without refresh and id tokens. Disabled users get no tokens.
+func (c *issuer) Issue(grant Grant) (Token, error) {
 	var token Token
 
-	refresh, err := c.refresh.Generate(user)
-	if err != nil {
-		return token, err
+	if grant.User.Disabled {
+		return token, ErrUserDisabled
+	}
+
+	var refresh model.RefreshToken
+	if grant.User.ID != "" {
+		var err error
+		if refresh, err = c.refresh.Generate(grant); err != nil {
+			return token, err
+		}
+	}
+
+	ttl := c.ttl
+	if grant.Client.AccessTTL > 0 {
+		ttl = time.Duration(grant.Client.AccessTTL) * time.Second
 	}
 
 	now := c.timer.Now()
-	exp := now.Add(c.ttl).Unix()
+	exp := now.Add(ttl).Unix()
+
+	claims := map[string]interface{}{}
+	if err := c.claims.Extra.render(grant, claims); err != nil {
+		return token, err
+	}
+
+	if grant.User.ID != "" {
+		claims["sub"] = grant.User.ID
+		claims["email"] = grant.User.Name
+		claims["token_use"] = TokenUseAccess
+	} else {
+		claims["sub"] = grant.Client.ID
+		claims["token_use"] = TokenUseClient
+		if scopes := strings.Fields(grant.Client.Scopes); len(scopes) > 0 {
+			claims["scope"] = strings.Join(scopes, " ")
+		}
+	}
+
+	claims["iat"] = now.Unix()
+	claims["nbf"] = now.Unix()
+	claims["exp"] = exp
+	claims["jti"] = generateRandomString(TokenIDSize)
+
+	c.addRegistered(claims)
+
+	if grant.Provider != "" {
+		claims["provider"] = grant.Provider
+	}
 
-	claims := map[string]interface{}{
-		"sub": user.Name,
-		"exp": exp,
-		// TODO: add more claims.
+	if grant.Client.ID != "" {
+		claims["client_id"] = grant.Client.ID
 	}
 
-	access, err := encodeJWT(c.secret, claims, c.method)
+	if len(grant.Methods) > 0 {
+		claims["amr"] = grant.Methods
+	}
+
+	key, err := c.keys.Signing()
+	if err != nil {
+		return token, err
+	}
+
+	access, err := encodeJWT(key, claims)
 	if err != nil {
 		return token, fmt.Errorf("jwt encoding failed: %w", err)
 	}
@@ -64,5 +116,48 @@ func (c *issuer) Issue(user model.User) (Token, error) {
 	token.Refresh = refresh.ID
 	token.RefreshExpires = refresh.Expires
 
+	if grant.User.ID == "" {
+		return token, nil
+	}
+
+	idClaims := map[string]interface{}{
+		"sub":       grant.User.ID,
+		"email":     grant.User.Name,
+		"iat":       now.Unix(),
+		"exp":       exp,
+		"token_use": TokenUseID,
+	}
+	c.addRegistered(idClaims)
+
+	if grant.Client.ID != "" {
+		idClaims["aud"] = grant.Client.ID
+	}
+
+	if len(grant.Methods) > 0 {
+		idClaims["amr"] = grant.Methods
+	}
+
+	if grant.Nonce != "" {
+		idClaims["nonce"] = grant.Nonce
+	}
+
+	if token.IDToken, err = encodeJWT(key, idClaims); err != nil {
+		return token, fmt.Errorf("jwt encoding failed: %w", err)
+	}
+
 	return token, nil
 }
+
+func (c *issuer) addRegistered(claims map[string]interface{}) {
+	if c.claims.Issuer != "" {
+		claims["iss"] = c.claims.Issuer
+	}
+
+	switch len(c.claims.Audience) {
+	case 0:
+	case 1:
+		claims["aud"] = c.claims.Audience[0]
+	default:
+		claims["aud"] = c.claims.Audience
+	}
+}
@@ -17,8 +17,8 @@ type refreshGeneratorMock struct {
 	mock.Mock
 }
 
-func (m *refreshGeneratorMock) Generate(user model.User) (model.RefreshToken, error) {
-	args := m.Called(user)
+func (m *refreshGeneratorMock) Generate(grant auth.Grant) (model.RefreshToken, error) {
+	args := m.Called(grant)
 
 	token := args.Get(0)
 	if token == nil {
@@ -41,8 +41,8 @@ type issuerMock struct {
 	mock.Mock
 }
 
-func (m *issuerMock) Issue(user model.User) (auth.Token, error) {
-	args := m.Called(user)
+func (m *issuerMock) Issue(grant auth.Grant) (auth.Token, error) {
+	args := m.Called(grant)
 
 	token := args.Get(0)
 	if token == nil {
@@ -70,7 +70,6 @@ func (m *signingMethodMock) Sign(signingString string, key interface{}) (string,
 }
 
 func TestIssuer(t *testing.T) {
-	sm := jwt.SigningMethodHS256
 	t.Run("Success", func(t *testing.T) {
 		secret := "123.456"
 		timer := &timerMock{value: time.Now()}
@@ -93,12 +92,12 @@ func TestIssuer(t *testing.T) {
 		}
 
 		refresh.
-			On("Generate", mock.MatchedBy(matchUser(user))).
+			On("Generate", auth.Grant{User: user}).
 			Return(refreshToken, nil)
 
-		cmd := auth.NewIssuer(secret, timer, accessTTL, sm, refresh)
+		cmd := auth.NewIssuer(auth.NewKeySet(timer, 0, auth.NewSecretKey(secret)), timer, accessTTL, auth.Claims{}, refresh)
 
-		token, err := cmd.Issue(user)
+		token, err := cmd.Issue(auth.Grant{User: user})
 		require.NoError(t, err)
 
 		expires := timer.Now().Add(accessTTL).Unix()
@@ -109,8 +108,27 @@ func TestIssuer(t *testing.T) {
 
 		raw, err := decodeJWT(secret, token.Access)
 		require.NoError(t, err)
-		require.Equal(t, user.Name, (raw.Claims).(jwt.MapClaims)["sub"])
-		require.Equal(t, expires, int64((raw.Claims).(jwt.MapClaims)["exp"].(float64)))
+		claims := raw.Claims.(jwt.MapClaims)
+		require.Equal(t, user.ID, claims["sub"])
+		require.Equal(t, user.Name, claims["email"])
+		require.Equal(t, expires, int64(claims["exp"].(float64)))
+		require.Equal(t, timer.Now().Unix(), int64(claims["iat"].(float64)))
+		require.Equal(t, timer.Now().Unix(), int64(claims["nbf"].(float64)))
+		require.NotEmpty(t, claims["jti"])
+		require.Equal(t, auth.TokenUseAccess, claims["token_use"])
+		require.NotContains(t, claims, "iss")
+		require.NotContains(t, claims, "aud")
+		require.NotContains(t, claims, "provider")
+
+		raw, err = decodeJWT(secret, token.IDToken)
+		require.NoError(t, err)
+
+		idClaims := raw.Claims.(jwt.MapClaims)
+		require.Equal(t, user.ID, idClaims["sub"])
+		require.Equal(t, user.Name, idClaims["email"])
+		require.Equal(t, expires, int64(idClaims["exp"].(float64)))
+		require.Equal(t, auth.TokenUseID, idClaims["token_use"])
+		require.Equal(t, auth.NewSecretKey(secret).ID, raw.Header["kid"])
 	})
 
 	t.Run("FailedCreateRefresh", func(t *testing.T) {
@@ -132,9 +150,9 @@ func TestIssuer(t *testing.T) {
 			On("Generate", mock.Anything).
 			Return(nil, fail)
 
-		cmd := auth.NewIssuer(secret, timer, accessTTL, sm, refresh)
+		cmd := auth.NewIssuer(auth.NewKeySet(timer, 0, auth.NewSecretKey(secret)), timer, accessTTL, auth.Claims{}, refresh)
 
-		_, err := cmd.Issue(user)
+		_, err := cmd.Issue(auth.Grant{User: user})
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
@@ -162,16 +180,235 @@ func TestIssuer(t *testing.T) {
 		}
 
 		refresh.
-			On("Generate", mock.MatchedBy(matchUser(user))).
+			On("Generate", auth.Grant{User: user}).
 			Return(refreshToken, nil)
 
 		fail := errors.New("xxx")
 		signing.On("Sign", mock.Anything, mock.Anything).Return("", fail)
 
-		cmd := auth.NewIssuer(secret, timer, accessTTL, signing, refresh)
+		keys := auth.NewKeySet(timer, 0, auth.Key{Method: signing, Private: []byte(secret)})
+		cmd := auth.NewIssuer(keys, timer, accessTTL, auth.Claims{}, refresh)
 
-		_, err := cmd.Issue(user)
+		_, err := cmd.Issue(auth.Grant{User: user})
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
+
+	t.Run("NoActiveKey", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		refresh := &refreshGeneratorMock{}
+
+		key := auth.NewSecretKey("123.456")
+		key.Activated = timer.Now().Add(time.Hour).Unix()
+
+		refresh.On("Generate", mock.Anything).Return(model.RefreshToken{}, nil)
+
+		cmd := auth.NewIssuer(auth.NewKeySet(timer, 0, key), timer, time.Minute, auth.Claims{}, refresh)
+
+		_, err := cmd.Issue(auth.Grant{User: model.User{Name: "u0@mail.org"}})
+		require.Error(t, err)
+	})
+
+	t.Run("UserDisabled", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		refresh := &refreshGeneratorMock{}
+
+		user := model.User{
+			ID:       "issuer.user.123",
+			Name:     "u0@mail.org",
+			Disabled: true,
+			Created:  timer.Now().Unix(),
+		}
+
+		cmd := auth.NewIssuer(auth.NewKeySet(timer, 0, auth.NewSecretKey("123.456")), timer, time.Minute, auth.Claims{}, refresh)
+
+		_, err := cmd.Issue(auth.Grant{User: user})
+		require.ErrorIs(t, err, auth.ErrUserDisabled)
+		refresh.AssertNotCalled(t, "Generate", mock.Anything)
+	})
+}
+
+func TestIssueClaims(t *testing.T) {
+	secret := "123.456"
+
+	user := model.User{
+		ID:      "issuer.user.123",
+		Name:    "u0@mail.org",
+		Created: 1600000000,
+	}
+
+	grant := auth.Grant{User: user, Provider: "google"}
+
+	newIssuer := func(claims auth.Claims) auth.Issuer {
+		timer := &timerMock{value: time.Now()}
+		refresh := &refreshGeneratorMock{}
+		refresh.On("Generate", mock.Anything).Return(model.RefreshToken{}, nil)
+
+		return auth.NewIssuer(auth.NewKeySet(timer, 0, auth.NewSecretKey(secret)), timer, time.Minute, claims, refresh)
+	}
+
+	issue := func(t *testing.T, claims auth.Claims) jwt.MapClaims {
+		token, err := newIssuer(claims).Issue(grant)
+		require.NoError(t, err)
+
+		raw, err := decodeJWT(secret, token.Access)
+		require.NoError(t, err)
+
+		return raw.Claims.(jwt.MapClaims)
+	}
+
+	t.Run("Registered", func(t *testing.T) {
+		claims := issue(t, auth.Claims{
+			Issuer:   "https://auth.example.org",
+			Audience: []string{"api"},
+		})
+
+		require.Equal(t, "https://auth.example.org", claims["iss"])
+		require.Equal(t, "api", claims["aud"])
+		require.Equal(t, "google", claims["provider"])
+	})
+
+	t.Run("IDToken", func(t *testing.T) {
+		token, err := newIssuer(auth.Claims{
+			Issuer:   "https://auth.example.org",
+			Audience: []string{"api"},
+			Extra:    auth.ExtraClaims{"role": "user"},
+		}).Issue(grant)
+		require.NoError(t, err)
+
+		raw, err := decodeJWT(secret, token.IDToken)
+		require.NoError(t, err)
+
+		claims := raw.Claims.(jwt.MapClaims)
+		require.Equal(t, "https://auth.example.org", claims["iss"])
+		require.Equal(t, "api", claims["aud"])
+		require.NotContains(t, claims, "role")
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		refresh := &refreshGeneratorMock{}
+		refresh.On("Generate", mock.Anything).Return(model.RefreshToken{}, nil)
+
+		cmd := auth.NewIssuer(auth.NewKeySet(timer, 0, auth.NewSecretKey(secret)), timer, time.Minute, auth.Claims{Audience: []string{"api"}}, refresh)
+
+		client := model.Client{ID: "client.123", AccessTTL: 30}
+		token, err := cmd.Issue(auth.Grant{User: user, Client: client})
+		require.NoError(t, err)
+		require.Equal(t, timer.Now().Add(30*time.Second).Unix(), token.AccessExpires)
+
+		raw, err := decodeJWT(secret, token.Access)
+		require.NoError(t, err)
+		require.Equal(t, client.ID, raw.Claims.(jwt.MapClaims)["client_id"])
+
+		raw, err = decodeJWT(secret, token.IDToken)
+		require.NoError(t, err)
+		require.Equal(t, client.ID, raw.Claims.(jwt.MapClaims)["aud"])
+	})
+
+	t.Run("Methods", func(t *testing.T) {
+		grant := auth.Grant{User: user, Methods: []string{auth.MethodPassword, auth.MethodOTP, auth.MethodMFA}}
+
+		token, err := newIssuer(auth.Claims{}).Issue(grant)
+		require.NoError(t, err)
+
+		expected := []interface{}{"pwd", "otp", "mfa"}
+
+		raw, err := decodeJWT(secret, token.Access)
+		require.NoError(t, err)
+		require.Equal(t, expected, raw.Claims.(jwt.MapClaims)["amr"])
+
+		raw, err = decodeJWT(secret, token.IDToken)
+		require.NoError(t, err)
+		require.Equal(t, expected, raw.Claims.(jwt.MapClaims)["amr"])
+
+		require.NotContains(t, issue(t, auth.Claims{}), "amr")
+	})
+
+	t.Run("Nonce", func(t *testing.T) {
+		grant := auth.Grant{User: user, Nonce: "nonce.123"}
+
+		token, err := newIssuer(auth.Claims{}).Issue(grant)
+		require.NoError(t, err)
+
+		raw, err := decodeJWT(secret, token.IDToken)
+		require.NoError(t, err)
+		require.Equal(t, "nonce.123", raw.Claims.(jwt.MapClaims)["nonce"])
+
+		require.NotContains(t, issue(t, auth.Claims{}), "nonce")
+	})
+
+	t.Run("ClientGrant", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		refresh := &refreshGeneratorMock{}
+
+		cmd := auth.NewIssuer(auth.NewKeySet(timer, 0, auth.NewSecretKey(secret)), timer, time.Minute, auth.Claims{}, refresh)
+
+		client := model.Client{ID: "client.123", Scopes: "users:read  users:write"}
+		token, err := cmd.Issue(auth.Grant{Client: client})
+		require.NoError(t, err)
+		require.Empty(t, token.Refresh)
+		require.Empty(t, token.IDToken)
+		refresh.AssertNotCalled(t, "Generate", mock.Anything)
+
+		raw, err := decodeJWT(secret, token.Access)
+		require.NoError(t, err)
+
+		claims := raw.Claims.(jwt.MapClaims)
+		require.Equal(t, client.ID, claims["sub"])
+		require.Equal(t, client.ID, claims["client_id"])
+		require.Equal(t, "users:read users:write", claims["scope"])
+		require.Equal(t, auth.TokenUseClient, claims["token_use"])
+		require.NotContains(t, claims, "email")
+	})
+
+	t.Run("MultipleAudience", func(t *testing.T) {
+		claims := issue(t, auth.Claims{Audience: []string{"api", "web"}})
+		require.Equal(t, []interface{}{"api", "web"}, claims["aud"])
+	})
+
+	t.Run("UniqueID", func(t *testing.T) {
+		c1 := issue(t, auth.Claims{})
+		c2 := issue(t, auth.Claims{})
+		require.NotEqual(t, c1["jti"], c2["jti"])
+	})
+
+	t.Run("Extra", func(t *testing.T) {
+		extra, err := auth.ParseExtraClaims(`{
+			"role": "user",
+			"level": 3,
+			"tenant": "{{.Provider}}:{{.User.ID}}"
+		}`)
+		require.NoError(t, err)
+
+		claims := issue(t, auth.Claims{Extra: extra})
+		require.Equal(t, "user", claims["role"])
+		require.Equal(t, float64(3), claims["level"])
+		require.Equal(t, "google:"+user.ID, claims["tenant"])
+	})
+
+	t.Run("ExtraRenderFailed", func(t *testing.T) {
+		extra, err := auth.ParseExtraClaims(`{"x": "{{.Missing}}"}`)
+		require.NoError(t, err)
+
+		_, err = newIssuer(auth.Claims{Extra: extra}).Issue(grant)
+		require.Error(t, err)
+	})
+}
+
+func TestParseExtraClaims(t *testing.T) {
+	t.Run("InvalidJSON", func(t *testing.T) {
+		_, err := auth.ParseExtraClaims("xxx")
+		require.Error(t, err)
+	})
+
+	t.Run("Reserved", func(t *testing.T) {
+		_, err := auth.ParseExtraClaims(`{"sub": "xxx"}`)
+		require.Error(t, err)
+	})
+
+	t.Run("InvalidTemplate", func(t *testing.T) {
+		_, err := auth.ParseExtraClaims(`{"x": "{{.User"}`)
+		require.Error(t, err)
+	})
 }
@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var errNoPEMBlock = errors.New("no PEM block found")
+
+// Key is a JWT signing key. Public is nil for symmetric keys, such keys are
+// never published.
+type Key struct {
+	ID        string
+	Method    jwt.SigningMethod
+	Private   interface{}
+	Public    crypto.PublicKey
+	Activated int64
+}
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	K   string `json:"k,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// secretKeyID is the id of the secret key. The id of a key is public, so it
+// is not derived from the secret.
+const secretKeyID = "hs256"
+
+// NewSecretKey creates the HS256 key of the secret.
+func NewSecretKey(secret string) Key {
+	return Key{
+		ID:      secretKeyID,
+		Method:  jwt.SigningMethodHS256,
+		Private: []byte(secret),
+	}
+}
+
+func LoadKey(path string) (Key, error) {
+	/* #nosec G304 */
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+
+	key, err := ParseKey(data)
+	if err != nil {
+		return key, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return key, nil
+}
+
+// ParseKey parses a PEM encoded RSA, ECDSA or Ed25519 private key.
+func ParseKey(data []byte) (Key, error) {
+	var key Key
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return key, errNoPEMBlock
+	}
+
+	private, err := parsePrivateKey(block)
+	if err != nil {
+		return key, err
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		key.Method = jwt.SigningMethodRS256
+		key.Private = private
+		key.Public = &private.PublicKey
+	case *ecdsa.PrivateKey:
+		method, err := ecdsaMethod(private.Curve)
+		if err != nil {
+			return key, err
+		}
+		key.Method = method
+		key.Private = private
+		key.Public = &private.PublicKey
+	case ed25519.PrivateKey:
+		key.Method = jwt.SigningMethodEdDSA
+		key.Private = private
+		key.Public = private.Public()
+	default:
+		return key, fmt.Errorf("unsupported private key type: %T", private)
+	}
+
+	key.ID = thumbprint(publicJWK(key))
+	return key, nil
+}
+
+// GenerateKey generates a private key of the JWT algorithm and returns it PEM
+// encoded in the PKCS #8 form.
+func GenerateKey(alg string) ([]byte, error) {
+	var private interface{}
+	var err error
+
+	switch alg {
+	case jwt.SigningMethodRS256.Alg():
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	case jwt.SigningMethodES256.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case jwt.SigningMethodES384.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	case jwt.SigningMethodES512.Alg():
+		private, err = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	case jwt.SigningMethodEdDSA.Alg():
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("unsupported key algorithm: %s", alg)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: data}), nil
+}
+
+func parsePrivateKey(block *pem.Block) (interface{}, error) {
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block type: %s", block.Type)
+	}
+}
+
+func ecdsaMethod(curve elliptic.Curve) (jwt.SigningMethod, error) {
+	switch curve {
+	case elliptic.P256():
+		return jwt.SigningMethodES256, nil
+	case elliptic.P384():
+		return jwt.SigningMethodES384, nil
+	case elliptic.P521():
+		return jwt.SigningMethodES512, nil
+	default:
+		return nil, fmt.Errorf("unsupported elliptic curve: %s", curve.Params().Name)
+	}
+}
+
+func NewJWKS(keys ...Key) JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, key := range keys {
+		if key.Public == nil {
+			continue
+		}
+
+		jwk := publicJWK(key)
+		jwk.Kid = key.ID
+		jwk.Use = "sig"
+		jwk.Alg = key.Method.Alg()
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	return jwks
+}
+
+func publicJWK(key Key) JWK {
+	switch public := key.Public.(type) {
+	case *rsa.PublicKey:
+		return JWK{
+			Kty: "RSA",
+			N:   b64(public.N.Bytes()),
+			E:   b64(big.NewInt(int64(public.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		size := (public.Curve.Params().BitSize + 7) / 8
+		return JWK{
+			Kty: "EC",
+			Crv: public.Curve.Params().Name,
+			X:   b64(pad(public.X.Bytes(), size)),
+			Y:   b64(pad(public.Y.Bytes(), size)),
+		}
+	case ed25519.PublicKey:
+		return JWK{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   b64(public),
+		}
+	default:
+		return JWK{}
+	}
+}
+
+// thumbprint computes RFC 7638 JWK thumbprint. The JSON encoder sorts map
+// keys, which gives the required lexicographic order of the members.
+func thumbprint(jwk JWK) string {
+	var members map[string]string
+
+	switch jwk.Kty {
+	case "RSA":
+		members = map[string]string{"kty": jwk.Kty, "n": jwk.N, "e": jwk.E}
+	case "EC":
+		members = map[string]string{"kty": jwk.Kty, "crv": jwk.Crv, "x": jwk.X, "y": jwk.Y}
+	case "OKP":
+		members = map[string]string{"kty": jwk.Kty, "crv": jwk.Crv, "x": jwk.X}
+	default:
+		members = map[string]string{"kty": jwk.Kty, "k": jwk.K}
+	}
+
+	data, err := json.Marshal(members)
+	if err != nil {
+		panic(fmt.Errorf("json.Marshal: %w", err))
+	}
+
+	sum := sha256.Sum256(data)
+	return b64(sum[:])
+}
+
+func pad(b []byte, size int) []byte {
+	if len(b) >= size {
+		return b
+	}
+
+	padded := make([]byte, size)
+	copy(padded[size-len(b):], b)
+	return padded
+}
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
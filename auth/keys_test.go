@@ -0,0 +1,179 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+)
+
+func encodePEM(t *testing.T, private interface{}, pkcs8 bool) []byte {
+	if pkcs8 {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	}
+
+	switch private := private.(type) {
+	case *rsa.PrivateKey:
+		der := x509.MarshalPKCS1PrivateKey(private)
+		return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der})
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(private)
+		require.NoError(t, err)
+		return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	}
+
+	t.Fatalf("unexpected key type %T", private)
+	return nil
+}
+
+func TestParseKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	cases := []struct {
+		name string
+		data []byte
+		alg  string
+		kty  string
+	}{
+		{name: "RSA", data: encodePEM(t, rsaKey, false), alg: "RS256", kty: "RSA"},
+		{name: "RSAPKCS8", data: encodePEM(t, rsaKey, true), alg: "RS256", kty: "RSA"},
+		{name: "ECDSA", data: encodePEM(t, ecKey, false), alg: "ES256", kty: "EC"},
+		{name: "ECDSAPKCS8", data: encodePEM(t, ecKey, true), alg: "ES256", kty: "EC"},
+		{name: "Ed25519", data: encodePEM(t, edKey, true), alg: "EdDSA", kty: "OKP"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			key, err := auth.ParseKey(c.data)
+			require.NoError(t, err)
+			require.NotEmpty(t, key.ID)
+			require.Equal(t, c.alg, key.Method.Alg())
+
+			jwks := auth.NewJWKS(key)
+			require.Len(t, jwks.Keys, 1)
+			require.Equal(t, key.ID, jwks.Keys[0].Kid)
+			require.Equal(t, c.kty, jwks.Keys[0].Kty)
+			require.Equal(t, c.alg, jwks.Keys[0].Alg)
+			require.Equal(t, "sig", jwks.Keys[0].Use)
+			require.Empty(t, jwks.Keys[0].K)
+
+			timer := &timerMock{value: time.Now()}
+			refresh := &refreshGeneratorMock{}
+			refresh.On("Generate", mock.Anything).Return(model.RefreshToken{}, nil)
+
+			cmd := auth.NewIssuer(auth.NewKeySet(timer, 0, key), timer, time.Minute, auth.Claims{}, refresh)
+
+			token, err := cmd.Issue(auth.Grant{User: model.User{Name: "u0@mail.org"}})
+			require.NoError(t, err)
+
+			raw, err := jwt.Parse(token.Access, func(token *jwt.Token) (interface{}, error) {
+				require.Equal(t, key.ID, token.Header["kid"])
+				return key.Public, nil
+			})
+			require.NoError(t, err)
+			require.True(t, raw.Valid)
+		})
+	}
+
+	t.Run("SameKeySameID", func(t *testing.T) {
+		k1, err := auth.ParseKey(encodePEM(t, rsaKey, false))
+		require.NoError(t, err)
+
+		k2, err := auth.ParseKey(encodePEM(t, rsaKey, true))
+		require.NoError(t, err)
+
+		require.Equal(t, k1.ID, k2.ID)
+	})
+
+	t.Run("NoPEM", func(t *testing.T) {
+		_, err := auth.ParseKey([]byte("xxx"))
+		require.Error(t, err)
+	})
+
+	t.Run("UnsupportedBlock", func(t *testing.T) {
+		data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: []byte("xxx")})
+		_, err := auth.ParseKey(data)
+		require.Error(t, err)
+	})
+
+	t.Run("UnsupportedCurve", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+		require.NoError(t, err)
+
+		_, err = auth.ParseKey(encodePEM(t, key, false))
+		require.Error(t, err)
+	})
+}
+
+func TestLoadKey(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		private, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+		require.NoError(t, err)
+
+		path := filepath.Join(t.TempDir(), "key.pem")
+		require.NoError(t, ioutil.WriteFile(path, encodePEM(t, private, false), 0600))
+
+		key, err := auth.LoadKey(path)
+		require.NoError(t, err)
+		require.Equal(t, "ES384", key.Method.Alg())
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := auth.LoadKey(filepath.Join(t.TempDir(), "key.pem"))
+		require.Error(t, err)
+	})
+}
+
+func TestGenerateKey(t *testing.T) {
+	for _, alg := range []string{"RS256", "ES256", "ES384", "ES512", "EdDSA"} {
+		t.Run(alg, func(t *testing.T) {
+			data, err := auth.GenerateKey(alg)
+			require.NoError(t, err)
+
+			key, err := auth.ParseKey(data)
+			require.NoError(t, err)
+			require.Equal(t, alg, key.Method.Alg())
+		})
+	}
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := auth.GenerateKey("HS256")
+		require.Error(t, err)
+	})
+}
+
+func TestNewJWKS(t *testing.T) {
+	t.Run("SecretNotPublished", func(t *testing.T) {
+		key := auth.NewSecretKey("123.456")
+		require.NotEmpty(t, key.ID)
+		require.Empty(t, auth.NewJWKS(key).Keys)
+	})
+
+	t.Run("SecretKeyID", func(t *testing.T) {
+		require.Equal(t, "hs256", auth.NewSecretKey("123.456").ID)
+		require.Equal(t, "hs256", auth.NewSecretKey("456.789").ID)
+	})
+}
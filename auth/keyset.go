@@ -0,0 +1,190 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const keyFileExt = ".pem"
+
+var errNoActiveKey = errors.New("no active signing key")
+
+// KeySet selects the signing key and the keys published for verification.
+type KeySet interface {
+	Signing() (Key, error)
+	Published() []Key
+}
+
+type keySet struct {
+	keys  []Key
+	timer Timer
+	grace time.Duration
+}
+
+// NewKeySet creates a key set where the most recently activated key is used
+// for signing. Keys which are not activated yet are published in advance and
+// replaced keys stay published during the grace period.
+func NewKeySet(timer Timer, grace time.Duration, keys ...Key) KeySet {
+	sorted := make([]Key, len(keys))
+	copy(sorted, keys)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Activated < sorted[j].Activated
+	})
+
+	return &keySet{
+		keys:  sorted,
+		timer: timer,
+		grace: grace,
+	}
+}
+
+func (ks *keySet) current() int {
+	now := ks.timer.Now().Unix()
+
+	current := -1
+	for i, key := range ks.keys {
+		if key.Activated <= now {
+			current = i
+		}
+	}
+
+	return current
+}
+
+func (ks *keySet) Signing() (Key, error) {
+	current := ks.current()
+	if current == -1 {
+		return Key{}, errNoActiveKey
+	}
+
+	return ks.keys[current], nil
+}
+
+func (ks *keySet) Published() []Key {
+	retired := ks.timer.Now().Add(-ks.grace).Unix()
+
+	keys := []Key{}
+	for i, key := range ks.keys {
+		if i+1 < len(ks.keys) && ks.keys[i+1].Activated <= retired {
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	return keys
+}
+
+type keyFile struct {
+	name string
+	key  Key
+}
+
+// LoadKeyDir loads all the PEM keys from the directory. A key file name
+// starts with the unix time of the key activation, i.e. 1700000000.pem or
+// 1700000000-ed25519.pem.
+func LoadKeyDir(dir string) ([]Key, error) {
+	files, err := loadKeyFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(files))
+	for _, file := range files {
+		keys = append(keys, file.key)
+	}
+
+	return keys, nil
+}
+
+func loadKeyFiles(dir string) ([]keyFile, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []keyFile{}
+	for _, file := range files {
+		if file.IsDir() || filepath.Ext(file.Name()) != keyFileExt {
+			continue
+		}
+
+		activated, err := keyActivation(file.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := LoadKey(filepath.Join(dir, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		key.Activated = activated
+		keys = append(keys, keyFile{name: file.Name(), key: key})
+	}
+
+	return keys, nil
+}
+
+// KeyFileName returns the name of the key file activated at the time given.
+func KeyFileName(activated time.Time) string {
+	return strconv.FormatInt(activated.Unix(), 10) + keyFileExt
+}
+
+func keyFileID(key Key) string {
+	return fmt.Sprintf("%d:%s", key.Activated, key.ID)
+}
+
+// PruneKeyDir removes the key files which are no longer published by the key
+// set of the directory and returns their names.
+func PruneKeyDir(dir string, timer Timer, grace time.Duration) ([]string, error) {
+	files, err := loadKeyFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]Key, 0, len(files))
+	for _, file := range files {
+		keys = append(keys, file.key)
+	}
+
+	published := map[string]bool{}
+	for _, key := range NewKeySet(timer, grace, keys...).Published() {
+		published[keyFileID(key)] = true
+	}
+
+	removed := []string{}
+	for _, file := range files {
+		if published[keyFileID(file.key)] {
+			continue
+		}
+
+		if err := os.Remove(filepath.Join(dir, file.name)); err != nil {
+			return removed, err
+		}
+		removed = append(removed, file.name)
+	}
+
+	return removed, nil
+}
+
+func keyActivation(name string) (int64, error) {
+	prefix := strings.TrimSuffix(name, keyFileExt)
+	if i := strings.Index(prefix, "-"); i != -1 {
+		prefix = prefix[:i]
+	}
+
+	activated, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("key file name %s does not start with activation time", name)
+	}
+
+	return activated, nil
+}
@@ -0,0 +1,153 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+)
+
+func keyIDs(keys []auth.Key) []string {
+	ids := []string{}
+	for _, key := range keys {
+		ids = append(ids, key.ID)
+	}
+	return ids
+}
+
+func TestKeySet(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	grace := 300 * time.Second
+
+	k1 := auth.Key{ID: "k1", Activated: 0}
+	k2 := auth.Key{ID: "k2", Activated: now.Add(-time.Hour).Unix()}
+	k3 := auth.Key{ID: "k3", Activated: now.Add(-time.Minute).Unix()}
+	k4 := auth.Key{ID: "k4", Activated: now.Add(time.Hour).Unix()}
+
+	t.Run("Rotated", func(t *testing.T) {
+		timer := &timerMock{value: now}
+		ks := auth.NewKeySet(timer, grace, k4, k3, k2, k1)
+
+		key, err := ks.Signing()
+		require.NoError(t, err)
+		require.Equal(t, k3.ID, key.ID)
+
+		require.Equal(t, []string{"k2", "k3", "k4"}, keyIDs(ks.Published()))
+	})
+
+	t.Run("GraceExpired", func(t *testing.T) {
+		timer := &timerMock{value: now.Add(grace)}
+		ks := auth.NewKeySet(timer, grace, k1, k2, k3)
+
+		key, err := ks.Signing()
+		require.NoError(t, err)
+		require.Equal(t, k3.ID, key.ID)
+
+		require.Equal(t, []string{"k3"}, keyIDs(ks.Published()))
+	})
+
+	t.Run("Activated", func(t *testing.T) {
+		timer := &timerMock{value: now.Add(time.Hour)}
+		ks := auth.NewKeySet(timer, grace, k3, k4)
+
+		key, err := ks.Signing()
+		require.NoError(t, err)
+		require.Equal(t, k4.ID, key.ID)
+
+		require.Equal(t, []string{"k3", "k4"}, keyIDs(ks.Published()))
+	})
+
+	t.Run("NoActiveKey", func(t *testing.T) {
+		timer := &timerMock{value: now}
+		ks := auth.NewKeySet(timer, grace, k4)
+
+		_, err := ks.Signing()
+		require.Error(t, err)
+
+		require.Equal(t, []string{"k4"}, keyIDs(ks.Published()))
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		timer := &timerMock{value: now}
+		ks := auth.NewKeySet(timer, grace)
+
+		_, err := ks.Signing()
+		require.Error(t, err)
+		require.Empty(t, ks.Published())
+	})
+}
+
+func TestLoadKeyDir(t *testing.T) {
+	private, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	data := encodePEM(t, private, false)
+
+	t.Run("Success", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1600000000.pem"), data, 0600))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1700000000-es256.pem"), data, 0600))
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("xxx"), 0600))
+		require.NoError(t, os.Mkdir(filepath.Join(dir, "old.pem"), 0700))
+
+		keys, err := auth.LoadKeyDir(dir)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		require.Equal(t, int64(1600000000), keys[0].Activated)
+		require.Equal(t, int64(1700000000), keys[1].Activated)
+	})
+
+	t.Run("InvalidName", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "key.pem"), data, 0600))
+
+		_, err := auth.LoadKeyDir(dir)
+		require.Error(t, err)
+	})
+
+	t.Run("InvalidKey", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "1600000000.pem"), []byte("xxx"), 0600))
+
+		_, err := auth.LoadKeyDir(dir)
+		require.Error(t, err)
+	})
+
+	t.Run("MissingDir", func(t *testing.T) {
+		_, err := auth.LoadKeyDir(filepath.Join(t.TempDir(), "xxx"))
+		require.Error(t, err)
+	})
+}
+
+func TestPruneKeyDir(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"1600000000.pem", "1600001000.pem", "1600002000.pem", "1700000000.pem"} {
+		data, err := auth.GenerateKey("EdDSA")
+		require.NoError(t, err)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+
+	timer := &timerMock{value: time.Unix(1600002100, 0)}
+
+	removed, err := auth.PruneKeyDir(dir, timer, 50*time.Second)
+	require.NoError(t, err)
+	require.Equal(t, []string{"1600000000.pem", "1600001000.pem"}, removed)
+
+	keys, err := auth.LoadKeyDir(dir)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, int64(1600002000), keys[0].Activated)
+	require.Equal(t, int64(1700000000), keys[1].Activated)
+}
+
+func TestKeyFileName(t *testing.T) {
+	require.Equal(t, "1600000000.pem", auth.KeyFileName(time.Unix(1600000000, 0)))
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vbogretsov/guard/mail"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+const (
+	// EmailProvider is the provider of users signed in with a magic link.
+	EmailProvider = "email"
+
+	linkSessionPrefix = "link:"
+)
+
+var ErrInvalidLinkToken = Error{msg: "invalid link token"}
+
+type MagicLinker interface {
+	SendLink(email string) error
+	Redeem(token string) (SignInResult, error)
+}
+
+type magicLinker struct {
+	timer    Timer
+	ttl      time.Duration
+	linkURL  string
+	sessions repo.Sessions
+	users    UserFindOrCreator
+	finisher SignInFinisher
+	mailer   mail.Mailer
+}
+
+func NewMagicLinker(timer Timer, ttl time.Duration, linkURL string, sessions repo.Sessions, users UserFindOrCreator, finisher SignInFinisher, mailer mail.Mailer) MagicLinker {
+	return &magicLinker{
+		timer:    timer,
+		ttl:      ttl,
+		linkURL:  linkURL,
+		sessions: sessions,
+		users:    users,
+		finisher: finisher,
+		mailer:   mailer,
+	}
+}
+
+// SendLink mails a single use sign in link to the email. The user is created
+// when the link is redeemed.
+func (c *magicLinker) SendLink(email string) error {
+	if err := validateEmail(email); err != nil {
+		return err
+	}
+
+	token := generateRandomString(SessionIDSize)
+
+	link, err := tokenLink(c.linkURL, token)
+	if err != nil {
+		return err
+	}
+
+	now := c.timer.Now()
+
+	err = c.sessions.Create(model.Session{
+		ID:      linkSessionPrefix + token,
+		Value:   email,
+		Created: now.Unix(),
+		Expires: now.Add(c.ttl).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.mailer.Send(mail.Message{
+		To:      email,
+		Subject: "Sign in",
+		Body:    fmt.Sprintf("Follow the link to sign in:\n\n%s\n", link),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send sign in mail: %w", err)
+	}
+
+	return nil
+}
+
+func (c *magicLinker) Redeem(token string) (SignInResult, error) {
+	var empty SignInResult
+
+	if token == "" || strings.Contains(token, ":") {
+		return empty, ErrInvalidLinkToken
+	}
+
+	sess, err := c.sessions.Consume(linkSessionPrefix + token)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) || errors.Is(err, repo.ErrorConsumed) {
+			return empty, ErrInvalidLinkToken
+		}
+		return empty, err
+	}
+
+	if sess.Expires < c.timer.Now().Unix() {
+		return empty, ErrSessionExpired
+	}
+
+	user, err := c.users.FindOrCreate(sess.Value)
+	if err != nil {
+		return empty, err
+	}
+
+	grant := Grant{
+		User:     user,
+		Provider: EmailProvider,
+		Methods:  []string{MethodEmail},
+	}
+
+	return c.finisher.Finish(grant, nil)
+}
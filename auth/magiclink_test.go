@@ -0,0 +1,119 @@
+package auth_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/mail"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func TestMagicLink(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	ttl := 15 * time.Minute
+	linkURL := "http://app.local/magic"
+
+	newLinker := func(timer auth.Timer, sessions *sessionsMock, users *userFindOrCreatorMock, finisher *signInFinisherMock, mailer *mailerMock) auth.MagicLinker {
+		return auth.NewMagicLinker(timer, ttl, linkURL, sessions, users, finisher, mailer)
+	}
+
+	t.Run("SendLink", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		sessions := &sessionsMock{}
+		mailer := &mailerMock{}
+
+		sessions.On("Create", mock.Anything).Return(nil)
+		mailer.On("Send", mock.Anything).Return(nil)
+
+		cmd := newLinker(timer, sessions, &userFindOrCreatorMock{}, &signInFinisherMock{}, mailer)
+
+		require.NoError(t, cmd.SendLink(user.Name))
+
+		session := sessions.Calls[0].Arguments.Get(0).(model.Session)
+		require.True(t, strings.HasPrefix(session.ID, "link:"))
+		require.Equal(t, user.Name, session.Value)
+		require.Equal(t, timer.Now().Add(ttl).Unix(), session.Expires)
+
+		msg := mailer.Calls[0].Arguments.Get(0).(mail.Message)
+		require.Equal(t, user.Name, msg.To)
+		require.Contains(t, msg.Body, linkURL+"?token="+strings.TrimPrefix(session.ID, "link:"))
+	})
+
+	t.Run("SendLinkInvalidEmail", func(t *testing.T) {
+		sessions := &sessionsMock{}
+
+		cmd := newLinker(&timerMock{value: time.Now()}, sessions, &userFindOrCreatorMock{}, &signInFinisherMock{}, &mailerMock{})
+
+		require.ErrorIs(t, cmd.SendLink("xxx"), auth.ErrInvalidEmail)
+		sessions.AssertNotCalled(t, "Create", mock.Anything)
+	})
+
+	t.Run("SendLinkMailFailed", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		mailer := &mailerMock{}
+
+		fail := errors.New("xxx")
+		sessions.On("Create", mock.Anything).Return(nil)
+		mailer.On("Send", mock.Anything).Return(fail)
+
+		cmd := newLinker(&timerMock{value: time.Now()}, sessions, &userFindOrCreatorMock{}, &signInFinisherMock{}, mailer)
+
+		require.ErrorIs(t, cmd.SendLink(user.Name), fail)
+	})
+
+	t.Run("Redeem", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000000, 0)}
+		sessions := &sessionsMock{}
+		users := &userFindOrCreatorMock{}
+		finisher := &signInFinisherMock{}
+
+		token := auth.Token{Access: "access.123"}
+
+		session := model.Session{ID: "link:token.123", Value: user.Name, Expires: 1600000060}
+		sessions.On("Consume", session.ID).Return(session, nil)
+		users.On("FindOrCreate", user.Name).Return(user, nil)
+		grant := auth.Grant{User: user, Provider: auth.EmailProvider, Methods: []string{auth.MethodEmail}}
+		finisher.On("Finish", grant, (*auth.AuthRequest)(nil)).Return(auth.SignInResult{Token: token}, nil)
+
+		cmd := newLinker(timer, sessions, users, finisher, &mailerMock{})
+
+		result, err := cmd.Redeem("token.123")
+		require.NoError(t, err)
+		require.Equal(t, auth.SignInResult{Token: token}, result)
+	})
+
+	t.Run("RedeemExpired", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000000, 0)}
+		sessions := &sessionsMock{}
+		users := &userFindOrCreatorMock{}
+
+		session := model.Session{ID: "link:token.123", Value: user.Name, Expires: 1599999999}
+		sessions.On("Consume", session.ID).Return(session, nil)
+
+		cmd := newLinker(timer, sessions, users, &signInFinisherMock{}, &mailerMock{})
+
+		_, err := cmd.Redeem("token.123")
+		require.ErrorIs(t, err, auth.ErrSessionExpired)
+		users.AssertNotCalled(t, "FindOrCreate", mock.Anything)
+	})
+
+	t.Run("RedeemInvalidToken", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		sessions.On("Consume", "link:xxx").Return(nil, repo.ErrorNotFound)
+		sessions.On("Consume", "link:used").Return(model.Session{}, repo.ErrorConsumed)
+
+		cmd := newLinker(&timerMock{value: time.Now()}, sessions, &userFindOrCreatorMock{}, &signInFinisherMock{}, &mailerMock{})
+
+		for _, token := range []string{"xxx", "used", "reset:xxx", ""} {
+			_, err := cmd.Redeem(token)
+			require.ErrorIs(t, err, auth.ErrInvalidLinkToken, token)
+		}
+	})
+}
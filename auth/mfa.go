@@ -0,0 +1,430 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+const mfaSessionPrefix = "mfa:"
+
+var (
+	ErrMFADisabled    = Error{msg: "two factor authentication is not configured"}
+	ErrMFAEnabled     = Error{msg: "two factor authentication already enabled"}
+	ErrMFANotEnrolled = Error{msg: "two factor authentication not enrolled"}
+	ErrInvalidMFA     = Error{msg: "invalid mfa token"}
+	ErrInvalidOTP     = Error{msg: "invalid code"}
+)
+
+// mfaChallenge is the value of the session created when a sign in waits for
+// the second factor.
+type mfaChallenge struct {
+	UserID   string       `json:"user_id"`
+	Provider string       `json:"provider"`
+	Methods  []string     `json:"methods"`
+	Request  *AuthRequest `json:"request,omitempty"`
+}
+
+// finishSignIn issues the token pair or, if the sign in was started by a
+// client request, an authorization code.
+func finishSignIn(timer Timer, sessions repo.Sessions, issuer Issuer, codeTTL time.Duration, grant Grant, req *AuthRequest) (SignInResult, error) {
+	var empty SignInResult
+
+	if req != nil {
+		redirect, err := createCode(sessions, timer, codeTTL, authCode{
+			UserID:   grant.User.ID,
+			Provider: grant.Provider,
+			Methods:  grant.Methods,
+			Request:  *req,
+		})
+		if err != nil {
+			return empty, err
+		}
+
+		return SignInResult{Redirect: redirect}, nil
+	}
+
+	token, err := issuer.Issue(grant)
+	if err != nil {
+		return empty, fmt.Errorf("token issue failed: %w", err)
+	}
+
+	return SignInResult{Token: token}, nil
+}
+
+// consumeMFAChallenge returns the sign in waiting for the second factor. The
+// challenge can be used once.
+func consumeMFAChallenge(timer Timer, sessions repo.Sessions, challenge string) (mfaChallenge, error) {
+	var value mfaChallenge
+
+	if challenge == "" || strings.Contains(challenge, ":") {
+		return value, ErrInvalidMFA
+	}
+
+	sess, err := sessions.Consume(mfaSessionPrefix + challenge)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) || errors.Is(err, repo.ErrorConsumed) {
+			return value, ErrInvalidMFA
+		}
+		return value, err
+	}
+
+	if sess.Expires < timer.Now().Unix() {
+		return value, ErrSessionExpired
+	}
+
+	if err := json.Unmarshal([]byte(sess.Value), &value); err != nil {
+		return value, fmt.Errorf("failed to decode mfa challenge: %w", err)
+	}
+
+	return value, nil
+}
+
+// SignInFinisher ends the sign in of the user authenticated by the first
+// factor. Users with two factor authentication enabled or with passkeys get
+// an MFA challenge instead of tokens.
+type SignInFinisher interface {
+	Finish(grant Grant, req *AuthRequest) (SignInResult, error)
+}
+
+type signInFinisher struct {
+	timer    Timer
+	sessions repo.Sessions
+	totps    repo.TOTPs
+	passkeys repo.Passkeys
+	issuer   Issuer
+	codeTTL  time.Duration
+	mfaTTL   time.Duration
+	mfaURL   string
+}
+
+func NewSignInFinisher(timer Timer, sessions repo.Sessions, totps repo.TOTPs, passkeys repo.Passkeys, issuer Issuer, codeTTL, mfaTTL time.Duration, mfaURL string) SignInFinisher {
+	return &signInFinisher{
+		timer:    timer,
+		sessions: sessions,
+		totps:    totps,
+		passkeys: passkeys,
+		issuer:   issuer,
+		codeTTL:  codeTTL,
+		mfaTTL:   mfaTTL,
+		mfaURL:   mfaURL,
+	}
+}
+
+func (c *signInFinisher) mfaEnabled(userID string) (bool, error) {
+	totp, err := c.totps.Find(userID)
+	if err != nil && !errors.Is(err, repo.ErrorNotFound) {
+		return false, fmt.Errorf("failed to find totp: %w", err)
+	}
+
+	if err == nil && totp.Enabled {
+		return true, nil
+	}
+
+	passkeys, err := c.passkeys.FindByUser(userID)
+	if err != nil {
+		return false, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+
+	return len(passkeys) > 0, nil
+}
+
+// Finish returns the MFA challenge if the user has enabled two factor
+// authentication. A sign in started by a client request is redirected to the
+// MFA page with the challenge in the token query parameter.
+func (c *signInFinisher) Finish(grant Grant, req *AuthRequest) (SignInResult, error) {
+	var empty SignInResult
+
+	enabled, err := c.mfaEnabled(grant.User.ID)
+	if err != nil {
+		return empty, err
+	}
+
+	if !enabled {
+		return finishSignIn(c.timer, c.sessions, c.issuer, c.codeTTL, grant, req)
+	}
+
+	value, err := json.Marshal(mfaChallenge{
+		UserID:   grant.User.ID,
+		Provider: grant.Provider,
+		Methods:  grant.Methods,
+		Request:  req,
+	})
+	if err != nil {
+		return empty, fmt.Errorf("failed to encode mfa challenge: %w", err)
+	}
+
+	token := generateRandomString(SessionIDSize)
+	now := c.timer.Now()
+
+	err = c.sessions.Create(model.Session{
+		ID:      mfaSessionPrefix + token,
+		Value:   string(value),
+		Created: now.Unix(),
+		Expires: now.Add(c.mfaTTL).Unix(),
+	})
+	if err != nil {
+		return empty, err
+	}
+
+	result := SignInResult{Challenge: token}
+
+	if req != nil {
+		if result.Redirect, err = tokenLink(c.mfaURL, token); err != nil {
+			return empty, err
+		}
+	}
+
+	return result, nil
+}
+
+type MFACompleter interface {
+	Complete(challenge, code string) (SignInResult, error)
+}
+
+type mfaCompleter struct {
+	timer    Timer
+	sessions repo.Sessions
+	users    repo.Users
+	totps    repo.TOTPs
+	cipher   Cipher
+	issuer   Issuer
+	codeTTL  time.Duration
+}
+
+func NewMFACompleter(timer Timer, sessions repo.Sessions, users repo.Users, totps repo.TOTPs, cipher Cipher, issuer Issuer, codeTTL time.Duration) MFACompleter {
+	return &mfaCompleter{
+		timer:    timer,
+		sessions: sessions,
+		users:    users,
+		totps:    totps,
+		cipher:   cipher,
+		issuer:   issuer,
+		codeTTL:  codeTTL,
+	}
+}
+
+// Complete finishes the sign in waiting for the second factor. The code is
+// either a TOTP code or a recovery code. The challenge is consumed by the
+// first attempt, so a wrong code requires to sign in again.
+func (c *mfaCompleter) Complete(challenge, code string) (SignInResult, error) {
+	var empty SignInResult
+
+	if c.cipher == nil {
+		return empty, ErrMFADisabled
+	}
+
+	value, err := consumeMFAChallenge(c.timer, c.sessions, challenge)
+	if err != nil {
+		return empty, err
+	}
+
+	totp, err := c.totps.Find(value.UserID)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, ErrInvalidMFA
+		}
+		return empty, err
+	}
+
+	prev := totp
+
+	ok, err := checkTOTP(c.cipher, c.timer.Now(), &totp, code, true)
+	if err != nil {
+		return empty, err
+	}
+	if !ok {
+		return empty, ErrInvalidOTP
+	}
+
+	if err := useTOTP(c.totps, prev, totp); err != nil {
+		return empty, err
+	}
+
+	user, err := c.users.FindByID(value.UserID)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, ErrInvalidMFA
+		}
+		return empty, err
+	}
+
+	grant := Grant{
+		User:     user,
+		Provider: value.Provider,
+		Methods:  append(value.Methods, MethodOTP, MethodMFA),
+	}
+
+	return finishSignIn(c.timer, c.sessions, c.issuer, c.codeTTL, grant, value.Request)
+}
+
+// Enrollment is the TOTP secret and the recovery codes shown to the user once.
+type Enrollment struct {
+	Secret        string   `json:"secret"`
+	URI           string   `json:"uri"`
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+type MFAEnroller interface {
+	Enroll(access string) (Enrollment, error)
+	Confirm(access, code string) error
+	Disable(access, code string) error
+}
+
+type mfaEnroller struct {
+	timer    Timer
+	verifier Verifier
+	users    repo.Users
+	totps    repo.TOTPs
+	cipher   Cipher
+	issuer   string
+}
+
+func NewMFAEnroller(timer Timer, verifier Verifier, users repo.Users, totps repo.TOTPs, cipher Cipher, issuer string) MFAEnroller {
+	return &mfaEnroller{
+		timer:    timer,
+		verifier: verifier,
+		users:    users,
+		totps:    totps,
+		cipher:   cipher,
+		issuer:   issuer,
+	}
+}
+
+func (c *mfaEnroller) subject(access string) (string, error) {
+	if c.cipher == nil {
+		return "", ErrMFADisabled
+	}
+
+	claims, err := c.verifier.Verify(access)
+	if err != nil {
+		return "", err
+	}
+
+	sub, _ := claims["sub"].(string)
+	return sub, nil
+}
+
+func (c *mfaEnroller) find(userID string) (model.TOTP, error) {
+	totp, err := c.totps.Find(userID)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return totp, ErrMFANotEnrolled
+		}
+		return totp, fmt.Errorf("failed to find totp: %w", err)
+	}
+
+	return totp, nil
+}
+
+// Enroll generates a new TOTP secret and recovery codes for the access token
+// owner. Two factor authentication is enabled once a code is confirmed.
+func (c *mfaEnroller) Enroll(access string) (Enrollment, error) {
+	var empty Enrollment
+
+	sub, err := c.subject(access)
+	if err != nil {
+		return empty, err
+	}
+
+	user, err := c.users.FindByID(sub)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, Error{msg: "invalid token"}
+		}
+		return empty, err
+	}
+
+	existing, err := c.totps.Find(user.ID)
+	if err == nil && existing.Enabled {
+		return empty, ErrMFAEnabled
+	}
+	if err != nil && !errors.Is(err, repo.ErrorNotFound) {
+		return empty, fmt.Errorf("failed to find totp: %w", err)
+	}
+
+	secret := generateRandomBytes(TOTPSecretSize)
+
+	encrypted, err := c.cipher.Encrypt(secret)
+	if err != nil {
+		return empty, err
+	}
+
+	codes, hashes := generateRecoveryCodes()
+
+	err = c.totps.Save(model.TOTP{
+		UserID:        user.ID,
+		Secret:        encrypted,
+		RecoveryCodes: hashes,
+		Created:       c.timer.Now().Unix(),
+	})
+	if err != nil {
+		return empty, err
+	}
+
+	return Enrollment{
+		Secret:        totpEncoding.EncodeToString(secret),
+		URI:           otpauthURI(c.issuer, user.Name, secret),
+		RecoveryCodes: codes,
+	}, nil
+}
+
+func (c *mfaEnroller) Confirm(access, code string) error {
+	sub, err := c.subject(access)
+	if err != nil {
+		return err
+	}
+
+	totp, err := c.find(sub)
+	if err != nil {
+		return err
+	}
+
+	if totp.Enabled {
+		return ErrMFAEnabled
+	}
+
+	prev := totp
+
+	ok, err := checkTOTP(c.cipher, c.timer.Now(), &totp, code, false)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidOTP
+	}
+
+	totp.Enabled = true
+	return useTOTP(c.totps, prev, totp)
+}
+
+// Disable removes two factor authentication of the access token owner, a
+// valid code is required.
+func (c *mfaEnroller) Disable(access, code string) error {
+	sub, err := c.subject(access)
+	if err != nil {
+		return err
+	}
+
+	totp, err := c.find(sub)
+	if err != nil {
+		return err
+	}
+
+	if totp.Enabled {
+		ok, err := checkTOTP(c.cipher, c.timer.Now(), &totp, code, true)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return ErrInvalidOTP
+		}
+	}
+
+	return c.totps.Delete(sub)
+}
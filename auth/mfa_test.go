@@ -0,0 +1,577 @@
+package auth_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+type signInFinisherMock struct {
+	mock.Mock
+}
+
+func (m *signInFinisherMock) Finish(grant auth.Grant, req *auth.AuthRequest) (auth.SignInResult, error) {
+	args := m.Called(grant, req)
+	return args.Get(0).(auth.SignInResult), args.Error(1)
+}
+
+type totpsMock struct {
+	mock.Mock
+}
+
+func (m *totpsMock) Find(userID string) (model.TOTP, error) {
+	args := m.Called(userID)
+
+	totp := args.Get(0)
+	if totp == nil {
+		return model.TOTP{}, args.Error(1)
+	}
+
+	return totp.(model.TOTP), args.Error(1)
+}
+
+func (m *totpsMock) Save(totp model.TOTP) error {
+	return m.Called(totp).Error(0)
+}
+
+func (m *totpsMock) Use(prev, totp model.TOTP) error {
+	return m.Called(prev, totp).Error(0)
+}
+
+func (m *totpsMock) Delete(userID string) error {
+	return m.Called(userID).Error(0)
+}
+
+// rfcSecret is the RFC 6238 test secret, the codes below are the last 6
+// digits of the RFC SHA1 test vectors.
+var rfcSecret = []byte("12345678901234567890")
+
+const (
+	rfcTime1 = 59
+	rfcCode1 = "287082"
+	rfcTime2 = 1111111109
+	rfcCode2 = "081804"
+)
+
+func newTestCipher(t *testing.T) auth.Cipher {
+	cipher, err := auth.NewCipher(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	return cipher
+}
+
+func newTestTOTP(t *testing.T, cipher auth.Cipher, userID string, recoveryCodes ...string) model.TOTP {
+	secret, err := cipher.Encrypt(rfcSecret)
+	require.NoError(t, err)
+
+	hashes := make([]string, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		sum := sha256.Sum256([]byte(code))
+		hashes[i] = hex.EncodeToString(sum[:])
+	}
+
+	return model.TOTP{
+		UserID:        userID,
+		Secret:        secret,
+		RecoveryCodes: strings.Join(hashes, " "),
+		Enabled:       true,
+	}
+}
+
+func TestSignInFinisher(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	grant := auth.Grant{User: user, Provider: auth.LocalProvider, Methods: []string{auth.MethodPassword}}
+	mfaURL := "http://app.local/mfa"
+
+	newFinisher := func(timer auth.Timer, sessions *sessionsMock, totps *totpsMock, issuer *issuerMock) auth.SignInFinisher {
+		passkeys := &passkeysMock{}
+		passkeys.On("FindByUser", user.ID).Return([]model.Passkey{}, nil)
+		return auth.NewSignInFinisher(timer, sessions, totps, passkeys, issuer, time.Minute, 5*time.Minute, mfaURL)
+	}
+
+	t.Run("NoTOTP", func(t *testing.T) {
+		totps := &totpsMock{}
+		issuer := &issuerMock{}
+
+		token := auth.Token{Access: "access.123"}
+		totps.On("Find", user.ID).Return(nil, repo.ErrorNotFound)
+		issuer.On("Issue", grant).Return(token, nil)
+
+		cmd := newFinisher(&timerMock{value: time.Now()}, &sessionsMock{}, totps, issuer)
+
+		result, err := cmd.Finish(grant, nil)
+		require.NoError(t, err)
+		require.Equal(t, auth.SignInResult{Token: token}, result)
+	})
+
+	t.Run("TOTPNotConfirmed", func(t *testing.T) {
+		totps := &totpsMock{}
+		issuer := &issuerMock{}
+
+		token := auth.Token{Access: "access.123"}
+		totps.On("Find", user.ID).Return(model.TOTP{UserID: user.ID}, nil)
+		issuer.On("Issue", grant).Return(token, nil)
+
+		cmd := newFinisher(&timerMock{value: time.Now()}, &sessionsMock{}, totps, issuer)
+
+		result, err := cmd.Finish(grant, nil)
+		require.NoError(t, err)
+		require.Equal(t, auth.SignInResult{Token: token}, result)
+	})
+
+	t.Run("AuthRequest", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
+		sessions := &sessionsMock{}
+		totps := &totpsMock{}
+		issuer := &issuerMock{}
+
+		req := &auth.AuthRequest{
+			RedirectURI: "http://app.local/callback?x=1",
+			State:       "state.123",
+		}
+
+		var code model.Session
+		totps.On("Find", user.ID).Return(nil, repo.ErrorNotFound)
+		sessions.On("Create", mock.Anything).Run(func(args mock.Arguments) {
+			code = args.Get(0).(model.Session)
+		}).Return(nil)
+
+		cmd := newFinisher(timer, sessions, totps, issuer)
+
+		result, err := cmd.Finish(grant, req)
+		require.NoError(t, err)
+		require.Empty(t, result.Token)
+		issuer.AssertNotCalled(t, "Issue", mock.Anything)
+
+		require.True(t, strings.HasPrefix(code.ID, "code:"))
+		require.Equal(t, timer.Now().Add(time.Minute).Unix(), code.Expires)
+		require.Contains(t, code.Value, user.ID)
+		require.Contains(t, code.Value, auth.MethodPassword)
+
+		redirect, err := url.Parse(result.Redirect)
+		require.NoError(t, err)
+		require.Equal(t, "app.local", redirect.Host)
+		require.Equal(t, "1", redirect.Query().Get("x"))
+		require.Equal(t, "state.123", redirect.Query().Get("state"))
+		require.Equal(t, strings.TrimPrefix(code.ID, "code:"), redirect.Query().Get("code"))
+	})
+
+	t.Run("Challenge", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
+		sessions := &sessionsMock{}
+		totps := &totpsMock{}
+		issuer := &issuerMock{}
+
+		totps.On("Find", user.ID).Return(model.TOTP{UserID: user.ID, Enabled: true}, nil)
+		sessions.On("Create", mock.Anything).Return(nil)
+
+		cmd := newFinisher(timer, sessions, totps, issuer)
+
+		result, err := cmd.Finish(grant, nil)
+		require.NoError(t, err)
+		require.NotEmpty(t, result.Challenge)
+		require.Empty(t, result.Token)
+		require.Empty(t, result.Redirect)
+		issuer.AssertNotCalled(t, "Issue", mock.Anything)
+
+		session := sessions.Calls[0].Arguments.Get(0).(model.Session)
+		require.Equal(t, "mfa:"+result.Challenge, session.ID)
+		require.Equal(t, timer.Now().Add(5*time.Minute).Unix(), session.Expires)
+		require.Contains(t, session.Value, user.ID)
+	})
+
+	t.Run("ChallengeAuthRequest", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		totps := &totpsMock{}
+
+		totps.On("Find", user.ID).Return(model.TOTP{UserID: user.ID, Enabled: true}, nil)
+		sessions.On("Create", mock.Anything).Return(nil)
+
+		cmd := newFinisher(&timerMock{value: time.Now()}, sessions, totps, &issuerMock{})
+
+		result, err := cmd.Finish(grant, &auth.AuthRequest{RedirectURI: "http://app.local/callback"})
+		require.NoError(t, err)
+		require.Equal(t, mfaURL+"?token="+result.Challenge, result.Redirect)
+
+		session := sessions.Calls[0].Arguments.Get(0).(model.Session)
+		require.Contains(t, session.Value, "http://app.local/callback")
+	})
+
+	t.Run("Passkeys", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		totps := &totpsMock{}
+		passkeys := &passkeysMock{}
+		issuer := &issuerMock{}
+
+		totps.On("Find", user.ID).Return(nil, repo.ErrorNotFound)
+		passkeys.On("FindByUser", user.ID).Return([]model.Passkey{{ID: "key.123", UserID: user.ID}}, nil)
+		sessions.On("Create", mock.Anything).Return(nil)
+
+		cmd := auth.NewSignInFinisher(&timerMock{value: time.Now()}, sessions, totps, passkeys, issuer, time.Minute, 5*time.Minute, mfaURL)
+
+		result, err := cmd.Finish(grant, nil)
+		require.NoError(t, err)
+		require.NotEmpty(t, result.Challenge)
+		require.Empty(t, result.Token)
+		issuer.AssertNotCalled(t, "Issue", mock.Anything)
+	})
+
+	t.Run("FindFailed", func(t *testing.T) {
+		totps := &totpsMock{}
+		issuer := &issuerMock{}
+
+		fail := errors.New("xxx")
+		totps.On("Find", user.ID).Return(nil, fail)
+
+		cmd := newFinisher(&timerMock{value: time.Now()}, &sessionsMock{}, totps, issuer)
+
+		_, err := cmd.Finish(grant, nil)
+		require.ErrorIs(t, err, fail)
+		issuer.AssertNotCalled(t, "Issue", mock.Anything)
+	})
+}
+
+func TestMFACompleter(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	cipher := newTestCipher(t)
+
+	session := model.Session{
+		ID:      "mfa:challenge.123",
+		Value:   `{"user_id":"user.123","provider":"local","methods":["pwd"]}`,
+		Expires: rfcTime2 + 60,
+	}
+
+	grant := auth.Grant{
+		User:     user,
+		Provider: auth.LocalProvider,
+		Methods:  []string{auth.MethodPassword, auth.MethodOTP, auth.MethodMFA},
+	}
+
+	newCompleter := func(timer auth.Timer, sessions *sessionsMock, users *usersMock, totps *totpsMock, issuer *issuerMock) auth.MFACompleter {
+		return auth.NewMFACompleter(timer, sessions, users, totps, cipher, issuer, time.Minute)
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		for _, tc := range []struct {
+			now  int64
+			code string
+			step int64
+		}{
+			{now: rfcTime1, code: rfcCode1, step: rfcTime1 / auth.TOTPPeriod},
+			{now: rfcTime2, code: rfcCode2, step: rfcTime2 / auth.TOTPPeriod},
+			{now: rfcTime2 + auth.TOTPPeriod, code: rfcCode2, step: rfcTime2 / auth.TOTPPeriod},
+		} {
+			sessions := &sessionsMock{}
+			users := &usersMock{}
+			totps := &totpsMock{}
+			issuer := &issuerMock{}
+
+			totp := newTestTOTP(t, cipher, user.ID)
+			token := auth.Token{Access: "access.123"}
+
+			sessions.On("Consume", session.ID).Return(session, nil)
+			users.On("FindByID", user.ID).Return(user, nil)
+			totps.On("Find", user.ID).Return(totp, nil)
+			totps.On("Use", totp, mock.Anything).Return(nil)
+			issuer.On("Issue", grant).Return(token, nil)
+
+			cmd := newCompleter(&timerMock{value: time.Unix(tc.now, 0)}, sessions, users, totps, issuer)
+
+			result, err := cmd.Complete("challenge.123", tc.code)
+			require.NoError(t, err, tc.code)
+			require.Equal(t, auth.SignInResult{Token: token}, result)
+
+			saved := totps.Calls[1].Arguments.Get(1).(model.TOTP)
+			require.Equal(t, tc.step, saved.LastStep)
+		}
+	})
+
+	t.Run("ReplayedCode", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		totps := &totpsMock{}
+		issuer := &issuerMock{}
+
+		totp := newTestTOTP(t, cipher, user.ID)
+		totp.LastStep = rfcTime2 / auth.TOTPPeriod
+
+		sessions.On("Consume", session.ID).Return(session, nil)
+		totps.On("Find", user.ID).Return(totp, nil)
+
+		cmd := newCompleter(&timerMock{value: time.Unix(rfcTime2, 0)}, sessions, &usersMock{}, totps, issuer)
+
+		_, err := cmd.Complete("challenge.123", rfcCode2)
+		require.ErrorIs(t, err, auth.ErrInvalidOTP)
+		issuer.AssertNotCalled(t, "Issue", mock.Anything)
+	})
+
+	t.Run("ConcurrentCode", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		totps := &totpsMock{}
+		issuer := &issuerMock{}
+
+		totp := newTestTOTP(t, cipher, user.ID)
+
+		sessions.On("Consume", session.ID).Return(session, nil)
+		totps.On("Find", user.ID).Return(totp, nil)
+		totps.On("Use", totp, mock.Anything).Return(repo.ErrorConsumed)
+
+		cmd := newCompleter(&timerMock{value: time.Unix(rfcTime2, 0)}, sessions, &usersMock{}, totps, issuer)
+
+		_, err := cmd.Complete("challenge.123", rfcCode2)
+		require.ErrorIs(t, err, auth.ErrInvalidOTP)
+		issuer.AssertNotCalled(t, "Issue", mock.Anything)
+	})
+
+	t.Run("WrongCode", func(t *testing.T) {
+		for _, code := range []string{"000000", "", "12345", "xxxxxxxxxx"} {
+			sessions := &sessionsMock{}
+			totps := &totpsMock{}
+
+			sessions.On("Consume", session.ID).Return(session, nil)
+			totps.On("Find", user.ID).Return(newTestTOTP(t, cipher, user.ID, "recovery12"), nil)
+
+			cmd := newCompleter(&timerMock{value: time.Unix(rfcTime2, 0)}, sessions, &usersMock{}, totps, &issuerMock{})
+
+			_, err := cmd.Complete("challenge.123", code)
+			require.ErrorIs(t, err, auth.ErrInvalidOTP, code)
+			totps.AssertNotCalled(t, "Use", mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("RecoveryCode", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		users := &usersMock{}
+		totps := &totpsMock{}
+		issuer := &issuerMock{}
+
+		totp := newTestTOTP(t, cipher, user.ID, "recovery12", "recovery34")
+
+		sessions.On("Consume", session.ID).Return(session, nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+		totps.On("Find", user.ID).Return(totp, nil)
+		totps.On("Use", totp, mock.Anything).Return(nil)
+		issuer.On("Issue", grant).Return(auth.Token{}, nil)
+
+		cmd := newCompleter(&timerMock{value: time.Unix(rfcTime2, 0)}, sessions, users, totps, issuer)
+
+		_, err := cmd.Complete("challenge.123", "RECOVERY34")
+		require.NoError(t, err)
+
+		saved := totps.Calls[1].Arguments.Get(1).(model.TOTP)
+		require.Equal(t, newTestTOTP(t, cipher, user.ID, "recovery12").RecoveryCodes, saved.RecoveryCodes)
+	})
+
+	t.Run("InvalidChallenge", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		sessions.On("Consume", "mfa:xxx").Return(nil, repo.ErrorNotFound)
+		sessions.On("Consume", "mfa:used").Return(model.Session{}, repo.ErrorConsumed)
+
+		cmd := newCompleter(&timerMock{value: time.Now()}, sessions, &usersMock{}, &totpsMock{}, &issuerMock{})
+
+		for _, challenge := range []string{"xxx", "used", "code:xxx", ""} {
+			_, err := cmd.Complete(challenge, rfcCode1)
+			require.ErrorIs(t, err, auth.ErrInvalidMFA, challenge)
+		}
+	})
+
+	t.Run("ChallengeExpired", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		totps := &totpsMock{}
+
+		sessions.On("Consume", session.ID).Return(session, nil)
+
+		cmd := newCompleter(&timerMock{value: time.Unix(session.Expires+1, 0)}, sessions, &usersMock{}, totps, &issuerMock{})
+
+		_, err := cmd.Complete("challenge.123", rfcCode2)
+		require.ErrorIs(t, err, auth.ErrSessionExpired)
+		totps.AssertNotCalled(t, "Find", mock.Anything)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		sessions := &sessionsMock{}
+
+		cmd := auth.NewMFACompleter(&timerMock{value: time.Now()}, sessions, &usersMock{}, &totpsMock{}, nil, &issuerMock{}, time.Minute)
+
+		_, err := cmd.Complete("challenge.123", rfcCode2)
+		require.ErrorIs(t, err, auth.ErrMFADisabled)
+		sessions.AssertNotCalled(t, "Consume", mock.Anything)
+	})
+}
+
+func TestMFAEnroller(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	claims := map[string]interface{}{"sub": user.ID}
+	cipher := newTestCipher(t)
+
+	newEnroller := func(timer auth.Timer, verifier *verifierMock, users *usersMock, totps *totpsMock) auth.MFAEnroller {
+		return auth.NewMFAEnroller(timer, verifier, users, totps, cipher, "guard")
+	}
+
+	t.Run("Enroll", func(t *testing.T) {
+		verifier := &verifierMock{}
+		users := &usersMock{}
+		totps := &totpsMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+		totps.On("Find", user.ID).Return(nil, repo.ErrorNotFound)
+		totps.On("Save", mock.Anything).Return(nil)
+
+		cmd := newEnroller(&timerMock{value: time.Now()}, verifier, users, totps)
+
+		enrollment, err := cmd.Enroll("access.123")
+		require.NoError(t, err)
+		require.Len(t, enrollment.RecoveryCodes, auth.RecoveryCodeCount)
+
+		secret, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(enrollment.Secret)
+		require.NoError(t, err)
+		require.Len(t, secret, auth.TOTPSecretSize)
+
+		uri, err := url.Parse(enrollment.URI)
+		require.NoError(t, err)
+		require.Equal(t, "otpauth", uri.Scheme)
+		require.Equal(t, "totp", uri.Host)
+		require.Equal(t, "/guard:"+user.Name, uri.Path)
+		require.Equal(t, enrollment.Secret, uri.Query().Get("secret"))
+		require.Equal(t, "guard", uri.Query().Get("issuer"))
+
+		saved := totps.Calls[1].Arguments.Get(0).(model.TOTP)
+		require.False(t, saved.Enabled)
+		require.NotContains(t, saved.Secret, enrollment.Secret)
+
+		value, err := cipher.Decrypt(saved.Secret)
+		require.NoError(t, err)
+		require.Equal(t, secret, value)
+
+		for _, code := range enrollment.RecoveryCodes {
+			require.Len(t, code, auth.RecoveryCodeSize)
+			require.NotContains(t, saved.RecoveryCodes, code)
+		}
+		require.Len(t, strings.Fields(saved.RecoveryCodes), auth.RecoveryCodeCount)
+	})
+
+	t.Run("EnrollEnabled", func(t *testing.T) {
+		verifier := &verifierMock{}
+		users := &usersMock{}
+		totps := &totpsMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+		totps.On("Find", user.ID).Return(model.TOTP{UserID: user.ID, Enabled: true}, nil)
+
+		cmd := newEnroller(&timerMock{value: time.Now()}, verifier, users, totps)
+
+		_, err := cmd.Enroll("access.123")
+		require.ErrorIs(t, err, auth.ErrMFAEnabled)
+		totps.AssertNotCalled(t, "Save", mock.Anything)
+	})
+
+	t.Run("EnrollInvalidToken", func(t *testing.T) {
+		verifier := &verifierMock{}
+		verifier.On("Verify", "xxx").Return(nil, auth.Error{})
+
+		cmd := newEnroller(&timerMock{value: time.Now()}, verifier, &usersMock{}, &totpsMock{})
+
+		_, err := cmd.Enroll("xxx")
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("Confirm", func(t *testing.T) {
+		verifier := &verifierMock{}
+		totps := &totpsMock{}
+
+		totp := newTestTOTP(t, cipher, user.ID)
+		totp.Enabled = false
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		totps.On("Find", user.ID).Return(totp, nil)
+		totps.On("Use", totp, mock.Anything).Return(nil)
+
+		cmd := newEnroller(&timerMock{value: time.Unix(rfcTime2, 0)}, verifier, &usersMock{}, totps)
+
+		require.NoError(t, cmd.Confirm("access.123", rfcCode2))
+
+		saved := totps.Calls[1].Arguments.Get(1).(model.TOTP)
+		require.True(t, saved.Enabled)
+		require.Equal(t, int64(rfcTime2/auth.TOTPPeriod), saved.LastStep)
+	})
+
+	t.Run("ConfirmRecoveryCode", func(t *testing.T) {
+		verifier := &verifierMock{}
+		totps := &totpsMock{}
+
+		totp := newTestTOTP(t, cipher, user.ID, "recovery12")
+		totp.Enabled = false
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		totps.On("Find", user.ID).Return(totp, nil)
+
+		cmd := newEnroller(&timerMock{value: time.Unix(rfcTime2, 0)}, verifier, &usersMock{}, totps)
+
+		require.ErrorIs(t, cmd.Confirm("access.123", "recovery12"), auth.ErrInvalidOTP)
+		totps.AssertNotCalled(t, "Use", mock.Anything, mock.Anything)
+	})
+
+	t.Run("ConfirmNotEnrolled", func(t *testing.T) {
+		verifier := &verifierMock{}
+		totps := &totpsMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		totps.On("Find", user.ID).Return(nil, repo.ErrorNotFound)
+
+		cmd := newEnroller(&timerMock{value: time.Now()}, verifier, &usersMock{}, totps)
+
+		require.ErrorIs(t, cmd.Confirm("access.123", rfcCode2), auth.ErrMFANotEnrolled)
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		verifier := &verifierMock{}
+		totps := &totpsMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		totps.On("Find", user.ID).Return(newTestTOTP(t, cipher, user.ID), nil)
+		totps.On("Delete", user.ID).Return(nil)
+
+		cmd := newEnroller(&timerMock{value: time.Unix(rfcTime2, 0)}, verifier, &usersMock{}, totps)
+
+		require.NoError(t, cmd.Disable("access.123", rfcCode2))
+		totps.AssertCalled(t, "Delete", user.ID)
+	})
+
+	t.Run("DisableWrongCode", func(t *testing.T) {
+		verifier := &verifierMock{}
+		totps := &totpsMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		totps.On("Find", user.ID).Return(newTestTOTP(t, cipher, user.ID), nil)
+
+		cmd := newEnroller(&timerMock{value: time.Unix(rfcTime2, 0)}, verifier, &usersMock{}, totps)
+
+		require.ErrorIs(t, cmd.Disable("access.123", "000000"), auth.ErrInvalidOTP)
+		totps.AssertNotCalled(t, "Delete", mock.Anything)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		verifier := &verifierMock{}
+
+		cmd := auth.NewMFAEnroller(&timerMock{value: time.Now()}, verifier, &usersMock{}, &totpsMock{}, nil, "guard")
+
+		_, err := cmd.Enroll("access.123")
+		require.ErrorIs(t, err, auth.ErrMFADisabled)
+		require.ErrorIs(t, cmd.Confirm("access.123", rfcCode2), auth.ErrMFADisabled)
+		require.ErrorIs(t, cmd.Disable("access.123", rfcCode2), auth.ErrMFADisabled)
+		verifier.AssertNotCalled(t, "Verify", mock.Anything)
+	})
+}
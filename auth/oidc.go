@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/vbogretsov/guard/repo"
+)
+
+// Discovery is the OpenID Connect provider metadata.
+type Discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	UserInfoEndpoint      string   `json:"userinfo_endpoint"`
+	RevocationEndpoint    string   `json:"revocation_endpoint"`
+	IntrospectionEndpoint string   `json:"introspection_endpoint"`
+	ResponseTypes         []string `json:"response_types_supported"`
+	SubjectTypes          []string `json:"subject_types_supported"`
+	SigningAlgs           []string `json:"id_token_signing_alg_values_supported"`
+	GrantTypes            []string `json:"grant_types_supported"`
+	CodeChallengeMethods  []string `json:"code_challenge_methods_supported"`
+	Scopes                []string `json:"scopes_supported"`
+	Claims                []string `json:"claims_supported"`
+}
+
+// NewDiscovery describes the endpoints served at baseURL. The issuer has to
+// match the iss claim of the tokens issued. Only the algorithms of the keys
+// published are listed, the clients can not verify the secret key tokens.
+func NewDiscovery(baseURL, issuer string, keys []Key) Discovery {
+	baseURL = strings.TrimSuffix(baseURL, "/")
+
+	algs := []string{}
+	seen := map[string]bool{}
+	for _, key := range keys {
+		if key.Public == nil {
+			continue
+		}
+		alg := key.Method.Alg()
+		if !seen[alg] {
+			seen[alg] = true
+			algs = append(algs, alg)
+		}
+	}
+
+	return Discovery{
+		Issuer:                issuer,
+		AuthorizationEndpoint: baseURL + "/authorize",
+		JWKSURI:               baseURL + "/.well-known/jwks.json",
+		TokenEndpoint:         baseURL + "/token",
+		UserInfoEndpoint:      baseURL + "/userinfo",
+		RevocationEndpoint:    baseURL + "/revoke",
+		IntrospectionEndpoint: baseURL + "/introspect",
+		ResponseTypes:         []string{"code"},
+		SubjectTypes:          []string{"public"},
+		SigningAlgs:           algs,
+		GrantTypes:            []string{"authorization_code", "refresh_token", "client_credentials"},
+		CodeChallengeMethods:  []string{codeChallengeS256},
+		Scopes:                []string{"openid", "email"},
+		Claims:                []string{"iss", "sub", "aud", "exp", "iat", "email", "nonce"},
+	}
+}
+
+// UserInfo is the OpenID Connect userinfo response.
+type UserInfo struct {
+	Sub   string `json:"sub"`
+	Email string `json:"email,omitempty"`
+}
+
+type UserInfoer interface {
+	UserInfo(access string) (UserInfo, error)
+}
+
+type userInfoer struct {
+	verifier Verifier
+	users    repo.Users
+}
+
+func NewUserInfoer(verifier Verifier, users repo.Users) UserInfoer {
+	return &userInfoer{verifier: verifier, users: users}
+}
+
+func (c *userInfoer) UserInfo(access string) (UserInfo, error) {
+	var empty UserInfo
+
+	claims, err := c.verifier.Verify(access)
+	if err != nil {
+		return empty, err
+	}
+
+	sub, _ := claims["sub"].(string)
+
+	user, err := c.users.FindByID(sub)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, Error{msg: "invalid token"}
+		}
+		return empty, err
+	}
+
+	return UserInfo{Sub: user.ID, Email: user.Name}, nil
+}
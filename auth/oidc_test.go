@@ -0,0 +1,78 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"testing"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func TestNewDiscovery(t *testing.T) {
+	keys := []auth.Key{
+		{Method: jwt.SigningMethodRS256, Public: &rsa.PublicKey{}},
+		{Method: jwt.SigningMethodEdDSA, Public: ed25519.PublicKey{}},
+		{Method: jwt.SigningMethodRS256, Public: &rsa.PublicKey{}},
+		auth.NewSecretKey("123.456"),
+	}
+
+	discovery := auth.NewDiscovery("https://auth.example.org/", "https://auth.example.org", keys)
+	require.Equal(t, "https://auth.example.org", discovery.Issuer)
+	require.Equal(t, "https://auth.example.org/authorize", discovery.AuthorizationEndpoint)
+	require.Equal(t, "https://auth.example.org/.well-known/jwks.json", discovery.JWKSURI)
+	require.Equal(t, "https://auth.example.org/userinfo", discovery.UserInfoEndpoint)
+	require.Equal(t, []string{"RS256", "EdDSA"}, discovery.SigningAlgs)
+}
+
+func TestUserInfoer(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org", Created: 1600000000}
+
+	t.Run("Success", func(t *testing.T) {
+		verifier := &verifierMock{}
+		users := &usersMock{}
+
+		verifier.On("Verify", "access.123").Return(map[string]interface{}{"sub": user.ID}, nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+
+		info, err := auth.NewUserInfoer(verifier, users).UserInfo("access.123")
+		require.NoError(t, err)
+		require.Equal(t, auth.UserInfo{Sub: user.ID, Email: user.Name}, info)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		verifier := &verifierMock{}
+		verifier.On("Verify", "xxx").Return(nil, auth.Error{})
+
+		_, err := auth.NewUserInfoer(verifier, &usersMock{}).UserInfo("xxx")
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("UserNotFound", func(t *testing.T) {
+		verifier := &verifierMock{}
+		users := &usersMock{}
+
+		verifier.On("Verify", "access.123").Return(map[string]interface{}{"sub": user.ID}, nil)
+		users.On("FindByID", user.ID).Return(nil, repo.ErrorNotFound)
+
+		_, err := auth.NewUserInfoer(verifier, users).UserInfo("access.123")
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("FindFailed", func(t *testing.T) {
+		verifier := &verifierMock{}
+		users := &usersMock{}
+
+		fail := errors.New("xxx")
+		verifier.On("Verify", "access.123").Return(map[string]interface{}{"sub": user.ID}, nil)
+		users.On("FindByID", user.ID).Return(nil, fail)
+
+		_, err := auth.NewUserInfoer(verifier, users).UserInfo("access.123")
+		require.ErrorIs(t, err, fail)
+	})
+}
@@ -0,0 +1,540 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+	"github.com/vbogretsov/guard/webauthn"
+)
+
+const (
+	// PasskeyProvider is the provider of users signed in with a passkey.
+	PasskeyProvider = "passkey"
+
+	PasskeyChallengeSize = 32
+
+	passkeySessionPrefix = "passkey:"
+
+	ceremonyRegister = "register"
+	ceremonySignIn   = "signin"
+	ceremonyMFA      = "mfa"
+)
+
+var (
+	ErrInvalidPasskey = Error{msg: "invalid passkey"}
+	ErrPasskeyExists  = Error{msg: "passkey already registered"}
+	ErrPasskeyCloned  = Error{msg: "passkey sign count did not increase"}
+)
+
+// passkeyCeremony is the value of the session created for a WebAuthn
+// challenge. The MFA ceremony keeps the sign in challenge it completes.
+type passkeyCeremony struct {
+	Kind   string `json:"kind"`
+	UserID string `json:"user_id,omitempty"`
+	MFA    string `json:"mfa,omitempty"`
+}
+
+// PasskeyInfo is a registered passkey shown to its owner.
+type PasskeyInfo struct {
+	ID       string `json:"id"`
+	Created  int64  `json:"created"`
+	LastUsed int64  `json:"last_used,omitempty"`
+}
+
+func newPasskeyInfo(passkey model.Passkey) PasskeyInfo {
+	return PasskeyInfo{
+		ID:       passkey.ID,
+		Created:  passkey.Created,
+		LastUsed: passkey.LastUsed,
+	}
+}
+
+func createPasskeyChallenge(timer Timer, sessions repo.Sessions, ttl time.Duration, value passkeyCeremony) ([]byte, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode passkey ceremony: %w", err)
+	}
+
+	challenge := generateRandomBytes(PasskeyChallengeSize)
+	now := timer.Now()
+
+	err = sessions.Create(model.Session{
+		ID:      passkeySessionPrefix + base64.RawURLEncoding.EncodeToString(challenge),
+		Value:   string(data),
+		Created: now.Unix(),
+		Expires: now.Add(ttl).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+// consumePasskeyChallenge returns the ceremony the challenge signed by the
+// authenticator was created for.
+func consumePasskeyChallenge(timer Timer, sessions repo.Sessions, challenge, kind string) (passkeyCeremony, error) {
+	var value passkeyCeremony
+
+	if challenge == "" || strings.Contains(challenge, ":") {
+		return value, ErrInvalidPasskey
+	}
+
+	sess, err := sessions.Consume(passkeySessionPrefix + challenge)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) || errors.Is(err, repo.ErrorConsumed) {
+			return value, ErrInvalidPasskey
+		}
+		return value, err
+	}
+
+	if sess.Expires < timer.Now().Unix() {
+		return value, ErrSessionExpired
+	}
+
+	if err := json.Unmarshal([]byte(sess.Value), &value); err != nil {
+		return value, fmt.Errorf("failed to decode passkey ceremony: %w", err)
+	}
+
+	if value.Kind != kind {
+		return value, ErrInvalidPasskey
+	}
+
+	return value, nil
+}
+
+func findPasskey(passkeys repo.Passkeys, id string) (model.Passkey, error) {
+	passkey, err := passkeys.Find(id)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return passkey, ErrInvalidPasskey
+		}
+		return passkey, fmt.Errorf("failed to find passkey: %w", err)
+	}
+
+	return passkey, nil
+}
+
+func verifyPasskey(rp webauthn.RelyingParty, passkey model.Passkey, resp webauthn.AuthenticationResponse) (webauthn.Assertion, error) {
+	assertion, err := rp.VerifyAssertion(resp, passkey.PublicKey)
+	if err != nil {
+		return assertion, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+	return assertion, nil
+}
+
+// countSignature stores the sign count of the passkey. A sign count not
+// greater than the stored one means the authenticator could have been
+// cloned. Authenticators that do not count signatures always report zero.
+func countSignature(timer Timer, passkeys repo.Passkeys, passkey model.Passkey, assertion webauthn.Assertion) error {
+	count := int64(assertion.SignCount)
+	if (count != 0 || passkey.SignCount != 0) && count <= passkey.SignCount {
+		return ErrPasskeyCloned
+	}
+
+	passkey.SignCount = count
+	passkey.LastUsed = timer.Now().Unix()
+
+	if err := passkeys.Update(passkey); err != nil {
+		return fmt.Errorf("failed to update passkey: %w", err)
+	}
+
+	return nil
+}
+
+func passkeyIDs(items []model.Passkey) [][]byte {
+	var ids [][]byte
+	for _, item := range items {
+		if id, err := base64.RawURLEncoding.DecodeString(item.ID); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+type PasskeyRegistrar interface {
+	Begin(access string) (webauthn.CreationOptions, error)
+	Finish(access string, resp webauthn.RegistrationResponse) (PasskeyInfo, error)
+	List(access string) ([]PasskeyInfo, error)
+	Delete(access, id string) error
+}
+
+type passkeyRegistrar struct {
+	timer    Timer
+	ttl      time.Duration
+	verifier Verifier
+	users    repo.Users
+	sessions repo.Sessions
+	passkeys repo.Passkeys
+	rp       webauthn.RelyingParty
+}
+
+func NewPasskeyRegistrar(timer Timer, ttl time.Duration, verifier Verifier, users repo.Users, sessions repo.Sessions, passkeys repo.Passkeys, rp webauthn.RelyingParty) PasskeyRegistrar {
+	return &passkeyRegistrar{
+		timer:    timer,
+		ttl:      ttl,
+		verifier: verifier,
+		users:    users,
+		sessions: sessions,
+		passkeys: passkeys,
+		rp:       rp,
+	}
+}
+
+func (c *passkeyRegistrar) subject(access string) (string, error) {
+	claims, err := c.verifier.Verify(access)
+	if err != nil {
+		return "", err
+	}
+
+	sub, _ := claims["sub"].(string)
+	return sub, nil
+}
+
+// Begin starts the registration of a passkey for the access token owner. The
+// passkeys already registered are excluded.
+func (c *passkeyRegistrar) Begin(access string) (webauthn.CreationOptions, error) {
+	var empty webauthn.CreationOptions
+
+	sub, err := c.subject(access)
+	if err != nil {
+		return empty, err
+	}
+
+	user, err := c.users.FindByID(sub)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, Error{msg: "invalid token"}
+		}
+		return empty, err
+	}
+
+	existing, err := c.passkeys.FindByUser(user.ID)
+	if err != nil {
+		return empty, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+
+	challenge, err := createPasskeyChallenge(c.timer, c.sessions, c.ttl, passkeyCeremony{
+		Kind:   ceremonyRegister,
+		UserID: user.ID,
+	})
+	if err != nil {
+		return empty, err
+	}
+
+	entity := webauthn.UserEntity{
+		ID:          []byte(user.ID),
+		Name:        user.Name,
+		DisplayName: user.Name,
+	}
+
+	return c.rp.CreationOptions(entity, challenge, passkeyIDs(existing)), nil
+}
+
+func (c *passkeyRegistrar) Finish(access string, resp webauthn.RegistrationResponse) (PasskeyInfo, error) {
+	var empty PasskeyInfo
+
+	sub, err := c.subject(access)
+	if err != nil {
+		return empty, err
+	}
+
+	reg, err := c.rp.VerifyRegistration(resp)
+	if err != nil {
+		return empty, fmt.Errorf("%w: %v", ErrInvalidPasskey, err)
+	}
+
+	ceremony, err := consumePasskeyChallenge(c.timer, c.sessions, reg.Challenge, ceremonyRegister)
+	if err != nil {
+		return empty, err
+	}
+
+	if ceremony.UserID != sub {
+		return empty, ErrInvalidPasskey
+	}
+
+	id := base64.RawURLEncoding.EncodeToString(reg.CredentialID)
+
+	_, err = c.passkeys.Find(id)
+	if err == nil {
+		return empty, ErrPasskeyExists
+	}
+	if !errors.Is(err, repo.ErrorNotFound) {
+		return empty, fmt.Errorf("failed to find passkey: %w", err)
+	}
+
+	passkey := model.Passkey{
+		ID:        id,
+		UserID:    sub,
+		PublicKey: reg.PublicKey,
+		SignCount: int64(reg.SignCount),
+		Created:   c.timer.Now().Unix(),
+	}
+
+	if err := c.passkeys.Create(passkey); err != nil {
+		return empty, err
+	}
+
+	return newPasskeyInfo(passkey), nil
+}
+
+func (c *passkeyRegistrar) List(access string) ([]PasskeyInfo, error) {
+	sub, err := c.subject(access)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := c.passkeys.FindByUser(sub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+
+	infos := []PasskeyInfo{}
+	for _, item := range items {
+		infos = append(infos, newPasskeyInfo(item))
+	}
+
+	return infos, nil
+}
+
+func (c *passkeyRegistrar) Delete(access, id string) error {
+	sub, err := c.subject(access)
+	if err != nil {
+		return err
+	}
+
+	if err := c.passkeys.Delete(sub, id); err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return ErrInvalidPasskey
+		}
+		return err
+	}
+
+	return nil
+}
+
+// PasskeySignIner signs the user in with a discoverable passkey. The
+// authenticator has to verify the user, so the sign in counts as multi
+// factor and no MFA challenge follows.
+type PasskeySignIner interface {
+	Begin() (webauthn.RequestOptions, error)
+	Finish(resp webauthn.AuthenticationResponse) (SignInResult, error)
+}
+
+type passkeySignIner struct {
+	timer    Timer
+	ttl      time.Duration
+	users    repo.Users
+	sessions repo.Sessions
+	passkeys repo.Passkeys
+	rp       webauthn.RelyingParty
+	issuer   Issuer
+}
+
+func NewPasskeySignIner(timer Timer, ttl time.Duration, users repo.Users, sessions repo.Sessions, passkeys repo.Passkeys, rp webauthn.RelyingParty, issuer Issuer) PasskeySignIner {
+	return &passkeySignIner{
+		timer:    timer,
+		ttl:      ttl,
+		users:    users,
+		sessions: sessions,
+		passkeys: passkeys,
+		rp:       rp,
+		issuer:   issuer,
+	}
+}
+
+func (c *passkeySignIner) Begin() (webauthn.RequestOptions, error) {
+	challenge, err := createPasskeyChallenge(c.timer, c.sessions, c.ttl, passkeyCeremony{Kind: ceremonySignIn})
+	if err != nil {
+		return webauthn.RequestOptions{}, err
+	}
+
+	return c.rp.RequestOptions(challenge, nil, webauthn.UVRequired), nil
+}
+
+func (c *passkeySignIner) Finish(resp webauthn.AuthenticationResponse) (SignInResult, error) {
+	var empty SignInResult
+
+	passkey, err := findPasskey(c.passkeys, resp.ID)
+	if err != nil {
+		return empty, err
+	}
+
+	if len(resp.Response.UserHandle) > 0 && string(resp.Response.UserHandle) != passkey.UserID {
+		return empty, ErrInvalidPasskey
+	}
+
+	assertion, err := verifyPasskey(c.rp, passkey, resp)
+	if err != nil {
+		return empty, err
+	}
+
+	if _, err := consumePasskeyChallenge(c.timer, c.sessions, assertion.Challenge, ceremonySignIn); err != nil {
+		return empty, err
+	}
+
+	if !assertion.UserVerified {
+		return empty, ErrInvalidPasskey
+	}
+
+	if err := countSignature(c.timer, c.passkeys, passkey, assertion); err != nil {
+		return empty, err
+	}
+
+	user, err := c.users.FindByID(passkey.UserID)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, ErrInvalidPasskey
+		}
+		return empty, err
+	}
+
+	grant := Grant{
+		User:     user,
+		Provider: PasskeyProvider,
+		Methods:  []string{MethodPasskey, MethodMFA},
+	}
+
+	return finishSignIn(c.timer, c.sessions, c.issuer, 0, grant, nil)
+}
+
+// PasskeyCompleter completes the sign in waiting for the second factor with
+// a passkey of the user.
+type PasskeyCompleter interface {
+	Begin(challenge string) (webauthn.RequestOptions, error)
+	Complete(resp webauthn.AuthenticationResponse) (SignInResult, error)
+}
+
+type passkeyCompleter struct {
+	timer    Timer
+	ttl      time.Duration
+	codeTTL  time.Duration
+	users    repo.Users
+	sessions repo.Sessions
+	passkeys repo.Passkeys
+	rp       webauthn.RelyingParty
+	issuer   Issuer
+}
+
+func NewPasskeyCompleter(timer Timer, ttl, codeTTL time.Duration, users repo.Users, sessions repo.Sessions, passkeys repo.Passkeys, rp webauthn.RelyingParty, issuer Issuer) PasskeyCompleter {
+	return &passkeyCompleter{
+		timer:    timer,
+		ttl:      ttl,
+		codeTTL:  codeTTL,
+		users:    users,
+		sessions: sessions,
+		passkeys: passkeys,
+		rp:       rp,
+		issuer:   issuer,
+	}
+}
+
+// Begin returns the assertion options for the passkeys of the user waiting
+// for the second factor. The MFA challenge is not consumed until the
+// assertion completes.
+func (c *passkeyCompleter) Begin(challenge string) (webauthn.RequestOptions, error) {
+	var empty webauthn.RequestOptions
+
+	if challenge == "" || strings.Contains(challenge, ":") {
+		return empty, ErrInvalidMFA
+	}
+
+	sess, err := c.sessions.Find(mfaSessionPrefix + challenge)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, ErrInvalidMFA
+		}
+		return empty, err
+	}
+
+	if sess.Used {
+		return empty, ErrInvalidMFA
+	}
+
+	if sess.Expires < c.timer.Now().Unix() {
+		return empty, ErrSessionExpired
+	}
+
+	var value mfaChallenge
+	if err := json.Unmarshal([]byte(sess.Value), &value); err != nil {
+		return empty, fmt.Errorf("failed to decode mfa challenge: %w", err)
+	}
+
+	items, err := c.passkeys.FindByUser(value.UserID)
+	if err != nil {
+		return empty, fmt.Errorf("failed to find passkeys: %w", err)
+	}
+
+	if len(items) == 0 {
+		return empty, ErrMFANotEnrolled
+	}
+
+	nonce, err := createPasskeyChallenge(c.timer, c.sessions, c.ttl, passkeyCeremony{
+		Kind:   ceremonyMFA,
+		UserID: value.UserID,
+		MFA:    challenge,
+	})
+	if err != nil {
+		return empty, err
+	}
+
+	return c.rp.RequestOptions(nonce, passkeyIDs(items), webauthn.UVPreferred), nil
+}
+
+func (c *passkeyCompleter) Complete(resp webauthn.AuthenticationResponse) (SignInResult, error) {
+	var empty SignInResult
+
+	passkey, err := findPasskey(c.passkeys, resp.ID)
+	if err != nil {
+		return empty, err
+	}
+
+	assertion, err := verifyPasskey(c.rp, passkey, resp)
+	if err != nil {
+		return empty, err
+	}
+
+	ceremony, err := consumePasskeyChallenge(c.timer, c.sessions, assertion.Challenge, ceremonyMFA)
+	if err != nil {
+		return empty, err
+	}
+
+	if ceremony.UserID != passkey.UserID {
+		return empty, ErrInvalidPasskey
+	}
+
+	if err := countSignature(c.timer, c.passkeys, passkey, assertion); err != nil {
+		return empty, err
+	}
+
+	value, err := consumeMFAChallenge(c.timer, c.sessions, ceremony.MFA)
+	if err != nil {
+		return empty, err
+	}
+
+	if value.UserID != passkey.UserID {
+		return empty, ErrInvalidMFA
+	}
+
+	user, err := c.users.FindByID(value.UserID)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, ErrInvalidMFA
+		}
+		return empty, err
+	}
+
+	grant := Grant{
+		User:     user,
+		Provider: value.Provider,
+		Methods:  append(value.Methods, MethodPasskey, MethodMFA),
+	}
+
+	return finishSignIn(c.timer, c.sessions, c.issuer, c.codeTTL, grant, value.Request)
+}
@@ -0,0 +1,637 @@
+package auth_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+	"github.com/vbogretsov/guard/webauthn"
+)
+
+type passkeysMock struct {
+	mock.Mock
+}
+
+func (m *passkeysMock) Find(id string) (model.Passkey, error) {
+	args := m.Called(id)
+
+	value := args.Get(0)
+	if value == nil {
+		return model.Passkey{}, args.Error(1)
+	}
+
+	return value.(model.Passkey), args.Error(1)
+}
+
+func (m *passkeysMock) FindByUser(userID string) ([]model.Passkey, error) {
+	args := m.Called(userID)
+
+	value := args.Get(0)
+	if value == nil {
+		return nil, args.Error(1)
+	}
+
+	return value.([]model.Passkey), args.Error(1)
+}
+
+func (m *passkeysMock) Create(passkey model.Passkey) error {
+	return m.Called(passkey).Error(0)
+}
+
+func (m *passkeysMock) Update(passkey model.Passkey) error {
+	return m.Called(passkey).Error(0)
+}
+
+func (m *passkeysMock) Delete(userID, id string) error {
+	return m.Called(userID, id).Error(0)
+}
+
+type relyingPartyMock struct {
+	mock.Mock
+}
+
+func (m *relyingPartyMock) CreationOptions(user webauthn.UserEntity, challenge []byte, exclude [][]byte) webauthn.CreationOptions {
+	return m.Called(user, challenge, exclude).Get(0).(webauthn.CreationOptions)
+}
+
+func (m *relyingPartyMock) RequestOptions(challenge []byte, allow [][]byte, userVerification string) webauthn.RequestOptions {
+	return m.Called(challenge, allow, userVerification).Get(0).(webauthn.RequestOptions)
+}
+
+func (m *relyingPartyMock) VerifyRegistration(resp webauthn.RegistrationResponse) (webauthn.Registration, error) {
+	args := m.Called(resp)
+	return args.Get(0).(webauthn.Registration), args.Error(1)
+}
+
+func (m *relyingPartyMock) VerifyAssertion(resp webauthn.AuthenticationResponse, publicKey []byte) (webauthn.Assertion, error) {
+	args := m.Called(resp, publicKey)
+	return args.Get(0).(webauthn.Assertion), args.Error(1)
+}
+
+func TestPasskeyRegistrar(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	claims := map[string]interface{}{"sub": user.ID}
+	now := time.Unix(1600000050, 0)
+
+	credentialID := []byte{1, 2, 3, 4}
+	keyID := base64.RawURLEncoding.EncodeToString(credentialID)
+
+	resp := webauthn.RegistrationResponse{ID: keyID, RawID: credentialID, Type: "public-key"}
+
+	newRegistrar := func(verifier *verifierMock, users *usersMock, sessions *sessionsMock, passkeys *passkeysMock, rp *relyingPartyMock) auth.PasskeyRegistrar {
+		return auth.NewPasskeyRegistrar(&timerMock{value: now}, 5*time.Minute, verifier, users, sessions, passkeys, rp)
+	}
+
+	t.Run("Begin", func(t *testing.T) {
+		verifier := &verifierMock{}
+		users := &usersMock{}
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		options := webauthn.CreationOptions{Attestation: "none"}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+		passkeys.On("FindByUser", user.ID).Return([]model.Passkey{{ID: keyID}}, nil)
+		sessions.On("Create", mock.Anything).Return(nil)
+		rp.On("CreationOptions", mock.Anything, mock.Anything, [][]byte{credentialID}).Return(options)
+
+		cmd := newRegistrar(verifier, users, sessions, passkeys, rp)
+
+		result, err := cmd.Begin("access.123")
+		require.NoError(t, err)
+		require.Equal(t, options, result)
+
+		entity := rp.Calls[0].Arguments.Get(0).(webauthn.UserEntity)
+		require.Equal(t, webauthn.Bytes(user.ID), entity.ID)
+		require.Equal(t, user.Name, entity.Name)
+
+		challenge := rp.Calls[0].Arguments.Get(1).([]byte)
+		require.Len(t, challenge, auth.PasskeyChallengeSize)
+
+		session := sessions.Calls[0].Arguments.Get(0).(model.Session)
+		require.Equal(t, "passkey:"+base64.RawURLEncoding.EncodeToString(challenge), session.ID)
+		require.Equal(t, now.Add(5*time.Minute).Unix(), session.Expires)
+		require.Contains(t, session.Value, user.ID)
+	})
+
+	t.Run("Finish", func(t *testing.T) {
+		verifier := &verifierMock{}
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		reg := webauthn.Registration{
+			Challenge:    "challenge.123",
+			CredentialID: credentialID,
+			PublicKey:    []byte("key"),
+			SignCount:    7,
+		}
+
+		passkey := model.Passkey{
+			ID:        keyID,
+			UserID:    user.ID,
+			PublicKey: reg.PublicKey,
+			SignCount: 7,
+			Created:   now.Unix(),
+		}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		rp.On("VerifyRegistration", resp).Return(reg, nil)
+		sessions.On("Consume", "passkey:challenge.123").Return(model.Session{
+			Value:   `{"kind":"register","user_id":"user.123"}`,
+			Expires: now.Unix() + 60,
+		}, nil)
+		passkeys.On("Find", keyID).Return(nil, repo.ErrorNotFound)
+		passkeys.On("Create", passkey).Return(nil)
+
+		cmd := newRegistrar(verifier, &usersMock{}, sessions, passkeys, rp)
+
+		info, err := cmd.Finish("access.123", resp)
+		require.NoError(t, err)
+		require.Equal(t, auth.PasskeyInfo{ID: keyID, Created: now.Unix()}, info)
+		passkeys.AssertCalled(t, "Create", passkey)
+	})
+
+	t.Run("FinishOtherCeremony", func(t *testing.T) {
+		for _, value := range []string{
+			`{"kind":"register","user_id":"user.456"}`,
+			`{"kind":"signin"}`,
+		} {
+			verifier := &verifierMock{}
+			sessions := &sessionsMock{}
+			passkeys := &passkeysMock{}
+			rp := &relyingPartyMock{}
+
+			verifier.On("Verify", "access.123").Return(claims, nil)
+			rp.On("VerifyRegistration", resp).Return(webauthn.Registration{Challenge: "challenge.123"}, nil)
+			sessions.On("Consume", "passkey:challenge.123").Return(model.Session{
+				Value:   value,
+				Expires: now.Unix() + 60,
+			}, nil)
+
+			cmd := newRegistrar(verifier, &usersMock{}, sessions, passkeys, rp)
+
+			_, err := cmd.Finish("access.123", resp)
+			require.ErrorIs(t, err, auth.ErrInvalidPasskey, value)
+			passkeys.AssertNotCalled(t, "Create", mock.Anything)
+		}
+	})
+
+	t.Run("FinishExists", func(t *testing.T) {
+		verifier := &verifierMock{}
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		rp.On("VerifyRegistration", resp).Return(webauthn.Registration{
+			Challenge:    "challenge.123",
+			CredentialID: credentialID,
+		}, nil)
+		sessions.On("Consume", "passkey:challenge.123").Return(model.Session{
+			Value:   `{"kind":"register","user_id":"user.123"}`,
+			Expires: now.Unix() + 60,
+		}, nil)
+		passkeys.On("Find", keyID).Return(model.Passkey{ID: keyID}, nil)
+
+		cmd := newRegistrar(verifier, &usersMock{}, sessions, passkeys, rp)
+
+		_, err := cmd.Finish("access.123", resp)
+		require.ErrorIs(t, err, auth.ErrPasskeyExists)
+		passkeys.AssertNotCalled(t, "Create", mock.Anything)
+	})
+
+	t.Run("FinishInvalidResponse", func(t *testing.T) {
+		verifier := &verifierMock{}
+		sessions := &sessionsMock{}
+		rp := &relyingPartyMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		rp.On("VerifyRegistration", resp).Return(webauthn.Registration{}, webauthn.ErrInvalidResponse)
+
+		cmd := newRegistrar(verifier, &usersMock{}, sessions, &passkeysMock{}, rp)
+
+		_, err := cmd.Finish("access.123", resp)
+		require.ErrorIs(t, err, auth.ErrInvalidPasskey)
+		sessions.AssertNotCalled(t, "Consume", mock.Anything)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		verifier := &verifierMock{}
+		passkeys := &passkeysMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		passkeys.On("FindByUser", user.ID).Return([]model.Passkey{
+			{ID: "key.1", Created: 10, LastUsed: 20, PublicKey: []byte("key")},
+		}, nil)
+
+		cmd := newRegistrar(verifier, &usersMock{}, &sessionsMock{}, passkeys, &relyingPartyMock{})
+
+		items, err := cmd.List("access.123")
+		require.NoError(t, err)
+		require.Equal(t, []auth.PasskeyInfo{{ID: "key.1", Created: 10, LastUsed: 20}}, items)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		verifier := &verifierMock{}
+		passkeys := &passkeysMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		passkeys.On("Delete", user.ID, "key.1").Return(nil)
+		passkeys.On("Delete", user.ID, "key.2").Return(repo.ErrorNotFound)
+
+		cmd := newRegistrar(verifier, &usersMock{}, &sessionsMock{}, passkeys, &relyingPartyMock{})
+
+		require.NoError(t, cmd.Delete("access.123", "key.1"))
+		require.ErrorIs(t, cmd.Delete("access.123", "key.2"), auth.ErrInvalidPasskey)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		verifier := &verifierMock{}
+		fail := auth.Error{}
+		verifier.On("Verify", "xxx").Return(nil, fail)
+
+		cmd := newRegistrar(verifier, &usersMock{}, &sessionsMock{}, &passkeysMock{}, &relyingPartyMock{})
+
+		_, err := cmd.Begin("xxx")
+		require.ErrorIs(t, err, fail)
+
+		_, err = cmd.Finish("xxx", resp)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestPasskeySignIner(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	now := time.Unix(1600000050, 0)
+
+	passkey := model.Passkey{ID: "key.123", UserID: user.ID, PublicKey: []byte("key"), SignCount: 5}
+
+	resp := webauthn.AuthenticationResponse{
+		ID:   passkey.ID,
+		Type: "public-key",
+		Response: webauthn.AssertionResponse{
+			UserHandle: []byte(user.ID),
+		},
+	}
+
+	signin := model.Session{Value: `{"kind":"signin"}`, Expires: now.Unix() + 60}
+
+	grant := auth.Grant{
+		User:     user,
+		Provider: auth.PasskeyProvider,
+		Methods:  []string{auth.MethodPasskey, auth.MethodMFA},
+	}
+
+	newSignIner := func(users *usersMock, sessions *sessionsMock, passkeys *passkeysMock, rp *relyingPartyMock, issuer *issuerMock) auth.PasskeySignIner {
+		return auth.NewPasskeySignIner(&timerMock{value: now}, 5*time.Minute, users, sessions, passkeys, rp, issuer)
+	}
+
+	t.Run("Begin", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		rp := &relyingPartyMock{}
+
+		options := webauthn.RequestOptions{RPID: "app.local"}
+
+		sessions.On("Create", mock.Anything).Return(nil)
+		rp.On("RequestOptions", mock.Anything, [][]byte(nil), webauthn.UVRequired).Return(options)
+
+		cmd := newSignIner(&usersMock{}, sessions, &passkeysMock{}, rp, &issuerMock{})
+
+		result, err := cmd.Begin()
+		require.NoError(t, err)
+		require.Equal(t, options, result)
+
+		session := sessions.Calls[0].Arguments.Get(0).(model.Session)
+		require.True(t, strings.HasPrefix(session.ID, "passkey:"))
+		require.Contains(t, session.Value, "signin")
+	})
+
+	t.Run("Finish", func(t *testing.T) {
+		users := &usersMock{}
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+		issuer := &issuerMock{}
+
+		token := auth.Token{Access: "access.123"}
+		assertion := webauthn.Assertion{Challenge: "challenge.123", SignCount: 6, UserVerified: true}
+
+		updated := passkey
+		updated.SignCount = 6
+		updated.LastUsed = now.Unix()
+
+		passkeys.On("Find", passkey.ID).Return(passkey, nil)
+		rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(assertion, nil)
+		sessions.On("Consume", "passkey:challenge.123").Return(signin, nil)
+		passkeys.On("Update", updated).Return(nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+		issuer.On("Issue", grant).Return(token, nil)
+
+		cmd := newSignIner(users, sessions, passkeys, rp, issuer)
+
+		result, err := cmd.Finish(resp)
+		require.NoError(t, err)
+		require.Equal(t, auth.SignInResult{Token: token}, result)
+		passkeys.AssertCalled(t, "Update", updated)
+	})
+
+	t.Run("UserNotVerified", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+		issuer := &issuerMock{}
+
+		passkeys.On("Find", passkey.ID).Return(passkey, nil)
+		rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(webauthn.Assertion{
+			Challenge: "challenge.123",
+			SignCount: 6,
+		}, nil)
+		sessions.On("Consume", "passkey:challenge.123").Return(signin, nil)
+
+		cmd := newSignIner(&usersMock{}, sessions, passkeys, rp, issuer)
+
+		_, err := cmd.Finish(resp)
+		require.ErrorIs(t, err, auth.ErrInvalidPasskey)
+		passkeys.AssertNotCalled(t, "Update", mock.Anything)
+		issuer.AssertNotCalled(t, "Issue", mock.Anything)
+	})
+
+	t.Run("Cloned", func(t *testing.T) {
+		for _, count := range []uint32{0, 4, 5} {
+			sessions := &sessionsMock{}
+			passkeys := &passkeysMock{}
+			rp := &relyingPartyMock{}
+			issuer := &issuerMock{}
+
+			passkeys.On("Find", passkey.ID).Return(passkey, nil)
+			rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(webauthn.Assertion{
+				Challenge:    "challenge.123",
+				SignCount:    count,
+				UserVerified: true,
+			}, nil)
+			sessions.On("Consume", "passkey:challenge.123").Return(signin, nil)
+
+			cmd := newSignIner(&usersMock{}, sessions, passkeys, rp, issuer)
+
+			_, err := cmd.Finish(resp)
+			require.ErrorIs(t, err, auth.ErrPasskeyCloned, count)
+			passkeys.AssertNotCalled(t, "Update", mock.Anything)
+			issuer.AssertNotCalled(t, "Issue", mock.Anything)
+		}
+	})
+
+	t.Run("NoSignCount", func(t *testing.T) {
+		users := &usersMock{}
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+		issuer := &issuerMock{}
+
+		stored := passkey
+		stored.SignCount = 0
+
+		passkeys.On("Find", passkey.ID).Return(stored, nil)
+		rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(webauthn.Assertion{
+			Challenge:    "challenge.123",
+			UserVerified: true,
+		}, nil)
+		sessions.On("Consume", "passkey:challenge.123").Return(signin, nil)
+		passkeys.On("Update", mock.Anything).Return(nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+		issuer.On("Issue", grant).Return(auth.Token{}, nil)
+
+		cmd := newSignIner(users, sessions, passkeys, rp, issuer)
+
+		_, err := cmd.Finish(resp)
+		require.NoError(t, err)
+	})
+
+	t.Run("UnknownPasskey", func(t *testing.T) {
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		passkeys.On("Find", passkey.ID).Return(nil, repo.ErrorNotFound)
+
+		cmd := newSignIner(&usersMock{}, &sessionsMock{}, passkeys, rp, &issuerMock{})
+
+		_, err := cmd.Finish(resp)
+		require.ErrorIs(t, err, auth.ErrInvalidPasskey)
+		rp.AssertNotCalled(t, "VerifyAssertion", mock.Anything, mock.Anything)
+	})
+
+	t.Run("UserHandleMismatch", func(t *testing.T) {
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		other := resp
+		other.Response.UserHandle = []byte("user.456")
+
+		passkeys.On("Find", passkey.ID).Return(passkey, nil)
+
+		cmd := newSignIner(&usersMock{}, &sessionsMock{}, passkeys, rp, &issuerMock{})
+
+		_, err := cmd.Finish(other)
+		require.ErrorIs(t, err, auth.ErrInvalidPasskey)
+		rp.AssertNotCalled(t, "VerifyAssertion", mock.Anything, mock.Anything)
+	})
+
+	t.Run("InvalidChallenge", func(t *testing.T) {
+		for _, tc := range []struct {
+			session model.Session
+			err     error
+			want    error
+		}{
+			{err: repo.ErrorNotFound, want: auth.ErrInvalidPasskey},
+			{err: repo.ErrorConsumed, want: auth.ErrInvalidPasskey},
+			{session: model.Session{Value: `{"kind":"register"}`, Expires: now.Unix() + 60}, want: auth.ErrInvalidPasskey},
+			{session: model.Session{Value: `{"kind":"signin"}`, Expires: now.Unix() - 1}, want: auth.ErrSessionExpired},
+		} {
+			sessions := &sessionsMock{}
+			passkeys := &passkeysMock{}
+			rp := &relyingPartyMock{}
+
+			passkeys.On("Find", passkey.ID).Return(passkey, nil)
+			rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(webauthn.Assertion{
+				Challenge:    "challenge.123",
+				SignCount:    6,
+				UserVerified: true,
+			}, nil)
+			sessions.On("Consume", "passkey:challenge.123").Return(tc.session, tc.err)
+
+			cmd := newSignIner(&usersMock{}, sessions, passkeys, rp, &issuerMock{})
+
+			_, err := cmd.Finish(resp)
+			require.ErrorIs(t, err, tc.want)
+			passkeys.AssertNotCalled(t, "Update", mock.Anything)
+		}
+	})
+
+	t.Run("InvalidAssertion", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		passkeys.On("Find", passkey.ID).Return(passkey, nil)
+		rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(webauthn.Assertion{}, webauthn.ErrInvalidResponse)
+
+		cmd := newSignIner(&usersMock{}, sessions, passkeys, rp, &issuerMock{})
+
+		_, err := cmd.Finish(resp)
+		require.ErrorIs(t, err, auth.ErrInvalidPasskey)
+		sessions.AssertNotCalled(t, "Consume", mock.Anything)
+	})
+}
+
+func TestPasskeyCompleter(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	now := time.Unix(1600000050, 0)
+
+	passkey := model.Passkey{ID: "key.123", UserID: user.ID, PublicKey: []byte("key"), SignCount: 5}
+	resp := webauthn.AuthenticationResponse{ID: passkey.ID, Type: "public-key"}
+
+	challenge := model.Session{
+		ID:      "mfa:challenge.123",
+		Value:   `{"user_id":"user.123","provider":"local","methods":["pwd"]}`,
+		Expires: now.Unix() + 60,
+	}
+
+	ceremony := model.Session{
+		Value:   `{"kind":"mfa","user_id":"user.123","mfa":"challenge.123"}`,
+		Expires: now.Unix() + 60,
+	}
+
+	assertion := webauthn.Assertion{Challenge: "nonce.123", SignCount: 6}
+
+	newCompleter := func(users *usersMock, sessions *sessionsMock, passkeys *passkeysMock, rp *relyingPartyMock, issuer *issuerMock) auth.PasskeyCompleter {
+		return auth.NewPasskeyCompleter(&timerMock{value: now}, 5*time.Minute, time.Minute, users, sessions, passkeys, rp, issuer)
+	}
+
+	t.Run("Begin", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		options := webauthn.RequestOptions{RPID: "app.local"}
+		keyID := base64.RawURLEncoding.EncodeToString([]byte{1, 2, 3})
+
+		sessions.On("Find", challenge.ID).Return(challenge, nil)
+		sessions.On("Create", mock.Anything).Return(nil)
+		passkeys.On("FindByUser", user.ID).Return([]model.Passkey{{ID: keyID}}, nil)
+		rp.On("RequestOptions", mock.Anything, [][]byte{{1, 2, 3}}, webauthn.UVPreferred).Return(options)
+
+		cmd := newCompleter(&usersMock{}, sessions, passkeys, rp, &issuerMock{})
+
+		result, err := cmd.Begin("challenge.123")
+		require.NoError(t, err)
+		require.Equal(t, options, result)
+		sessions.AssertNotCalled(t, "Consume", mock.Anything)
+
+		session := sessions.Calls[1].Arguments.Get(0).(model.Session)
+		require.True(t, strings.HasPrefix(session.ID, "passkey:"))
+		require.Contains(t, session.Value, `"mfa":"challenge.123"`)
+	})
+
+	t.Run("BeginNoPasskeys", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+
+		sessions.On("Find", challenge.ID).Return(challenge, nil)
+		passkeys.On("FindByUser", user.ID).Return([]model.Passkey{}, nil)
+
+		cmd := newCompleter(&usersMock{}, sessions, passkeys, &relyingPartyMock{}, &issuerMock{})
+
+		_, err := cmd.Begin("challenge.123")
+		require.ErrorIs(t, err, auth.ErrMFANotEnrolled)
+	})
+
+	t.Run("BeginInvalidChallenge", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		sessions.On("Find", "mfa:xxx").Return(nil, repo.ErrorNotFound)
+
+		cmd := newCompleter(&usersMock{}, sessions, &passkeysMock{}, &relyingPartyMock{}, &issuerMock{})
+
+		for _, value := range []string{"xxx", "code:xxx", ""} {
+			_, err := cmd.Begin(value)
+			require.ErrorIs(t, err, auth.ErrInvalidMFA, value)
+		}
+	})
+
+	t.Run("Complete", func(t *testing.T) {
+		users := &usersMock{}
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+		issuer := &issuerMock{}
+
+		token := auth.Token{Access: "access.123"}
+		grant := auth.Grant{
+			User:     user,
+			Provider: auth.LocalProvider,
+			Methods:  []string{auth.MethodPassword, auth.MethodPasskey, auth.MethodMFA},
+		}
+
+		passkeys.On("Find", passkey.ID).Return(passkey, nil)
+		rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(assertion, nil)
+		sessions.On("Consume", "passkey:nonce.123").Return(ceremony, nil)
+		passkeys.On("Update", mock.Anything).Return(nil)
+		sessions.On("Consume", challenge.ID).Return(challenge, nil)
+		users.On("FindByID", user.ID).Return(user, nil)
+		issuer.On("Issue", grant).Return(token, nil)
+
+		cmd := newCompleter(users, sessions, passkeys, rp, issuer)
+
+		result, err := cmd.Complete(resp)
+		require.NoError(t, err)
+		require.Equal(t, auth.SignInResult{Token: token}, result)
+	})
+
+	t.Run("OtherUserPasskey", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		other := passkey
+		other.UserID = "user.456"
+
+		passkeys.On("Find", passkey.ID).Return(other, nil)
+		rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(assertion, nil)
+		sessions.On("Consume", "passkey:nonce.123").Return(ceremony, nil)
+
+		cmd := newCompleter(&usersMock{}, sessions, passkeys, rp, &issuerMock{})
+
+		_, err := cmd.Complete(resp)
+		require.ErrorIs(t, err, auth.ErrInvalidPasskey)
+		passkeys.AssertNotCalled(t, "Update", mock.Anything)
+		sessions.AssertNotCalled(t, "Consume", challenge.ID)
+	})
+
+	t.Run("UpdateFailed", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		passkeys := &passkeysMock{}
+		rp := &relyingPartyMock{}
+
+		fail := errors.New("xxx")
+
+		passkeys.On("Find", passkey.ID).Return(passkey, nil)
+		rp.On("VerifyAssertion", resp, passkey.PublicKey).Return(assertion, nil)
+		sessions.On("Consume", "passkey:nonce.123").Return(ceremony, nil)
+		passkeys.On("Update", mock.Anything).Return(fail)
+
+		cmd := newCompleter(&usersMock{}, sessions, passkeys, rp, &issuerMock{})
+
+		_, err := cmd.Complete(resp)
+		require.ErrorIs(t, err, fail)
+		sessions.AssertNotCalled(t, "Consume", challenge.ID)
+	})
+}
@@ -0,0 +1,392 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	netmail "net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vbogretsov/guard/mail"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+const (
+	// LocalProvider is the provider of users signed in with a password.
+	LocalProvider = "local"
+
+	MinPasswordSize = 8
+	MaxPasswordSize = 72
+
+	resetSessionPrefix = "reset:"
+
+	// dummyPasswordHash is verified when the user or the password is not
+	// found so the response time does not tell whether the email is known.
+	dummyPasswordHash = "$2a$10$o36PEbQTfpPWUH37a..RBeb1SqqbzmDbx4qrMEE3Fg25QDb1om016"
+)
+
+var (
+	ErrInvalidEmail       = Error{msg: "invalid email"}
+	ErrInvalidPassword    = Error{msg: "password must be 8 to 72 bytes long"}
+	ErrInvalidCredentials = Error{msg: "invalid credentials"}
+	ErrUserExists         = Error{msg: "user already exists"}
+	ErrInvalidResetToken  = Error{msg: "invalid reset token"}
+)
+
+func validateEmail(email string) error {
+	addr, err := netmail.ParseAddress(email)
+	if err != nil || addr.Address != email {
+		return ErrInvalidEmail
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len(password) < MinPasswordSize || len(password) > MaxPasswordSize {
+		return ErrInvalidPassword
+	}
+	return nil
+}
+
+func findCredential(credentials repo.Credentials, userID string) (model.Credential, error) {
+	credential, err := credentials.Find(userID)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return credential, ErrInvalidCredentials
+		}
+		return credential, fmt.Errorf("failed to find credential: %w", err)
+	}
+
+	return credential, nil
+}
+
+func saveCredential(credentials repo.Credentials, timer Timer, userID, password string) error {
+	hash, err := HashSecret(password)
+	if err != nil {
+		return err
+	}
+
+	return credentials.Save(model.Credential{
+		UserID:       userID,
+		PasswordHash: hash,
+		Updated:      timer.Now().Unix(),
+	})
+}
+
+type SignUper interface {
+	SignUp(email, password string) (Token, error)
+}
+
+type signUper struct {
+	tx          repo.Transaction
+	timer       Timer
+	users       repo.Users
+	credentials repo.Credentials
+	issuer      Issuer
+}
+
+func NewSignUper(tx repo.Transaction, timer Timer, users repo.Users, credentials repo.Credentials, issuer Issuer) SignUper {
+	return &signUper{
+		tx:          tx,
+		timer:       timer,
+		users:       users,
+		credentials: credentials,
+		issuer:      issuer,
+	}
+}
+
+// SignUp creates a local account and signs the user in. Users created by a
+// social sign in have to reset the password to get a local account.
+func (c *signUper) SignUp(email, password string) (Token, error) {
+	var empty Token
+
+	if err := validateEmail(email); err != nil {
+		return empty, err
+	}
+
+	if err := validatePassword(password); err != nil {
+		return empty, err
+	}
+
+	if err := c.tx.Begin(); err != nil {
+		return empty, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer c.tx.Close()
+
+	_, err := c.users.Find(email)
+	if err == nil {
+		return empty, ErrUserExists
+	}
+	if !errors.Is(err, repo.ErrorNotFound) {
+		return empty, err
+	}
+
+	user := model.User{
+		ID:      generateRandomString(UserIDSize),
+		Name:    email,
+		Created: c.timer.Now().Unix(),
+	}
+
+	if err := c.users.Create(user); err != nil {
+		return empty, err
+	}
+
+	if err := saveCredential(c.credentials, c.timer, user.ID, password); err != nil {
+		return empty, err
+	}
+
+	token, err := c.issuer.Issue(Grant{
+		User:     user,
+		Provider: LocalProvider,
+		Methods:  []string{MethodPassword},
+	})
+	if err != nil {
+		return empty, err
+	}
+
+	if err := c.tx.Commit(); err != nil {
+		return empty, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return token, nil
+}
+
+type PasswordSignIner interface {
+	SignIn(email, password string) (SignInResult, error)
+}
+
+type passwordSignIner struct {
+	users       repo.Users
+	credentials repo.Credentials
+	finisher    SignInFinisher
+}
+
+func NewPasswordSignIner(users repo.Users, credentials repo.Credentials, finisher SignInFinisher) PasswordSignIner {
+	return &passwordSignIner{
+		users:       users,
+		credentials: credentials,
+		finisher:    finisher,
+	}
+}
+
+func (c *passwordSignIner) SignIn(email, password string) (SignInResult, error) {
+	var empty SignInResult
+
+	user, err := c.users.Find(email)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			verifySecret(dummyPasswordHash, password)
+			return empty, ErrInvalidCredentials
+		}
+		return empty, err
+	}
+
+	credential, err := findCredential(c.credentials, user.ID)
+	if err != nil {
+		if errors.Is(err, ErrInvalidCredentials) {
+			verifySecret(dummyPasswordHash, password)
+		}
+		return empty, err
+	}
+
+	if !verifySecret(credential.PasswordHash, password) {
+		return empty, ErrInvalidCredentials
+	}
+
+	grant := Grant{
+		User:     user,
+		Provider: LocalProvider,
+		Methods:  []string{MethodPassword},
+	}
+
+	return c.finisher.Finish(grant, nil)
+}
+
+type PasswordChanger interface {
+	ChangePassword(access, password, newPassword string) error
+}
+
+type passwordChanger struct {
+	tx          repo.Transaction
+	timer       Timer
+	verifier    Verifier
+	credentials repo.Credentials
+	tokens      repo.RefreshTokens
+}
+
+func NewPasswordChanger(tx repo.Transaction, timer Timer, verifier Verifier, credentials repo.Credentials, tokens repo.RefreshTokens) PasswordChanger {
+	return &passwordChanger{
+		tx:          tx,
+		timer:       timer,
+		verifier:    verifier,
+		credentials: credentials,
+		tokens:      tokens,
+	}
+}
+
+// ChangePassword changes the password of the access token owner and revokes
+// all the user refresh tokens.
+func (c *passwordChanger) ChangePassword(access, password, newPassword string) error {
+	claims, err := c.verifier.Verify(access)
+	if err != nil {
+		return err
+	}
+
+	if err := validatePassword(newPassword); err != nil {
+		return err
+	}
+
+	sub, _ := claims["sub"].(string)
+
+	credential, err := findCredential(c.credentials, sub)
+	if err != nil {
+		return err
+	}
+
+	if !verifySecret(credential.PasswordHash, password) {
+		return ErrInvalidCredentials
+	}
+
+	return setPassword(c.tx, c.timer, c.credentials, c.tokens, sub, newPassword)
+}
+
+func setPassword(tx repo.Transaction, timer Timer, credentials repo.Credentials, tokens repo.RefreshTokens, userID, password string) error {
+	if err := tx.Begin(); err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Close()
+
+	if err := saveCredential(credentials, timer, userID, password); err != nil {
+		return err
+	}
+
+	if err := tokens.DeleteByUser(userID); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// tokenLink adds the token to the query of the link mailed to the user.
+func tokenLink(base, token string) (string, error) {
+	link, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid link url: %w", err)
+	}
+
+	query := link.Query()
+	query.Set("token", token)
+	link.RawQuery = query.Encode()
+
+	return link.String(), nil
+}
+
+type PasswordResetter interface {
+	RequestReset(email string) error
+	Reset(token, password string) error
+}
+
+type passwordResetter struct {
+	tx          repo.Transaction
+	timer       Timer
+	ttl         time.Duration
+	resetURL    string
+	users       repo.Users
+	credentials repo.Credentials
+	sessions    repo.Sessions
+	tokens      repo.RefreshTokens
+	mailer      mail.Mailer
+}
+
+func NewPasswordResetter(tx repo.Transaction, timer Timer, ttl time.Duration, resetURL string, users repo.Users, credentials repo.Credentials, sessions repo.Sessions, tokens repo.RefreshTokens, mailer mail.Mailer) PasswordResetter {
+	return &passwordResetter{
+		tx:          tx,
+		timer:       timer,
+		ttl:         ttl,
+		resetURL:    resetURL,
+		users:       users,
+		credentials: credentials,
+		sessions:    sessions,
+		tokens:      tokens,
+		mailer:      mailer,
+	}
+}
+
+// RequestReset mails a single use reset link to the user. Unknown emails are
+// ignored to not disclose which users exist.
+func (c *passwordResetter) RequestReset(email string) error {
+	user, err := c.users.Find(email)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return nil
+		}
+		return err
+	}
+
+	token := generateRandomString(SessionIDSize)
+
+	link, err := tokenLink(c.resetURL, token)
+	if err != nil {
+		return err
+	}
+
+	now := c.timer.Now()
+
+	err = c.sessions.Create(model.Session{
+		ID:      resetSessionPrefix + token,
+		Value:   user.ID,
+		Created: now.Unix(),
+		Expires: now.Add(c.ttl).Unix(),
+	})
+	if err != nil {
+		return err
+	}
+
+	err = c.mailer.Send(mail.Message{
+		To:      user.Name,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Follow the link to reset your password:\n\n%s\n", link),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to send reset mail: %w", err)
+	}
+
+	return nil
+}
+
+// Reset sets the password of the reset token owner, the user gets a local
+// account if it had none. The reset mail proves the user owns the email, so
+// the user is marked verified. All the user refresh tokens are revoked.
+func (c *passwordResetter) Reset(token, password string) error {
+	if token == "" || strings.Contains(token, ":") {
+		return ErrInvalidResetToken
+	}
+
+	if err := validatePassword(password); err != nil {
+		return err
+	}
+
+	sess, err := c.sessions.Consume(resetSessionPrefix + token)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) || errors.Is(err, repo.ErrorConsumed) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	if sess.Expires < c.timer.Now().Unix() {
+		return ErrSessionExpired
+	}
+
+	if err := c.users.SetVerified(sess.Value); err != nil {
+		return fmt.Errorf("failed to verify user: %w", err)
+	}
+
+	return setPassword(c.tx, c.timer, c.credentials, c.tokens, sess.Value, password)
+}
@@ -0,0 +1,373 @@
+package auth_test
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/mail"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+type credentialsMock struct {
+	mock.Mock
+}
+
+func (m *credentialsMock) Find(userID string) (model.Credential, error) {
+	args := m.Called(userID)
+
+	credential := args.Get(0)
+	if credential == nil {
+		return model.Credential{}, args.Error(1)
+	}
+
+	return credential.(model.Credential), args.Error(1)
+}
+
+func (m *credentialsMock) Save(credential model.Credential) error {
+	return m.Called(credential).Error(0)
+}
+
+type mailerMock struct {
+	mock.Mock
+}
+
+func (m *mailerMock) Send(msg mail.Message) error {
+	return m.Called(msg).Error(0)
+}
+
+func matchPassword(userID, password string) func(model.Credential) bool {
+	return func(credential model.Credential) bool {
+		return credential.UserID == userID && bcrypt.CompareHashAndPassword([]byte(credential.PasswordHash), []byte(password)) == nil
+	}
+}
+
+func TestSignUp(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		tx := newTransactionMock()
+		timer := &timerMock{value: time.Now()}
+		users := &usersMock{}
+		credentials := &credentialsMock{}
+		issuer := &issuerMock{}
+
+		token := auth.Token{Access: "access.123"}
+
+		users.On("Find", "u0@mail.org").Return(nil, repo.ErrorNotFound)
+		users.On("Create", mock.Anything).Return(nil)
+		credentials.On("Save", mock.Anything).Return(nil)
+		issuer.On("Issue", mock.Anything).Return(token, nil)
+
+		cmd := auth.NewSignUper(tx, timer, users, credentials, issuer)
+
+		result, err := cmd.SignUp("u0@mail.org", "password.123")
+		require.NoError(t, err)
+		require.Equal(t, token, result)
+		tx.AssertCalled(t, "Commit")
+
+		user := users.Calls[1].Arguments.Get(0).(model.User)
+		require.Equal(t, "u0@mail.org", user.Name)
+		require.Len(t, user.ID, auth.UserIDSize)
+
+		credentials.AssertCalled(t, "Save", mock.MatchedBy(matchPassword(user.ID, "password.123")))
+		issuer.AssertCalled(t, "Issue", auth.Grant{User: user, Provider: auth.LocalProvider, Methods: []string{auth.MethodPassword}})
+	})
+
+	t.Run("UserExists", func(t *testing.T) {
+		tx := newTransactionMock()
+		users := &usersMock{}
+
+		users.On("Find", "u0@mail.org").Return(model.User{ID: "user.123"}, nil)
+
+		cmd := auth.NewSignUper(tx, &timerMock{value: time.Now()}, users, &credentialsMock{}, &issuerMock{})
+
+		_, err := cmd.SignUp("u0@mail.org", "password.123")
+		require.ErrorIs(t, err, auth.ErrUserExists)
+		tx.AssertNotCalled(t, "Commit")
+	})
+
+	cases := map[string]struct {
+		email    string
+		password string
+		err      error
+	}{
+		"InvalidEmail":    {email: "xxx", password: "password.123", err: auth.ErrInvalidEmail},
+		"NamedEmail":      {email: "U0 <u0@mail.org>", password: "password.123", err: auth.ErrInvalidEmail},
+		"ShortPassword":   {email: "u0@mail.org", password: "xxx", err: auth.ErrInvalidPassword},
+		"LongPassword":    {email: "u0@mail.org", password: strings.Repeat("x", 73), err: auth.ErrInvalidPassword},
+		"MissingPassword": {email: "u0@mail.org", err: auth.ErrInvalidPassword},
+	}
+
+	for name, c := range cases {
+		t.Run(name, func(t *testing.T) {
+			tx := newTransactionMock()
+
+			cmd := auth.NewSignUper(tx, &timerMock{value: time.Now()}, &usersMock{}, &credentialsMock{}, &issuerMock{})
+
+			_, err := cmd.SignUp(c.email, c.password)
+			require.ErrorIs(t, err, c.err)
+			tx.AssertNotCalled(t, "Begin")
+		})
+	}
+}
+
+func TestPasswordSignIn(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	credential := model.Credential{UserID: user.ID, PasswordHash: hashSecret(t, "password.123")}
+
+	t.Run("Success", func(t *testing.T) {
+		users := &usersMock{}
+		credentials := &credentialsMock{}
+		finisher := &signInFinisherMock{}
+
+		token := auth.Token{Access: "access.123"}
+
+		users.On("Find", user.Name).Return(user, nil)
+		credentials.On("Find", user.ID).Return(credential, nil)
+		grant := auth.Grant{User: user, Provider: auth.LocalProvider, Methods: []string{auth.MethodPassword}}
+		finisher.On("Finish", grant, (*auth.AuthRequest)(nil)).Return(auth.SignInResult{Token: token}, nil)
+
+		cmd := auth.NewPasswordSignIner(users, credentials, finisher)
+
+		result, err := cmd.SignIn(user.Name, "password.123")
+		require.NoError(t, err)
+		require.Equal(t, auth.SignInResult{Token: token}, result)
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		users := &usersMock{}
+		credentials := &credentialsMock{}
+		finisher := &signInFinisherMock{}
+
+		users.On("Find", user.Name).Return(user, nil)
+		credentials.On("Find", user.ID).Return(credential, nil)
+
+		cmd := auth.NewPasswordSignIner(users, credentials, finisher)
+
+		_, err := cmd.SignIn(user.Name, "xxx")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		finisher.AssertNotCalled(t, "Finish", mock.Anything, mock.Anything)
+	})
+
+	t.Run("UnknownUser", func(t *testing.T) {
+		users := &usersMock{}
+		users.On("Find", "u1@mail.org").Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewPasswordSignIner(users, &credentialsMock{}, &signInFinisherMock{})
+
+		_, err := cmd.SignIn("u1@mail.org", "password.123")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("SocialUser", func(t *testing.T) {
+		users := &usersMock{}
+		credentials := &credentialsMock{}
+
+		users.On("Find", user.Name).Return(user, nil)
+		credentials.On("Find", user.ID).Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewPasswordSignIner(users, credentials, &signInFinisherMock{})
+
+		_, err := cmd.SignIn(user.Name, "password.123")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+	})
+
+	t.Run("FindFailed", func(t *testing.T) {
+		users := &usersMock{}
+		credentials := &credentialsMock{}
+
+		fail := errors.New("xxx")
+		users.On("Find", user.Name).Return(user, nil)
+		credentials.On("Find", user.ID).Return(nil, fail)
+
+		cmd := auth.NewPasswordSignIner(users, credentials, &signInFinisherMock{})
+
+		_, err := cmd.SignIn(user.Name, "password.123")
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestChangePassword(t *testing.T) {
+	credential := model.Credential{UserID: "user.123", PasswordHash: hashSecret(t, "password.123")}
+	claims := map[string]interface{}{"sub": credential.UserID}
+
+	t.Run("Success", func(t *testing.T) {
+		tx := newTransactionMock()
+		verifier := &verifierMock{}
+		credentials := &credentialsMock{}
+		tokens := &refreshTokensMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		credentials.On("Find", credential.UserID).Return(credential, nil)
+		credentials.On("Save", mock.MatchedBy(matchPassword(credential.UserID, "password.456"))).Return(nil)
+		tokens.On("DeleteByUser", credential.UserID).Return(nil)
+
+		cmd := auth.NewPasswordChanger(tx, &timerMock{value: time.Now()}, verifier, credentials, tokens)
+
+		err := cmd.ChangePassword("access.123", "password.123", "password.456")
+		require.NoError(t, err)
+		tokens.AssertCalled(t, "DeleteByUser", credential.UserID)
+		tx.AssertCalled(t, "Commit")
+	})
+
+	t.Run("WrongPassword", func(t *testing.T) {
+		verifier := &verifierMock{}
+		credentials := &credentialsMock{}
+
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		credentials.On("Find", credential.UserID).Return(credential, nil)
+
+		cmd := auth.NewPasswordChanger(newTransactionMock(), &timerMock{value: time.Now()}, verifier, credentials, &refreshTokensMock{})
+
+		err := cmd.ChangePassword("access.123", "xxx", "password.456")
+		require.ErrorIs(t, err, auth.ErrInvalidCredentials)
+		credentials.AssertNotCalled(t, "Save", mock.Anything)
+	})
+
+	t.Run("InvalidPassword", func(t *testing.T) {
+		verifier := &verifierMock{}
+		verifier.On("Verify", "access.123").Return(claims, nil)
+
+		cmd := auth.NewPasswordChanger(newTransactionMock(), &timerMock{value: time.Now()}, verifier, &credentialsMock{}, &refreshTokensMock{})
+
+		err := cmd.ChangePassword("access.123", "password.123", "xxx")
+		require.ErrorIs(t, err, auth.ErrInvalidPassword)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		verifier := &verifierMock{}
+		verifier.On("Verify", "xxx").Return(nil, auth.Error{})
+
+		cmd := auth.NewPasswordChanger(newTransactionMock(), &timerMock{value: time.Now()}, verifier, &credentialsMock{}, &refreshTokensMock{})
+
+		err := cmd.ChangePassword("xxx", "password.123", "password.456")
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+}
+
+func TestPasswordReset(t *testing.T) {
+	user := model.User{ID: "user.123", Name: "u0@mail.org"}
+	ttl := 30 * time.Minute
+	resetURL := "http://app.local/reset?lang=en"
+
+	newResetter := func(timer auth.Timer, users *usersMock, credentials *credentialsMock, sessions *sessionsMock, tokens *refreshTokensMock, mailer *mailerMock) auth.PasswordResetter {
+		return auth.NewPasswordResetter(newTransactionMock(), timer, ttl, resetURL, users, credentials, sessions, tokens, mailer)
+	}
+
+	t.Run("RequestReset", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		users := &usersMock{}
+		sessions := &sessionsMock{}
+		mailer := &mailerMock{}
+
+		users.On("Find", user.Name).Return(user, nil)
+		sessions.On("Create", mock.Anything).Return(nil)
+		mailer.On("Send", mock.Anything).Return(nil)
+
+		cmd := newResetter(timer, users, &credentialsMock{}, sessions, &refreshTokensMock{}, mailer)
+
+		require.NoError(t, cmd.RequestReset(user.Name))
+
+		session := sessions.Calls[0].Arguments.Get(0).(model.Session)
+		require.True(t, strings.HasPrefix(session.ID, "reset:"))
+		require.Equal(t, user.ID, session.Value)
+		require.Equal(t, timer.Now().Add(ttl).Unix(), session.Expires)
+
+		msg := mailer.Calls[0].Arguments.Get(0).(mail.Message)
+		require.Equal(t, user.Name, msg.To)
+
+		query := url.Values{"lang": {"en"}, "token": {strings.TrimPrefix(session.ID, "reset:")}}
+		require.Contains(t, msg.Body, "http://app.local/reset?"+query.Encode())
+	})
+
+	t.Run("RequestResetUnknownUser", func(t *testing.T) {
+		users := &usersMock{}
+		sessions := &sessionsMock{}
+		mailer := &mailerMock{}
+
+		users.On("Find", "u1@mail.org").Return(nil, repo.ErrorNotFound)
+
+		cmd := newResetter(&timerMock{value: time.Now()}, users, &credentialsMock{}, sessions, &refreshTokensMock{}, mailer)
+
+		require.NoError(t, cmd.RequestReset("u1@mail.org"))
+		sessions.AssertNotCalled(t, "Create", mock.Anything)
+		mailer.AssertNotCalled(t, "Send", mock.Anything)
+	})
+
+	t.Run("RequestResetMailFailed", func(t *testing.T) {
+		users := &usersMock{}
+		sessions := &sessionsMock{}
+		mailer := &mailerMock{}
+
+		fail := errors.New("xxx")
+		users.On("Find", user.Name).Return(user, nil)
+		sessions.On("Create", mock.Anything).Return(nil)
+		mailer.On("Send", mock.Anything).Return(fail)
+
+		cmd := newResetter(&timerMock{value: time.Now()}, users, &credentialsMock{}, sessions, &refreshTokensMock{}, mailer)
+
+		require.ErrorIs(t, cmd.RequestReset(user.Name), fail)
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000000, 0)}
+		credentials := &credentialsMock{}
+		sessions := &sessionsMock{}
+		tokens := &refreshTokensMock{}
+
+		session := model.Session{ID: "reset:token.123", Value: user.ID, Expires: 1600000060}
+		sessions.On("Consume", session.ID).Return(session, nil)
+		credentials.On("Save", mock.MatchedBy(matchPassword(user.ID, "password.456"))).Return(nil)
+		tokens.On("DeleteByUser", user.ID).Return(nil)
+
+		users := &usersMock{}
+		users.On("SetVerified", user.ID).Return(nil)
+
+		cmd := newResetter(timer, users, credentials, sessions, tokens, &mailerMock{})
+
+		require.NoError(t, cmd.Reset("token.123", "password.456"))
+		tokens.AssertCalled(t, "DeleteByUser", user.ID)
+		users.AssertCalled(t, "SetVerified", user.ID)
+	})
+
+	t.Run("ResetExpired", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000000, 0)}
+		credentials := &credentialsMock{}
+		sessions := &sessionsMock{}
+
+		session := model.Session{ID: "reset:token.123", Value: user.ID, Expires: 1599999999}
+		sessions.On("Consume", session.ID).Return(session, nil)
+
+		cmd := newResetter(timer, &usersMock{}, credentials, sessions, &refreshTokensMock{}, &mailerMock{})
+
+		require.ErrorIs(t, cmd.Reset("token.123", "password.456"), auth.ErrSessionExpired)
+		credentials.AssertNotCalled(t, "Save", mock.Anything)
+	})
+
+	t.Run("ResetInvalidToken", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		sessions.On("Consume", "reset:xxx").Return(nil, repo.ErrorNotFound)
+
+		cmd := newResetter(&timerMock{value: time.Now()}, &usersMock{}, &credentialsMock{}, sessions, &refreshTokensMock{}, &mailerMock{})
+
+		require.ErrorIs(t, cmd.Reset("xxx", "password.456"), auth.ErrInvalidResetToken)
+		require.ErrorIs(t, cmd.Reset("code:xxx", "password.456"), auth.ErrInvalidResetToken)
+		require.ErrorIs(t, cmd.Reset("", "password.456"), auth.ErrInvalidResetToken)
+	})
+
+	t.Run("ResetInvalidPassword", func(t *testing.T) {
+		sessions := &sessionsMock{}
+
+		cmd := newResetter(&timerMock{value: time.Now()}, &usersMock{}, &credentialsMock{}, sessions, &refreshTokensMock{}, &mailerMock{})
+
+		require.ErrorIs(t, cmd.Reset("token.123", "xxx"), auth.ErrInvalidPassword)
+		sessions.AssertNotCalled(t, "Consume", mock.Anything)
+	})
+}
@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+// ProviderProfile is the profile given by a provider identity of the user.
+type ProviderProfile struct {
+	Provider      string          `json:"provider"`
+	Name          string          `json:"name,omitempty"`
+	AvatarURL     string          `json:"avatar_url,omitempty"`
+	Locale        string          `json:"locale,omitempty"`
+	Email         string          `json:"email,omitempty"`
+	EmailVerified bool            `json:"email_verified"`
+	RawData       json.RawMessage `json:"raw_data,omitempty"`
+	Updated       int64           `json:"updated"`
+}
+
+func newProviderProfile(value model.Profile) ProviderProfile {
+	profile := ProviderProfile{
+		Provider:      value.Provider,
+		Name:          value.Name,
+		AvatarURL:     value.AvatarURL,
+		Locale:        value.Locale,
+		Email:         value.Email,
+		EmailVerified: value.EmailVerified,
+		Updated:       value.Updated,
+	}
+
+	if json.Valid([]byte(value.RawData)) {
+		profile.RawData = json.RawMessage(value.RawData)
+	}
+
+	return profile
+}
+
+// Profile is the signed in user together with the provider profiles.
+type Profile struct {
+	ID       string            `json:"id"`
+	Name     string            `json:"name"`
+	Created  int64             `json:"created"`
+	Profiles []ProviderProfile `json:"profiles"`
+}
+
+type ProfileReader interface {
+	Read(access string) (Profile, error)
+}
+
+type profileReader struct {
+	verifier Verifier
+	users    repo.Users
+	profiles repo.Profiles
+}
+
+func NewProfileReader(verifier Verifier, users repo.Users, profiles repo.Profiles) ProfileReader {
+	return &profileReader{
+		verifier: verifier,
+		users:    users,
+		profiles: profiles,
+	}
+}
+
+func (c *profileReader) Read(access string) (Profile, error) {
+	var empty Profile
+
+	claims, err := c.verifier.Verify(access)
+	if err != nil {
+		return empty, err
+	}
+
+	sub, _ := claims["sub"].(string)
+
+	user, err := c.users.FindByID(sub)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, Error{msg: "invalid token"}
+		}
+		return empty, err
+	}
+
+	items, err := c.profiles.FindByUser(user.ID)
+	if err != nil {
+		return empty, err
+	}
+
+	profile := Profile{
+		ID:       user.ID,
+		Name:     user.Name,
+		Created:  user.Created,
+		Profiles: []ProviderProfile{},
+	}
+
+	for _, item := range items {
+		profile.Profiles = append(profile.Profiles, newProviderProfile(item))
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,156 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+type profilesMock struct {
+	mock.Mock
+}
+
+func (m *profilesMock) FindByUser(userID string) ([]model.Profile, error) {
+	args := m.Called(userID)
+
+	value := args.Get(0)
+	if value == nil {
+		return nil, args.Error(1)
+	}
+
+	return value.([]model.Profile), args.Error(1)
+}
+
+func (m *profilesMock) Save(profile model.Profile) error {
+	return m.Called(profile).Error(0)
+}
+
+func (m *profilesMock) Delete(userID, provider string) error {
+	return m.Called(userID, provider).Error(0)
+}
+
+func TestProfileReader(t *testing.T) {
+	claims := map[string]interface{}{"sub": "user.123"}
+	user := model.User{ID: "user.123", Name: "u0@mail.org", Created: 1600000000}
+
+	newVerifier := func() *verifierMock {
+		verifier := &verifierMock{}
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		verifier.On("Verify", mock.Anything).Return(nil, auth.Error{})
+		return verifier
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		users := &usersMock{}
+		profiles := &profilesMock{}
+
+		users.On("FindByID", "user.123").Return(user, nil)
+		profiles.On("FindByUser", "user.123").Return([]model.Profile{
+			{
+				Provider:      "google",
+				Subject:       "google.123",
+				UserID:        "user.123",
+				Name:          "User Zero",
+				AvatarURL:     "https://example.org/u0.png",
+				Locale:        "en",
+				Email:         "u0@mail.org",
+				EmailVerified: true,
+				RawData:       `{"locale":"en"}`,
+				Updated:       1600000010,
+			},
+			{
+				Provider: "github",
+				Subject:  "github.123",
+				UserID:   "user.123",
+				RawData:  "xxx",
+				Updated:  1600000020,
+			},
+		}, nil)
+
+		cmd := auth.NewProfileReader(newVerifier(), users, profiles)
+
+		value, err := cmd.Read("access.123")
+		require.NoError(t, err)
+		require.Equal(t, auth.Profile{
+			ID:      user.ID,
+			Name:    user.Name,
+			Created: user.Created,
+			Profiles: []auth.ProviderProfile{
+				{
+					Provider:      "google",
+					Name:          "User Zero",
+					AvatarURL:     "https://example.org/u0.png",
+					Locale:        "en",
+					Email:         "u0@mail.org",
+					EmailVerified: true,
+					RawData:       json.RawMessage(`{"locale":"en"}`),
+					Updated:       1600000010,
+				},
+				{
+					Provider: "github",
+					Updated:  1600000020,
+				},
+			},
+		}, value)
+	})
+
+	t.Run("NoProfiles", func(t *testing.T) {
+		users := &usersMock{}
+		profiles := &profilesMock{}
+
+		users.On("FindByID", "user.123").Return(user, nil)
+		profiles.On("FindByUser", "user.123").Return(nil, nil)
+
+		cmd := auth.NewProfileReader(newVerifier(), users, profiles)
+
+		value, err := cmd.Read("access.123")
+		require.NoError(t, err)
+		require.NotNil(t, value.Profiles)
+		require.Empty(t, value.Profiles)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		users := &usersMock{}
+		profiles := &profilesMock{}
+
+		cmd := auth.NewProfileReader(newVerifier(), users, profiles)
+
+		_, err := cmd.Read("xxx")
+		require.ErrorAs(t, err, &auth.Error{})
+		users.AssertNotCalled(t, "FindByID", mock.Anything)
+	})
+
+	t.Run("UserNotFound", func(t *testing.T) {
+		users := &usersMock{}
+		profiles := &profilesMock{}
+
+		users.On("FindByID", "user.123").Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewProfileReader(newVerifier(), users, profiles)
+
+		_, err := cmd.Read("access.123")
+		require.ErrorAs(t, err, &auth.Error{})
+		profiles.AssertNotCalled(t, "FindByUser", mock.Anything)
+	})
+
+	t.Run("FailOnProfiles", func(t *testing.T) {
+		users := &usersMock{}
+		profiles := &profilesMock{}
+		fail := errors.New("xxx")
+
+		users.On("FindByID", "user.123").Return(user, nil)
+		profiles.On("FindByUser", "user.123").Return(nil, fail)
+
+		cmd := auth.NewProfileReader(newVerifier(), users, profiles)
+
+		_, err := cmd.Read("access.123")
+		require.ErrorIs(t, err, fail)
+	})
+}
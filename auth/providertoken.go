@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/markbates/goth"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/profile"
+	"github.com/vbogretsov/guard/repo"
+)
+
+// providerTokenSkew is how long before the expiration a provider token is
+// refreshed, so the caller gets the time to use it.
+const providerTokenSkew = 30 * time.Second
+
+var (
+	ErrProviderTokensDisabled = Error{msg: "provider tokens are not configured"}
+	ErrProviderTokenNotFound  = Error{msg: "provider token not found"}
+	ErrProviderTokenExpired   = Error{msg: "provider token expired, sign in with the provider again"}
+)
+
+// ProviderToken is a current access token of the provider identity of the
+// user.
+type ProviderToken struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in,omitempty"`
+}
+
+func expiresAt(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+type providerTokenSaver struct {
+	cipher Cipher
+	timer  Timer
+	tokens repo.ProviderTokens
+}
+
+// NewProviderTokenSaver returns the profile updater storing the encrypted
+// provider tokens of the identity. The refresh token is kept if the provider
+// gives none on the next sign in.
+func NewProviderTokenSaver(cipher Cipher, timer Timer, tokens repo.ProviderTokens) profile.Updater {
+	return &providerTokenSaver{
+		cipher: cipher,
+		timer:  timer,
+		tokens: tokens,
+	}
+}
+
+func (c *providerTokenSaver) Update(userID, provider string, gUser goth.User) error {
+	if gUser.AccessToken == "" {
+		return nil
+	}
+
+	token := model.ProviderToken{
+		Provider: provider,
+		Subject:  gUser.UserID,
+		UserID:   userID,
+		Expires:  expiresAt(gUser.ExpiresAt),
+		Updated:  c.timer.Now().Unix(),
+	}
+
+	access, err := c.cipher.Encrypt([]byte(gUser.AccessToken))
+	if err != nil {
+		return fmt.Errorf("failed to encrypt provider token: %w", err)
+	}
+	token.AccessToken = access
+
+	if gUser.RefreshToken != "" {
+		refresh, err := c.cipher.Encrypt([]byte(gUser.RefreshToken))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt provider token: %w", err)
+		}
+		token.RefreshToken = refresh
+	} else {
+		prev, err := c.tokens.Find(provider, gUser.UserID)
+		switch {
+		case err == nil:
+			token.RefreshToken = prev.RefreshToken
+		case !errors.Is(err, repo.ErrorNotFound):
+			return fmt.Errorf("failed to find provider token: %w", err)
+		}
+	}
+
+	if err := c.tokens.Save(token); err != nil {
+		return fmt.Errorf("failed to save provider token: %w", err)
+	}
+
+	return nil
+}
+
+// ProviderTokener gives the provider access token of the access token owner
+// to the client the access token was issued to.
+type ProviderTokener interface {
+	Token(access string, client model.Client) (ProviderToken, error)
+}
+
+type providerTokener struct {
+	cipher   Cipher
+	timer    Timer
+	verifier Verifier
+	tokens   repo.ProviderTokens
+	provider goth.Provider
+}
+
+func NewProviderTokener(cipher Cipher, timer Timer, verifier Verifier, tokens repo.ProviderTokens, provider goth.Provider) ProviderTokener {
+	return &providerTokener{
+		cipher:   cipher,
+		timer:    timer,
+		verifier: verifier,
+		tokens:   tokens,
+		provider: provider,
+	}
+}
+
+// Token returns the stored provider access token, the token expiring soon is
+// refreshed if the provider supports it. The tokens of other clients and the
+// tokens of the clients themselves are rejected.
+func (c *providerTokener) Token(access string, client model.Client) (ProviderToken, error) {
+	var empty ProviderToken
+
+	if c.cipher == nil {
+		return empty, ErrProviderTokensDisabled
+	}
+
+	claims, err := c.verifier.Verify(access)
+	if err != nil {
+		return empty, err
+	}
+
+	sub, _ := claims["sub"].(string)
+	clientID, _ := claims["client_id"].(string)
+
+	if client.ID == "" || clientID != client.ID || sub == "" || sub == clientID {
+		return empty, ErrInvalidGrant
+	}
+
+	token, err := c.tokens.FindByUser(sub, c.provider.Name())
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return empty, ErrProviderTokenNotFound
+		}
+		return empty, fmt.Errorf("failed to find provider token: %w", err)
+	}
+
+	now := c.timer.Now()
+
+	if token.Expires != 0 && token.Expires <= now.Add(providerTokenSkew).Unix() {
+		token, err = c.refresh(token)
+		if err != nil {
+			return empty, err
+		}
+	}
+
+	plain, err := c.cipher.Decrypt(token.AccessToken)
+	if err != nil {
+		return empty, err
+	}
+
+	value := ProviderToken{
+		AccessToken: string(plain),
+		TokenType:   "Bearer",
+	}
+
+	if token.Expires != 0 {
+		value.ExpiresIn = token.Expires - now.Unix()
+	}
+
+	return value, nil
+}
+
+func (c *providerTokener) refresh(token model.ProviderToken) (model.ProviderToken, error) {
+	if token.RefreshToken == "" || !c.provider.RefreshTokenAvailable() {
+		return token, ErrProviderTokenExpired
+	}
+
+	refresh, err := c.cipher.Decrypt(token.RefreshToken)
+	if err != nil {
+		return token, err
+	}
+
+	fresh, err := c.provider.RefreshToken(string(refresh))
+	if err != nil {
+		return token, fmt.Errorf("failed to refresh provider token: %v: %w", err, ErrProviderTokenExpired)
+	}
+
+	token.AccessToken, err = c.cipher.Encrypt([]byte(fresh.AccessToken))
+	if err != nil {
+		return token, fmt.Errorf("failed to encrypt provider token: %w", err)
+	}
+
+	if fresh.RefreshToken != "" {
+		token.RefreshToken, err = c.cipher.Encrypt([]byte(fresh.RefreshToken))
+		if err != nil {
+			return token, fmt.Errorf("failed to encrypt provider token: %w", err)
+		}
+	}
+
+	token.Expires = expiresAt(fresh.Expiry)
+	token.Updated = c.timer.Now().Unix()
+
+	if err := c.tokens.Save(token); err != nil {
+		return token, fmt.Errorf("failed to save provider token: %w", err)
+	}
+
+	return token, nil
+}
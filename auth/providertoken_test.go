@@ -0,0 +1,350 @@
+package auth_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+type providerTokensMock struct {
+	mock.Mock
+}
+
+func (m *providerTokensMock) Find(provider, subject string) (model.ProviderToken, error) {
+	args := m.Called(provider, subject)
+
+	value := args.Get(0)
+	if value == nil {
+		return model.ProviderToken{}, args.Error(1)
+	}
+
+	return value.(model.ProviderToken), args.Error(1)
+}
+
+func (m *providerTokensMock) FindByUser(userID, provider string) (model.ProviderToken, error) {
+	args := m.Called(userID, provider)
+
+	value := args.Get(0)
+	if value == nil {
+		return model.ProviderToken{}, args.Error(1)
+	}
+
+	return value.(model.ProviderToken), args.Error(1)
+}
+
+func (m *providerTokensMock) Save(token model.ProviderToken) error {
+	return m.Called(token).Error(0)
+}
+
+func (m *providerTokensMock) Delete(userID, provider string) error {
+	return m.Called(userID, provider).Error(0)
+}
+
+func newTokenCipher(t *testing.T) auth.Cipher {
+	cipher, err := auth.NewCipher(bytes.Repeat([]byte{1}, 32))
+	require.NoError(t, err)
+	return cipher
+}
+
+func decrypt(t *testing.T, cipher auth.Cipher, text string) string {
+	plain, err := cipher.Decrypt(text)
+	require.NoError(t, err)
+	return string(plain)
+}
+
+func TestProviderTokenSaver(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	cipher := newTokenCipher(t)
+
+	gUser := goth.User{
+		UserID:       "google.123",
+		AccessToken:  "google.access",
+		RefreshToken: "google.refresh",
+		ExpiresAt:    now.Add(time.Hour),
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		tokens := &providerTokensMock{}
+		tokens.On("Save", mock.Anything).Return(nil)
+
+		cmd := auth.NewProviderTokenSaver(cipher, &timerMock{value: now}, tokens)
+		require.NoError(t, cmd.Update("user.123", "google", gUser))
+
+		saved := tokens.Calls[0].Arguments.Get(0).(model.ProviderToken)
+		require.Equal(t, "google", saved.Provider)
+		require.Equal(t, "google.123", saved.Subject)
+		require.Equal(t, "user.123", saved.UserID)
+		require.Equal(t, now.Add(time.Hour).Unix(), saved.Expires)
+		require.Equal(t, now.Unix(), saved.Updated)
+		require.NotEqual(t, gUser.AccessToken, saved.AccessToken)
+		require.Equal(t, gUser.AccessToken, decrypt(t, cipher, saved.AccessToken))
+		require.Equal(t, gUser.RefreshToken, decrypt(t, cipher, saved.RefreshToken))
+		tokens.AssertNotCalled(t, "Find", mock.Anything, mock.Anything)
+	})
+
+	t.Run("KeepRefreshToken", func(t *testing.T) {
+		tokens := &providerTokensMock{}
+		tokens.On("Find", "google", "google.123").Return(model.ProviderToken{RefreshToken: "encrypted.refresh"}, nil)
+		tokens.On("Save", mock.Anything).Return(nil)
+
+		user := gUser
+		user.RefreshToken = ""
+		user.ExpiresAt = time.Time{}
+
+		cmd := auth.NewProviderTokenSaver(cipher, &timerMock{value: now}, tokens)
+		require.NoError(t, cmd.Update("user.123", "google", user))
+
+		saved := tokens.Calls[1].Arguments.Get(0).(model.ProviderToken)
+		require.Equal(t, "encrypted.refresh", saved.RefreshToken)
+		require.Equal(t, int64(0), saved.Expires)
+	})
+
+	t.Run("NoRefreshToken", func(t *testing.T) {
+		tokens := &providerTokensMock{}
+		tokens.On("Find", "google", "google.123").Return(nil, repo.ErrorNotFound)
+		tokens.On("Save", mock.Anything).Return(nil)
+
+		user := gUser
+		user.RefreshToken = ""
+
+		cmd := auth.NewProviderTokenSaver(cipher, &timerMock{value: now}, tokens)
+		require.NoError(t, cmd.Update("user.123", "google", user))
+
+		saved := tokens.Calls[1].Arguments.Get(0).(model.ProviderToken)
+		require.Empty(t, saved.RefreshToken)
+	})
+
+	t.Run("NoAccessToken", func(t *testing.T) {
+		tokens := &providerTokensMock{}
+
+		cmd := auth.NewProviderTokenSaver(cipher, &timerMock{value: now}, tokens)
+		require.NoError(t, cmd.Update("user.123", "google", goth.User{UserID: "google.123"}))
+		tokens.AssertNotCalled(t, "Save", mock.Anything)
+	})
+
+	t.Run("FailOnSave", func(t *testing.T) {
+		fail := errors.New("xxx")
+
+		tokens := &providerTokensMock{}
+		tokens.On("Save", mock.Anything).Return(fail)
+
+		cmd := auth.NewProviderTokenSaver(cipher, &timerMock{value: now}, tokens)
+		require.ErrorIs(t, cmd.Update("user.123", "google", gUser), fail)
+	})
+}
+
+func TestProviderTokener(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	cipher := newTokenCipher(t)
+	client := model.Client{ID: "client.123"}
+	claims := map[string]interface{}{"sub": "user.123", "client_id": client.ID}
+
+	encrypt := func(value string) string {
+		text, err := cipher.Encrypt([]byte(value))
+		require.NoError(t, err)
+		return text
+	}
+
+	newVerifier := func() *verifierMock {
+		verifier := &verifierMock{}
+		verifier.On("Verify", "access.123").Return(claims, nil)
+		verifier.On("Verify", mock.Anything).Return(nil, auth.Error{})
+		return verifier
+	}
+
+	newProvider := func(refresh bool) *providerMock {
+		provider := &providerMock{}
+		provider.On("Name").Return("google")
+		provider.On("RefreshTokenAvailable").Return(refresh)
+		return provider
+	}
+
+	stored := model.ProviderToken{
+		Provider:     "google",
+		Subject:      "google.123",
+		UserID:       "user.123",
+		AccessToken:  encrypt("google.access"),
+		RefreshToken: encrypt("google.refresh"),
+		Expires:      now.Add(time.Hour).Unix(),
+		Updated:      now.Add(-time.Minute).Unix(),
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		tokens := &providerTokensMock{}
+		tokens.On("FindByUser", "user.123", "google").Return(stored, nil)
+
+		provider := newProvider(true)
+
+		cmd := auth.NewProviderTokener(cipher, &timerMock{value: now}, newVerifier(), tokens, provider)
+
+		value, err := cmd.Token("access.123", client)
+		require.NoError(t, err)
+		require.Equal(t, auth.ProviderToken{
+			AccessToken: "google.access",
+			TokenType:   "Bearer",
+			ExpiresIn:   3600,
+		}, value)
+		provider.AssertNotCalled(t, "RefreshToken", mock.Anything)
+		tokens.AssertNotCalled(t, "Save", mock.Anything)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		expired := stored
+		expired.Expires = now.Add(10 * time.Second).Unix()
+
+		tokens := &providerTokensMock{}
+		tokens.On("FindByUser", "user.123", "google").Return(expired, nil)
+		tokens.On("Save", mock.Anything).Return(nil)
+
+		provider := newProvider(true)
+		provider.On("RefreshToken", "google.refresh").Return(&oauth2.Token{
+			AccessToken: "google.access.new",
+			Expiry:      now.Add(time.Hour),
+		}, nil)
+
+		cmd := auth.NewProviderTokener(cipher, &timerMock{value: now}, newVerifier(), tokens, provider)
+
+		value, err := cmd.Token("access.123", client)
+		require.NoError(t, err)
+		require.Equal(t, "google.access.new", value.AccessToken)
+		require.Equal(t, int64(3600), value.ExpiresIn)
+
+		saved := tokens.Calls[1].Arguments.Get(0).(model.ProviderToken)
+		require.Equal(t, "google.access.new", decrypt(t, cipher, saved.AccessToken))
+		require.Equal(t, expired.RefreshToken, saved.RefreshToken)
+		require.Equal(t, now.Add(time.Hour).Unix(), saved.Expires)
+		require.Equal(t, now.Unix(), saved.Updated)
+	})
+
+	t.Run("RefreshRotated", func(t *testing.T) {
+		expired := stored
+		expired.Expires = now.Add(-time.Minute).Unix()
+
+		tokens := &providerTokensMock{}
+		tokens.On("FindByUser", "user.123", "google").Return(expired, nil)
+		tokens.On("Save", mock.Anything).Return(nil)
+
+		provider := newProvider(true)
+		provider.On("RefreshToken", "google.refresh").Return(&oauth2.Token{
+			AccessToken:  "google.access.new",
+			RefreshToken: "google.refresh.new",
+		}, nil)
+
+		cmd := auth.NewProviderTokener(cipher, &timerMock{value: now}, newVerifier(), tokens, provider)
+
+		value, err := cmd.Token("access.123", client)
+		require.NoError(t, err)
+		require.Equal(t, int64(0), value.ExpiresIn)
+
+		saved := tokens.Calls[1].Arguments.Get(0).(model.ProviderToken)
+		require.Equal(t, "google.refresh.new", decrypt(t, cipher, saved.RefreshToken))
+		require.Equal(t, int64(0), saved.Expires)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		expired := stored
+		expired.Expires = now.Add(-time.Minute).Unix()
+
+		for name, tc := range map[string]struct {
+			token    model.ProviderToken
+			provider *providerMock
+		}{
+			"NoRefreshToken": {
+				token:    model.ProviderToken{AccessToken: expired.AccessToken, Expires: expired.Expires},
+				provider: newProvider(true),
+			},
+			"RefreshUnavailable": {
+				token:    expired,
+				provider: newProvider(false),
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				tokens := &providerTokensMock{}
+				tokens.On("FindByUser", "user.123", "google").Return(tc.token, nil)
+
+				cmd := auth.NewProviderTokener(cipher, &timerMock{value: now}, newVerifier(), tokens, tc.provider)
+
+				_, err := cmd.Token("access.123", client)
+				require.ErrorIs(t, err, auth.ErrProviderTokenExpired)
+				tc.provider.AssertNotCalled(t, "RefreshToken", mock.Anything)
+			})
+		}
+	})
+
+	t.Run("RefreshFailed", func(t *testing.T) {
+		expired := stored
+		expired.Expires = now.Add(-time.Minute).Unix()
+
+		tokens := &providerTokensMock{}
+		tokens.On("FindByUser", "user.123", "google").Return(expired, nil)
+
+		provider := newProvider(true)
+		provider.On("RefreshToken", "google.refresh").Return(nil, errors.New("invalid_grant"))
+
+		cmd := auth.NewProviderTokener(cipher, &timerMock{value: now}, newVerifier(), tokens, provider)
+
+		_, err := cmd.Token("access.123", client)
+		require.ErrorIs(t, err, auth.ErrProviderTokenExpired)
+		tokens.AssertNotCalled(t, "Save", mock.Anything)
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		tokens := &providerTokensMock{}
+		tokens.On("FindByUser", "user.123", "google").Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewProviderTokener(cipher, &timerMock{value: now}, newVerifier(), tokens, newProvider(true))
+
+		_, err := cmd.Token("access.123", client)
+		require.ErrorIs(t, err, auth.ErrProviderTokenNotFound)
+	})
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		tokens := &providerTokensMock{}
+
+		cmd := auth.NewProviderTokener(cipher, &timerMock{value: now}, newVerifier(), tokens, newProvider(true))
+
+		_, err := cmd.Token("xxx", client)
+		require.ErrorAs(t, err, &auth.Error{})
+		tokens.AssertNotCalled(t, "FindByUser", mock.Anything, mock.Anything)
+	})
+
+	t.Run("OtherClient", func(t *testing.T) {
+		for name, claims := range map[string]map[string]interface{}{
+			"FirstParty":        {"sub": "user.123"},
+			"OtherClient":       {"sub": "user.123", "client_id": "client.456"},
+			"ClientCredentials": {"sub": client.ID, "client_id": client.ID},
+		} {
+			tokens := &providerTokensMock{}
+
+			verifier := &verifierMock{}
+			verifier.On("Verify", "access.123").Return(claims, nil)
+
+			cmd := auth.NewProviderTokener(cipher, &timerMock{value: now}, verifier, tokens, newProvider(true))
+
+			_, err := cmd.Token("access.123", client)
+			require.ErrorIs(t, err, auth.ErrInvalidGrant, name)
+			tokens.AssertNotCalled(t, "FindByUser", mock.Anything, mock.Anything)
+		}
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		tokens := &providerTokensMock{}
+		verifier := newVerifier()
+
+		cmd := auth.NewProviderTokener(nil, &timerMock{value: now}, verifier, tokens, newProvider(true))
+
+		_, err := cmd.Token("access.123", client)
+		require.ErrorIs(t, err, auth.ErrProviderTokensDisabled)
+		verifier.AssertNotCalled(t, "Verify", mock.Anything)
+	})
+}
@@ -9,13 +9,25 @@ import (
 const letters = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz-"
 
 func generateRandomString(n int) string {
+	return generateRandomStringFrom(letters, n)
+}
+
+func generateRandomStringFrom(alphabet string, n int) string {
 	ret := make([]byte, n)
 	for i := 0; i < n; i++ {
-		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(letters))))
+		num, err := rand.Int(rand.Reader, big.NewInt(int64(len(alphabet))))
 		if err != nil {
 			panic(fmt.Errorf("rand.Int: %w", err))
 		}
-		ret[i] = letters[num.Int64()]
+		ret[i] = alphabet[num.Int64()]
 	}
 	return string(ret)
 }
+
+func generateRandomBytes(n int) []byte {
+	ret := make([]byte, n)
+	if _, err := rand.Read(ret); err != nil {
+		panic(fmt.Errorf("rand.Read: %w", err))
+	}
+	return ret
+}
@@ -2,6 +2,8 @@ package auth
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/vbogretsov/guard/model"
@@ -9,7 +11,7 @@ import (
 )
 
 type RefreshGenerator interface {
-	Generate(user model.User) (model.RefreshToken, error)
+	Generate(grant Grant) (model.RefreshToken, error)
 }
 
 type refreshGenerator struct {
@@ -26,15 +28,31 @@ func NewRefreshGenerator(tokens repo.RefreshTokens, timer Timer, ttl time.Durati
 	}
 }
 
-func (c *refreshGenerator) Generate(user model.User) (model.RefreshToken, error) {
+func (c *refreshGenerator) Generate(grant Grant) (model.RefreshToken, error) {
+	ttl := c.ttl
+	if grant.Client.RefreshTTL > 0 {
+		ttl = time.Duration(grant.Client.RefreshTTL) * time.Second
+	}
+
 	now := c.timer.Now()
 
+	id := generateRandomString(RefreshTokenSize)
+
+	family := grant.Family
+	if family == "" {
+		family = id
+	}
+
 	token := model.RefreshToken{
-		ID:      generateRandomString(RefreshTokenSize),
-		UserID:  user.ID,
-		User:    user,
-		Created: now.Unix(),
-		Expires: now.Add(c.ttl).Unix(),
+		ID:       id,
+		UserID:   grant.User.ID,
+		User:     grant.User,
+		Family:   family,
+		Provider: grant.Provider,
+		ClientID: grant.Client.ID,
+		Methods:  strings.Join(grant.Methods, " "),
+		Created:  now.Unix(),
+		Expires:  now.Add(ttl).Unix(),
 	}
 
 	if err := c.tokens.Create(token); err != nil {
@@ -44,33 +62,55 @@ func (c *refreshGenerator) Generate(user model.User) (model.RefreshToken, error)
 	return token, nil
 }
 
+// RefreshGrant is a refresh token request. The client is the one the token
+// was issued to, the client secret is required for confidential clients only.
+type RefreshGrant struct {
+	RefreshToken string
+	ClientID     string
+	ClientSecret string
+}
+
 type Refresher interface {
-	Refresh(refreshToken string) (Token, error)
+	Refresh(grant RefreshGrant) (Token, error)
 }
 
 type refresher struct {
-	tx     repo.Transaction
-	timer  Timer
-	tokens repo.RefreshTokens
-	issuer Issuer
+	tx      repo.Transaction
+	timer   Timer
+	tokens  repo.RefreshTokens
+	clients repo.Clients
+	issuer  Issuer
 }
 
-func NewRefresher(timer Timer, tokens repo.RefreshTokens, issuer Issuer) Refresher {
+func NewRefresher(tx repo.Transaction, timer Timer, tokens repo.RefreshTokens, clients repo.Clients, issuer Issuer) Refresher {
 	return &refresher{
-		timer:  timer,
-		tokens: tokens,
-		issuer: issuer,
+		tx:      tx,
+		timer:   timer,
+		tokens:  tokens,
+		clients: clients,
+		issuer:  issuer,
 	}
 }
 
-func (c *refresher) Refresh(refreshToken string) (Token, error) {
+// Refresh consumes the refresh token and issues a new token pair in the same
+// family. Presenting an already consumed token revokes the whole family. The
+// token is kept if the caller is not the client the token was issued to.
+func (c *refresher) Refresh(grant RefreshGrant) (Token, error) {
 	var empty Token
 
-	old, err := c.tokens.Find(refreshToken)
+	if err := c.tx.Begin(); err != nil {
+		return empty, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer c.tx.Close()
+
+	old, err := c.tokens.Consume(grant.RefreshToken)
 	if err != nil {
 		if errors.Is(err, repo.ErrorNotFound) {
 			return empty, Error{msg: "invalid token"}
 		}
+		if errors.Is(err, repo.ErrorConsumed) {
+			return empty, c.revoke(old.Family)
+		}
 		return empty, err
 	}
 
@@ -78,10 +118,53 @@ func (c *refresher) Refresh(refreshToken string) (Token, error) {
 		return empty, Error{msg: "expired token"}
 	}
 
-	token, err := c.issuer.Issue(old.User)
+	if old.ClientID != grant.ClientID {
+		return empty, ErrInvalidGrant
+	}
+
+	var client model.Client
+	if old.ClientID != "" {
+		client, err = findClient(c.clients, old.ClientID)
+		if err != nil {
+			return empty, err
+		}
+
+		if client.SecretHash != "" && !verifySecret(client.SecretHash, grant.ClientSecret) {
+			return empty, ErrInvalidClient
+		}
+	}
+
+	var methods []string
+	if old.Methods != "" {
+		methods = strings.Fields(old.Methods)
+	}
+
+	token, err := c.issuer.Issue(Grant{
+		User:     old.User,
+		Provider: old.Provider,
+		Family:   old.Family,
+		Client:   client,
+		Methods:  methods,
+	})
 	if err != nil {
 		return empty, err
 	}
 
+	if err := c.tx.Commit(); err != nil {
+		return empty, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
 	return token, nil
 }
+
+func (c *refresher) revoke(family string) error {
+	if err := c.tokens.DeleteFamily(family); err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+
+	if err := c.tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return Error{msg: "token reuse detected"}
+}
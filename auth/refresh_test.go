@@ -38,6 +38,56 @@ func (m *refreshTokensMock) Delete(id string) error {
 	return args.Error(0)
 }
 
+func (m *refreshTokensMock) Consume(id string) (model.RefreshToken, error) {
+	args := m.Called(id)
+
+	token := args.Get(0)
+	if token == nil {
+		return model.RefreshToken{}, args.Error(1)
+	}
+
+	return token.(model.RefreshToken), args.Error(1)
+}
+
+func (m *refreshTokensMock) DeleteFamily(family string) error {
+	args := m.Called(family)
+	return args.Error(0)
+}
+
+func (m *refreshTokensMock) DeleteByUser(userID string) error {
+	args := m.Called(userID)
+	return args.Error(0)
+}
+
+func (m *refreshTokensMock) DeleteExpired(before int64, limit int) (int64, error) {
+	args := m.Called(before, limit)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type transactionMock struct {
+	mock.Mock
+}
+
+func (m *transactionMock) Begin() error {
+	return m.Called().Error(0)
+}
+
+func (m *transactionMock) Commit() error {
+	return m.Called().Error(0)
+}
+
+func (m *transactionMock) Close() error {
+	return m.Called().Error(0)
+}
+
+func newTransactionMock() *transactionMock {
+	tx := &transactionMock{}
+	tx.On("Begin").Return(nil)
+	tx.On("Commit").Return(nil)
+	tx.On("Close").Return(nil)
+	return tx
+}
+
 func matchRefreshToken(token model.RefreshToken) func(model.RefreshToken) bool {
 	return func(arg model.RefreshToken) bool {
 		return token.UserID == arg.UserID &&
@@ -70,10 +120,11 @@ func TestRefreshGenerator(t *testing.T) {
 
 		cmd := auth.NewRefreshGenerator(rtm, tm, ttl)
 
-		result, err := cmd.Generate(user)
+		result, err := cmd.Generate(auth.Grant{User: user})
 
 		require.NoError(t, err)
 		require.NotEmpty(t, result.ID)
+		require.Equal(t, result.ID, result.Family)
 
 		require.Equal(t, token.UserID, result.UserID)
 		require.Equal(t, token.User, result.User)
@@ -91,14 +142,63 @@ func TestRefreshGenerator(t *testing.T) {
 
 		cmd := auth.NewRefreshGenerator(rtm, tm, ttl)
 
-		_, err := cmd.Generate(user)
+		_, err := cmd.Generate(auth.Grant{User: user})
 
 		require.ErrorIs(t, err, fail)
 	})
+
+	t.Run("ClientTTL", func(t *testing.T) {
+		rtm := &refreshTokensMock{}
+		tm := &timerMock{value: time.Now()}
+
+		rtm.On("Create", mock.Anything).Return(nil)
+
+		cmd := auth.NewRefreshGenerator(rtm, tm, ttl)
+
+		client := model.Client{ID: "client.123", RefreshTTL: 600}
+		result, err := cmd.Generate(auth.Grant{User: user, Client: client})
+
+		require.NoError(t, err)
+		require.Equal(t, client.ID, result.ClientID)
+		require.Equal(t, tm.Now().Add(600*time.Second).Unix(), result.Expires)
+	})
+
+	t.Run("SameFamily", func(t *testing.T) {
+		rtm := &refreshTokensMock{}
+		tm := &timerMock{value: time.Now()}
+
+		family := "family.123"
+
+		rtm.On("Create", mock.Anything).Return(nil)
+
+		cmd := auth.NewRefreshGenerator(rtm, tm, ttl)
+
+		result, err := cmd.Generate(auth.Grant{User: user, Provider: "google", Family: family})
+
+		require.NoError(t, err)
+		require.NotEqual(t, family, result.ID)
+		require.Equal(t, family, result.Family)
+		require.Equal(t, "google", result.Provider)
+	})
+
+	t.Run("Methods", func(t *testing.T) {
+		rtm := &refreshTokensMock{}
+		tm := &timerMock{value: time.Now()}
+
+		rtm.On("Create", mock.Anything).Return(nil)
+
+		cmd := auth.NewRefreshGenerator(rtm, tm, ttl)
+
+		result, err := cmd.Generate(auth.Grant{User: user, Methods: []string{auth.MethodExternal, auth.MethodOTP}})
+
+		require.NoError(t, err)
+		require.Equal(t, "ext otp", result.Methods)
+	})
 }
 
 func TestRefreshToken(t *testing.T) {
 	t.Run("Fresh", func(t *testing.T) {
+		tx := newTransactionMock()
 		timer := &timerMock{value: time.Now()}
 		tokens := &refreshTokensMock{}
 		issuer := &issuerMock{}
@@ -109,27 +209,86 @@ func TestRefreshToken(t *testing.T) {
 			Created: 1600000000,
 		}
 
+		refresh := model.RefreshToken{
+			ID:       "refresh.123",
+			UserID:   user.ID,
+			User:     user,
+			Family:   "family.123",
+			Provider: "google",
+			Methods:  "ext otp mfa",
+			Used:     true,
+			Created:  time.Now().Unix(),
+			Expires:  time.Now().Add(3600 * time.Second).Unix(),
+		}
+
+		grant := auth.Grant{
+			User:     user,
+			Provider: refresh.Provider,
+			Family:   refresh.Family,
+			Methods:  []string{auth.MethodExternal, auth.MethodOTP, auth.MethodMFA},
+		}
+
+		timer.value = time.Now().Add(2600 * time.Second)
+		tokens.On("Consume", refresh.ID).Return(refresh, nil)
+		issuer.On("Issue", grant).Return(auth.Token{}, nil)
+
+		cmd := auth.NewRefresher(tx, timer, tokens, &clientsMock{}, issuer)
+
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID})
+		require.NoError(t, err)
+		tx.AssertCalled(t, "Commit")
+	})
+
+	t.Run("Reused", func(t *testing.T) {
+		tx := newTransactionMock()
+		timer := &timerMock{value: time.Now()}
+		tokens := &refreshTokensMock{}
+
 		refresh := model.RefreshToken{
 			ID:      "refresh.123",
-			UserID:  user.ID,
-			User:    user,
+			UserID:  "xxx",
+			Family:  "family.123",
+			Used:    true,
 			Created: time.Now().Unix(),
 			Expires: time.Now().Add(3600 * time.Second).Unix(),
 		}
 
-		timer.value = time.Now().Add(2600 * time.Second)
+		tokens.On("Consume", refresh.ID).Return(refresh, repo.ErrorConsumed)
+		tokens.On("DeleteFamily", refresh.Family).Return(nil)
+
+		cmd := auth.NewRefresher(tx, timer, tokens, &clientsMock{}, &issuerMock{})
+
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID})
+		require.Error(t, err)
+		require.ErrorAs(t, err, &auth.Error{})
+		tokens.AssertCalled(t, "DeleteFamily", refresh.Family)
+		tx.AssertCalled(t, "Commit")
+	})
 
-		tokens.On("Find", refresh.ID).Return(refresh, nil)
-		tokens.On("Delete", refresh.ID).Return(nil)
-		issuer.On("Issue", user).Return(auth.Token{}, nil)
+	t.Run("RevokeFailed", func(t *testing.T) {
+		tx := newTransactionMock()
+		timer := &timerMock{value: time.Now()}
+		tokens := &refreshTokensMock{}
 
-		cmd := auth.NewRefresher(timer, tokens, issuer)
+		refresh := model.RefreshToken{
+			ID:     "refresh.123",
+			Family: "family.123",
+		}
 
-		_, err := cmd.Refresh(refresh.ID)
-		require.NoError(t, err)
+		fail := errors.New("xxx")
+		tokens.On("Consume", refresh.ID).Return(refresh, repo.ErrorConsumed)
+		tokens.On("DeleteFamily", refresh.Family).Return(fail)
+
+		cmd := auth.NewRefresher(tx, timer, tokens, &clientsMock{}, &issuerMock{})
+
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID})
+		require.Error(t, err)
+		require.ErrorIs(t, err, fail)
+		tx.AssertNotCalled(t, "Commit")
 	})
 
 	t.Run("Expired", func(t *testing.T) {
+		tx := newTransactionMock()
 		timer := &timerMock{value: time.Now()}
 		tokens := &refreshTokensMock{}
 
@@ -141,52 +300,68 @@ func TestRefreshToken(t *testing.T) {
 		}
 
 		timer.value = time.Now().Add(4600 * time.Second)
+		tokens.On("Consume", refresh.ID).Return(refresh, nil)
 
-		tokens.On("Find", refresh.ID).Return(refresh, nil)
+		cmd := auth.NewRefresher(tx, timer, tokens, &clientsMock{}, &issuerMock{})
 
-		cmd := auth.NewRefresher(timer, tokens, &issuerMock{})
-
-		_, err := cmd.Refresh(refresh.ID)
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID})
 		require.Error(t, err)
 		require.ErrorAs(t, err, &auth.Error{})
+		tx.AssertNotCalled(t, "Commit")
 	})
 
 	t.Run("Invalid", func(t *testing.T) {
+		tx := newTransactionMock()
 		timer := &timerMock{value: time.Now()}
 		tokens := &refreshTokensMock{}
 
 		timer.value = time.Now().Add(4600 * time.Second)
-
 		refreshToken := "xxx"
 
-		tokens.On("Find", refreshToken).Return(nil, repo.ErrorNotFound)
+		tokens.On("Consume", refreshToken).Return(nil, repo.ErrorNotFound)
 
-		cmd := auth.NewRefresher(timer, tokens, &issuerMock{})
+		cmd := auth.NewRefresher(tx, timer, tokens, &clientsMock{}, &issuerMock{})
 
-		_, err := cmd.Refresh(refreshToken)
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refreshToken})
 		require.Error(t, err)
 		require.ErrorAs(t, err, &auth.Error{})
 	})
 
-	t.Run("FainOldFailed", func(t *testing.T) {
+	t.Run("BeginFailed", func(t *testing.T) {
+		tx := &transactionMock{}
 		timer := &timerMock{value: time.Now()}
 		tokens := &refreshTokensMock{}
 
-		timer.value = time.Now().Add(4600 * time.Second)
+		fail := errors.New("xxx")
+		tx.On("Begin").Return(fail)
+
+		cmd := auth.NewRefresher(tx, timer, tokens, &clientsMock{}, &issuerMock{})
+
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: "xxx"})
+		require.Error(t, err)
+		require.ErrorIs(t, err, fail)
+	})
 
+	t.Run("ConsumeOldFailed", func(t *testing.T) {
+		tx := newTransactionMock()
+		timer := &timerMock{value: time.Now()}
+		tokens := &refreshTokensMock{}
+
+		timer.value = time.Now().Add(4600 * time.Second)
 		refreshToken := "xxx"
 		fail := errors.New("xxx")
 
-		tokens.On("Find", refreshToken).Return(nil, fail)
+		tokens.On("Consume", refreshToken).Return(nil, fail)
 
-		cmd := auth.NewRefresher(timer, tokens, &issuerMock{})
+		cmd := auth.NewRefresher(tx, timer, tokens, &clientsMock{}, &issuerMock{})
 
-		_, err := cmd.Refresh(refreshToken)
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refreshToken})
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
 
 	t.Run("IssueNewFailed", func(t *testing.T) {
+		tx := newTransactionMock()
 		timer := &timerMock{value: time.Now()}
 		issuer := &issuerMock{}
 		tokens := &refreshTokensMock{}
@@ -199,16 +374,121 @@ func TestRefreshToken(t *testing.T) {
 		}
 
 		timer.value = time.Now().Add(2600 * time.Second)
-
 		fail := errors.New("xxx")
 
-		tokens.On("Find", refresh.ID).Return(refresh, nil)
+		tokens.On("Consume", refresh.ID).Return(refresh, nil)
 		issuer.On("Issue", mock.Anything).Return(nil, fail)
 
-		cmd := auth.NewRefresher(timer, tokens, issuer)
+		cmd := auth.NewRefresher(tx, timer, tokens, &clientsMock{}, issuer)
 
-		_, err := cmd.Refresh(refresh.ID)
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID})
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
+		tx.AssertNotCalled(t, "Commit")
+	})
+
+	t.Run("Client", func(t *testing.T) {
+		tx := newTransactionMock()
+		timer := &timerMock{value: time.Now()}
+		tokens := &refreshTokensMock{}
+		clients := &clientsMock{}
+		issuer := &issuerMock{}
+
+		client := model.Client{ID: "client.123", AccessTTL: 60}
+		refresh := model.RefreshToken{
+			ID:       "refresh.123",
+			UserID:   "xxx",
+			Family:   "family.123",
+			ClientID: client.ID,
+			Expires:  time.Now().Add(3600 * time.Second).Unix(),
+		}
+
+		tokens.On("Consume", refresh.ID).Return(refresh, nil)
+		clients.On("Find", client.ID).Return(client, nil)
+		issuer.On("Issue", auth.Grant{Family: refresh.Family, Client: client}).Return(auth.Token{}, nil)
+
+		cmd := auth.NewRefresher(tx, timer, tokens, clients, issuer)
+
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID, ClientID: refresh.ClientID})
+		require.NoError(t, err)
+		tx.AssertCalled(t, "Commit")
+	})
+
+	t.Run("ClientDeleted", func(t *testing.T) {
+		tx := newTransactionMock()
+		timer := &timerMock{value: time.Now()}
+		tokens := &refreshTokensMock{}
+		clients := &clientsMock{}
+
+		refresh := model.RefreshToken{
+			ID:       "refresh.123",
+			ClientID: "client.123",
+			Expires:  time.Now().Add(3600 * time.Second).Unix(),
+		}
+
+		tokens.On("Consume", refresh.ID).Return(refresh, nil)
+		clients.On("Find", refresh.ClientID).Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewRefresher(tx, timer, tokens, clients, &issuerMock{})
+
+		_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID, ClientID: refresh.ClientID})
+		require.ErrorIs(t, err, auth.ErrInvalidClient)
+		tx.AssertNotCalled(t, "Commit")
+	})
+
+	t.Run("ClientMismatch", func(t *testing.T) {
+		for _, clientID := range []string{"", "client.456"} {
+			tx := newTransactionMock()
+			tokens := &refreshTokensMock{}
+
+			refresh := model.RefreshToken{
+				ID:       "refresh.123",
+				ClientID: "client.123",
+				Expires:  time.Now().Add(3600 * time.Second).Unix(),
+			}
+
+			tokens.On("Consume", refresh.ID).Return(refresh, nil)
+
+			cmd := auth.NewRefresher(tx, &timerMock{value: time.Now()}, tokens, &clientsMock{}, &issuerMock{})
+
+			_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID, ClientID: clientID})
+			require.ErrorIs(t, err, auth.ErrInvalidGrant, clientID)
+			tx.AssertNotCalled(t, "Commit")
+		}
+	})
+
+	t.Run("ConfidentialClient", func(t *testing.T) {
+		hash, err := auth.HashSecret("secret")
+		require.NoError(t, err)
+
+		client := model.Client{ID: "client.123", SecretHash: hash}
+		refresh := model.RefreshToken{
+			ID:       "refresh.123",
+			Family:   "family.123",
+			ClientID: client.ID,
+			Expires:  time.Now().Add(3600 * time.Second).Unix(),
+		}
+
+		for secret, valid := range map[string]bool{"secret": true, "xxx": false, "": false} {
+			tx := newTransactionMock()
+			tokens := &refreshTokensMock{}
+			clients := &clientsMock{}
+			issuer := &issuerMock{}
+
+			tokens.On("Consume", refresh.ID).Return(refresh, nil)
+			clients.On("Find", client.ID).Return(client, nil)
+			issuer.On("Issue", auth.Grant{Family: refresh.Family, Client: client}).Return(auth.Token{}, nil)
+
+			cmd := auth.NewRefresher(tx, &timerMock{value: time.Now()}, tokens, clients, issuer)
+
+			_, err := cmd.Refresh(auth.RefreshGrant{RefreshToken: refresh.ID, ClientID: client.ID, ClientSecret: secret})
+			if valid {
+				require.NoError(t, err)
+				tx.AssertCalled(t, "Commit")
+			} else {
+				require.ErrorIs(t, err, auth.ErrInvalidClient, secret)
+				tx.AssertNotCalled(t, "Commit")
+			}
+		}
 	})
 }
@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+// RevokeGrant is a RFC 7009 revocation request. The client secret is required
+// for confidential clients only.
+type RevokeGrant struct {
+	Token        string
+	ClientID     string
+	ClientSecret string
+}
+
+type Revoker interface {
+	Revoke(refreshToken string) error
+	RevokeAll(refreshToken string) error
+	RevokeClient(grant RevokeGrant) error
+}
+
+type revoker struct {
+	tokens  repo.RefreshTokens
+	clients repo.Clients
+}
+
+func NewRevoker(tokens repo.RefreshTokens, clients repo.Clients) Revoker {
+	return &revoker{tokens: tokens, clients: clients}
+}
+
+func (c *revoker) find(refreshToken string) (model.RefreshToken, error) {
+	token, err := c.tokens.Find(refreshToken)
+	if err != nil {
+		if errors.Is(err, repo.ErrorNotFound) {
+			return token, Error{msg: "invalid token"}
+		}
+		return token, err
+	}
+
+	return token, nil
+}
+
+// Revoke revokes the refresh token together with all the tokens issued by
+// refreshing it.
+func (c *revoker) Revoke(refreshToken string) error {
+	token, err := c.find(refreshToken)
+	if err != nil {
+		return err
+	}
+
+	return c.revokeFamily(token)
+}
+
+// RevokeClient revokes the refresh token family if the caller is the client
+// the token was issued to. Confidential clients are authenticated before the
+// token is looked up.
+func (c *revoker) RevokeClient(grant RevokeGrant) error {
+	if grant.ClientID != "" {
+		client, err := findClient(c.clients, grant.ClientID)
+		if err != nil {
+			return err
+		}
+
+		if client.SecretHash != "" && !verifySecret(client.SecretHash, grant.ClientSecret) {
+			return ErrInvalidClient
+		}
+	}
+
+	token, err := c.find(grant.Token)
+	if err != nil {
+		return err
+	}
+
+	if token.ClientID != grant.ClientID {
+		return ErrInvalidGrant
+	}
+
+	return c.revokeFamily(token)
+}
+
+func (c *revoker) revokeFamily(token model.RefreshToken) error {
+	if err := c.tokens.DeleteFamily(token.Family); err != nil {
+		return fmt.Errorf("failed to revoke token family: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAll revokes all the refresh tokens of the refresh token owner.
+func (c *revoker) RevokeAll(refreshToken string) error {
+	token, err := c.find(refreshToken)
+	if err != nil {
+		return err
+	}
+
+	if err := c.tokens.DeleteByUser(token.UserID); err != nil {
+		return fmt.Errorf("failed to revoke user tokens: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,143 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func TestRevoker(t *testing.T) {
+	refresh := model.RefreshToken{
+		ID:     "refresh.123",
+		UserID: "user.123",
+		Family: "family.123",
+	}
+
+	t.Run("Revoke", func(t *testing.T) {
+		tokens := &refreshTokensMock{}
+
+		tokens.On("Find", refresh.ID).Return(refresh, nil)
+		tokens.On("DeleteFamily", refresh.Family).Return(nil)
+
+		cmd := auth.NewRevoker(tokens, &clientsMock{})
+
+		require.NoError(t, cmd.Revoke(refresh.ID))
+		tokens.AssertCalled(t, "DeleteFamily", refresh.Family)
+	})
+
+	t.Run("RevokeAll", func(t *testing.T) {
+		tokens := &refreshTokensMock{}
+
+		tokens.On("Find", refresh.ID).Return(refresh, nil)
+		tokens.On("DeleteByUser", refresh.UserID).Return(nil)
+
+		cmd := auth.NewRevoker(tokens, &clientsMock{})
+
+		require.NoError(t, cmd.RevokeAll(refresh.ID))
+		tokens.AssertCalled(t, "DeleteByUser", refresh.UserID)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		tokens := &refreshTokensMock{}
+
+		tokens.On("Find", "xxx").Return(nil, repo.ErrorNotFound)
+
+		cmd := auth.NewRevoker(tokens, &clientsMock{})
+
+		require.ErrorAs(t, cmd.Revoke("xxx"), &auth.Error{})
+		require.ErrorAs(t, cmd.RevokeAll("xxx"), &auth.Error{})
+	})
+
+	t.Run("FindFailed", func(t *testing.T) {
+		tokens := &refreshTokensMock{}
+
+		fail := errors.New("xxx")
+		tokens.On("Find", "xxx").Return(nil, fail)
+
+		cmd := auth.NewRevoker(tokens, &clientsMock{})
+
+		require.ErrorIs(t, cmd.Revoke("xxx"), fail)
+		require.ErrorIs(t, cmd.RevokeAll("xxx"), fail)
+	})
+
+	t.Run("DeleteFailed", func(t *testing.T) {
+		tokens := &refreshTokensMock{}
+
+		fail := errors.New("xxx")
+		tokens.On("Find", refresh.ID).Return(refresh, nil)
+		tokens.On("DeleteFamily", refresh.Family).Return(fail)
+		tokens.On("DeleteByUser", refresh.UserID).Return(fail)
+
+		cmd := auth.NewRevoker(tokens, &clientsMock{})
+
+		require.ErrorIs(t, cmd.Revoke(refresh.ID), fail)
+		require.ErrorIs(t, cmd.RevokeAll(refresh.ID), fail)
+	})
+
+	t.Run("RevokeClient", func(t *testing.T) {
+		hash, err := auth.HashSecret("secret")
+		require.NoError(t, err)
+
+		public := model.Client{ID: "public.123"}
+		confidential := model.Client{ID: "confidential.123", SecretHash: hash}
+
+		for name, tc := range map[string]struct {
+			token model.RefreshToken
+			grant auth.RevokeGrant
+			err   error
+		}{
+			"FirstParty": {
+				token: refresh,
+				grant: auth.RevokeGrant{Token: refresh.ID},
+			},
+			"Public": {
+				token: model.RefreshToken{ID: refresh.ID, Family: refresh.Family, ClientID: public.ID},
+				grant: auth.RevokeGrant{Token: refresh.ID, ClientID: public.ID},
+			},
+			"Confidential": {
+				token: model.RefreshToken{ID: refresh.ID, Family: refresh.Family, ClientID: confidential.ID},
+				grant: auth.RevokeGrant{Token: refresh.ID, ClientID: confidential.ID, ClientSecret: "secret"},
+			},
+			"InvalidSecret": {
+				token: model.RefreshToken{ID: refresh.ID, Family: refresh.Family, ClientID: confidential.ID},
+				grant: auth.RevokeGrant{Token: refresh.ID, ClientID: confidential.ID, ClientSecret: "xxx"},
+				err:   auth.ErrInvalidClient,
+			},
+			"OtherClient": {
+				token: model.RefreshToken{ID: refresh.ID, Family: refresh.Family, ClientID: confidential.ID},
+				grant: auth.RevokeGrant{Token: refresh.ID, ClientID: public.ID},
+				err:   auth.ErrInvalidGrant,
+			},
+			"NoClient": {
+				token: model.RefreshToken{ID: refresh.ID, Family: refresh.Family, ClientID: public.ID},
+				grant: auth.RevokeGrant{Token: refresh.ID},
+				err:   auth.ErrInvalidGrant,
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				tokens := &refreshTokensMock{}
+				tokens.On("Find", refresh.ID).Return(tc.token, nil)
+				tokens.On("DeleteFamily", refresh.Family).Return(nil)
+
+				clients := &clientsMock{}
+				clients.On("Find", public.ID).Return(public, nil)
+				clients.On("Find", confidential.ID).Return(confidential, nil)
+
+				err := auth.NewRevoker(tokens, clients).RevokeClient(tc.grant)
+				if tc.err != nil {
+					require.ErrorIs(t, err, tc.err)
+					tokens.AssertNotCalled(t, "DeleteFamily", refresh.Family)
+					return
+				}
+
+				require.NoError(t, err)
+				tokens.AssertCalled(t, "DeleteFamily", refresh.Family)
+			})
+		}
+	})
+}
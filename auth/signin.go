@@ -1,51 +1,103 @@
 package auth
 
 import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 
 	"github.com/markbates/goth"
 
 	"github.com/vbogretsov/guard/repo"
 )
 
+// SignInResult is either the token pair or, if the sign in was started by a
+// client request, the client redirect URL carrying an authorization code.
+// Users with two factor authentication enabled get the MFA challenge instead,
+// together with the MFA page redirect if the sign in has a client request.
+// A provider flow started to link an identity returns the linked identity.
+type SignInResult struct {
+	Token     Token
+	Redirect  string
+	Challenge string
+	Linked    *IdentityInfo
+}
+
 type SignIner interface {
-	SignIn(code string, params goth.Params) (Token, error)
+	SignIn(code, nonce string, params goth.Params) (SignInResult, error)
 }
 
 type signiner struct {
+	timer    Timer
 	sessions repo.Sessions
 	fetcher  UserFetcher
-	issuer   Issuer
+	finisher SignInFinisher
+	provider string
 }
 
-func NewSignIner(sessions repo.Sessions, fetcher UserFetcher, issuer Issuer) SignIner {
+func NewSignIner(timer Timer, sessions repo.Sessions, fetcher UserFetcher, finisher SignInFinisher, provider string) SignIner {
 	return &signiner{
+		timer:    timer,
 		sessions: sessions,
 		fetcher:  fetcher,
-		issuer:   issuer,
+		finisher: finisher,
+		provider: provider,
 	}
 }
 
-func (c *signiner) SignIn(state string, params goth.Params) (Token, error) {
-	var empty Token
+// SignIn finishes the provider flow of the state given. A link flow is
+// finished only by the caller holding the link nonce, otherwise anyone given
+// the provider URL could link the own provider identity to the link owner.
+func (c *signiner) SignIn(state, nonce string, params goth.Params) (SignInResult, error) {
+	var empty SignInResult
+
+	if strings.Contains(state, ":") {
+		return empty, Error{msg: "invalid session"}
+	}
 
-	session, err := c.sessions.Find(state)
+	session, err := c.sessions.Consume(state)
 	if err != nil {
-		if err == repo.ErrorNotFound {
+		if errors.Is(err, repo.ErrorNotFound) {
 			return empty, Error{msg: "invalid session"}
 		}
+		if errors.Is(err, repo.ErrorConsumed) {
+			return empty, ErrSessionUsed
+		}
 		return empty, fmt.Errorf("session validation failed: %w", err)
 	}
 
-	user, err := c.fetcher.Fetch(session.Value, params)
+	if session.Expires < c.timer.Now().Unix() {
+		return empty, ErrSessionExpired
+	}
+
+	var value oauthSession
+	if err := json.Unmarshal([]byte(session.Value), &value); err != nil {
+		return empty, Error{msg: "invalid session"}
+	}
+
+	if value.Link != "" {
+		if value.LinkNonce == "" || subtle.ConstantTimeCompare([]byte(value.LinkNonce), []byte(nonce)) != 1 {
+			return empty, ErrLinkNonce
+		}
+
+		info, err := c.fetcher.Link(value.Provider, params, value.Link)
+		if err != nil {
+			return empty, fmt.Errorf("link identity failed: %w", err)
+		}
+		return SignInResult{Linked: &info}, nil
+	}
+
+	user, err := c.fetcher.Fetch(value.Provider, params)
 	if err != nil {
 		return empty, fmt.Errorf("fetch user failed: %w", err)
 	}
 
-	token, err := c.issuer.Issue(user)
-	if err != nil {
-		return empty, fmt.Errorf("token issue failed: %w", err)
+	grant := Grant{
+		User:     user,
+		Provider: c.provider,
+		Methods:  []string{MethodExternal},
 	}
 
-	return token, nil
+	return c.finisher.Finish(grant, value.Request)
 }
@@ -3,9 +3,13 @@ package auth_test
 import (
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 
 	"github.com/vbogretsov/guard/auth"
 	"github.com/vbogretsov/guard/model"
@@ -37,6 +41,22 @@ func (m *sessionsMock) Delete(id string) error {
 	return args.Error(0)
 }
 
+func (m *sessionsMock) Consume(id string) (model.Session, error) {
+	args := m.Called(id)
+
+	value := args.Get(0)
+	if value == nil {
+		return model.Session{}, args.Error(1)
+	}
+
+	return value.(model.Session), args.Error(1)
+}
+
+func (m *sessionsMock) DeleteExpired(before int64, limit int) (int64, error) {
+	args := m.Called(before, limit)
+	return args.Get(0).(int64), args.Error(1)
+}
+
 func matchSession(sess model.Session) func(model.Session) bool {
 	return func(arg model.Session) bool {
 		return sess.Created == arg.Created &&
@@ -47,13 +67,14 @@ func matchSession(sess model.Session) func(model.Session) bool {
 
 func TestSignIn(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
 		sessions := &sessionsMock{}
 		fetcher := &userFetcherMock{}
-		issuer := &issuerMock{}
+		finisher := &signInFinisherMock{}
 
 		session := model.Session{
 			ID:      "singin.session.id.123",
-			Value:   "signin.session.value.123",
+			Value:   `{"provider":"signin.session.value.123"}`,
 			Created: 1600000000,
 			Expires: 1600000100,
 		}
@@ -72,82 +93,162 @@ func TestSignIn(t *testing.T) {
 			RefreshExpires: 1640000020,
 		}
 
-		sessions.On("Find", session.ID).Return(session, nil)
-		fetcher.On("Fetch", session.Value, nil).Return(user, nil)
-		issuer.On("Issue", user).Return(token, nil)
+		sessions.On("Consume", session.ID).Return(session, nil)
+		fetcher.On("Fetch", "signin.session.value.123", nil).Return(user, nil)
+		grant := auth.Grant{User: user, Provider: "google", Methods: []string{auth.MethodExternal}}
+		finisher.On("Finish", grant, (*auth.AuthRequest)(nil)).Return(auth.SignInResult{Token: token}, nil)
 
-		cmd := auth.NewSignIner(sessions, fetcher, issuer)
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
 
-		result, err := cmd.SignIn(session.ID, nil)
+		result, err := cmd.SignIn(session.ID, "", nil)
 		require.NoError(t, err)
-		require.Equal(t, token, result)
+		require.Equal(t, auth.SignInResult{Token: token}, result)
 	})
 
 	t.Run("FailOnSessionFind", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
 		sessions := &sessionsMock{}
 		fetcher := &userFetcherMock{}
-		issuer := &issuerMock{}
+		finisher := &signInFinisherMock{}
 
 		sessionID := "singin.session.id.123"
 		fail := errors.New("unexpected error")
 
-		sessions.On("Find", sessionID).Return(nil, fail)
+		sessions.On("Consume", sessionID).Return(nil, fail)
 
-		cmd := auth.NewSignIner(sessions, fetcher, issuer)
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
 
-		_, err := cmd.SignIn(sessionID, nil)
+		_, err := cmd.SignIn(sessionID, "", nil)
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
 
 	t.Run("SessionNotFound", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
 		sessions := &sessionsMock{}
 		fetcher := &userFetcherMock{}
-		issuer := &issuerMock{}
+		finisher := &signInFinisherMock{}
 
 		sessionID := "singin.session.id.123"
 
-		sessions.On("Find", sessionID).Return(nil, repo.ErrorNotFound)
+		sessions.On("Consume", sessionID).Return(nil, repo.ErrorNotFound)
 
-		cmd := auth.NewSignIner(sessions, fetcher, issuer)
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
 
-		_, err := cmd.SignIn(sessionID, nil)
+		_, err := cmd.SignIn(sessionID, "", nil)
 		require.Error(t, err)
 		require.ErrorAs(t, err, &auth.Error{})
 	})
 
+	t.Run("SessionExpired", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000200, 0)}
+		sessions := &sessionsMock{}
+		fetcher := &userFetcherMock{}
+		finisher := &signInFinisherMock{}
+
+		session := model.Session{
+			ID:      "singin.session.id.123",
+			Value:   `{"provider":"signin.session.value.123"}`,
+			Created: 1600000000,
+			Expires: 1600000100,
+		}
+
+		sessions.On("Consume", session.ID).Return(session, nil)
+
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
+
+		_, err := cmd.SignIn(session.ID, "", nil)
+		require.ErrorIs(t, err, auth.ErrSessionExpired)
+		fetcher.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+	})
+
+	t.Run("SessionUsed", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
+		sessions := &sessionsMock{}
+		fetcher := &userFetcherMock{}
+		finisher := &signInFinisherMock{}
+
+		sessionID := "singin.session.id.123"
+
+		sessions.On("Consume", sessionID).Return(model.Session{ID: sessionID}, repo.ErrorConsumed)
+
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
+
+		_, err := cmd.SignIn(sessionID, "", nil)
+		require.ErrorIs(t, err, auth.ErrSessionUsed)
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("SessionReplayed", func(t *testing.T) {
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+			Logger: logger.Default.LogMode(logger.Silent),
+		})
+		require.NoError(t, err)
+		require.NoError(t, db.AutoMigrate(&model.Session{}))
+
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
+		sessions := repo.NewSessions(repo.NewConn(db))
+		fetcher := &userFetcherMock{}
+		finisher := &signInFinisherMock{}
+
+		session := model.Session{
+			ID:      "singin.session.id.123",
+			Value:   `{"provider":"signin.session.value.123"}`,
+			Created: 1600000000,
+			Expires: 1600000100,
+		}
+		require.NoError(t, sessions.Create(session))
+
+		user := model.User{ID: "signin.user.123", Name: "u0@mial.org", Created: 1600000000}
+		grant := auth.Grant{User: user, Provider: "google", Methods: []string{auth.MethodExternal}}
+
+		fetcher.On("Fetch", "signin.session.value.123", nil).Return(user, nil)
+		finisher.On("Finish", grant, (*auth.AuthRequest)(nil)).Return(auth.SignInResult{}, nil)
+
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
+
+		_, err = cmd.SignIn(session.ID, "", nil)
+		require.NoError(t, err)
+
+		_, err = cmd.SignIn(session.ID, "", nil)
+		require.ErrorIs(t, err, auth.ErrSessionUsed)
+		fetcher.AssertNumberOfCalls(t, "Fetch", 1)
+	})
+
 	t.Run("FailOnFetch", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
 		sessions := &sessionsMock{}
 		fetcher := &userFetcherMock{}
-		issuer := &issuerMock{}
+		finisher := &signInFinisherMock{}
 
 		session := model.Session{
 			ID:      "singin.session.id.123",
-			Value:   "signin.session.value.123",
+			Value:   `{"provider":"signin.session.value.123"}`,
 			Created: 1600000000,
 			Expires: 1600000100,
 		}
 
 		fail := errors.New("xxx")
 
-		sessions.On("Find", session.ID).Return(session, nil)
-		fetcher.On("Fetch", session.Value, nil).Return(nil, fail)
+		sessions.On("Consume", session.ID).Return(session, nil)
+		fetcher.On("Fetch", "signin.session.value.123", nil).Return(nil, fail)
 
-		cmd := auth.NewSignIner(sessions, fetcher, issuer)
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
 
-		_, err := cmd.SignIn(session.ID, nil)
+		_, err := cmd.SignIn(session.ID, "", nil)
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
 
-	t.Run("FailOnIssue", func(t *testing.T) {
+	t.Run("FailOnFinish", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
 		sessions := &sessionsMock{}
 		fetcher := &userFetcherMock{}
-		issuer := &issuerMock{}
+		finisher := &signInFinisherMock{}
 
 		session := model.Session{
 			ID:      "singin.session.id.123",
-			Value:   "signin.session.value.123",
+			Value:   `{"provider":"signin.session.value.123"}`,
 			Created: 1600000000,
 			Expires: 1600000100,
 		}
@@ -160,14 +261,130 @@ func TestSignIn(t *testing.T) {
 
 		fail := errors.New("xxx")
 
-		sessions.On("Find", session.ID).Return(session, nil)
-		fetcher.On("Fetch", session.Value, nil).Return(user, nil)
-		issuer.On("Issue", user).Return(nil, fail)
+		sessions.On("Consume", session.ID).Return(session, nil)
+		fetcher.On("Fetch", "signin.session.value.123", nil).Return(user, nil)
+		finisher.On("Finish", mock.Anything, mock.Anything).Return(auth.SignInResult{}, fail)
 
-		cmd := auth.NewSignIner(sessions, fetcher, issuer)
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
 
-		_, err := cmd.SignIn(session.ID, nil)
+		_, err := cmd.SignIn(session.ID, "", nil)
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
+
+	t.Run("AuthRequest", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
+		sessions := &sessionsMock{}
+		fetcher := &userFetcherMock{}
+		finisher := &signInFinisherMock{}
+
+		session := model.Session{
+			ID: "singin.session.id.123",
+			Value: `{"provider":"signin.session.value.123","request":{` +
+				`"redirect_uri":"http://app.local/callback?x=1","state":"state.123",` +
+				`"code_challenge":"challenge.123","code_challenge_method":"S256"}}`,
+			Created: 1600000000,
+			Expires: 1600000100,
+		}
+
+		user := model.User{ID: "signin.user.123", Name: "u0@mial.org"}
+
+		req := &auth.AuthRequest{
+			RedirectURI:         "http://app.local/callback?x=1",
+			State:               "state.123",
+			CodeChallenge:       "challenge.123",
+			CodeChallengeMethod: "S256",
+		}
+		grant := auth.Grant{User: user, Provider: "google", Methods: []string{auth.MethodExternal}}
+		redirect := "http://app.local/callback?code=code.123"
+
+		sessions.On("Consume", session.ID).Return(session, nil)
+		fetcher.On("Fetch", "signin.session.value.123", nil).Return(user, nil)
+		finisher.On("Finish", grant, req).Return(auth.SignInResult{Redirect: redirect}, nil)
+
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
+
+		result, err := cmd.SignIn(session.ID, "", nil)
+		require.NoError(t, err)
+		require.Equal(t, auth.SignInResult{Redirect: redirect}, result)
+	})
+
+	t.Run("PrefixedState", func(t *testing.T) {
+		sessions := &sessionsMock{}
+
+		cmd := auth.NewSignIner(&timerMock{}, sessions, &userFetcherMock{}, &signInFinisherMock{}, "google")
+
+		_, err := cmd.SignIn("code:123", "", nil)
+		require.ErrorAs(t, err, &auth.Error{})
+		sessions.AssertNotCalled(t, "Consume", mock.Anything)
+	})
+
+	t.Run("Link", func(t *testing.T) {
+		timer := &timerMock{value: time.Unix(1600000050, 0)}
+		sessions := &sessionsMock{}
+		fetcher := &userFetcherMock{}
+		finisher := &signInFinisherMock{}
+
+		session := model.Session{
+			ID:      "singin.session.id.123",
+			Value:   `{"provider":"signin.session.value.123","link":"user.123","link_nonce":"nonce.123"}`,
+			Created: 1600000000,
+			Expires: 1600000100,
+		}
+
+		info := auth.IdentityInfo{Provider: "google", Email: "u0@mail.org", Created: 1600000050}
+
+		sessions.On("Consume", session.ID).Return(session, nil)
+		fetcher.On("Link", "signin.session.value.123", nil, "user.123").Return(info, nil)
+
+		cmd := auth.NewSignIner(timer, sessions, fetcher, finisher, "google")
+
+		result, err := cmd.SignIn(session.ID, "nonce.123", nil)
+		require.NoError(t, err)
+		require.Equal(t, auth.SignInResult{Linked: &info}, result)
+		fetcher.AssertNotCalled(t, "Fetch", mock.Anything, mock.Anything)
+		finisher.AssertNotCalled(t, "Finish", mock.Anything, mock.Anything)
+	})
+
+	t.Run("LinkFailed", func(t *testing.T) {
+		sessions := &sessionsMock{}
+		fetcher := &userFetcherMock{}
+
+		session := model.Session{
+			ID:      "singin.session.id.123",
+			Value:   `{"provider":"signin.session.value.123","link":"user.123","link_nonce":"nonce.123"}`,
+			Expires: 1600000100,
+		}
+
+		sessions.On("Consume", session.ID).Return(session, nil)
+		fetcher.On("Link", "signin.session.value.123", nil, "user.123").Return(auth.IdentityInfo{}, auth.ErrIdentityLinked)
+
+		cmd := auth.NewSignIner(&timerMock{value: time.Unix(1600000050, 0)}, sessions, fetcher, &signInFinisherMock{}, "google")
+
+		_, err := cmd.SignIn(session.ID, "nonce.123", nil)
+		require.ErrorIs(t, err, auth.ErrIdentityLinked)
+	})
+
+	t.Run("LinkOtherBrowser", func(t *testing.T) {
+		for _, tc := range []struct {
+			value string
+			nonce string
+		}{
+			{value: `{"provider":"signin.session.value.123","link":"user.123","link_nonce":"nonce.123"}`, nonce: ""},
+			{value: `{"provider":"signin.session.value.123","link":"user.123","link_nonce":"nonce.123"}`, nonce: "nonce.456"},
+			{value: `{"provider":"signin.session.value.123","link":"user.123"}`, nonce: ""},
+		} {
+			sessions := &sessionsMock{}
+			fetcher := &userFetcherMock{}
+
+			session := model.Session{ID: "singin.session.id.123", Value: tc.value, Expires: 1600000100}
+			sessions.On("Consume", session.ID).Return(session, nil)
+
+			cmd := auth.NewSignIner(&timerMock{value: time.Unix(1600000050, 0)}, sessions, fetcher, &signInFinisherMock{}, "google")
+
+			_, err := cmd.SignIn(session.ID, tc.nonce, nil)
+			require.ErrorIs(t, err, auth.ErrLinkNonce, tc.nonce)
+			fetcher.AssertNotCalled(t, "Link", mock.Anything, mock.Anything, mock.Anything)
+		}
+	})
 }
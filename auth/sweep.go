@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"fmt"
+
+	"github.com/vbogretsov/guard/repo"
+)
+
+// DefaultSweepBatch is the batch size used when the batch given is not
+// positive. A zero limit would delete everything at once and never stop.
+const DefaultSweepBatch = 1000
+
+type Sweeper interface {
+	Sweep() (int64, error)
+}
+
+type sweeper struct {
+	timer    Timer
+	sessions repo.Sessions
+	tokens   repo.RefreshTokens
+	batch    int
+}
+
+func NewSweeper(timer Timer, sessions repo.Sessions, tokens repo.RefreshTokens, batch int) Sweeper {
+	if batch <= 0 {
+		batch = DefaultSweepBatch
+	}
+
+	return &sweeper{
+		timer:    timer,
+		sessions: sessions,
+		tokens:   tokens,
+		batch:    batch,
+	}
+}
+
+// Sweep deletes expired sessions and refresh tokens batch by batch and
+// returns the number of records deleted.
+func (c *sweeper) Sweep() (int64, error) {
+	now := c.timer.Now().Unix()
+
+	sessions, err := c.drain(now, c.sessions.DeleteExpired)
+	if err != nil {
+		return sessions, fmt.Errorf("failed to delete expired sessions: %w", err)
+	}
+
+	tokens, err := c.drain(now, c.tokens.DeleteExpired)
+	if err != nil {
+		return sessions + tokens, fmt.Errorf("failed to delete expired refresh tokens: %w", err)
+	}
+
+	return sessions + tokens, nil
+}
+
+func (c *sweeper) drain(now int64, deleteExpired func(int64, int) (int64, error)) (int64, error) {
+	var total int64
+
+	for {
+		n, err := deleteExpired(now, c.batch)
+		total += n
+
+		if err != nil {
+			return total, err
+		}
+
+		if n < int64(c.batch) {
+			return total, nil
+		}
+	}
+}
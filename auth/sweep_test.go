@@ -0,0 +1,80 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+)
+
+func TestSweeper(t *testing.T) {
+	batch := 2
+
+	t.Run("Success", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		sessions := &sessionsMock{}
+		tokens := &refreshTokensMock{}
+
+		now := timer.Now().Unix()
+		sessions.On("DeleteExpired", now, batch).Return(int64(1), nil).Once()
+		tokens.On("DeleteExpired", now, batch).Return(int64(2), nil).Twice()
+		tokens.On("DeleteExpired", now, batch).Return(int64(0), nil).Once()
+
+		cmd := auth.NewSweeper(timer, sessions, tokens, batch)
+
+		n, err := cmd.Sweep()
+		require.NoError(t, err)
+		require.Equal(t, int64(5), n)
+		sessions.AssertNumberOfCalls(t, "DeleteExpired", 1)
+		tokens.AssertNumberOfCalls(t, "DeleteExpired", 3)
+	})
+
+	t.Run("SessionsFailed", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		sessions := &sessionsMock{}
+		tokens := &refreshTokensMock{}
+
+		fail := errors.New("xxx")
+		sessions.On("DeleteExpired", timer.Now().Unix(), batch).Return(int64(0), fail)
+
+		cmd := auth.NewSweeper(timer, sessions, tokens, batch)
+
+		_, err := cmd.Sweep()
+		require.ErrorIs(t, err, fail)
+		tokens.AssertNotCalled(t, "DeleteExpired")
+	})
+
+	t.Run("TokensFailed", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		sessions := &sessionsMock{}
+		tokens := &refreshTokensMock{}
+
+		fail := errors.New("xxx")
+		sessions.On("DeleteExpired", timer.Now().Unix(), batch).Return(int64(1), nil)
+		tokens.On("DeleteExpired", timer.Now().Unix(), batch).Return(int64(0), fail)
+
+		cmd := auth.NewSweeper(timer, sessions, tokens, batch)
+
+		n, err := cmd.Sweep()
+		require.ErrorIs(t, err, fail)
+		require.Equal(t, int64(1), n)
+	})
+	t.Run("DefaultBatch", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		sessions := &sessionsMock{}
+		tokens := &refreshTokensMock{}
+
+		now := timer.Now().Unix()
+		sessions.On("DeleteExpired", now, auth.DefaultSweepBatch).Return(int64(1), nil).Once()
+		tokens.On("DeleteExpired", now, auth.DefaultSweepBatch).Return(int64(0), nil).Once()
+
+		cmd := auth.NewSweeper(timer, sessions, tokens, 0)
+
+		n, err := cmd.Sweep()
+		require.NoError(t, err)
+		require.Equal(t, int64(1), n)
+	})
+}
@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+const (
+	TOTPSecretSize    = 20
+	TOTPDigits        = 6
+	TOTPPeriod        = 30
+	RecoveryCodeCount = 10
+	RecoveryCodeSize  = 10
+
+	// totpSkew is the number of periods a code is accepted before and after
+	// the current one to tolerate clock drift.
+	totpSkew = 1
+
+	recoveryLetters = "23456789abcdefghijkmnpqrstuvwxyz"
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// totpCode computes the RFC 6238 code with HMAC-SHA1 for the time step.
+func totpCode(secret []byte, step int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", TOTPDigits, value%mod)
+}
+
+// verifyTOTP returns the time step the code matches.
+func verifyTOTP(secret []byte, code string, now time.Time) (int64, bool) {
+	current := now.Unix() / TOTPPeriod
+
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(secret, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+
+	return 0, false
+}
+
+func otpauthURI(issuer, account string, secret []byte) string {
+	query := url.Values{}
+	query.Set("secret", totpEncoding.EncodeToString(secret))
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(TOTPPeriod))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: query.Encode(),
+	}
+
+	return u.String()
+}
+
+func hashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateRecoveryCodes returns the codes and their hashes to be stored.
+func generateRecoveryCodes() ([]string, string) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+
+	for i := range codes {
+		codes[i] = generateRandomStringFrom(recoveryLetters, RecoveryCodeSize)
+		hashes[i] = hashRecoveryCode(codes[i])
+	}
+
+	return codes, strings.Join(hashes, " ")
+}
+
+// checkTOTP verifies the code and updates the totp record so the code cannot
+// be used again. Recovery codes are accepted if allowed.
+func checkTOTP(cipher Cipher, now time.Time, totp *model.TOTP, code string, recovery bool) (bool, error) {
+	if len(code) == TOTPDigits {
+		secret, err := cipher.Decrypt(totp.Secret)
+		if err != nil {
+			return false, err
+		}
+
+		step, ok := verifyTOTP(secret, code, now)
+		if !ok || step <= totp.LastStep {
+			return false, nil
+		}
+
+		totp.LastStep = step
+		return true, nil
+	}
+
+	if !recovery || len(code) != RecoveryCodeSize {
+		return false, nil
+	}
+
+	hash := hashRecoveryCode(strings.ToLower(code))
+	hashes := strings.Fields(totp.RecoveryCodes)
+
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) == 1 {
+			hashes = append(hashes[:i], hashes[i+1:]...)
+			totp.RecoveryCodes = strings.Join(hashes, " ")
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// useTOTP saves the totp updated by checkTOTP, the code used concurrently by
+// another request is rejected.
+func useTOTP(totps repo.TOTPs, prev, totp model.TOTP) error {
+	if err := totps.Use(prev, totp); err != nil {
+		if errors.Is(err, repo.ErrorConsumed) {
+			return ErrInvalidOTP
+		}
+		return fmt.Errorf("failed to save totp: %w", err)
+	}
+	return nil
+}
@@ -11,26 +11,29 @@ import (
 	"github.com/vbogretsov/guard/repo"
 )
 
+// UserFetcher completes the provider authorization and returns the user the
+// provider identity belongs to, or links the identity to the given user.
 type UserFetcher interface {
 	Fetch(session string, params goth.Params) (model.User, error)
+	Link(session string, params goth.Params, userID string) (IdentityInfo, error)
 }
 
 type userFetcher struct {
 	provider goth.Provider
-	users    UserFindOrCreator
+	resolver IdentityResolver
 	updater  profile.Updater
 }
 
-func NewUserFetcher(provider goth.Provider, users UserFindOrCreator, updater profile.Updater) UserFetcher {
+func NewUserFetcher(provider goth.Provider, resolver IdentityResolver, updater profile.Updater) UserFetcher {
 	return &userFetcher{
 		provider: provider,
-		users:    users,
+		resolver: resolver,
 		updater:  updater,
 	}
 }
 
-func (c *userFetcher) Fetch(rawsess string, params goth.Params) (model.User, error) {
-	var empty model.User
+func (c *userFetcher) fetch(rawsess string, params goth.Params) (goth.User, error) {
+	var empty goth.User
 
 	session, err := c.provider.UnmarshalSession(rawsess)
 	if err != nil {
@@ -47,18 +50,49 @@ func (c *userFetcher) Fetch(rawsess string, params goth.Params) (model.User, err
 		return empty, fmt.Errorf("fetch user from provider failed: %w", err)
 	}
 
-	user, err := c.users.FindOrCreate(gUser.Email)
+	return gUser, nil
+}
+
+func (c *userFetcher) Fetch(rawsess string, params goth.Params) (model.User, error) {
+	var empty model.User
+
+	gUser, err := c.fetch(rawsess, params)
 	if err != nil {
 		return empty, err
 	}
 
-	if err := c.updater.Update(user.ID, gUser.RawData); err != nil {
+	user, err := c.resolver.Resolve(c.provider.Name(), gUser)
+	if err != nil {
+		return empty, err
+	}
+
+	if err := c.updater.Update(user.ID, c.provider.Name(), gUser); err != nil {
 		return empty, fmt.Errorf("failed to update user profile: %w", err)
 	}
 
 	return user, err
 }
 
+func (c *userFetcher) Link(rawsess string, params goth.Params, userID string) (IdentityInfo, error) {
+	var empty IdentityInfo
+
+	gUser, err := c.fetch(rawsess, params)
+	if err != nil {
+		return empty, err
+	}
+
+	info, err := c.resolver.Link(userID, c.provider.Name(), gUser)
+	if err != nil {
+		return empty, err
+	}
+
+	if err := c.updater.Update(userID, c.provider.Name(), gUser); err != nil {
+		return empty, fmt.Errorf("failed to update user profile: %w", err)
+	}
+
+	return info, nil
+}
+
 type UserFindOrCreator interface {
 	FindOrCreate(username string) (model.User, error)
 }
@@ -72,6 +106,9 @@ func NewUserFindOrCreator(users repo.Users, timer Timer) UserFindOrCreator {
 	return &userFindOrCreator{users: users, timer: timer}
 }
 
+// FindOrCreate returns the user with the email given, the user is created if
+// not found. The caller has proven owning the email, so the user is marked
+// verified.
 func (c *userFindOrCreator) FindOrCreate(username string) (model.User, error) {
 	user, err := c.users.Find(username)
 	if err != nil {
@@ -81,11 +118,21 @@ func (c *userFindOrCreator) FindOrCreate(username string) (model.User, error) {
 
 		user.ID = generateRandomString(UserIDSize)
 		user.Name = username
+		user.Verified = true
 		user.Created = c.timer.Now().Unix()
 
 		if err := c.users.Create(user); err != nil {
 			return user, err
 		}
+		return user, nil
 	}
+
+	if !user.Verified {
+		if err := c.users.SetVerified(user.ID); err != nil {
+			return user, fmt.Errorf("failed to verify user: %w", err)
+		}
+		user.Verified = true
+	}
+
 	return user, nil
 }
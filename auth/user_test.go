@@ -34,6 +34,43 @@ func (m *usersMock) Find(name string) (model.User, error) {
 	return user.(model.User), args.Error(1)
 }
 
+func (m *usersMock) FindByID(id string) (model.User, error) {
+	args := m.Called(id)
+
+	user := args.Get(0)
+	if user == nil {
+		return model.User{}, args.Error(1)
+	}
+
+	return user.(model.User), args.Error(1)
+}
+
+func (m *usersMock) List(offset, limit int) ([]model.User, error) {
+	args := m.Called(offset, limit)
+
+	users := args.Get(0)
+	if users == nil {
+		return nil, args.Error(1)
+	}
+
+	return users.([]model.User), args.Error(1)
+}
+
+func (m *usersMock) SetDisabled(id string, disabled bool) error {
+	args := m.Called(id, disabled)
+	return args.Error(0)
+}
+
+func (m *usersMock) SetVerified(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *usersMock) Delete(id string) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
 func matchUser(user model.User) func(model.User) bool {
 	return func(arg model.User) bool {
 		return user.Name == arg.Name &&
@@ -71,12 +108,37 @@ func (m *userFetcherMock) Fetch(rawsess string, params goth.Params) (model.User,
 	return user.(model.User), args.Error(1)
 }
 
+func (m *userFetcherMock) Link(rawsess string, params goth.Params, userID string) (auth.IdentityInfo, error) {
+	args := m.Called(rawsess, params, userID)
+	return args.Get(0).(auth.IdentityInfo), args.Error(1)
+}
+
+type identityResolverMock struct {
+	mock.Mock
+}
+
+func (m *identityResolverMock) Resolve(provider string, gUser goth.User) (model.User, error) {
+	args := m.Called(provider, gUser)
+
+	user := args.Get(0)
+	if user == nil {
+		return model.User{}, args.Error(1)
+	}
+
+	return user.(model.User), args.Error(1)
+}
+
+func (m *identityResolverMock) Link(userID, provider string, gUser goth.User) (auth.IdentityInfo, error) {
+	args := m.Called(userID, provider, gUser)
+	return args.Get(0).(auth.IdentityInfo), args.Error(1)
+}
+
 type updaterMock struct {
 	mock.Mock
 }
 
-func (m *updaterMock) Update(userID string, data map[string]interface{}) error {
-	return m.Called(userID, data).Error(0)
+func (m *updaterMock) Update(userID, provider string, user goth.User) error {
+	return m.Called(userID, provider, user).Error(0)
 }
 
 func TestUserFinOrCreator(t *testing.T) {
@@ -99,6 +161,7 @@ func TestUserFinOrCreator(t *testing.T) {
 		result, err := svc.FindOrCreate(user.Name)
 		require.NoError(t, err)
 		require.NotEmpty(t, result.ID)
+		require.True(t, result.Verified)
 	})
 
 	t.Run("Old", func(t *testing.T) {
@@ -106,17 +169,40 @@ func TestUserFinOrCreator(t *testing.T) {
 		tm := &timerMock{value: time.Now()}
 
 		user := model.User{
+			Name:     "u0@mail.org",
+			Verified: true,
+			Created:  tm.Now().Unix(),
+		}
+
+		um.On("Find", user.Name).Return(user, nil)
+
+		svc := auth.NewUserFindOrCreator(um, tm)
+
+		result, err := svc.FindOrCreate(user.Name)
+		require.NoError(t, err)
+		require.Equal(t, user, result)
+		um.AssertNotCalled(t, "SetVerified", mock.Anything)
+	})
+
+	t.Run("OldNotVerified", func(t *testing.T) {
+		um := &usersMock{}
+		tm := &timerMock{value: time.Now()}
+
+		user := model.User{
+			ID:      "user.123",
 			Name:    "u0@mail.org",
 			Created: tm.Now().Unix(),
 		}
 
 		um.On("Find", user.Name).Return(user, nil)
+		um.On("SetVerified", user.ID).Return(nil)
 
 		svc := auth.NewUserFindOrCreator(um, tm)
 
 		result, err := svc.FindOrCreate(user.Name)
 		require.NoError(t, err)
-		require.Equal(t, user, result)
+		require.True(t, result.Verified)
+		um.AssertCalled(t, "SetVerified", user.ID)
 	})
 
 	t.Run("FailOnFind", func(t *testing.T) {
@@ -158,12 +244,13 @@ func TestUserFetcher(t *testing.T) {
 
 		provider := &providerMock{}
 		session := &sessionMock{}
-		userFoC := &userFindOrCreatorMock{}
+		resolver := &identityResolverMock{}
 		updater := &updaterMock{}
 
 		rawsess := "user.session.value.123"
 
 		gUser := goth.User{
+			UserID:  "google.123",
 			Email:   "u1@mail.org",
 			RawData: map[string]interface{}{"Email": "u1@mail.org"},
 		}
@@ -177,10 +264,11 @@ func TestUserFetcher(t *testing.T) {
 		provider.On("UnmarshalSession", rawsess).Return(session, nil)
 		session.On("Authorize", provider, params).Return(nil, nil)
 		provider.On("FetchUser", session).Return(gUser, nil)
-		userFoC.On("FindOrCreate", gUser.Email).Return(user, nil)
-		updater.On("Update", user.ID, gUser.RawData).Return(nil)
+		provider.On("Name").Return("google")
+		resolver.On("Resolve", "google", gUser).Return(user, nil)
+		updater.On("Update", user.ID, "google", gUser).Return(nil)
 
-		cmd := auth.NewUserFetcher(provider, userFoC, updater)
+		cmd := auth.NewUserFetcher(provider, resolver, updater)
 
 		result, err := cmd.Fetch(rawsess, params)
 		require.NoError(t, err)
@@ -191,14 +279,14 @@ func TestUserFetcher(t *testing.T) {
 		var params goth.Params
 
 		provider := &providerMock{}
-		userFoC := &userFindOrCreatorMock{}
+		resolver := &identityResolverMock{}
 
 		rawsess := "user.session.value.123"
 		fail := errors.New("xxx")
 
 		provider.On("UnmarshalSession", rawsess).Return(nil, fail)
 
-		cmd := auth.NewUserFetcher(provider, userFoC, &updaterMock{})
+		cmd := auth.NewUserFetcher(provider, resolver, &updaterMock{})
 
 		_, err := cmd.Fetch(rawsess, params)
 		require.Error(t, err)
@@ -210,7 +298,7 @@ func TestUserFetcher(t *testing.T) {
 
 		provider := &providerMock{}
 		session := &sessionMock{}
-		userFoC := &userFindOrCreatorMock{}
+		resolver := &identityResolverMock{}
 
 		rawsess := "user.session.value.123"
 
@@ -219,7 +307,7 @@ func TestUserFetcher(t *testing.T) {
 		fail := errors.New("xxx")
 		session.On("Authorize", provider, params).Return(nil, fail)
 
-		cmd := auth.NewUserFetcher(provider, userFoC, &updaterMock{})
+		cmd := auth.NewUserFetcher(provider, resolver, &updaterMock{})
 
 		_, err := cmd.Fetch(rawsess, params)
 		require.Error(t, err)
@@ -231,7 +319,7 @@ func TestUserFetcher(t *testing.T) {
 
 		provider := &providerMock{}
 		session := &sessionMock{}
-		userFoC := &userFindOrCreatorMock{}
+		resolver := &identityResolverMock{}
 
 		rawsess := "user.session.value.123"
 		fail := errors.New("xxx")
@@ -240,33 +328,35 @@ func TestUserFetcher(t *testing.T) {
 		session.On("Authorize", provider, params).Return(nil, nil)
 		provider.On("FetchUser", session).Return(nil, fail)
 
-		cmd := auth.NewUserFetcher(provider, userFoC, &updaterMock{})
+		cmd := auth.NewUserFetcher(provider, resolver, &updaterMock{})
 
 		_, err := cmd.Fetch(rawsess, params)
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
 
-	t.Run("FailOnFindOrCreate", func(t *testing.T) {
+	t.Run("FailOnResolve", func(t *testing.T) {
 		var params goth.Params
 
 		provider := &providerMock{}
 		session := &sessionMock{}
-		userFoC := &userFindOrCreatorMock{}
+		resolver := &identityResolverMock{}
 
 		rawsess := "user.session.value.123"
 		fail := errors.New("xxx")
 
 		gUser := goth.User{
-			Email: "u1@mail.org",
+			UserID: "google.123",
+			Email:  "u1@mail.org",
 		}
 
 		provider.On("UnmarshalSession", rawsess).Return(session, nil)
 		session.On("Authorize", provider, params).Return(nil, nil)
 		provider.On("FetchUser", session).Return(gUser, nil)
-		userFoC.On("FindOrCreate", gUser.Email).Return(nil, fail)
+		provider.On("Name").Return("google")
+		resolver.On("Resolve", "google", gUser).Return(nil, fail)
 
-		cmd := auth.NewUserFetcher(provider, userFoC, &updaterMock{})
+		cmd := auth.NewUserFetcher(provider, resolver, &updaterMock{})
 
 		_, err := cmd.Fetch(rawsess, params)
 		require.Error(t, err)
@@ -278,13 +368,14 @@ func TestUserFetcher(t *testing.T) {
 
 		provider := &providerMock{}
 		session := &sessionMock{}
-		userFoC := &userFindOrCreatorMock{}
+		resolver := &identityResolverMock{}
 		updater := &updaterMock{}
 
 		rawsess := "user.session.value.123"
 		fail := errors.New("xxx")
 
 		gUser := goth.User{
+			UserID:  "google.123",
 			Email:   "u1@mail.org",
 			RawData: map[string]interface{}{"Email": "u1@mail.org"},
 		}
@@ -298,13 +389,41 @@ func TestUserFetcher(t *testing.T) {
 		provider.On("UnmarshalSession", rawsess).Return(session, nil)
 		session.On("Authorize", provider, params).Return(nil, nil)
 		provider.On("FetchUser", session).Return(gUser, nil)
-		userFoC.On("FindOrCreate", gUser.Email).Return(user, nil)
-		updater.On("Update", user.ID, gUser.RawData).Return(fail)
+		provider.On("Name").Return("google")
+		resolver.On("Resolve", "google", gUser).Return(user, nil)
+		updater.On("Update", user.ID, "google", gUser).Return(fail)
 
-		cmd := auth.NewUserFetcher(provider, userFoC, updater)
+		cmd := auth.NewUserFetcher(provider, resolver, updater)
 
 		_, err := cmd.Fetch(rawsess, params)
 		require.Error(t, err)
 		require.ErrorIs(t, err, fail)
 	})
+
+	t.Run("Link", func(t *testing.T) {
+		var params goth.Params
+
+		provider := &providerMock{}
+		session := &sessionMock{}
+		resolver := &identityResolverMock{}
+		updater := &updaterMock{}
+
+		rawsess := "user.session.value.123"
+		gUser := goth.User{UserID: "google.123", Email: "u1@mail.org"}
+		info := auth.IdentityInfo{Provider: "google", Email: gUser.Email, Created: 1600000000}
+
+		provider.On("UnmarshalSession", rawsess).Return(session, nil)
+		session.On("Authorize", provider, params).Return(nil, nil)
+		provider.On("FetchUser", session).Return(gUser, nil)
+		provider.On("Name").Return("google")
+		resolver.On("Link", "user.123", "google", gUser).Return(info, nil)
+		updater.On("Update", "user.123", "google", gUser).Return(nil)
+
+		cmd := auth.NewUserFetcher(provider, resolver, updater)
+
+		result, err := cmd.Link(rawsess, params, "user.123")
+		require.NoError(t, err)
+		require.Equal(t, info, result)
+		updater.AssertCalled(t, "Update", "user.123", "google", gUser)
+	})
 }
@@ -0,0 +1,84 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/golang-jwt/jwt"
+)
+
+var errUnknownKey = errors.New("unknown signing key")
+
+// The token_use claim tells the access tokens of users from the access tokens
+// of clients and from the id tokens, all of them are signed by the same keys.
+const (
+	TokenUseAccess = "access"
+	TokenUseClient = "client"
+	TokenUseID     = "id"
+)
+
+// Verifier validates tokens issued by guard and returns their claims.
+type Verifier interface {
+	// Verify accepts the access tokens of users only.
+	Verify(access string) (map[string]interface{}, error)
+	// VerifyUse accepts the tokens of the given uses.
+	VerifyUse(token string, uses ...string) (map[string]interface{}, error)
+}
+
+type verifier struct {
+	keys  KeySet
+	timer Timer
+}
+
+func NewVerifier(keys KeySet, timer Timer) Verifier {
+	return &verifier{keys: keys, timer: timer}
+}
+
+func (c *verifier) Verify(access string) (map[string]interface{}, error) {
+	return c.VerifyUse(access, TokenUseAccess)
+}
+
+func (c *verifier) VerifyUse(token string, uses ...string) (map[string]interface{}, error) {
+	claims := jwt.MapClaims{}
+
+	parser := jwt.Parser{SkipClaimsValidation: true}
+	if _, err := parser.ParseWithClaims(token, claims, c.key); err != nil {
+		return nil, Error{msg: "invalid token"}
+	}
+
+	use, _ := claims["token_use"].(string)
+	if !containsString(uses, use) {
+		return nil, Error{msg: "invalid token"}
+	}
+
+	now := c.timer.Now().Unix()
+	if !claims.VerifyExpiresAt(now, true) || !claims.VerifyNotBefore(now, false) {
+		return nil, Error{msg: "expired token"}
+	}
+
+	return claims, nil
+}
+
+// key finds the verification key by the token kid among the published keys.
+// The token algorithm must match the key one to prevent algorithm confusion.
+func (c *verifier) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	for _, key := range c.keys.Published() {
+		if key.ID != kid {
+			continue
+		}
+
+		if key.Method.Alg() != token.Method.Alg() {
+			return nil, fmt.Errorf("unexpected signing method: %s", token.Method.Alg())
+		}
+
+		if key.Public == nil {
+			return key.Private, nil
+		}
+
+		return key.Public, nil
+	}
+
+	return nil, errUnknownKey
+}
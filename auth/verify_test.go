@@ -0,0 +1,151 @@
+package auth_test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+)
+
+type verifierMock struct {
+	mock.Mock
+}
+
+func (m *verifierMock) Verify(access string) (map[string]interface{}, error) {
+	args := m.Called(access)
+
+	claims := args.Get(0)
+	if claims == nil {
+		return nil, args.Error(1)
+	}
+
+	return claims.(map[string]interface{}), args.Error(1)
+}
+
+func (m *verifierMock) VerifyUse(token string, uses ...string) (map[string]interface{}, error) {
+	args := m.Called(token, uses)
+
+	claims := args.Get(0)
+	if claims == nil {
+		return nil, args.Error(1)
+	}
+
+	return claims.(map[string]interface{}), args.Error(1)
+}
+
+func issueToken(t *testing.T, keys auth.KeySet, timer auth.Timer, grant auth.Grant) auth.Token {
+	refresh := &refreshGeneratorMock{}
+	refresh.On("Generate", mock.Anything).Return(model.RefreshToken{}, nil)
+
+	cmd := auth.NewIssuer(keys, timer, time.Minute, auth.Claims{}, refresh)
+
+	token, err := cmd.Issue(grant)
+	require.NoError(t, err)
+
+	return token
+}
+
+func issueAccess(t *testing.T, keys auth.KeySet, timer auth.Timer) string {
+	return issueToken(t, keys, timer, auth.Grant{User: model.User{ID: "user.123", Name: "u0@mail.org"}}).Access
+}
+
+func TestVerifier(t *testing.T) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	key, err := auth.ParseKey(encodePEM(t, private, true))
+	require.NoError(t, err)
+
+	t.Run("Success", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		keys := auth.NewKeySet(timer, 0, key)
+
+		claims, err := auth.NewVerifier(keys, timer).Verify(issueAccess(t, keys, timer))
+		require.NoError(t, err)
+		require.Equal(t, "user.123", claims["sub"])
+	})
+
+	t.Run("TokenUse", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		keys := auth.NewKeySet(timer, 0, key)
+		verifier := auth.NewVerifier(keys, timer)
+
+		user := issueToken(t, keys, timer, auth.Grant{User: model.User{ID: "user.123", Name: "u0@mail.org"}})
+		client := issueToken(t, keys, timer, auth.Grant{Client: model.Client{ID: "client.123"}})
+
+		_, err := verifier.Verify(user.IDToken)
+		require.ErrorAs(t, err, &auth.Error{})
+
+		_, err = verifier.Verify(client.Access)
+		require.ErrorAs(t, err, &auth.Error{})
+
+		claims, err := verifier.VerifyUse(client.Access, auth.TokenUseAccess, auth.TokenUseClient)
+		require.NoError(t, err)
+		require.Equal(t, "client.123", claims["sub"])
+
+		_, err = verifier.VerifyUse(user.IDToken, auth.TokenUseAccess, auth.TokenUseClient)
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("SecretKey", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		keys := auth.NewKeySet(timer, 0, auth.NewSecretKey("123.456"))
+
+		_, err := auth.NewVerifier(keys, timer).Verify(issueAccess(t, keys, timer))
+		require.NoError(t, err)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		keys := auth.NewKeySet(timer, 0, key)
+		access := issueAccess(t, keys, timer)
+
+		timer.value = timer.value.Add(2 * time.Minute)
+
+		_, err := auth.NewVerifier(keys, timer).Verify(access)
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("UnknownKey", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		access := issueAccess(t, auth.NewKeySet(timer, 0, key), timer)
+
+		keys := auth.NewKeySet(timer, 0, auth.NewSecretKey("123.456"))
+
+		_, err := auth.NewVerifier(keys, timer).Verify(access)
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("AlgorithmMismatch", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		keys := auth.NewKeySet(timer, 0, key)
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+			"sub":       "user.123",
+			"exp":       timer.Now().Add(time.Minute).Unix(),
+			"token_use": auth.TokenUseAccess,
+		})
+		token.Header["kid"] = key.ID
+
+		access, err := token.SignedString([]byte(key.Public.(ed25519.PublicKey)))
+		require.NoError(t, err)
+
+		_, err = auth.NewVerifier(keys, timer).Verify(access)
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+
+	t.Run("Malformed", func(t *testing.T) {
+		timer := &timerMock{value: time.Now()}
+		keys := auth.NewKeySet(timer, 0, key)
+
+		_, err := auth.NewVerifier(keys, timer).Verify("xxx")
+		require.ErrorAs(t, err, &auth.Error{})
+	})
+}
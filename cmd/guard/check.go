@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// checkConfig validates the configuration the way the server does on start
+// and checks the storages are reachable and the database schema is up to
+// date.
+func checkConfig(cfg Conf, args []string, out io.Writer) error {
+	if len(args) != 0 {
+		return fmt.Errorf("%w: expected 0 arguments, got %d", errInvalidArgs, len(args))
+	}
+
+	if err := validateConf(cfg); err != nil {
+		return err
+	}
+
+	db, rdb, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	if rdb != nil {
+		defer rdb.Close()
+	}
+
+	if cfg.DBDriver != memoryDriver {
+		m, err := newMigrator(cfg, db)
+		if err != nil {
+			return err
+		}
+
+		status, err := m.Status()
+		if err != nil {
+			return fmt.Errorf("failed to read migrations: %w", err)
+		}
+
+		if status.Dirty {
+			return fmt.Errorf("database is dirty at version %d", status.Version)
+		}
+
+		pending := len(status.Pending())
+		if pending > 0 && !cfg.AutoMigrate {
+			return fmt.Errorf("%d pending migrations, run guard migrate up", pending)
+		}
+
+		if pending == 0 {
+			if err := checkMFAKey(cfg, db); err != nil {
+				return err
+			}
+		}
+	}
+
+	fmt.Fprintln(out, "config ok")
+	return nil
+}
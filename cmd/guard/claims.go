@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/vbogretsov/guard/auth"
+)
+
+func tokenClaims(cfg Conf) (auth.Claims, error) {
+	claims := auth.Claims{
+		Issuer:   cfg.TokenIssuer,
+		Audience: cfg.TokenAudience,
+	}
+
+	if claims.Issuer == "" {
+		claims.Issuer = cfg.BaseURL
+	}
+
+	if cfg.ExtraClaims != "" {
+		extra, err := auth.ParseExtraClaims(cfg.ExtraClaims)
+		if err != nil {
+			return claims, err
+		}
+		claims.Extra = extra
+	}
+
+	return claims, nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenClaims(t *testing.T) {
+	t.Run("DefaultIssuer", func(t *testing.T) {
+		claims, err := tokenClaims(Conf{BaseURL: "http://localhost:8000"})
+		require.NoError(t, err)
+		require.Equal(t, "http://localhost:8000", claims.Issuer)
+		require.Empty(t, claims.Extra)
+	})
+
+	t.Run("Configured", func(t *testing.T) {
+		claims, err := tokenClaims(Conf{
+			BaseURL:       "http://localhost:8000",
+			TokenIssuer:   "https://auth.example.org",
+			TokenAudience: []string{"api", "web"},
+			ExtraClaims:   `{"role": "user"}`,
+		})
+		require.NoError(t, err)
+		require.Equal(t, "https://auth.example.org", claims.Issuer)
+		require.Equal(t, []string{"api", "web"}, claims.Audience)
+		require.Equal(t, "user", claims.Extra["role"])
+	})
+
+	t.Run("InvalidExtra", func(t *testing.T) {
+		_, err := tokenClaims(Conf{ExtraClaims: `{"exp": 0}`})
+		require.Error(t, err)
+	})
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+var errInvalidArgs = errors.New("invalid arguments")
+
+// command is a subcommand of the guard binary. A command either runs or
+// dispatches to its subcommands by the first argument.
+type command struct {
+	name     string
+	usage    string
+	run      func(cfg Conf, args []string, out io.Writer) error
+	commands []command
+}
+
+var commands = command{
+	commands: []command{
+		{
+			name:  "serve",
+			usage: "guard [serve]",
+			run: func(cfg Conf, args []string, out io.Writer) error {
+				return serve(cfg)
+			},
+		},
+		{
+			name:  "migrate",
+			usage: "guard migrate up|down [n]|status",
+			run: func(cfg Conf, args []string, out io.Writer) error {
+				db, err := dbconnect(cfg)
+				if err != nil {
+					return fmt.Errorf("failed to connect database: %w", err)
+				}
+				return migrate(cfg, db, args, out)
+			},
+		},
+		{
+			name: "users",
+			commands: []command{
+				{name: "list", usage: "guard users list [-offset n] [-limit n]", run: listUsers},
+				{name: "show", usage: "guard users show <id|email>", run: showUser},
+				{name: "disable", usage: "guard users disable <id|email>", run: disableUser},
+				{name: "enable", usage: "guard users enable <id|email>", run: enableUser},
+				{name: "delete", usage: "guard users delete <id|email>", run: deleteUser},
+			},
+		},
+		{
+			name: "tokens",
+			commands: []command{
+				{name: "revoke", usage: "guard tokens revoke -user <id|email>", run: revokeTokens},
+			},
+		},
+		{
+			name: "keys",
+			commands: []command{
+				{name: "generate", usage: "guard keys generate [-alg alg] [-out file]", run: generateKey},
+				{name: "rotate", usage: "guard keys rotate [-alg alg] [-delay duration] [-prune]", run: rotateKeys},
+			},
+		},
+		{
+			name: "config",
+			commands: []command{
+				{name: "check", usage: "guard config check", run: checkConfig},
+			},
+		},
+	},
+}
+
+// runCLI runs the command given by the arguments, the server is run if there
+// are no arguments.
+func runCLI(cfg Conf, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+	return commands.exec(cfg, args, out)
+}
+
+func (c command) exec(cfg Conf, args []string, out io.Writer) error {
+	if c.run != nil {
+		err := c.run(cfg, args, out)
+		if errors.Is(err, errInvalidArgs) {
+			return fmt.Errorf("%v\nusage: %s", err, c.usage)
+		}
+		return err
+	}
+
+	if len(args) > 0 {
+		for _, sub := range c.commands {
+			if sub.name == args[0] {
+				return sub.exec(cfg, args[1:], out)
+			}
+		}
+	}
+
+	return c.usageError()
+}
+
+func (c command) usages() []string {
+	if c.run != nil {
+		return []string{c.usage}
+	}
+
+	usages := []string{}
+	for _, sub := range c.commands {
+		usages = append(usages, sub.usages()...)
+	}
+	return usages
+}
+
+func (c command) usageError() error {
+	return fmt.Errorf("usage:\n\t%s", strings.Join(c.usages(), "\n\t"))
+}
+
+// parseFlags parses the command flags and checks the number of the positional
+// arguments left.
+func parseFlags(fs *flag.FlagSet, args []string, nargs int) error {
+	fs.SetOutput(ioutil.Discard)
+
+	if err := fs.Parse(args); err != nil {
+		return fmt.Errorf("%w: %v", errInvalidArgs, err)
+	}
+
+	if fs.NArg() != nargs {
+		return fmt.Errorf("%w: expected %d arguments, got %d", errInvalidArgs, nargs, fs.NArg())
+	}
+
+	return nil
+}
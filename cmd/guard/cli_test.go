@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func TestCLI(t *testing.T) {
+	cfg, err := loadConf("")
+	require.NoError(t, err)
+
+	cfg.DBDriver = "sqlite"
+	cfg.DSN = filepath.Join(t.TempDir(), "guard.db")
+	cfg.SecretKey = "123.456"
+
+	run := func(args ...string) (string, error) {
+		out := &bytes.Buffer{}
+		err := runCLI(cfg, args, out)
+		return out.String(), err
+	}
+
+	_, err = run("migrate", "up")
+	require.NoError(t, err)
+
+	db, err := dbconnect(cfg)
+	require.NoError(t, err)
+
+	conn := repo.NewConn(db)
+	users := []model.User{
+		{ID: "user.123", Name: "u0@mail.org", Created: 1600000000},
+		{ID: "user.456", Name: "u1@mail.org", Created: 1600000010},
+	}
+	for _, user := range users {
+		require.NoError(t, repo.NewUsers(conn).Create(user))
+	}
+	require.NoError(t, repo.NewIdentities(conn).Create(model.UserIdentity{
+		Provider: "google",
+		Subject:  "google.123",
+		UserID:   "user.123",
+		Email:    "u0@mail.org",
+		Created:  1600000020,
+	}))
+
+	createToken := func(id string) {
+		require.NoError(t, repo.NewRefreshTokens(conn).Create(model.RefreshToken{
+			ID:      id,
+			UserID:  "user.123",
+			Family:  id,
+			Created: 1600000000,
+			Expires: 1900000000,
+		}))
+	}
+
+	findToken := func(id string) error {
+		_, err := repo.NewRefreshTokens(conn).Find(id)
+		return err
+	}
+
+	t.Run("UsersList", func(t *testing.T) {
+		out, err := run("users", "list")
+		require.NoError(t, err)
+		require.Equal(t, ""+
+			"ID        NAME         DISABLED  CREATED\n"+
+			"user.123  u0@mail.org  false     2020-09-13T12:26:40Z\n"+
+			"user.456  u1@mail.org  false     2020-09-13T12:26:50Z\n", out)
+
+		out, err = run("users", "list", "-offset", "1", "-limit", "1")
+		require.NoError(t, err)
+		require.NotContains(t, out, "user.123")
+		require.Contains(t, out, "user.456")
+	})
+
+	t.Run("UsersShow", func(t *testing.T) {
+		out, err := run("users", "show", "u0@mail.org")
+		require.NoError(t, err)
+		require.Contains(t, out, "id:        user.123\n")
+		require.Contains(t, out, "google  u0@mail.org  2020-09-13T12:27:00Z\n")
+
+		_, err = run("users", "show", "xxx")
+		require.ErrorIs(t, err, auth.ErrUserNotFound)
+	})
+
+	t.Run("UsersDisable", func(t *testing.T) {
+		createToken("refresh.1")
+
+		out, err := run("users", "disable", "user.123")
+		require.NoError(t, err)
+		require.Equal(t, "user user.123 (u0@mail.org) disabled\n", out)
+		require.ErrorIs(t, findToken("refresh.1"), repo.ErrorNotFound)
+
+		user, err := repo.NewUsers(conn).FindByID("user.123")
+		require.NoError(t, err)
+		require.True(t, user.Disabled)
+
+		out, err = run("users", "enable", "u0@mail.org")
+		require.NoError(t, err)
+		require.Equal(t, "user user.123 (u0@mail.org) enabled\n", out)
+
+		user, err = repo.NewUsers(conn).FindByID("user.123")
+		require.NoError(t, err)
+		require.False(t, user.Disabled)
+	})
+
+	t.Run("TokensRevoke", func(t *testing.T) {
+		createToken("refresh.2")
+
+		out, err := run("tokens", "revoke", "-user", "u0@mail.org")
+		require.NoError(t, err)
+		require.Equal(t, "user user.123 (u0@mail.org) refresh tokens revoked\n", out)
+		require.ErrorIs(t, findToken("refresh.2"), repo.ErrorNotFound)
+
+		_, err = run("tokens", "revoke")
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "usage:")
+	})
+
+	t.Run("UsersDelete", func(t *testing.T) {
+		out, err := run("users", "delete", "user.123")
+		require.NoError(t, err)
+		require.Equal(t, "user user.123 (u0@mail.org) deleted\n", out)
+
+		_, err = repo.NewUsers(conn).FindByID("user.123")
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+
+		identities, err := repo.NewIdentities(conn).FindByUser("user.123")
+		require.NoError(t, err)
+		require.Empty(t, identities)
+	})
+
+	t.Run("KeysGenerate", func(t *testing.T) {
+		out, err := run("keys", "generate", "-alg", "ES384")
+		require.NoError(t, err)
+
+		key, err := auth.ParseKey([]byte(out))
+		require.NoError(t, err)
+		require.Equal(t, "ES384", key.Method.Alg())
+
+		path := filepath.Join(t.TempDir(), "key.pem")
+		_, err = run("keys", "generate", "-out", path)
+		require.NoError(t, err)
+
+		key, err = auth.LoadKey(path)
+		require.NoError(t, err)
+		require.Equal(t, "ES256", key.Method.Alg())
+
+		_, err = run("keys", "generate", "-out", path)
+		require.Error(t, err, "existing key overwritten")
+	})
+
+	t.Run("KeysRotate", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKey(t, filepath.Join(dir, "1600000000.pem"))
+		writeKey(t, filepath.Join(dir, "1600001000.pem"))
+
+		cfg := cfg
+		cfg.SigningKeysDir = dir
+
+		out := &bytes.Buffer{}
+		require.NoError(t, runCLI(cfg, []string{"keys", "rotate", "-delay", "0s", "-prune"}, out))
+		require.Contains(t, out.String(), "removed "+filepath.Join(dir, "1600000000.pem")+"\n")
+
+		// The replaced key stays published during the grace period.
+		keys, err := auth.LoadKeyDir(dir)
+		require.NoError(t, err)
+		require.Len(t, keys, 2)
+		require.Equal(t, int64(1600001000), keys[0].Activated)
+		require.Equal(t, "ES256", keys[1].Method.Alg())
+
+		require.Error(t, runCLI(cfg, []string{"keys", "rotate", "-alg", "HS256"}, ioutil.Discard))
+
+		cfg.SigningKeysDir = ""
+		require.Error(t, runCLI(cfg, []string{"keys", "rotate"}, ioutil.Discard))
+	})
+
+	t.Run("ConfigCheck", func(t *testing.T) {
+		out, err := run("config", "check")
+		require.NoError(t, err)
+		require.Equal(t, "config ok\n", out)
+
+		_, err = run("migrate", "down")
+		require.NoError(t, err)
+
+		_, err = run("config", "check")
+		require.Error(t, err, "pending migrations")
+
+		cfg := cfg
+		cfg.SecretKey = ""
+		require.Error(t, runCLI(cfg, []string{"config", "check"}, ioutil.Discard))
+	})
+
+	t.Run("Memory", func(t *testing.T) {
+		cfg := Conf{DBDriver: memoryDriver}
+		require.Error(t, runCLI(cfg, []string{"users", "list"}, ioutil.Discard))
+	})
+
+	t.Run("Usage", func(t *testing.T) {
+		for _, args := range [][]string{{"xxx"}, {"users"}, {"users", "xxx"}, {"users", "show"}, {"users", "list", "-xxx"}} {
+			_, err := run(args...)
+			require.Error(t, err, "%v", args)
+			require.Contains(t, err.Error(), "usage:", "%v", args)
+		}
+	})
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+// fileSource is the source of the clients saved from the config file.
+const fileSource = "file"
+
+// syncClients saves the clients of the config file and deletes the clients
+// saved from the file before but missing from it now. The clients created
+// otherwise are kept.
+func syncClients(db *gorm.DB, clients []ClientConf) error {
+	repoClients := repo.NewClients(repo.NewConn(db))
+
+	ids := map[string]bool{}
+
+	for _, c := range clients {
+		client := model.Client{
+			ID:         c.ID,
+			SecretHash: c.SecretHash,
+			Redirects:  strings.Join(c.Redirects, " "),
+			Providers:  strings.Join(c.Providers, " "),
+			Scopes:     strings.Join(c.Scopes, " "),
+			AccessTTL:  int64(c.AccessTTL / time.Second),
+			RefreshTTL: int64(c.RefreshTTL / time.Second),
+			Source:     fileSource,
+			Created:    time.Now().Unix(),
+		}
+
+		existing, err := repoClients.Find(c.ID)
+		switch {
+		case err == nil:
+			client.Created = existing.Created
+		case !errors.Is(err, repo.ErrorNotFound):
+			return fmt.Errorf("failed to find client %s: %w", c.ID, err)
+		}
+
+		if err := repoClients.Save(client); err != nil {
+			return fmt.Errorf("failed to save client %s: %w", c.ID, err)
+		}
+
+		ids[c.ID] = true
+	}
+
+	var saved []string
+	if err := db.Model(&model.Client{}).Where("source = ?", fileSource).Pluck("id", &saved).Error; err != nil {
+		return fmt.Errorf("failed to find file clients: %w", err)
+	}
+
+	for _, id := range saved {
+		if ids[id] {
+			continue
+		}
+
+		if err := repoClients.Delete(id); err != nil {
+			return fmt.Errorf("failed to delete client %s: %w", id, err)
+		}
+
+		log.Warn().Str("client", id).Msg("client removed from the config file deleted")
+	}
+
+	return nil
+}
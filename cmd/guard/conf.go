@@ -1,32 +1,205 @@
 package main
 
-import "time"
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
 
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/webauthn"
+)
+
+// Conf is the configuration given by the environment variables and the
+// config file. A setting of the file is named by its variable without the
+// GUARD_ prefix in lower case, the variables set override the file.
 type Conf struct {
-	Debug              bool          `env:"GUARD_DEBUG" envDefault:"false"`
-	Port               int           `env:"GUARD_PORT" envDefault:"8000"`
-	LogLevel           string        `env:"GUARD_LOG_LEVEL" envDefault:"info"`
-	DBDriver           string        `env:"GUARD_DBDRIVER" envDefault:"sqlite"`
-	DBMaxIddleConn     int           `env:"GUARD_DB_MAX_IDDLE_CONN" envDefault:"16"`
-	DBMaxOpenConn      int           `env:"GUARD_DB_MAX_OPEN_CONN" envDefault:"128"`
-	DBConnMaxLifetime  time.Duration `env:"GUARD_DB_CONN_MAX_LIFETIME" envDefault:"3600s"`
-	DBConnMaxIddleTime time.Duration `env:"GUARD_DB_CONN_MAX_IDDLE_TIME" envDefault:"300s"`
-	DSN                string        `env:"GUARD_DSN,required"`
-	SecretKey          string        `env:"GUARD_SECRET_KEY,required"`
-	AccessTTL          time.Duration `env:"GUARD_ACCESS_TTL" envDefault:"300s"`
-	RefreshTTL         time.Duration `env:"GUARD_REFRESH_TTL" envDefault:"86400s"`
-	CodeTTL            time.Duration `env:"GUARD_CODE_TTL" envDefault:"3600s"`
-	BaseURL            string        `env:"GUARD_BASE_URL" envDefault:"http://localhost:8000"`
-	AppleClientID      string        `env:"APPLE_CLIENT_ID"`
-	AppleClientSecret  string        `env:"APPLE_CLIENT_SECRET"`
-	GoogleClientID     string        `env:"GOOGLE_CLIENT_ID"`
-	GoogleSecret       string        `env:"GOOGLE_CLIENT_SECRET"`
-	FacebookClientID   string        `env:"FACEBOOK_CLIENT_ID"`
-	FacebookSecret     string        `env:"FACEBOOK_CLIENT_SECRET"`
-	TwitterClientID    string        `env:"TWITTER_CLIENT_ID"`
-	TwitterSecret      string        `env:"TWITTER_CLIENT_SECRET"`
-	VkClientID         string        `env:"VK_CLIENT_ID"`
-	VkSecret           string        `env:"VK_CLIENT_SECRET"`
-	YandexClientID     string        `env:"YANDEX_CLIENT_ID"`
-	YandexSecret       string        `env:"YANDEX_CLIENT_SECRET"`
+	ConfigFile         string                  `env:"GUARD_CONFIG" yaml:"-"`
+	Debug              bool                    `env:"GUARD_DEBUG" envDefault:"false" yaml:"debug"`
+	Port               int                     `env:"GUARD_PORT" envDefault:"8000" yaml:"port"`
+	LogLevel           string                  `env:"GUARD_LOG_LEVEL" envDefault:"info" yaml:"log_level"`
+	DBDriver           string                  `env:"GUARD_DBDRIVER" envDefault:"sqlite" yaml:"dbdriver"`
+	DBMaxIddleConn     int                     `env:"GUARD_DB_MAX_IDDLE_CONN" envDefault:"16" yaml:"db_max_iddle_conn"`
+	DBMaxOpenConn      int                     `env:"GUARD_DB_MAX_OPEN_CONN" envDefault:"128" yaml:"db_max_open_conn"`
+	DBConnMaxLifetime  time.Duration           `env:"GUARD_DB_CONN_MAX_LIFETIME" envDefault:"3600s" yaml:"db_conn_max_lifetime"`
+	DBConnMaxIddleTime time.Duration           `env:"GUARD_DB_CONN_MAX_IDDLE_TIME" envDefault:"300s" yaml:"db_conn_max_iddle_time"`
+	DSN                string                  `env:"GUARD_DSN" yaml:"dsn"`
+	AutoMigrate        bool                    `env:"GUARD_AUTO_MIGRATE" envDefault:"false" yaml:"auto_migrate"`
+	SessionDriver      string                  `env:"GUARD_SESSION_DRIVER" envDefault:"sql" yaml:"session_driver"`
+	RedisURL           string                  `env:"GUARD_REDIS_URL" envDefault:"redis://localhost:6379/0" yaml:"redis_url"`
+	SecretKey          string                  `env:"GUARD_SECRET_KEY" yaml:"secret_key"`
+	SigningKey         string                  `env:"GUARD_SIGNING_KEY" yaml:"signing_key"`
+	SigningKeysDir     string                  `env:"GUARD_SIGNING_KEYS_DIR" yaml:"signing_keys_dir"`
+	KeyGracePeriod     time.Duration           `env:"GUARD_KEY_GRACE_PERIOD" envDefault:"0s" yaml:"key_grace_period"`
+	TokenIssuer        string                  `env:"GUARD_TOKEN_ISSUER" yaml:"token_issuer"`
+	TokenAudience      []string                `env:"GUARD_TOKEN_AUDIENCE" envSeparator:"," yaml:"token_audience"`
+	ExtraClaims        string                  `env:"GUARD_EXTRA_CLAIMS" yaml:"extra_claims"`
+	AccessTTL          time.Duration           `env:"GUARD_ACCESS_TTL" envDefault:"300s" yaml:"access_ttl"`
+	RefreshTTL         time.Duration           `env:"GUARD_REFRESH_TTL" envDefault:"86400s" yaml:"refresh_ttl"`
+	AuthCodeTTL        time.Duration           `env:"GUARD_AUTH_CODE_TTL" envDefault:"60s" yaml:"auth_code_ttl"`
+	CodeTTL            time.Duration           `env:"GUARD_CODE_TTL" envDefault:"3600s" yaml:"code_ttl"`
+	PasswordResetTTL   time.Duration           `env:"GUARD_PASSWORD_RESET_TTL" envDefault:"3600s" yaml:"password_reset_ttl"`
+	PasswordResetURL   string                  `env:"GUARD_PASSWORD_RESET_URL" yaml:"password_reset_url"`
+	MagicLinkTTL       time.Duration           `env:"GUARD_MAGIC_LINK_TTL" envDefault:"900s" yaml:"magic_link_ttl"`
+	MagicLinkURL       string                  `env:"GUARD_MAGIC_LINK_URL" yaml:"magic_link_url"`
+	Mailer             string                  `env:"GUARD_MAILER" envDefault:"log" yaml:"mailer"`
+	MailFrom           string                  `env:"GUARD_MAIL_FROM" envDefault:"guard@localhost" yaml:"mail_from"`
+	MailDir            string                  `env:"GUARD_MAIL_DIR" yaml:"mail_dir"`
+	SMTPAddr           string                  `env:"GUARD_SMTP_ADDR" envDefault:"localhost:25" yaml:"smtp_addr"`
+	SMTPUsername       string                  `env:"GUARD_SMTP_USERNAME" yaml:"smtp_username"`
+	SMTPPassword       string                  `env:"GUARD_SMTP_PASSWORD" yaml:"smtp_password"`
+	MFAKey             string                  `env:"GUARD_MFA_KEY" yaml:"mfa_key"`
+	ProviderTokenKey   string                  `env:"GUARD_PROVIDER_TOKEN_KEY" yaml:"provider_token_key"`
+	MFATTL             time.Duration           `env:"GUARD_MFA_TTL" envDefault:"300s" yaml:"mfa_ttl"`
+	MFAURL             string                  `env:"GUARD_MFA_URL" yaml:"mfa_url"`
+	MFAIssuer          string                  `env:"GUARD_MFA_ISSUER" envDefault:"guard" yaml:"mfa_issuer"`
+	WebAuthnRPID       string                  `env:"GUARD_WEBAUTHN_RP_ID" yaml:"webauthn_rp_id"`
+	WebAuthnRPName     string                  `env:"GUARD_WEBAUTHN_RP_NAME" envDefault:"guard" yaml:"webauthn_rp_name"`
+	WebAuthnOrigins    []string                `env:"GUARD_WEBAUTHN_ORIGINS" envSeparator:"," yaml:"webauthn_origins"`
+	WebAuthnTTL        time.Duration           `env:"GUARD_WEBAUTHN_TTL" envDefault:"300s" yaml:"webauthn_ttl"`
+	IdentityLinkPolicy string                  `env:"GUARD_IDENTITY_LINK_POLICY" envDefault:"verified" yaml:"identity_link_policy"`
+	ProfileUpdaters    []string                `env:"GUARD_PROFILE_UPDATERS" envSeparator:"," envDefault:"database" yaml:"profile_updaters"`
+	WebhookURLs        []string                `env:"GUARD_WEBHOOK_URLS" envSeparator:"," yaml:"webhook_urls"`
+	WebhookSecret      string                  `env:"GUARD_WEBHOOK_SECRET" yaml:"webhook_secret"`
+	WebhookInterval    time.Duration           `env:"GUARD_WEBHOOK_INTERVAL" envDefault:"5s" yaml:"webhook_interval"`
+	WebhookTimeout     time.Duration           `env:"GUARD_WEBHOOK_TIMEOUT" envDefault:"10s" yaml:"webhook_timeout"`
+	WebhookAttempts    int                     `env:"GUARD_WEBHOOK_ATTEMPTS" envDefault:"10" yaml:"webhook_attempts"`
+	WebhookBackoff     time.Duration           `env:"GUARD_WEBHOOK_BACKOFF" envDefault:"10s" yaml:"webhook_backoff"`
+	WebhookBatchSize   int                     `env:"GUARD_WEBHOOK_BATCH_SIZE" envDefault:"100" yaml:"webhook_batch_size"`
+	GCInterval         time.Duration           `env:"GUARD_GC_INTERVAL" envDefault:"60s" yaml:"gc_interval"`
+	GCBatchSize        int                     `env:"GUARD_GC_BATCH_SIZE" envDefault:"1000" yaml:"gc_batch_size"`
+	BaseURL            string                  `env:"GUARD_BASE_URL" envDefault:"http://localhost:8000" yaml:"base_url"`
+	AppleClientID      string                  `env:"APPLE_CLIENT_ID" yaml:"-"`
+	AppleClientSecret  string                  `env:"APPLE_CLIENT_SECRET" yaml:"-"`
+	GoogleClientID     string                  `env:"GOOGLE_CLIENT_ID" yaml:"-"`
+	GoogleSecret       string                  `env:"GOOGLE_CLIENT_SECRET" yaml:"-"`
+	FacebookClientID   string                  `env:"FACEBOOK_CLIENT_ID" yaml:"-"`
+	FacebookSecret     string                  `env:"FACEBOOK_CLIENT_SECRET" yaml:"-"`
+	TwitterClientID    string                  `env:"TWITTER_CLIENT_ID" yaml:"-"`
+	TwitterSecret      string                  `env:"TWITTER_CLIENT_SECRET" yaml:"-"`
+	VkClientID         string                  `env:"VK_CLIENT_ID" yaml:"-"`
+	VkSecret           string                  `env:"VK_CLIENT_SECRET" yaml:"-"`
+	YandexClientID     string                  `env:"YANDEX_CLIENT_ID" yaml:"-"`
+	YandexSecret       string                  `env:"YANDEX_CLIENT_SECRET" yaml:"-"`
+	Providers          map[string]ProviderConf `yaml:"providers"`
+	Clients            []ClientConf            `yaml:"clients"`
+}
+
+// passwordResetURL is the page the password reset links point at, the reset
+// token is added to its query.
+func passwordResetURL(cfg Conf) string {
+	if cfg.PasswordResetURL != "" {
+		return cfg.PasswordResetURL
+	}
+	return cfg.BaseURL + "/password/reset"
+}
+
+// magicLinkURL is the page the sign in links point at, the link token is
+// added to its query.
+func magicLinkURL(cfg Conf) string {
+	if cfg.MagicLinkURL != "" {
+		return cfg.MagicLinkURL
+	}
+	return cfg.BaseURL + "/magic"
+}
+
+// mfaURL is the page a client sign in is redirected to when the user has to
+// enter the second factor, the challenge is added to its query.
+func mfaURL(cfg Conf) string {
+	if cfg.MFAURL != "" {
+		return cfg.MFAURL
+	}
+	return cfg.BaseURL + "/mfa"
+}
+
+// newCipher returns the cipher of the base64 encoded 32 bytes key given by
+// the variable or nil if the key is empty.
+func newCipher(name, value string) (auth.Cipher, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not base64: %w", name, err)
+	}
+
+	if len(key) != 32 {
+		return nil, fmt.Errorf("%s has to be 32 bytes long", name)
+	}
+
+	return auth.NewCipher(key)
+}
+
+// mfaCipher returns the cipher of the TOTP secrets or nil if two factor
+// authentication is not configured.
+func mfaCipher(cfg Conf) (auth.Cipher, error) {
+	return newCipher("GUARD_MFA_KEY", cfg.MFAKey)
+}
+
+// providerTokenCipher returns the cipher of the provider tokens or nil if the
+// tokens are not stored.
+func providerTokenCipher(cfg Conf) (auth.Cipher, error) {
+	return newCipher("GUARD_PROVIDER_TOKEN_KEY", cfg.ProviderTokenKey)
+}
+
+// relyingParty returns the WebAuthn relying party, the id and the origin
+// default to the host and the origin of the base URL.
+func relyingParty(cfg Conf) (webauthn.RelyingParty, error) {
+	base, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GUARD_BASE_URL: %w", err)
+	}
+
+	id := cfg.WebAuthnRPID
+	if id == "" {
+		id = base.Hostname()
+	}
+
+	origins := cfg.WebAuthnOrigins
+	if len(origins) == 0 {
+		origins = []string{base.Scheme + "://" + base.Host}
+	}
+
+	return webauthn.New(id, cfg.WebAuthnRPName, origins, cfg.WebAuthnTTL), nil
+}
+
+// linkPolicy returns the policy of linking the provider identities to the
+// existing users by email.
+func linkPolicy(cfg Conf) (string, error) {
+	if !auth.ValidLinkPolicy(cfg.IdentityLinkPolicy) {
+		return "", fmt.Errorf("unsupported GUARD_IDENTITY_LINK_POLICY: %v", cfg.IdentityLinkPolicy)
+	}
+	return cfg.IdentityLinkPolicy, nil
+}
+
+// profileUpdaters returns the names of the profile updaters, none disables
+// storing the profiles. The webhook updater requires the URLs and the secret.
+func profileUpdaters(cfg Conf) ([]string, error) {
+	names := []string{}
+
+	for _, name := range cfg.ProfileUpdaters {
+		name = strings.TrimSpace(name)
+		if name == "none" {
+			continue
+		}
+
+		if _, ok := updaters[name]; !ok {
+			return nil, fmt.Errorf("unsupported profile updater: %v", name)
+		}
+
+		if name == "webhook" {
+			if len(cfg.WebhookURLs) == 0 {
+				return nil, fmt.Errorf("GUARD_WEBHOOK_URLS is required by the webhook updater")
+			}
+			if cfg.WebhookSecret == "" {
+				return nil, fmt.Errorf("GUARD_WEBHOOK_SECRET is required by the webhook updater")
+			}
+		}
+
+		names = append(names, name)
+	}
+
+	return names, nil
 }
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/caarlos0/env"
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+// ProviderConf is an OAuth provider of the config file. The providers other
+// than the wellknown ones are OIDC providers and require the discovery URL.
+type ProviderConf struct {
+	ClientID     string `yaml:"client_id"`
+	ClientSecret string `yaml:"client_secret"`
+	DiscoveryURL string `yaml:"discovery_url"`
+}
+
+// ClientConf is a client of the config file, it is saved to the clients
+// table on start and on reload.
+type ClientConf struct {
+	ID         string        `yaml:"id"`
+	SecretHash string        `yaml:"secret_hash"`
+	Redirects  []string      `yaml:"redirects"`
+	Providers  []string      `yaml:"providers"`
+	Scopes     []string      `yaml:"scopes"`
+	AccessTTL  time.Duration `yaml:"access_ttl"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl"`
+}
+
+// loadConf reads the configuration from the environment and the config file
+// given by the path or by GUARD_CONFIG. The file overrides the defaults and
+// the variables set override the file.
+func loadConf(path string) (Conf, error) {
+	cfg := Conf{}
+	if err := env.Parse(&cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse env: %w", err)
+	}
+
+	if path == "" {
+		path = cfg.ConfigFile
+	}
+	if path == "" {
+		return cfg, nil
+	}
+
+	/* #nosec G304 */
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	file := cfg
+
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	if err := dec.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return cfg, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+
+	overrideFromEnv(&file, cfg)
+	file.ConfigFile = path
+
+	return file, nil
+}
+
+// overrideFromEnv copies the settings of the variables set from the env
+// configuration.
+func overrideFromEnv(cfg *Conf, envCfg Conf) {
+	dst := reflect.ValueOf(cfg).Elem()
+	src := reflect.ValueOf(envCfg)
+
+	for i := 0; i < dst.NumField(); i++ {
+		name := dst.Type().Field(i).Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+}
+
+// confErrors are the problems of the configuration found by validation.
+type confErrors []string
+
+func (e confErrors) Error() string {
+	return "invalid configuration:\n\t" + strings.Join(e, "\n\t")
+}
+
+// validateConf checks the whole configuration and reports all the problems
+// found at once.
+func validateConf(cfg Conf) error {
+	errs := confErrors{}
+
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Sprintf(format, args...))
+		}
+	}
+
+	_, ok := dialects[cfg.DBDriver]
+	check(ok, "GUARD_DBDRIVER %q is not supported, use one of postgres, mysql, sqlite or memory", cfg.DBDriver)
+	check(cfg.DSN != "" || cfg.DBDriver == memoryDriver, "GUARD_DSN is required by the %v driver", cfg.DBDriver)
+
+	switch cfg.SessionDriver {
+	case "", "sql", "redis":
+	default:
+		check(false, "GUARD_SESSION_DRIVER %q is not supported, use sql or redis", cfg.SessionDriver)
+	}
+
+	check(cfg.Port > 0 && cfg.Port < 65536, "GUARD_PORT %d is not a valid TCP port", cfg.Port)
+
+	_, err := zerolog.ParseLevel(cfg.LogLevel)
+	check(err == nil, "GUARD_LOG_LEVEL %q is not a log level", cfg.LogLevel)
+
+	base, err := url.Parse(cfg.BaseURL)
+	check(err == nil && base.IsAbs(), "GUARD_BASE_URL %q is not an absolute URL", cfg.BaseURL)
+
+	ttls := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"GUARD_ACCESS_TTL", cfg.AccessTTL},
+		{"GUARD_REFRESH_TTL", cfg.RefreshTTL},
+		{"GUARD_AUTH_CODE_TTL", cfg.AuthCodeTTL},
+		{"GUARD_CODE_TTL", cfg.CodeTTL},
+		{"GUARD_PASSWORD_RESET_TTL", cfg.PasswordResetTTL},
+		{"GUARD_MAGIC_LINK_TTL", cfg.MagicLinkTTL},
+		{"GUARD_MFA_TTL", cfg.MFATTL},
+		{"GUARD_WEBAUTHN_TTL", cfg.WebAuthnTTL},
+	}
+	for _, ttl := range ttls {
+		check(ttl.value > 0, "%s has to be positive, got %v", ttl.name, ttl.value)
+	}
+
+	check(cfg.GCInterval >= 0, "GUARD_GC_INTERVAL can not be negative")
+	check(cfg.GCBatchSize > 0, "GUARD_GC_BATCH_SIZE has to be positive, got %d", cfg.GCBatchSize)
+	check(cfg.WebhookBatchSize > 0, "GUARD_WEBHOOK_BATCH_SIZE has to be positive, got %d", cfg.WebhookBatchSize)
+	check(cfg.WebhookAttempts > 0, "GUARD_WEBHOOK_ATTEMPTS has to be positive, got %d", cfg.WebhookAttempts)
+
+	for name, p := range cfg.Providers {
+		check(p.ClientID != "", "provider %s: client_id is required", name)
+		check(p.ClientSecret != "", "provider %s: client_secret is required", name)
+		check(wellKnown(name) || p.DiscoveryURL != "", "provider %s: discovery_url is required by OIDC providers", name)
+	}
+
+	ids := map[string]bool{}
+	for i, c := range cfg.Clients {
+		check(c.ID != "", "client %d: id is required", i)
+		check(!ids[c.ID], "client %s: duplicate id", c.ID)
+		ids[c.ID] = true
+
+		check(len(c.Redirects) > 0 || c.SecretHash != "", "client %s: redirects are required by public clients", c.ID)
+		for _, redirect := range c.Redirects {
+			u, err := url.Parse(redirect)
+			check(err == nil && u.IsAbs(), "client %s: redirect %q is not an absolute URL", c.ID, redirect)
+		}
+
+		check(c.AccessTTL >= 0 && c.RefreshTTL >= 0, "client %s: TTLs can not be negative", c.ID)
+	}
+
+	if _, err := factoryConfig(cfg); err != nil {
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// restartSettings are applied on start only, a reload keeps them.
+var restartSettings = []string{
+	"Port",
+	"DBDriver",
+	"DSN",
+	"DBMaxIddleConn",
+	"DBMaxOpenConn",
+	"DBConnMaxLifetime",
+	"DBConnMaxIddleTime",
+	"SessionDriver",
+	"RedisURL",
+	"GCInterval",
+	"WebhookInterval",
+	"Debug",
+}
+
+// restartRequired returns the variables of the settings changed which are
+// not applied by a reload.
+func restartRequired(prev, next Conf) []string {
+	changed := []string{}
+
+	pv := reflect.ValueOf(prev)
+	nv := reflect.ValueOf(next)
+
+	for _, name := range restartSettings {
+		if !reflect.DeepEqual(pv.FieldByName(name).Interface(), nv.FieldByName(name).Interface()) {
+			field, _ := pv.Type().FieldByName(name)
+			changed = append(changed, field.Tag.Get("env"))
+		}
+	}
+
+	return changed
+}
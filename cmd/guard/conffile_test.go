@@ -0,0 +1,153 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testConfFile = `
+port: 9000
+log_level: debug
+dbdriver: memory
+secret_key: "123.456"
+access_ttl: 10m
+refresh_ttl: 48h
+providers:
+  google:
+    client_id: google-id
+    client_secret: google-secret
+  corp:
+    client_id: corp-id
+    client_secret: corp-secret
+    discovery_url: http://corp.org/discovery
+clients:
+  - id: spa
+    redirects:
+      - http://app.local/callback
+    providers: [google]
+    scopes: [users:read]
+    access_ttl: 5m
+`
+
+func writeConf(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "guard.yaml")
+	require.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	return path
+}
+
+func setenv(t *testing.T, name, value string) {
+	require.NoError(t, os.Setenv(name, value))
+	t.Cleanup(func() { os.Unsetenv(name) })
+}
+
+func TestLoadConf(t *testing.T) {
+	t.Run("File", func(t *testing.T) {
+		cfg, err := loadConf(writeConf(t, testConfFile))
+		require.NoError(t, err)
+
+		require.Equal(t, 9000, cfg.Port)
+		require.Equal(t, "debug", cfg.LogLevel)
+		require.Equal(t, memoryDriver, cfg.DBDriver)
+		require.Equal(t, 10*time.Minute, cfg.AccessTTL)
+		require.Equal(t, 48*time.Hour, cfg.RefreshTTL)
+		require.Equal(t, 60*time.Second, cfg.AuthCodeTTL, "default kept")
+
+		require.Equal(t, map[string]ProviderConf{
+			"google": {ClientID: "google-id", ClientSecret: "google-secret"},
+			"corp":   {ClientID: "corp-id", ClientSecret: "corp-secret", DiscoveryURL: "http://corp.org/discovery"},
+		}, cfg.Providers)
+
+		require.Equal(t, []ClientConf{{
+			ID:        "spa",
+			Redirects: []string{"http://app.local/callback"},
+			Providers: []string{"google"},
+			Scopes:    []string{"users:read"},
+			AccessTTL: 5 * time.Minute,
+		}}, cfg.Clients)
+
+		require.NoError(t, validateConf(cfg))
+	})
+
+	t.Run("EnvOverrides", func(t *testing.T) {
+		path := writeConf(t, testConfFile)
+		setenv(t, "GUARD_CONFIG", path)
+		setenv(t, "GUARD_PORT", "9100")
+		setenv(t, "GUARD_ACCESS_TTL", "1m")
+
+		cfg, err := loadConf("")
+		require.NoError(t, err)
+		require.Equal(t, path, cfg.ConfigFile)
+		require.Equal(t, 9100, cfg.Port)
+		require.Equal(t, time.Minute, cfg.AccessTTL)
+		require.Equal(t, "debug", cfg.LogLevel)
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		cfg, err := loadConf(writeConf(t, ""))
+		require.NoError(t, err)
+		require.Equal(t, 8000, cfg.Port)
+	})
+
+	t.Run("UnknownField", func(t *testing.T) {
+		_, err := loadConf(writeConf(t, "prot: 9000\n"))
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "prot")
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := loadConf(filepath.Join(t.TempDir(), "guard.yaml"))
+		require.Error(t, err)
+	})
+}
+
+func TestValidateConf(t *testing.T) {
+	cfg, err := loadConf(writeConf(t, testConfFile))
+	require.NoError(t, err)
+
+	cfg.DBDriver = "xxx"
+	cfg.Port = 0
+	cfg.AccessTTL = 0
+	cfg.BaseURL = "localhost"
+	cfg.Providers["okta"] = ProviderConf{ClientID: "okta-id"}
+	cfg.Clients = append(cfg.Clients,
+		ClientConf{ID: "spa", Redirects: []string{"/callback"}},
+		ClientConf{ID: "cli"},
+	)
+
+	err = validateConf(cfg)
+	require.Error(t, err)
+
+	errs, ok := err.(confErrors)
+	require.True(t, ok)
+	require.ElementsMatch(t, confErrors{
+		`GUARD_DBDRIVER "xxx" is not supported, use one of postgres, mysql, sqlite or memory`,
+		`GUARD_DSN is required by the xxx driver`,
+		`GUARD_PORT 0 is not a valid TCP port`,
+		`GUARD_BASE_URL "localhost" is not an absolute URL`,
+		`GUARD_ACCESS_TTL has to be positive, got 0s`,
+		`provider okta: client_secret is required`,
+		`provider okta: discovery_url is required by OIDC providers`,
+		`client spa: duplicate id`,
+		`client spa: redirect "/callback" is not an absolute URL`,
+		`client cli: redirects are required by public clients`,
+	}, errs)
+	require.Contains(t, err.Error(), "invalid configuration:\n\t")
+}
+
+func TestRestartRequired(t *testing.T) {
+	prev := Conf{Port: 8000, DSN: "a.db", AccessTTL: time.Minute, LogLevel: "info"}
+
+	next := prev
+	next.AccessTTL = time.Hour
+	next.LogLevel = "debug"
+	require.Empty(t, restartRequired(prev, next))
+
+	next.Port = 9000
+	next.DSN = "b.db"
+	require.Equal(t, []string{"GUARD_PORT", "GUARD_DSN"}, restartRequired(prev, next))
+}
@@ -8,12 +8,46 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
 )
 
+const memoryDriver = "memory"
+
 var dialects = map[string]func(string) gorm.Dialector{
-	"postgres": postgres.Open,
-	"mysql":    mysql.Open,
-	"sqlite":   sqlite.Open,
+	"postgres":   postgres.Open,
+	"mysql":      mysql.Open,
+	"sqlite":     sqlite.Open,
+	memoryDriver: memoryDialector,
+}
+
+// memoryDialector opens the in-memory SQLite database keeping the records
+// which are not kept in the memory store, the DSN is ignored.
+func memoryDialector(string) gorm.Dialector {
+	return sqlite.Open(":memory:")
+}
+
+// memoryModels are the tables of the in-memory database, the users, the
+// refresh tokens and the sessions are kept in the memory store.
+var memoryModels = []interface{}{
+	&model.Client{},
+	&model.Credential{},
+	&model.TOTP{},
+	&model.Passkey{},
+	&model.UserIdentity{},
+	&model.Profile{},
+	&model.WebhookEvent{},
+	&model.ProviderToken{},
+}
+
+// memoryStore returns the store of the users, the refresh tokens and the
+// sessions or nil if they are kept in the database.
+func memoryStore(cfg Conf) *repo.Memory {
+	if cfg.DBDriver != memoryDriver {
+		return nil
+	}
+	return repo.NewMemory()
 }
 
 func dbconnect(cfg Conf) (*gorm.DB, error) {
@@ -22,6 +56,10 @@ func dbconnect(cfg Conf) (*gorm.DB, error) {
 		return nil, fmt.Errorf("unsupported database driver: %v", cfg.DBDriver)
 	}
 
+	if cfg.DSN == "" && cfg.DBDriver != memoryDriver {
+		return nil, fmt.Errorf("GUARD_DSN is required by the %v driver", cfg.DBDriver)
+	}
+
 	db, err := gorm.Open(dialect(cfg.DSN), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
 	})
@@ -35,6 +73,17 @@ func dbconnect(cfg Conf) (*gorm.DB, error) {
 		return nil, err
 	}
 
+	if cfg.DBDriver == memoryDriver {
+		// Every connection to :memory: opens a new database, so the single
+		// connection has to live as long as the process.
+		sqldb.SetMaxOpenConns(1)
+		sqldb.SetMaxIdleConns(1)
+		sqldb.SetConnMaxLifetime(0)
+		sqldb.SetConnMaxIdleTime(0)
+
+		return db, db.AutoMigrate(memoryModels...)
+	}
+
 	sqldb.SetMaxOpenConns(cfg.DBMaxOpenConn)
 	sqldb.SetMaxIdleConns(cfg.DBMaxIddleConn)
 	sqldb.SetConnMaxLifetime(cfg.DBConnMaxLifetime)
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/model"
 )
 
 func TestDbConn(t *testing.T) {
@@ -21,6 +23,18 @@ func TestDbConn(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, db)
 	})
+	t.Run("Memory", func(t *testing.T) {
+		db, err := dbconnect(Conf{DBDriver: "memory"})
+		require.NoError(t, err)
+		require.True(t, db.Migrator().HasTable(&model.Client{}))
+		require.False(t, db.Migrator().HasTable(&model.User{}))
+		require.NotNil(t, memoryStore(Conf{DBDriver: "memory"}))
+		require.Nil(t, memoryStore(Conf{DBDriver: "sqlite"}))
+	})
+	t.Run("MissingDSN", func(t *testing.T) {
+		_, err := dbconnect(Conf{DBDriver: "sqlite"})
+		require.Error(t, err)
+	})
 	t.Run("UnsupportedProvider", func(t *testing.T) {
 		_, err := dbconnect(Conf{
 			DBDriver: "xxx",
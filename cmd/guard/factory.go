@@ -1,56 +1,168 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
 	"time"
 
-	"github.com/golang-jwt/jwt"
+	"github.com/go-redis/redis/v8"
 	"github.com/markbates/goth"
 	"gorm.io/gorm"
 
 	"github.com/vbogretsov/guard/api"
 	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/mail"
 	"github.com/vbogretsov/guard/profile"
 	"github.com/vbogretsov/guard/repo"
+	"github.com/vbogretsov/guard/webauthn"
 )
 
 type FactoryConfig struct {
-	SecretKey  string
-	AccessTTL  time.Duration
-	RefreshTTL time.Duration
-	CodeTTL    time.Duration
+	Keys        []auth.Key
+	KeyGrace    time.Duration
+	Claims      auth.Claims
+	AccessTTL   time.Duration
+	RefreshTTL  time.Duration
+	CodeTTL     time.Duration
+	AuthCodeTTL time.Duration
+	ResetTTL    time.Duration
+	ResetURL    string
+	LinkTTL     time.Duration
+	LinkURL     string
+	Mailer      mail.Mailer
+	MFACipher   auth.Cipher
+	MFATTL      time.Duration
+	MFAURL      string
+	MFAIssuer   string
+	TokenCipher auth.Cipher
+	WebAuthn    webauthn.RelyingParty
+	PasskeyTTL  time.Duration
+	LinkPolicy  string
+	Updaters    []string
+	Webhook     WebhookConfig
+	Redis       redis.UniversalClient
+	Memory      *repo.Memory
+	Providers   []goth.Provider
+	GCBatch     int
+	BaseURL     string
+}
+
+type WebhookConfig struct {
+	URLs     []string
+	Secret   []byte
+	Timeout  time.Duration
+	Attempts int
+	Backoff  time.Duration
+	Batch    int
 }
 
 type factory struct {
 	db  *gorm.DB
+	mu  sync.RWMutex
 	cfg FactoryConfig
 }
 
 type scope struct {
 	db       *gorm.DB
 	cfg      FactoryConfig
+	conn     *repo.Conn
+	mconn    *repo.MemoryConn
 	timer    auth.Timer
 	users    repo.Users
 	tokens   repo.RefreshTokens
 	sessions repo.Sessions
+	clients  repo.Clients
+	creds    repo.Credentials
+	totps    repo.TOTPs
+	passkeys repo.Passkeys
+	idents   repo.Identities
+	profiles repo.Profiles
+	webhooks repo.WebhookEvents
+	ptokens  repo.ProviderTokens
+}
+
+// updaters are the profile updaters selectable by the configuration.
+var updaters = map[string]func(*scope) profile.Updater{
+	"database": func(s *scope) profile.Updater {
+		return profile.New(s.newTimer(), s.newProfilesRepo())
+	},
+	"webhook": func(s *scope) profile.Updater {
+		return profile.Webhook(s.newWebhookEventsRepo(), s.cfg.Webhook.URLs)
+	},
 }
 
 func NewFactory(db *gorm.DB, cfg FactoryConfig) api.Factory {
+	return newFactory(db, cfg)
+}
+
+func newFactory(db *gorm.DB, cfg FactoryConfig) *factory {
 	return &factory{
 		db:  db,
 		cfg: cfg,
 	}
 }
 
+func (f *factory) config() FactoryConfig {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.cfg
+}
+
+// Reload replaces the configuration used by the requests started afterwards.
+// The storages are kept.
+func (f *factory) Reload(cfg FactoryConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	cfg.Redis = f.cfg.Redis
+	cfg.Memory = f.cfg.Memory
+	f.cfg = cfg
+}
+
+func (f *factory) GetProvider(name string) (goth.Provider, error) {
+	for _, p := range f.config().Providers {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no provider for %s exists", name)
+}
+
 func (f *factory) NewHealthCheck() api.HealthCheck {
 	return func() error {
 		db, err := f.db.DB()
 		if err != nil {
 			return err
 		}
-		return db.Ping()
+		if err := db.Ping(); err != nil {
+			return err
+		}
+		if rdb := f.config().Redis; rdb != nil {
+			return rdb.Ping(context.Background()).Err()
+		}
+		return nil
 	}
 }
 
+func (f *factory) NewJWKS() auth.JWKS {
+	return auth.NewJWKS(f.scope().newKeySet().Published()...)
+}
+
+func (f *factory) NewDiscovery() auth.Discovery {
+	s := f.scope()
+	return auth.NewDiscovery(s.cfg.BaseURL, s.cfg.Claims.Issuer, s.newKeySet().Published())
+}
+
+func (f *factory) NewUserInfoer() auth.UserInfoer {
+	return f.scope().newUserInfoer()
+}
+
+func (f *factory) NewAuthorizer() auth.Authorizer {
+	return f.scope().newAuthorizer()
+}
+
 func (f *factory) NewOAuthStarter(provider goth.Provider) auth.OAuthStarter {
 	return f.scope().newOAuthStarter(provider)
 }
@@ -63,8 +175,88 @@ func (f *factory) NewRefresher() auth.Refresher {
 	return f.scope().newRefresher()
 }
 
+func (f *factory) NewCodeExchanger() auth.CodeExchanger {
+	return f.scope().newCodeExchanger()
+}
+
+func (f *factory) NewCredentialsExchanger() auth.CredentialsExchanger {
+	return f.scope().newCredentialsExchanger()
+}
+
+func (f *factory) NewRevoker() auth.Revoker {
+	return f.scope().newRevoker()
+}
+
+func (f *factory) NewSignUper() auth.SignUper {
+	return f.scope().newSignUper()
+}
+
+func (f *factory) NewPasswordSignIner() auth.PasswordSignIner {
+	return f.scope().newPasswordSignIner()
+}
+
+func (f *factory) NewPasswordChanger() auth.PasswordChanger {
+	return f.scope().newPasswordChanger()
+}
+
+func (f *factory) NewPasswordResetter() auth.PasswordResetter {
+	return f.scope().newPasswordResetter()
+}
+
+func (f *factory) NewMagicLinker() auth.MagicLinker {
+	return f.scope().newMagicLinker()
+}
+
+func (f *factory) NewMFAEnroller() auth.MFAEnroller {
+	return f.scope().newMFAEnroller()
+}
+
+func (f *factory) NewMFACompleter() auth.MFACompleter {
+	return f.scope().newMFACompleter()
+}
+
+func (f *factory) NewPasskeyRegistrar() auth.PasskeyRegistrar {
+	return f.scope().newPasskeyRegistrar()
+}
+
+func (f *factory) NewPasskeySignIner() auth.PasskeySignIner {
+	return f.scope().newPasskeySignIner()
+}
+
+func (f *factory) NewPasskeyCompleter() auth.PasskeyCompleter {
+	return f.scope().newPasskeyCompleter()
+}
+
+func (f *factory) NewProviderTokener(provider goth.Provider) auth.ProviderTokener {
+	return f.scope().newProviderTokener(provider)
+}
+
+func (f *factory) NewProfileReader() auth.ProfileReader {
+	return f.scope().newProfileReader()
+}
+
+func (f *factory) NewIdentityManager() auth.IdentityManager {
+	return f.scope().newIdentityManager()
+}
+
+func (f *factory) NewSweeper() auth.Sweeper {
+	return f.scope().newSweeper()
+}
+
+func (f *factory) NewDeliverer() profile.Deliverer {
+	return f.scope().newDeliverer()
+}
+
+func (f *factory) NewIntrospector() auth.Introspector {
+	return f.scope().newIntrospector()
+}
+
+func (f *factory) NewClientAuthenticator() auth.ClientAuthenticator {
+	return f.scope().newClientAuthenticator()
+}
+
 func (f *factory) scope() *scope {
-	return &scope{db: f.db, cfg: f.cfg}
+	return &scope{db: f.db, cfg: f.config()}
 }
 
 func (s *scope) newTimer() auth.Timer {
@@ -74,27 +266,133 @@ func (s *scope) newTimer() auth.Timer {
 	return s.timer
 }
 
+func (s *scope) newConn() *repo.Conn {
+	if s.conn == nil {
+		s.conn = repo.NewConn(s.db)
+	}
+	return s.conn
+}
+
+func (s *scope) newMemoryConn() *repo.MemoryConn {
+	if s.mconn == nil {
+		s.mconn = repo.NewMemoryConn(s.cfg.Memory)
+	}
+	return s.mconn
+}
+
+// newTx returns the transaction of the scope, it spans the memory store if
+// the users, the refresh tokens and the sessions are kept there.
+func (s *scope) newTx() repo.Transaction {
+	if s.cfg.Memory != nil {
+		return repo.Transactions(s.newConn(), s.newMemoryConn())
+	}
+	return s.newConn()
+}
+
 func (s *scope) newUsersRepo() repo.Users {
 	if s.users == nil {
-		s.users = repo.NewUsers(s.db)
+		if s.cfg.Memory != nil {
+			s.users = repo.NewMemoryUsers(s.newMemoryConn())
+		} else {
+			s.users = repo.NewUsers(s.newConn())
+		}
 	}
 	return s.users
 }
 
 func (s *scope) newRefreshTokensRepo() repo.RefreshTokens {
 	if s.tokens == nil {
-		s.tokens = repo.NewRefreshTokens(s.db)
+		if s.cfg.Redis != nil {
+			s.tokens = repo.NewRedisRefreshTokens(s.cfg.Redis, s.newUsersRepo())
+		} else if s.cfg.Memory != nil {
+			s.tokens = repo.NewMemoryRefreshTokens(s.newMemoryConn())
+		} else {
+			s.tokens = repo.NewRefreshTokens(s.newConn())
+		}
 	}
 	return s.tokens
 }
 
 func (s *scope) newSessionsRepo() repo.Sessions {
 	if s.sessions == nil {
-		s.sessions = repo.NewSessions(s.db)
+		if s.cfg.Redis != nil {
+			s.sessions = repo.NewRedisSessions(s.cfg.Redis)
+		} else if s.cfg.Memory != nil {
+			s.sessions = repo.NewMemorySessions(s.newMemoryConn())
+		} else {
+			s.sessions = repo.NewSessions(s.newConn())
+		}
 	}
 	return s.sessions
 }
 
+func (s *scope) newClientsRepo() repo.Clients {
+	if s.clients == nil {
+		s.clients = repo.NewClients(s.newConn())
+	}
+	return s.clients
+}
+
+func (s *scope) newCredentialsRepo() repo.Credentials {
+	if s.creds == nil {
+		s.creds = repo.NewCredentials(s.newConn())
+	}
+	return s.creds
+}
+
+func (s *scope) newTOTPsRepo() repo.TOTPs {
+	if s.totps == nil {
+		s.totps = repo.NewTOTPs(s.newConn())
+	}
+	return s.totps
+}
+
+func (s *scope) newPasskeysRepo() repo.Passkeys {
+	if s.passkeys == nil {
+		s.passkeys = repo.NewPasskeys(s.newConn())
+	}
+	return s.passkeys
+}
+
+func (s *scope) newIdentitiesRepo() repo.Identities {
+	if s.idents == nil {
+		s.idents = repo.NewIdentities(s.newConn())
+	}
+	return s.idents
+}
+
+func (s *scope) newProfilesRepo() repo.Profiles {
+	if s.profiles == nil {
+		s.profiles = repo.NewProfiles(s.newConn())
+	}
+	return s.profiles
+}
+
+func (s *scope) newProviderTokensRepo() repo.ProviderTokens {
+	if s.ptokens == nil {
+		s.ptokens = repo.NewProviderTokens(s.newConn())
+	}
+	return s.ptokens
+}
+
+func (s *scope) newWebhookEventsRepo() repo.WebhookEvents {
+	if s.webhooks == nil {
+		s.webhooks = repo.NewWebhookEvents(s.newConn())
+	}
+	return s.webhooks
+}
+
+func (s *scope) newMailer() mail.Mailer {
+	if s.cfg.Mailer == nil {
+		return mail.Log()
+	}
+	return s.cfg.Mailer
+}
+
+func (s *scope) newClientAuthenticator() auth.ClientAuthenticator {
+	return auth.NewClientAuthenticator(s.newClientsRepo())
+}
+
 func (s *scope) newUserFindOrCreator() auth.UserFindOrCreator {
 	return auth.NewUserFindOrCreator(
 		s.newUsersRepo(),
@@ -110,45 +408,327 @@ func (s *scope) newrefreshGenerator() auth.RefreshGenerator {
 	)
 }
 
+func (s *scope) newKeySet() auth.KeySet {
+	return auth.NewKeySet(
+		s.newTimer(),
+		s.cfg.KeyGrace,
+		s.cfg.Keys...,
+	)
+}
+
 func (s *scope) newIssuer() auth.Issuer {
 	return auth.NewIssuer(
-		s.cfg.SecretKey,
+		s.newKeySet(),
 		s.newTimer(),
 		s.cfg.AccessTTL,
-		jwt.SigningMethodHS256,
+		s.cfg.Claims,
 		s.newrefreshGenerator(),
 	)
 }
 
+func (s *scope) newVerifier() auth.Verifier {
+	return auth.NewVerifier(
+		s.newKeySet(),
+		s.newTimer(),
+	)
+}
+
+func (s *scope) newIntrospector() auth.Introspector {
+	return auth.NewIntrospector(
+		s.newTimer(),
+		s.newVerifier(),
+		s.newRefreshTokensRepo(),
+	)
+}
+
+func (s *scope) newUserInfoer() auth.UserInfoer {
+	return auth.NewUserInfoer(
+		s.newVerifier(),
+		s.newUsersRepo(),
+	)
+}
+
+func (s *scope) newProfileReader() auth.ProfileReader {
+	return auth.NewProfileReader(
+		s.newVerifier(),
+		s.newUsersRepo(),
+		s.newProfilesRepo(),
+	)
+}
+
 func (s *scope) newRefresher() auth.Refresher {
 	return auth.NewRefresher(
+		s.newTx(),
 		s.newTimer(),
 		s.newRefreshTokensRepo(),
+		s.newClientsRepo(),
+		s.newIssuer(),
+	)
+}
+
+func (s *scope) newCodeExchanger() auth.CodeExchanger {
+	return auth.NewCodeExchanger(
+		s.newTimer(),
+		s.newSessionsRepo(),
+		s.newUsersRepo(),
+		s.newClientsRepo(),
+		s.newIssuer(),
+	)
+}
+
+func (s *scope) newCredentialsExchanger() auth.CredentialsExchanger {
+	return auth.NewCredentialsExchanger(
+		s.newClientAuthenticator(),
+		s.newIssuer(),
+	)
+}
+
+func (s *scope) newRevoker() auth.Revoker {
+	return auth.NewRevoker(s.newRefreshTokensRepo(), s.newClientsRepo())
+}
+
+func (s *scope) newSignUper() auth.SignUper {
+	return auth.NewSignUper(
+		s.newTx(),
+		s.newTimer(),
+		s.newUsersRepo(),
+		s.newCredentialsRepo(),
+		s.newIssuer(),
+	)
+}
+
+func (s *scope) newSignInFinisher() auth.SignInFinisher {
+	return auth.NewSignInFinisher(
+		s.newTimer(),
+		s.newSessionsRepo(),
+		s.newTOTPsRepo(),
+		s.newPasskeysRepo(),
+		s.newIssuer(),
+		s.cfg.AuthCodeTTL,
+		s.cfg.MFATTL,
+		s.cfg.MFAURL,
+	)
+}
+
+func (s *scope) newMFAEnroller() auth.MFAEnroller {
+	return auth.NewMFAEnroller(
+		s.newTimer(),
+		s.newVerifier(),
+		s.newUsersRepo(),
+		s.newTOTPsRepo(),
+		s.cfg.MFACipher,
+		s.cfg.MFAIssuer,
+	)
+}
+
+func (s *scope) newMFACompleter() auth.MFACompleter {
+	return auth.NewMFACompleter(
+		s.newTimer(),
+		s.newSessionsRepo(),
+		s.newUsersRepo(),
+		s.newTOTPsRepo(),
+		s.cfg.MFACipher,
 		s.newIssuer(),
+		s.cfg.AuthCodeTTL,
+	)
+}
+
+func (s *scope) newPasskeyRegistrar() auth.PasskeyRegistrar {
+	return auth.NewPasskeyRegistrar(
+		s.newTimer(),
+		s.cfg.PasskeyTTL,
+		s.newVerifier(),
+		s.newUsersRepo(),
+		s.newSessionsRepo(),
+		s.newPasskeysRepo(),
+		s.cfg.WebAuthn,
+	)
+}
+
+func (s *scope) newPasskeySignIner() auth.PasskeySignIner {
+	return auth.NewPasskeySignIner(
+		s.newTimer(),
+		s.cfg.PasskeyTTL,
+		s.newUsersRepo(),
+		s.newSessionsRepo(),
+		s.newPasskeysRepo(),
+		s.cfg.WebAuthn,
+		s.newIssuer(),
+	)
+}
+
+func (s *scope) newPasskeyCompleter() auth.PasskeyCompleter {
+	return auth.NewPasskeyCompleter(
+		s.newTimer(),
+		s.cfg.PasskeyTTL,
+		s.cfg.AuthCodeTTL,
+		s.newUsersRepo(),
+		s.newSessionsRepo(),
+		s.newPasskeysRepo(),
+		s.cfg.WebAuthn,
+		s.newIssuer(),
+	)
+}
+
+func (s *scope) newPasswordSignIner() auth.PasswordSignIner {
+	return auth.NewPasswordSignIner(
+		s.newUsersRepo(),
+		s.newCredentialsRepo(),
+		s.newSignInFinisher(),
+	)
+}
+
+func (s *scope) newPasswordChanger() auth.PasswordChanger {
+	return auth.NewPasswordChanger(
+		s.newTx(),
+		s.newTimer(),
+		s.newVerifier(),
+		s.newCredentialsRepo(),
+		s.newRefreshTokensRepo(),
+	)
+}
+
+func (s *scope) newPasswordResetter() auth.PasswordResetter {
+	return auth.NewPasswordResetter(
+		s.newTx(),
+		s.newTimer(),
+		s.cfg.ResetTTL,
+		s.cfg.ResetURL,
+		s.newUsersRepo(),
+		s.newCredentialsRepo(),
+		s.newSessionsRepo(),
+		s.newRefreshTokensRepo(),
+		s.newMailer(),
+	)
+}
+
+func (s *scope) newMagicLinker() auth.MagicLinker {
+	return auth.NewMagicLinker(
+		s.newTimer(),
+		s.cfg.LinkTTL,
+		s.cfg.LinkURL,
+		s.newSessionsRepo(),
+		s.newUserFindOrCreator(),
+		s.newSignInFinisher(),
+		s.newMailer(),
+	)
+}
+
+func (s *scope) newSweeper() auth.Sweeper {
+	return auth.NewSweeper(
+		s.newTimer(),
+		s.newSessionsRepo(),
+		s.newRefreshTokensRepo(),
+		s.cfg.GCBatch,
+	)
+}
+
+func (s *scope) newIdentityResolver() auth.IdentityResolver {
+	return auth.NewIdentityResolver(
+		s.newTx(),
+		s.newTimer(),
+		s.newUsersRepo(),
+		s.newIdentitiesRepo(),
+		s.cfg.LinkPolicy,
+	)
+}
+
+func (s *scope) newIdentityManager() auth.IdentityManager {
+	return auth.NewIdentityManager(
+		s.newTx(),
+		s.newVerifier(),
+		s.newIdentitiesRepo(),
+		s.newProfilesRepo(),
+		s.newProviderTokensRepo(),
+		s.newCredentialsRepo(),
+		s.newPasskeysRepo(),
+	)
+}
+
+func (s *scope) newUserAdmin() auth.UserAdmin {
+	return auth.NewUserAdmin(
+		s.newTx(),
+		s.newUsersRepo(),
+		s.newRefreshTokensRepo(),
+		s.newIdentitiesRepo(),
 	)
 }
 
 func (s *scope) newUserFetcher(provider goth.Provider) auth.UserFetcher {
 	return auth.NewUserFetcher(
 		provider,
-		s.newUserFindOrCreator(),
-		profile.Empty(),
+		s.newIdentityResolver(),
+		s.newProfileUpdater(),
 	)
 }
 
 func (s *scope) newSignIner(provider goth.Provider) auth.SignIner {
 	return auth.NewSignIner(
+		s.newTimer(),
 		s.newSessionsRepo(),
 		s.newUserFetcher(provider),
-		s.newIssuer(),
+		s.newSignInFinisher(),
+		provider.Name(),
 	)
 }
 
+func (s *scope) newAuthorizer() auth.Authorizer {
+	names := make([]string, 0, len(s.cfg.Providers))
+	for _, p := range s.cfg.Providers {
+		names = append(names, p.Name())
+	}
+	return auth.NewAuthorizer(s.newClientsRepo(), names)
+}
+
 func (s *scope) newOAuthStarter(provider goth.Provider) auth.OAuthStarter {
 	return auth.NewOAuthStarter(
 		s.cfg.CodeTTL,
 		s.newTimer(),
 		s.newSessionsRepo(),
+		s.newClientsRepo(),
+		s.newVerifier(),
 		provider,
 	)
 }
+
+func (s *scope) newProfileUpdater() profile.Updater {
+	items := []profile.Updater{}
+	for _, name := range s.cfg.Updaters {
+		items = append(items, updaters[name](s))
+	}
+
+	if s.cfg.TokenCipher != nil {
+		items = append(items, auth.NewProviderTokenSaver(
+			s.cfg.TokenCipher,
+			s.newTimer(),
+			s.newProviderTokensRepo(),
+		))
+	}
+
+	if len(items) == 0 {
+		return profile.Empty()
+	}
+
+	return profile.Chain(items...)
+}
+
+func (s *scope) newProviderTokener(provider goth.Provider) auth.ProviderTokener {
+	return auth.NewProviderTokener(
+		s.cfg.TokenCipher,
+		s.newTimer(),
+		s.newVerifier(),
+		s.newProviderTokensRepo(),
+		provider,
+	)
+}
+
+func (s *scope) newDeliverer() profile.Deliverer {
+	return profile.NewDeliverer(
+		s.newWebhookEventsRepo(),
+		&http.Client{Timeout: s.cfg.Webhook.Timeout},
+		s.cfg.Webhook.Secret,
+		s.cfg.Webhook.Attempts,
+		s.cfg.Webhook.Backoff,
+		s.cfg.Webhook.Batch,
+	)
+}
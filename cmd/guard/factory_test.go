@@ -2,12 +2,18 @@ package main
 
 import (
 	"testing"
+	"time"
 
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/markbates/goth"
 	"github.com/markbates/goth/providers/google"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
+
+	"github.com/vbogretsov/guard/repo"
 )
 
 func TestFactory(t *testing.T) {
@@ -21,10 +27,132 @@ func TestFactory(t *testing.T) {
 	factory := NewFactory(db, FactoryConfig{})
 	require.NotNil(t, factory.NewSignIner(pr))
 	require.NotSame(t, factory.NewSignIner(pr), factory.NewSignIner(pr))
+	require.NotNil(t, factory.NewAuthorizer())
+	require.NotSame(t, factory.NewAuthorizer(), factory.NewAuthorizer())
 	require.NotNil(t, factory.NewOAuthStarter(pr))
 	require.NotSame(t, factory.NewOAuthStarter(pr), factory.NewOAuthStarter(pr))
 	require.NotNil(t, factory.NewRefresher())
 	require.NotSame(t, factory.NewRefresher(), factory.NewRefresher())
 
+	require.NotNil(t, factory.NewCodeExchanger())
+	require.NotSame(t, factory.NewCodeExchanger(), factory.NewCodeExchanger())
+
+	require.NotNil(t, factory.NewCredentialsExchanger())
+	require.NotSame(t, factory.NewCredentialsExchanger(), factory.NewCredentialsExchanger())
+
+	require.NotNil(t, factory.NewSignUper())
+	require.NotSame(t, factory.NewSignUper(), factory.NewSignUper())
+
+	require.NotNil(t, factory.NewPasswordSignIner())
+	require.NotSame(t, factory.NewPasswordSignIner(), factory.NewPasswordSignIner())
+
+	require.NotNil(t, factory.NewPasswordChanger())
+	require.NotSame(t, factory.NewPasswordChanger(), factory.NewPasswordChanger())
+
+	require.NotNil(t, factory.NewPasswordResetter())
+	require.NotSame(t, factory.NewPasswordResetter(), factory.NewPasswordResetter())
+
+	require.NotNil(t, factory.NewMagicLinker())
+	require.NotSame(t, factory.NewMagicLinker(), factory.NewMagicLinker())
+
+	require.NotNil(t, factory.NewMFAEnroller())
+	require.NotSame(t, factory.NewMFAEnroller(), factory.NewMFAEnroller())
+
+	require.NotNil(t, factory.NewMFACompleter())
+	require.NotSame(t, factory.NewMFACompleter(), factory.NewMFACompleter())
+
+	require.NotNil(t, factory.NewPasskeyRegistrar())
+	require.NotSame(t, factory.NewPasskeyRegistrar(), factory.NewPasskeyRegistrar())
+
+	require.NotNil(t, factory.NewPasskeySignIner())
+	require.NotSame(t, factory.NewPasskeySignIner(), factory.NewPasskeySignIner())
+
+	require.NotNil(t, factory.NewPasskeyCompleter())
+	require.NotSame(t, factory.NewPasskeyCompleter(), factory.NewPasskeyCompleter())
+
+	require.NotNil(t, factory.NewIdentityManager())
+	require.NotSame(t, factory.NewIdentityManager(), factory.NewIdentityManager())
+
+	require.NotNil(t, factory.NewProfileReader())
+	require.NotSame(t, factory.NewProfileReader(), factory.NewProfileReader())
+
+	require.NotNil(t, factory.NewProviderTokener(pr))
+	require.NotSame(t, factory.NewProviderTokener(pr), factory.NewProviderTokener(pr))
+
+	require.NotNil(t, factory.NewDeliverer())
+	require.NotSame(t, factory.NewDeliverer(), factory.NewDeliverer())
+
+	require.NotNil(t, factory.NewRevoker())
+	require.NotSame(t, factory.NewRevoker(), factory.NewRevoker())
+
+	require.NotNil(t, factory.NewSweeper())
+	require.NotSame(t, factory.NewSweeper(), factory.NewSweeper())
+
+	require.NotNil(t, factory.NewIntrospector())
+	require.NotSame(t, factory.NewIntrospector(), factory.NewIntrospector())
+	_, err = factory.NewClientAuthenticator().Authenticate("xxx", "xxx")
+	require.Error(t, err)
+
+	require.NotNil(t, factory.NewUserInfoer())
+	require.NotSame(t, factory.NewUserInfoer(), factory.NewUserInfoer())
+
 	require.NoError(t, factory.NewHealthCheck()())
+	require.Empty(t, factory.NewJWKS().Keys)
+	require.Empty(t, factory.NewDiscovery().SigningAlgs)
+}
+
+func TestFactoryOnRedis(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	srv := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer client.Close()
+
+	factory := NewFactory(db, FactoryConfig{Redis: client})
+	require.NotNil(t, factory.NewRefresher())
+	require.NotNil(t, factory.NewRevoker())
+	require.NoError(t, factory.NewHealthCheck()())
+
+	srv.Close()
+	require.Error(t, factory.NewHealthCheck()())
+}
+
+func TestFactoryOnMemory(t *testing.T) {
+	db, err := dbconnect(Conf{DBDriver: "memory"})
+	require.NoError(t, err)
+
+	factory := NewFactory(db, FactoryConfig{Memory: repo.NewMemory(), GCBatch: 100})
+	require.NotNil(t, factory.NewSignUper())
+	require.NotNil(t, factory.NewRefresher())
+	require.NotNil(t, factory.NewIdentityManager())
+	require.NotNil(t, factory.NewSweeper())
+	require.NoError(t, factory.NewHealthCheck()())
+
+	_, err = factory.NewSweeper().Sweep()
+	require.NoError(t, err)
+}
+
+func TestFactoryReload(t *testing.T) {
+	db, err := dbconnect(Conf{DBDriver: "memory"})
+	require.NoError(t, err)
+
+	memory := repo.NewMemory()
+	pr := google.New("google_id", "google_secret", "http://localhost:8000/google/callback")
+
+	f := newFactory(db, FactoryConfig{Memory: memory, AccessTTL: time.Minute})
+
+	_, err = f.GetProvider("google")
+	require.Error(t, err)
+
+	f.Reload(FactoryConfig{AccessTTL: time.Hour, Providers: []goth.Provider{pr}})
+
+	p, err := f.GetProvider("google")
+	require.NoError(t, err)
+	require.Same(t, pr, p)
+
+	require.Equal(t, time.Hour, f.config().AccessTTL)
+	require.Same(t, memory, f.config().Memory, "storages kept")
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -15,12 +16,29 @@ type Server interface {
 	Shutdown(context.Context) error
 }
 
-func start(server Server, address string, sig chan os.Signal, timeout time.Duration) error {
+// Worker is a background job running until the context is cancelled.
+type Worker interface {
+	Run(ctx context.Context)
+}
+
+func start(server Server, address string, sig chan os.Signal, timeout time.Duration, workers ...Worker) error {
 	exit := make(chan error)
 	go func() {
 		exit <- server.Start(address)
 	}()
 
+	wctx, stop := context.WithCancel(context.Background())
+	defer stop()
+
+	wg := sync.WaitGroup{}
+	for _, w := range workers {
+		wg.Add(1)
+		go func(w Worker) {
+			defer wg.Done()
+			w.Run(wctx)
+		}(w)
+	}
+
 	signal.Notify(sig, syscall.SIGTERM)
 	<-sig
 
@@ -30,6 +48,10 @@ func start(server Server, address string, sig chan os.Signal, timeout time.Durat
 	defer cancel()
 
 	log.Info().Msg("terminating")
+
+	stop()
+	wg.Wait()
+
 	if err := server.Shutdown(ctx); err != nil {
 		return err
 	}
@@ -27,6 +27,15 @@ func (m *serverMock) Shutdown(ctx context.Context) error {
 	return ctx.Err()
 }
 
+type workerMock struct {
+	stopped chan bool
+}
+
+func (m *workerMock) Run(ctx context.Context) {
+	<-ctx.Done()
+	m.stopped <- true
+}
+
 func TestGracefullShutdown(t *testing.T) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 	timeout := 1 * time.Second
@@ -51,6 +60,27 @@ func TestGracefullShutdown(t *testing.T) {
 		}
 	})
 
+	t.Run("WorkersStopped", func(t *testing.T) {
+		srv := &serverMock{running: make(chan bool)}
+		sig := make(chan os.Signal, 1)
+		worker := &workerMock{stopped: make(chan bool, 1)}
+
+		done := make(chan error, 1)
+		go func() {
+			done <- start(srv, "", sig, timeout, worker)
+		}()
+
+		sig <- syscall.SIGTERM
+
+		select {
+		case <-time.After(timeout):
+			t.Errorf("not stopped after %v", timeout)
+		case err := <-done:
+			require.NoError(t, err)
+			require.True(t, <-worker.stopped)
+		}
+	})
+
 	t.Run("TimedOut", func(t *testing.T) {
 		srv := &serverMock{running: make(chan bool), delay: timeout * 2}
 		sig := make(chan os.Signal, 1)
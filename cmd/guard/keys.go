@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vbogretsov/guard/auth"
+)
+
+const (
+	defaultKeyAlg   = "ES256"
+	defaultKeyDelay = time.Hour
+)
+
+var errNoSigningKey = errors.New("either GUARD_SIGNING_KEY, GUARD_SECRET_KEY or GUARD_SIGNING_KEYS_DIR is required")
+
+func signingKeys(cfg Conf) ([]auth.Key, error) {
+	keys := []auth.Key{}
+
+	if cfg.SigningKey != "" {
+		key, err := auth.LoadKey(cfg.SigningKey)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	} else if cfg.SecretKey != "" {
+		keys = append(keys, auth.NewSecretKey(cfg.SecretKey))
+	}
+
+	if cfg.SigningKeysDir != "" {
+		dirKeys, err := auth.LoadKeyDir(cfg.SigningKeysDir)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, dirKeys...)
+	}
+
+	if len(keys) == 0 {
+		return nil, errNoSigningKey
+	}
+
+	return keys, nil
+}
+
+// keyGrace keeps replaced keys published at least until the access tokens
+// signed with them expire, the clients of the config file can issue tokens
+// living longer than GUARD_ACCESS_TTL.
+func keyGrace(cfg Conf) time.Duration {
+	grace := cfg.KeyGracePeriod
+	if grace < cfg.AccessTTL {
+		grace = cfg.AccessTTL
+	}
+
+	for _, c := range cfg.Clients {
+		if grace < c.AccessTTL {
+			grace = c.AccessTTL
+		}
+	}
+
+	return grace
+}
+
+// createKeyFile writes the private key to the new file readable by the owner only.
+func createKeyFile(path string, data []byte) error {
+	/* #nosec G304 */
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// generateKey writes a new PEM encoded signing key to the file given or to
+// the output.
+func generateKey(cfg Conf, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("generate", flag.ContinueOnError)
+	alg := fs.String("alg", defaultKeyAlg, "key algorithm, one of RS256, ES256, ES384, ES512, EdDSA")
+	path := fs.String("out", "", "file to write the key to")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	data, err := auth.GenerateKey(*alg)
+	if err != nil {
+		return err
+	}
+
+	if *path == "" {
+		_, err := out.Write(data)
+		return err
+	}
+
+	return createKeyFile(*path, data)
+}
+
+// rotateKeys adds a new key to GUARD_SIGNING_KEYS_DIR activated after the
+// delay, so the key is published before it signs tokens. Prune removes the
+// keys which are no longer published.
+func rotateKeys(cfg Conf, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("rotate", flag.ContinueOnError)
+	alg := fs.String("alg", defaultKeyAlg, "key algorithm, one of RS256, ES256, ES384, ES512, EdDSA")
+	delay := fs.Duration("delay", defaultKeyDelay, "time before the new key signs tokens")
+	prune := fs.Bool("prune", false, "remove the keys which are no longer published")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if cfg.SigningKeysDir == "" {
+		return errors.New("GUARD_SIGNING_KEYS_DIR is required")
+	}
+
+	data, err := auth.GenerateKey(*alg)
+	if err != nil {
+		return err
+	}
+
+	timer := &auth.RealTimer{}
+
+	path := filepath.Join(cfg.SigningKeysDir, auth.KeyFileName(timer.Now().Add(*delay)))
+	if err := createKeyFile(path, data); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "created %s\n", path)
+
+	if !*prune {
+		return nil
+	}
+
+	removed, err := auth.PruneKeyDir(cfg.SigningKeysDir, timer, keyGrace(cfg))
+	for _, name := range removed {
+		fmt.Fprintf(out, "removed %s\n", filepath.Join(cfg.SigningKeysDir, name))
+	}
+
+	return err
+}
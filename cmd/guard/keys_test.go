@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeKey(t *testing.T, path string) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	require.NoError(t, err)
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+}
+
+func TestSigningKeys(t *testing.T) {
+	t.Run("SigningKey", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "key.pem")
+		writeKey(t, path)
+
+		keys, err := signingKeys(Conf{SigningKey: path, SecretKey: "123.456"})
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		require.Equal(t, "EdDSA", keys[0].Method.Alg())
+	})
+
+	t.Run("SecretKey", func(t *testing.T) {
+		keys, err := signingKeys(Conf{SecretKey: "123.456"})
+		require.NoError(t, err)
+		require.Len(t, keys, 1)
+		require.Equal(t, "HS256", keys[0].Method.Alg())
+	})
+
+	t.Run("KeysDir", func(t *testing.T) {
+		dir := t.TempDir()
+		writeKey(t, filepath.Join(dir, "1600000000.pem"))
+		writeKey(t, filepath.Join(dir, "1700000000.pem"))
+
+		keys, err := signingKeys(Conf{SecretKey: "123.456", SigningKeysDir: dir})
+		require.NoError(t, err)
+		require.Len(t, keys, 3)
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		_, err := signingKeys(Conf{})
+		require.ErrorIs(t, err, errNoSigningKey)
+	})
+
+	t.Run("EmptyKeysDir", func(t *testing.T) {
+		_, err := signingKeys(Conf{SigningKeysDir: t.TempDir()})
+		require.ErrorIs(t, err, errNoSigningKey)
+	})
+
+	t.Run("InvalidSigningKey", func(t *testing.T) {
+		_, err := signingKeys(Conf{SigningKey: filepath.Join(t.TempDir(), "key.pem")})
+		require.Error(t, err)
+	})
+
+	t.Run("InvalidKeysDir", func(t *testing.T) {
+		_, err := signingKeys(Conf{SigningKeysDir: filepath.Join(t.TempDir(), "xxx")})
+		require.Error(t, err)
+	})
+}
+
+func TestKeyGrace(t *testing.T) {
+	require.Equal(t, 5*time.Minute, keyGrace(Conf{AccessTTL: 5 * time.Minute}))
+	require.Equal(t, time.Hour, keyGrace(Conf{AccessTTL: 5 * time.Minute, KeyGracePeriod: time.Hour}))
+	require.Equal(t, 2*time.Hour, keyGrace(Conf{
+		AccessTTL:      5 * time.Minute,
+		KeyGracePeriod: time.Hour,
+		Clients:        []ClientConf{{ID: "a", AccessTTL: 2 * time.Hour}, {ID: "b", AccessTTL: time.Minute}},
+	}))
+}
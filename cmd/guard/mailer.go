@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/vbogretsov/guard/mail"
+)
+
+var mailers = map[string]func(Conf) (mail.Mailer, error){
+	"log": func(cfg Conf) (mail.Mailer, error) {
+		return mail.Log(), nil
+	},
+	"file": func(cfg Conf) (mail.Mailer, error) {
+		if cfg.MailDir == "" {
+			return nil, fmt.Errorf("GUARD_MAIL_DIR is required by the file mailer")
+		}
+		return mail.File(cfg.MailDir, cfg.MailFrom), nil
+	},
+	"smtp": func(cfg Conf) (mail.Mailer, error) {
+		return mail.SMTP(cfg.SMTPAddr, cfg.MailFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+	},
+}
+
+func newMailer(cfg Conf) (mail.Mailer, error) {
+	mailer, ok := mailers[cfg.Mailer]
+	if !ok {
+		return nil, fmt.Errorf("unsupported mailer: %v", cfg.Mailer)
+	}
+
+	return mailer(cfg)
+}
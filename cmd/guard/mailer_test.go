@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewMailer(t *testing.T) {
+	t.Run("Log", func(t *testing.T) {
+		mailer, err := newMailer(Conf{Mailer: "log"})
+		require.NoError(t, err)
+		require.NotNil(t, mailer)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		mailer, err := newMailer(Conf{Mailer: "file", MailDir: t.TempDir()})
+		require.NoError(t, err)
+		require.NotNil(t, mailer)
+	})
+
+	t.Run("FileMissingDir", func(t *testing.T) {
+		_, err := newMailer(Conf{Mailer: "file"})
+		require.Error(t, err)
+	})
+
+	t.Run("SMTP", func(t *testing.T) {
+		mailer, err := newMailer(Conf{Mailer: "smtp", SMTPAddr: "localhost:25"})
+		require.NoError(t, err)
+		require.NotNil(t, mailer)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := newMailer(Conf{Mailer: "xxx"})
+		require.Error(t, err)
+	})
+}
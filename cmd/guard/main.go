@@ -5,27 +5,179 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
-	"github.com/caarlos0/env"
+	"github.com/go-redis/redis/v8"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 	"github.com/ziflex/lecho"
+	"gorm.io/gorm"
 
 	"github.com/vbogretsov/guard/api"
 )
 
 const shutdownTimeout = 10 * time.Second
 
-func run() error {
-	cfg := Conf{}
-	if err := env.Parse(&cfg); err != nil {
-		return fmt.Errorf("failed to parse env: %w", err)
+// connect connects the database and the Redis if the sessions are kept there.
+func connect(cfg Conf) (*gorm.DB, redis.UniversalClient, error) {
+	db, err := dbconnect(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect database: %w", err)
 	}
 
-	db, err := dbconnect(cfg)
+	rdb, err := redisconnect(cfg)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect redis: %w", err)
+	}
+
+	return db, rdb, nil
+}
+
+// factoryConfig builds the factory configuration without the storages.
+func factoryConfig(cfg Conf) (FactoryConfig, error) {
+	var fc FactoryConfig
+
+	keys, err := signingKeys(cfg)
+	if err != nil {
+		return fc, fmt.Errorf("failed to load signing keys: %w", err)
+	}
+
+	claims, err := tokenClaims(cfg)
+	if err != nil {
+		return fc, fmt.Errorf("failed to configure token claims: %w", err)
+	}
+
+	mailer, err := newMailer(cfg)
+	if err != nil {
+		return fc, fmt.Errorf("failed to configure mailer: %w", err)
+	}
+
+	cipher, err := mfaCipher(cfg)
+	if err != nil {
+		return fc, fmt.Errorf("failed to configure mfa: %w", err)
+	}
+
+	tokenCipher, err := providerTokenCipher(cfg)
+	if err != nil {
+		return fc, fmt.Errorf("failed to configure provider tokens: %w", err)
+	}
+
+	rp, err := relyingParty(cfg)
+	if err != nil {
+		return fc, fmt.Errorf("failed to configure webauthn: %w", err)
+	}
+
+	policy, err := linkPolicy(cfg)
+	if err != nil {
+		return fc, err
+	}
+
+	updaters, err := profileUpdaters(cfg)
+	if err != nil {
+		return fc, fmt.Errorf("failed to configure profiles: %w", err)
+	}
+
+	return FactoryConfig{
+		Keys:        keys,
+		KeyGrace:    keyGrace(cfg),
+		Claims:      claims,
+		AccessTTL:   cfg.AccessTTL,
+		RefreshTTL:  cfg.RefreshTTL,
+		CodeTTL:     cfg.CodeTTL,
+		AuthCodeTTL: cfg.AuthCodeTTL,
+		ResetTTL:    cfg.PasswordResetTTL,
+		ResetURL:    passwordResetURL(cfg),
+		LinkTTL:     cfg.MagicLinkTTL,
+		LinkURL:     magicLinkURL(cfg),
+		Mailer:      mailer,
+		MFACipher:   cipher,
+		MFATTL:      cfg.MFATTL,
+		MFAURL:      mfaURL(cfg),
+		MFAIssuer:   cfg.MFAIssuer,
+		TokenCipher: tokenCipher,
+		WebAuthn:    rp,
+		PasskeyTTL:  cfg.WebAuthnTTL,
+		LinkPolicy:  policy,
+		Updaters:    updaters,
+		GCBatch:     cfg.GCBatchSize,
+		BaseURL:     cfg.BaseURL,
+		Webhook: WebhookConfig{
+			URLs:     cfg.WebhookURLs,
+			Secret:   []byte(cfg.WebhookSecret),
+			Timeout:  cfg.WebhookTimeout,
+			Attempts: cfg.WebhookAttempts,
+			Backoff:  cfg.WebhookBackoff,
+			Batch:    cfg.WebhookBatchSize,
+		},
+	}, nil
+}
+
+// storageConfig builds the factory configuration with the providers, the
+// storages are set by the caller.
+func storageConfig(cfg Conf) (FactoryConfig, error) {
+	fc, err := factoryConfig(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to connect database: %w", err)
+		return fc, err
+	}
+
+	fc.Providers = newProviders(cfg, os.Environ())
+	return fc, nil
+}
+
+// reloader reloads the configuration file and applies the providers, the
+// clients, the TTLs and the other settings not requiring a restart.
+func reloader(cfg Conf, db *gorm.DB, f *factory) func() error {
+	return func() error {
+		next, err := loadConf(cfg.ConfigFile)
+		if err != nil {
+			return err
+		}
+
+		if err := validateConf(next); err != nil {
+			return err
+		}
+
+		fc, err := storageConfig(next)
+		if err != nil {
+			return err
+		}
+
+		if err := checkMFAKey(next, db); err != nil {
+			return err
+		}
+
+		if err := syncClients(db, next.Clients); err != nil {
+			return err
+		}
+
+		logLevel, _ := zerolog.ParseLevel(next.LogLevel)
+		zerolog.SetGlobalLevel(logLevel)
+
+		f.Reload(fc)
+
+		if changed := restartRequired(cfg, next); len(changed) > 0 {
+			log.Warn().Strs("settings", changed).Msg("settings changed require restart")
+		}
+
+		return nil
+	}
+}
+
+func serve(cfg Conf) error {
+	if err := validateConf(cfg); err != nil {
+		return err
+	}
+
+	db, rdb, err := connect(cfg)
+	if err != nil {
+		return err
+	}
+
+	if err := autoMigrate(cfg, db); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	logLevel, err := zerolog.ParseLevel(cfg.LogLevel)
@@ -35,14 +187,25 @@ func run() error {
 
 	zerolog.SetGlobalLevel(logLevel)
 
-	useProviders(cfg, os.Environ())
+	if err := checkMFAKey(cfg, db); err != nil {
+		return err
+	}
+
+	if err := syncClients(db, cfg.Clients); err != nil {
+		return err
+	}
+
+	fc, err := storageConfig(cfg)
+	if err != nil {
+		return err
+	}
 
-	h := api.NewHttpAPI(NewFactory(db, FactoryConfig{
-		SecretKey:  cfg.SecretKey,
-		AccessTTL:  cfg.AccessTTL,
-		RefreshTTL: cfg.RefreshTTL,
-		CodeTTL:    cfg.CodeTTL,
-	}))
+	fc.Redis = rdb
+	fc.Memory = memoryStore(cfg)
+
+	f := newFactory(db, fc)
+
+	h := api.NewHttpAPI(f)
 
 	e := api.New(h)
 	e.Debug = cfg.Debug
@@ -53,13 +216,32 @@ func run() error {
 	e.Use(middleware.Logger())
 
 	sig := make(chan os.Signal, 1)
-	return start(e, fmt.Sprintf(":%d", cfg.Port), sig, shutdownTimeout)
+	workers := []Worker{}
+	if cfg.GCInterval > 0 {
+		workers = append(workers, NewSweepWorker(cfg.GCInterval, f.NewSweeper))
+	}
+	if len(cfg.WebhookURLs) > 0 && cfg.WebhookInterval > 0 {
+		workers = append(workers, NewDeliveryWorker(cfg.WebhookInterval, f.NewDeliverer))
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	workers = append(workers, NewReloadWorker(hup, reloader(cfg, db, f)))
+
+	return start(e, fmt.Sprintf(":%d", cfg.Port), sig, shutdownTimeout, workers...)
 }
 
 func main() {
+	configPath := flag.String("config", "", "path to the YAML config file, overrides GUARD_CONFIG")
 	flag.Parse()
 
-	if err := run(); err != nil && err != http.ErrServerClosed {
+	cfg, err := loadConf(*configPath)
+	if err == nil {
+		err = runCLI(cfg, flag.Args(), os.Stdout)
+	}
+
+	if err != nil && err != http.ErrServerClosed {
 		fmt.Printf("error: %v\n", err)
 		os.Exit(1)
 	}
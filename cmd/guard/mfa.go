@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+
+	"github.com/vbogretsov/guard/model"
+)
+
+// checkMFAKey fails if GUARD_MFA_KEY is not set while some users have two
+// factor authentication enabled, they would be unable to complete the sign
+// in otherwise.
+func checkMFAKey(cfg Conf, db *gorm.DB) error {
+	if cfg.MFAKey != "" {
+		return nil
+	}
+
+	var count int64
+	if err := db.Model(&model.TOTP{}).Where("enabled = ?", true).Count(&count).Error; err != nil {
+		return fmt.Errorf("failed to count totps: %w", err)
+	}
+
+	if count > 0 {
+		return fmt.Errorf("GUARD_MFA_KEY is required, %d users have two factor authentication enabled", count)
+	}
+
+	return nil
+}
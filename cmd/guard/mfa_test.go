@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/model"
+)
+
+func TestCheckMFAKey(t *testing.T) {
+	db, err := dbconnect(Conf{DBDriver: "memory"})
+	require.NoError(t, err)
+
+	require.NoError(t, db.Create(&model.TOTP{UserID: "user.1"}).Error)
+	require.NoError(t, checkMFAKey(Conf{}, db), "not confirmed enrollment ignored")
+
+	require.NoError(t, db.Create(&model.TOTP{UserID: "user.2", Enabled: true}).Error)
+
+	err = checkMFAKey(Conf{}, db)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "GUARD_MFA_KEY is required, 1 users")
+
+	require.NoError(t, checkMFAKey(Conf{MFAKey: "key"}, db))
+}
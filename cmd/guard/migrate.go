@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
+
+	"github.com/vbogretsov/guard/migrations"
+)
+
+const migrateUsage = "usage: guard migrate up|down [n]|status"
+
+func newMigrator(cfg Conf, db *gorm.DB) (migrations.Migrator, error) {
+	if cfg.DBDriver == memoryDriver {
+		return nil, fmt.Errorf("the %v driver has no migrations", memoryDriver)
+	}
+
+	sqldb, err := db.DB()
+	if err != nil {
+		return nil, err
+	}
+
+	return migrations.New(sqldb, db.Dialector.Name())
+}
+
+// autoMigrate applies the pending migrations on startup if enabled.
+func autoMigrate(cfg Conf, db *gorm.DB) error {
+	if !cfg.AutoMigrate || cfg.DBDriver == memoryDriver {
+		return nil
+	}
+
+	m, err := newMigrator(cfg, db)
+	if err != nil {
+		return err
+	}
+
+	applied, err := m.Up()
+	for _, mg := range applied {
+		log.Info().Int64("version", mg.Version).Str("name", mg.Name).Msg("migration applied")
+	}
+
+	return err
+}
+
+// migrate runs the migrate subcommand. Down reverts a single migration unless
+// the number of migrations is given.
+func migrate(cfg Conf, db *gorm.DB, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return fmt.Errorf(migrateUsage)
+	}
+
+	m, err := newMigrator(cfg, db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := m.Up()
+		for _, mg := range applied {
+			fmt.Fprintf(out, "applied %03d_%s\n", mg.Version, mg.Name)
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations: %v", args[1])
+			}
+		}
+
+		reverted, err := m.Down(steps)
+		for _, mg := range reverted {
+			fmt.Fprintf(out, "reverted %03d_%s\n", mg.Version, mg.Name)
+		}
+		return err
+	case "status":
+		status, err := m.Status()
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "version %d", status.Version)
+		if status.Dirty {
+			fmt.Fprint(out, " (dirty)")
+		}
+		fmt.Fprintln(out)
+
+		for _, mg := range status.Migrations {
+			state := "pending"
+			if mg.Version <= status.Version {
+				state = "applied"
+			}
+			fmt.Fprintf(out, "%03d_%s %s\n", mg.Version, mg.Name, state)
+		}
+		return nil
+	default:
+		return fmt.Errorf(migrateUsage)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/model"
+)
+
+func TestMigrate(t *testing.T) {
+	cfg := Conf{
+		DBDriver: "sqlite",
+		DSN:      filepath.Join(t.TempDir(), "guard.db"),
+	}
+
+	db, err := dbconnect(cfg)
+	require.NoError(t, err)
+
+	run := func(args ...string) (string, error) {
+		out := &bytes.Buffer{}
+		err := migrate(cfg, db, args, out)
+		return out.String(), err
+	}
+
+	t.Run("Status", func(t *testing.T) {
+		out, err := run("status")
+		require.NoError(t, err)
+		require.Contains(t, out, "version 0\n")
+		require.Contains(t, out, "140_initialize pending\n")
+	})
+
+	t.Run("Up", func(t *testing.T) {
+		out, err := run("up")
+		require.NoError(t, err)
+		require.Equal(t, "applied 140_initialize\napplied 150_user_disabled\napplied 160_session_used\napplied 170_user_verified\napplied 180_client_source\n", out)
+
+		out, err = run("status")
+		require.NoError(t, err)
+		require.Contains(t, out, "version 180\n")
+		require.Contains(t, out, "140_initialize applied\n")
+		require.Contains(t, out, "150_user_disabled applied\n")
+		require.Contains(t, out, "160_session_used applied\n")
+		require.Contains(t, out, "170_user_verified applied\n")
+		require.Contains(t, out, "180_client_source applied\n")
+	})
+
+	t.Run("Down", func(t *testing.T) {
+		out, err := run("down", "1")
+		require.NoError(t, err)
+		require.Equal(t, "reverted 180_client_source\n", out)
+		require.False(t, db.Migrator().HasColumn(&model.Client{}, "source"))
+
+		out, err = run("down", "1")
+		require.NoError(t, err)
+		require.Equal(t, "reverted 170_user_verified\n", out)
+		require.False(t, db.Migrator().HasColumn(&model.User{}, "verified"))
+		require.True(t, db.Migrator().HasColumn(&model.User{}, "disabled"))
+
+		out, err = run("down", "1")
+		require.NoError(t, err)
+		require.Equal(t, "reverted 160_session_used\n", out)
+		require.False(t, db.Migrator().HasColumn(&model.Session{}, "used"))
+
+		out, err = run("down", "1")
+		require.NoError(t, err)
+		require.Equal(t, "reverted 150_user_disabled\n", out)
+		require.False(t, db.Migrator().HasColumn(&model.User{}, "disabled"))
+
+		out, err = run("down")
+		require.NoError(t, err)
+		require.Equal(t, "reverted 140_initialize\n", out)
+
+		out, err = run("down")
+		require.NoError(t, err)
+		require.Empty(t, out)
+	})
+
+	t.Run("AutoMigrate", func(t *testing.T) {
+		require.NoError(t, autoMigrate(cfg, db))
+		require.False(t, db.Migrator().HasTable("users"))
+
+		cfg := cfg
+		cfg.AutoMigrate = true
+
+		require.NoError(t, autoMigrate(cfg, db))
+		require.True(t, db.Migrator().HasTable("users"))
+	})
+
+	t.Run("InvalidArgs", func(t *testing.T) {
+		for _, args := range [][]string{{}, {"xxx"}, {"down", "xxx"}, {"down", "0"}} {
+			_, err := run(args...)
+			require.Error(t, err, "%v", args)
+		}
+	})
+
+	t.Run("Memory", func(t *testing.T) {
+		cfg := Conf{DBDriver: "memory", AutoMigrate: true}
+
+		db, err := dbconnect(cfg)
+		require.NoError(t, err)
+
+		require.NoError(t, autoMigrate(cfg, db))
+		require.Error(t, migrate(cfg, db, []string{"up"}, &bytes.Buffer{}))
+	})
+}
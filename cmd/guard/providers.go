@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"sort"
 	"strings"
 
 	"github.com/markbates/goth"
@@ -112,15 +113,79 @@ func addProviders(providers []provider, environ []string) []provider {
 	return providers
 }
 
-func useProviders(cfg Conf, environ []string) {
-	for _, p := range addProviders(providers, environ) {
+func wellKnown(name string) bool {
+	for _, p := range providers {
+		if p.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// fileProviders returns the providers of the config file.
+func fileProviders(cfg Conf) []provider {
+	names := make([]string, 0, len(cfg.Providers))
+	for name := range cfg.Providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	result := []provider{}
+	for _, name := range names {
+		pc := cfg.Providers[name]
+
+		p := provider{
+			name:         name,
+			clientID:     func(Conf) string { return pc.ClientID },
+			clientSecret: func(Conf) string { return pc.ClientSecret },
+		}
+
+		for _, known := range providers {
+			if known.name == name {
+				p.ctor = known.ctor
+			}
+		}
+
+		if p.ctor == nil {
+			p.ctor = namedOIDCProvider(name, pc.DiscoveryURL)
+		}
+
+		result = append(result, p)
+	}
+
+	return result
+}
+
+// namedOIDCProvider creates the OIDC provider registered under the name of
+// the config file.
+func namedOIDCProvider(name, discoveryURL string) func(id, secret, url string) (goth.Provider, error) {
+	return func(id, secret, url string) (goth.Provider, error) {
+		p, err := openidConnect.New(id, secret, url, discoveryURL)
+		if err != nil {
+			return nil, err
+		}
+		p.SetName(name)
+		return p, nil
+	}
+}
+
+// newProviders creates the providers of the config file and of the
+// environment. The providers configured by the environment replace the file
+// providers of the same name.
+func newProviders(cfg Conf, environ []string) []goth.Provider {
+	byName := map[string]goth.Provider{}
+	names := []string{}
+
+	add := func(p provider, warn bool) {
 		clientID := p.clientID(cfg)
 		if clientID == "" {
-			log.Warn().
-				Str("provider", p.name).
-				Str("cause", "missing client id").
-				Msg("failed to use provider")
-			continue
+			if warn {
+				log.Warn().
+					Str("provider", p.name).
+					Str("cause", "missing client id").
+					Msg("failed to use provider")
+			}
+			return
 		}
 
 		clientSecret := p.clientSecret(cfg)
@@ -129,7 +194,7 @@ func useProviders(cfg Conf, environ []string) {
 				Str("provider", p.name).
 				Str("cause", "missing client secret").
 				Msg("failed to use provider")
-			continue
+			return
 		}
 
 		callbackURL := fmt.Sprintf("%s/%s/callback", cfg.BaseURL, p.name)
@@ -140,9 +205,28 @@ func useProviders(cfg Conf, environ []string) {
 				Str("provider", p.name).
 				Str("cause", err.Error()).
 				Msg("failed to use provider")
-			continue
+			return
+		}
+
+		if _, ok := byName[pvr.Name()]; !ok {
+			names = append(names, pvr.Name())
 		}
+		byName[pvr.Name()] = pvr
+	}
+
+	for _, p := range fileProviders(cfg) {
+		add(p, true)
+	}
 
-		goth.UseProviders(pvr)
+	for _, p := range addProviders(providers, environ) {
+		_, inFile := cfg.Providers[p.name]
+		add(p, !inFile)
 	}
+
+	result := make([]goth.Provider, 0, len(names))
+	for _, name := range names {
+		result = append(result, byName[name])
+	}
+
+	return result
 }
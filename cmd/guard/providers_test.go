@@ -7,6 +7,8 @@ import (
 	"testing"
 
 	"github.com/markbates/goth"
+	"github.com/markbates/goth/providers/google"
+	"github.com/markbates/goth/providers/yandex"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
 	"gopkg.in/h2non/gock.v1"
@@ -47,7 +49,16 @@ func TestAddProviders(t *testing.T) {
 
 }
 
-func TestUseProviders(t *testing.T) {
+func findProvider(ps []goth.Provider, name string) (goth.Provider, error) {
+	for _, p := range ps {
+		if p.Name() == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("no provider for %s exists", name)
+}
+
+func TestNewProviders(t *testing.T) {
 	t.Run("Success", func(t *testing.T) {
 		cfg := Conf{
 			BaseURL:           "http://localhost:8000",
@@ -67,33 +78,31 @@ func TestUseProviders(t *testing.T) {
 
 		zerolog.SetGlobalLevel(zerolog.Disabled)
 
-		useProviders(cfg, []string{})
+		ps := newProviders(cfg, []string{})
 
-		a, err := goth.GetProvider("apple")
+		a, err := findProvider(ps, "apple")
 		require.NoError(t, err)
 		require.NotNil(t, a)
 
-		g, err := goth.GetProvider("google")
+		g, err := findProvider(ps, "google")
 		require.NoError(t, err)
 		require.NotNil(t, g)
 
-		f, err := goth.GetProvider("facebook")
+		f, err := findProvider(ps, "facebook")
 		require.NoError(t, err)
 		require.NotNil(t, f)
 
-		tw, err := goth.GetProvider("twitter")
+		tw, err := findProvider(ps, "twitter")
 		require.NoError(t, err)
 		require.NotNil(t, tw)
 
-		v, err := goth.GetProvider("vk")
+		v, err := findProvider(ps, "vk")
 		require.NoError(t, err)
 		require.NotNil(t, v)
 
-		y, err := goth.GetProvider("yandex")
+		y, err := findProvider(ps, "yandex")
 		require.NoError(t, err)
 		require.NotNil(t, y)
-
-		goth.ClearProviders()
 	})
 
 	t.Run("Missconfigured", func(t *testing.T) {
@@ -107,17 +116,15 @@ func TestUseProviders(t *testing.T) {
 
 		zerolog.SetGlobalLevel(zerolog.Disabled)
 
-		useProviders(cfg, []string{})
+		ps := newProviders(cfg, []string{})
 
 		var err error
 
-		_, err = goth.GetProvider("vk")
+		_, err = findProvider(ps, "vk")
 		require.Error(t, err)
 
-		_, err = goth.GetProvider("yandex")
+		_, err = findProvider(ps, "yandex")
 		require.Error(t, err)
-
-		goth.ClearProviders()
 	})
 
 	t.Run("OpenIdConnectSuccess", func(t *testing.T) {
@@ -140,17 +147,15 @@ func TestUseProviders(t *testing.T) {
 				"issuer":                 "p1",
 			})
 
-		useProviders(cfg, []string{
+		ps := newProviders(cfg, []string{
 			"P1_OIDC_CLIENT_ID=p1-id",
 			"P1_OIDC_CLIENT_SECRET=p1-secret",
 			fmt.Sprintf("P1_OIDC_DISCOVERY_URL=%s/discovery", discoveryURL),
 		})
 
-		p1, err := goth.GetProvider("openid-connect")
+		p1, err := findProvider(ps, "openid-connect")
 		require.NoError(t, err)
 		require.NotNil(t, p1)
-
-		goth.ClearProviders()
 	})
 
 	t.Run("OpenIdConnectFailed", func(t *testing.T) {
@@ -167,15 +172,58 @@ func TestUseProviders(t *testing.T) {
 			Get("/discovery").
 			Reply(404)
 
-		useProviders(cfg, []string{
+		ps := newProviders(cfg, []string{
 			"P1_OIDC_CLIENT_ID=p1-id",
 			"P1_OIDC_CLIENT_SECRET=p1-secret",
 			fmt.Sprintf("P1_OIDC_DISCOVERY_URL=%s/discovery", discoveryURL),
 		})
 
-		_, err := goth.GetProvider("openid-connect")
+		_, err := findProvider(ps, "openid-connect")
 		require.Error(t, err)
-
-		goth.ClearProviders()
 	})
 }
+
+func TestFileProviders(t *testing.T) {
+	defer gock.Off()
+
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	discoveryURL := "http://corp.org"
+	gock.New(discoveryURL).
+		Get("/discovery").
+		Reply(200).
+		JSON(map[string]string{
+			"authorization_endpoint": "/authorize",
+			"token_endpoint":         "/token",
+			"userinfo_endpoint":      "/userinfo",
+			"issuer":                 "corp",
+		})
+
+	cfg := Conf{
+		BaseURL:        "http://localhost:8000",
+		GoogleClientID: "google-env-id",
+		GoogleSecret:   "google-env-secret",
+		Providers: map[string]ProviderConf{
+			"google": {ClientID: "google-id", ClientSecret: "google-secret"},
+			"yandex": {ClientID: "yandex-id", ClientSecret: "yandex-secret"},
+			"corp":   {ClientID: "corp-id", ClientSecret: "corp-secret", DiscoveryURL: discoveryURL + "/discovery"},
+			"vk":     {ClientID: "vk-id"},
+		},
+	}
+
+	ps := newProviders(cfg, []string{})
+
+	names := []string{}
+	for _, p := range ps {
+		names = append(names, p.Name())
+	}
+	require.Equal(t, []string{"corp", "google", "yandex"}, names)
+
+	g, err := findProvider(ps, "google")
+	require.NoError(t, err)
+	require.Equal(t, "google-env-id", g.(*google.Provider).ClientKey, "env overrides file")
+
+	y, err := findProvider(ps, "yandex")
+	require.NoError(t, err)
+	require.Equal(t, "http://localhost:8000/yandex/callback", y.(*yandex.Provider).CallbackURL)
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// redisconnect returns the Redis client storing the sessions and the refresh
+// tokens or nil if they are stored in the database.
+func redisconnect(cfg Conf) (redis.UniversalClient, error) {
+	switch cfg.SessionDriver {
+	case "", "sql":
+		return nil, nil
+	case "redis":
+	default:
+		return nil, fmt.Errorf("unsupported session driver: %v", cfg.SessionDriver)
+	}
+
+	opts, err := redis.ParseURL(cfg.RedisURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid GUARD_REDIS_URL: %w", err)
+	}
+
+	client := redis.NewClient(opts)
+
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	return client, nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedisConn(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		srv := miniredis.RunT(t)
+
+		client, err := redisconnect(Conf{
+			SessionDriver: "redis",
+			RedisURL:      "redis://" + srv.Addr() + "/0",
+		})
+
+		require.NoError(t, err)
+		require.NotNil(t, client)
+		require.NoError(t, client.Close())
+	})
+	t.Run("SQL", func(t *testing.T) {
+		client, err := redisconnect(Conf{SessionDriver: "sql"})
+
+		require.NoError(t, err)
+		require.Nil(t, client)
+	})
+	t.Run("UnsupportedDriver", func(t *testing.T) {
+		_, err := redisconnect(Conf{SessionDriver: "xxx"})
+		require.Error(t, err)
+	})
+	t.Run("InvalidURL", func(t *testing.T) {
+		_, err := redisconnect(Conf{SessionDriver: "redis", RedisURL: "http://xxx"})
+		require.Error(t, err)
+	})
+	t.Run("InvalidConnection", func(t *testing.T) {
+		_, err := redisconnect(Conf{SessionDriver: "redis", RedisURL: "redis://127.0.0.1:1/0"})
+		require.Error(t, err)
+	})
+}
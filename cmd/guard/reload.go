@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"github.com/rs/zerolog/log"
+)
+
+type reloadWorker struct {
+	sig    <-chan os.Signal
+	reload func() error
+}
+
+// NewReloadWorker creates a worker reloading the configuration on each
+// signal received. A failed reload keeps the current configuration.
+func NewReloadWorker(sig <-chan os.Signal, reload func() error) Worker {
+	return &reloadWorker{
+		sig:    sig,
+		reload: reload,
+	}
+}
+
+func (w *reloadWorker) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-w.sig:
+			if err := w.reload(); err != nil {
+				log.Error().Err(err).Msg("reload failed, configuration kept")
+				continue
+			}
+			log.Info().Msg("configuration reloaded")
+		}
+	}
+}
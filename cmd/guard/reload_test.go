@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func TestReloadWorker(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	var calls int32
+	sig := make(chan os.Signal)
+	w := NewReloadWorker(sig, func() error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			return errors.New("invalid configuration")
+		}
+		return nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	sig <- syscall.SIGHUP
+	sig <- syscall.SIGHUP
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("worker not stopped after cancel")
+	}
+}
+
+func TestReloader(t *testing.T) {
+	defer gock.Off()
+
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	gock.New("http://corp.org").
+		Get("/discovery").
+		Reply(404)
+
+	path := writeConf(t, testConfFile)
+
+	cfg, err := loadConf(path)
+	require.NoError(t, err)
+
+	db, err := dbconnect(cfg)
+	require.NoError(t, err)
+
+	require.NoError(t, syncClients(db, cfg.Clients))
+
+	clients := repo.NewClients(repo.NewConn(db))
+	client, err := clients.Find("spa")
+	require.NoError(t, err)
+	require.Equal(t, "http://app.local/callback", client.Redirects)
+	require.Equal(t, "google", client.Providers)
+	require.Equal(t, "users:read", client.Scopes)
+	require.Equal(t, int64(300), client.AccessTTL)
+
+	fc, err := storageConfig(cfg)
+	require.NoError(t, err)
+
+	f := newFactory(db, fc)
+	reload := reloader(cfg, db, f)
+
+	_, err = f.GetProvider("corp")
+	require.Error(t, err, "discovery failed")
+	require.True(t, gock.IsDone())
+
+	writeConf := func(data string) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	}
+
+	writeConf(`
+dbdriver: memory
+secret_key: "123.456"
+access_ttl: 20m
+providers:
+  yandex:
+    client_id: yandex-id
+    client_secret: yandex-secret
+clients:
+  - id: spa
+    redirects: [http://app.local/callback, http://app.local/next]
+`)
+	require.NoError(t, reload())
+
+	require.Equal(t, 20*time.Minute, f.config().AccessTTL)
+
+	_, err = f.GetProvider("google")
+	require.Error(t, err)
+
+	_, err = f.GetProvider("yandex")
+	require.NoError(t, err)
+
+	updated, err := clients.Find("spa")
+	require.NoError(t, err)
+	require.Equal(t, model.Client{
+		ID:        "spa",
+		Redirects: "http://app.local/callback http://app.local/next",
+		Source:    "file",
+		Created:   client.Created,
+	}, updated)
+
+	require.NoError(t, clients.Create(model.Client{ID: "cli"}))
+
+	writeConf(`
+dbdriver: memory
+secret_key: "123.456"
+access_ttl: 20m
+`)
+	require.NoError(t, reload())
+
+	_, err = clients.Find("spa")
+	require.ErrorIs(t, err, repo.ErrorNotFound, "client removed from the file")
+
+	_, err = clients.Find("cli")
+	require.NoError(t, err, "client created otherwise kept")
+
+	writeConf("access_ttl: -1m\n")
+	require.Error(t, reload())
+	require.Equal(t, 20*time.Minute, f.config().AccessTTL, "configuration kept")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vbogretsov/guard/auth"
+)
+
+type sweepWorker struct {
+	interval time.Duration
+	sweeper  func() auth.Sweeper
+}
+
+// NewSweepWorker creates a worker deleting expired records every interval.
+// A new sweeper is created for each run, so it gets the current time.
+func NewSweepWorker(interval time.Duration, sweeper func() auth.Sweeper) Worker {
+	return &sweepWorker{
+		interval: interval,
+		sweeper:  sweeper,
+	}
+}
+
+func (w *sweepWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := w.sweeper().Sweep()
+			if err != nil {
+				log.Error().Err(err).Msg("sweep failed")
+			}
+			if n > 0 {
+				log.Info().Int64("deleted", n).Msg("expired records deleted")
+			}
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/auth"
+)
+
+type sweeperStub struct {
+	calls *int32
+}
+
+func (s sweeperStub) Sweep() (int64, error) {
+	atomic.AddInt32(s.calls, 1)
+	return 1, nil
+}
+
+func TestSweepWorker(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	var calls int32
+	w := NewSweepWorker(time.Millisecond, func() auth.Sweeper {
+		return sweeperStub{calls: &calls}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) >= 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("worker not stopped after cancel")
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/model"
+)
+
+// userAdmin connects the storages of the server administered. The memory
+// driver keeps the records in the server process, so they can not be
+// administered.
+func userAdmin(cfg Conf) (auth.UserAdmin, error) {
+	if cfg.DBDriver == memoryDriver {
+		return nil, fmt.Errorf("the %v driver can not be administered", memoryDriver)
+	}
+
+	db, rdb, err := connect(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &factory{db: db, cfg: FactoryConfig{Redis: rdb}}
+	return f.scope().newUserAdmin(), nil
+}
+
+func formatTime(unix int64) string {
+	return time.Unix(unix, 0).UTC().Format(time.RFC3339)
+}
+
+func listUsers(cfg Conf, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("list", flag.ContinueOnError)
+	offset := fs.Int("offset", 0, "number of users to skip")
+	limit := fs.Int("limit", 100, "max number of users to list")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	admin, err := userAdmin(cfg)
+	if err != nil {
+		return err
+	}
+
+	users, err := admin.List(*offset, *limit)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tDISABLED\tCREATED")
+	for _, user := range users {
+		fmt.Fprintf(w, "%s\t%s\t%t\t%s\n", user.ID, user.Name, user.Disabled, formatTime(user.Created))
+	}
+
+	return w.Flush()
+}
+
+func showUser(cfg Conf, args []string, out io.Writer) error {
+	ref, err := userArg("show", args)
+	if err != nil {
+		return err
+	}
+
+	admin, err := userAdmin(cfg)
+	if err != nil {
+		return err
+	}
+
+	details, err := admin.Show(ref)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "id:\t%s\n", details.User.ID)
+	fmt.Fprintf(w, "name:\t%s\n", details.User.Name)
+	fmt.Fprintf(w, "disabled:\t%t\n", details.User.Disabled)
+	fmt.Fprintf(w, "created:\t%s\n", formatTime(details.User.Created))
+	fmt.Fprintln(w, "identities:")
+	for _, identity := range details.Identities {
+		fmt.Fprintf(w, "\t%s\t%s\t%s\n", identity.Provider, identity.Email, formatTime(identity.Created))
+	}
+
+	return w.Flush()
+}
+
+// userArg returns the id or the email of the user given by the only argument.
+func userArg(name string, args []string) (string, error) {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := parseFlags(fs, args, 1); err != nil {
+		return "", err
+	}
+	return fs.Arg(0), nil
+}
+
+// updateUser runs the admin action on the user and reports the result.
+func updateUser(cfg Conf, ref string, out io.Writer, done string, action func(auth.UserAdmin, string) (model.User, error)) error {
+	admin, err := userAdmin(cfg)
+	if err != nil {
+		return err
+	}
+
+	user, err := action(admin, ref)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "user %s (%s) %s\n", user.ID, user.Name, done)
+	return nil
+}
+
+func disableUser(cfg Conf, args []string, out io.Writer) error {
+	ref, err := userArg("disable", args)
+	if err != nil {
+		return err
+	}
+	return updateUser(cfg, ref, out, "disabled", auth.UserAdmin.Disable)
+}
+
+func enableUser(cfg Conf, args []string, out io.Writer) error {
+	ref, err := userArg("enable", args)
+	if err != nil {
+		return err
+	}
+	return updateUser(cfg, ref, out, "enabled", auth.UserAdmin.Enable)
+}
+
+func deleteUser(cfg Conf, args []string, out io.Writer) error {
+	ref, err := userArg("delete", args)
+	if err != nil {
+		return err
+	}
+	return updateUser(cfg, ref, out, "deleted", auth.UserAdmin.Delete)
+}
+
+func revokeTokens(cfg Conf, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("revoke", flag.ContinueOnError)
+	user := fs.String("user", "", "id or email of the user")
+
+	if err := parseFlags(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *user == "" {
+		return fmt.Errorf("%w: -user is required", errInvalidArgs)
+	}
+
+	return updateUser(cfg, *user, out, "refresh tokens revoked", auth.UserAdmin.RevokeTokens)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/vbogretsov/guard/profile"
+)
+
+type deliveryWorker struct {
+	interval  time.Duration
+	deliverer func() profile.Deliverer
+}
+
+// NewDeliveryWorker creates a worker sending the due webhook events every
+// interval.
+func NewDeliveryWorker(interval time.Duration, deliverer func() profile.Deliverer) Worker {
+	return &deliveryWorker{
+		interval:  interval,
+		deliverer: deliverer,
+	}
+}
+
+func (w *deliveryWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			n, err := w.deliverer().Deliver()
+			if err != nil {
+				log.Error().Err(err).Msg("webhook delivery failed")
+			}
+			if n > 0 {
+				log.Info().Int("delivered", n).Msg("webhook events delivered")
+			}
+		}
+	}
+}
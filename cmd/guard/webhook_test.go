@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/vbogretsov/guard/profile"
+)
+
+type delivererStub struct {
+	calls *int32
+}
+
+func (s delivererStub) Deliver() (int, error) {
+	atomic.AddInt32(s.calls, 1)
+	return 1, nil
+}
+
+func TestDeliveryWorker(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	var calls int32
+	w := NewDeliveryWorker(time.Millisecond, func() profile.Deliverer {
+		return delivererStub{calls: &calls}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		w.Run(ctx)
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) >= 2
+	}, time.Second, time.Millisecond)
+
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("worker not stopped after cancel")
+	}
+}
+
+func TestProfileUpdaters(t *testing.T) {
+	t.Run("Database", func(t *testing.T) {
+		names, err := profileUpdaters(Conf{ProfileUpdaters: []string{"database"}})
+		require.NoError(t, err)
+		require.Equal(t, []string{"database"}, names)
+	})
+
+	t.Run("None", func(t *testing.T) {
+		names, err := profileUpdaters(Conf{ProfileUpdaters: []string{"none"}})
+		require.NoError(t, err)
+		require.Empty(t, names)
+	})
+
+	t.Run("Webhook", func(t *testing.T) {
+		names, err := profileUpdaters(Conf{
+			ProfileUpdaters: []string{"database", " webhook"},
+			WebhookURLs:     []string{"http://localhost:9000/hook"},
+			WebhookSecret:   "secret",
+		})
+		require.NoError(t, err)
+		require.Equal(t, []string{"database", "webhook"}, names)
+	})
+
+	t.Run("WebhookMissingURLs", func(t *testing.T) {
+		_, err := profileUpdaters(Conf{ProfileUpdaters: []string{"webhook"}, WebhookSecret: "secret"})
+		require.Error(t, err)
+	})
+
+	t.Run("WebhookMissingSecret", func(t *testing.T) {
+		_, err := profileUpdaters(Conf{
+			ProfileUpdaters: []string{"webhook"},
+			WebhookURLs:     []string{"http://localhost:9000/hook"},
+		})
+		require.Error(t, err)
+	})
+
+	t.Run("Unsupported", func(t *testing.T) {
+		_, err := profileUpdaters(Conf{ProfileUpdaters: []string{"xxx"}})
+		require.Error(t, err)
+	})
+}
+
+func TestNewProfileUpdater(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	f := NewFactory(db, FactoryConfig{
+		Updaters: []string{"database", "webhook"},
+		Webhook:  WebhookConfig{URLs: []string{"http://localhost:9000/hook"}},
+	}).(*factory)
+
+	require.NotNil(t, f.scope().newProfileUpdater())
+
+	f.cfg.Updaters = nil
+	require.Equal(t, profile.Empty(), f.scope().newProfileUpdater())
+
+	f.cfg.TokenCipher, err = providerTokenCipher(Conf{ProviderTokenKey: base64.StdEncoding.EncodeToString(make([]byte, 32))})
+	require.NoError(t, err)
+	require.NotEqual(t, profile.Empty(), f.scope().newProfileUpdater())
+}
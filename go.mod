@@ -3,8 +3,11 @@ module github.com/vbogretsov/guard
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
 	github.com/caarlos0/env v3.5.0+incompatible
-	github.com/golang-jwt/jwt v3.2.1+incompatible // indirect
+	github.com/cespare/xxhash/v2 v2.1.2 // indirect
+	github.com/go-redis/redis/v8 v8.4.2
+	github.com/golang-jwt/jwt v3.2.2+incompatible // indirect
 	github.com/labstack/echo v3.3.10+incompatible // indirect
 	github.com/labstack/echo/v4 v4.4.0 // indirect
 	github.com/lib/pq v1.10.2 // indirect
@@ -13,8 +16,10 @@ require (
 	github.com/stretchr/testify v1.7.0 // indirect
 	github.com/ziflex/lecho v1.2.0 // indirect
 	github.com/ziflex/lecho/v2 v2.5.0 // indirect
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	golang.org/x/oauth2 v0.0.0-20200902213428-5d25da1a8d43 // indirect
 	gopkg.in/h2non/gock.v1 v1.1.2 // indirect
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 	gorm.io/driver/mysql v1.1.2 // indirect
 	gorm.io/driver/postgres v1.1.0 // indirect
 	gorm.io/driver/sqlite v1.1.4 // indirect
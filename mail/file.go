@@ -0,0 +1,33 @@
+package mail
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type fileMailer struct {
+	dir  string
+	from string
+}
+
+// File returns a mailer that writes each message to a separate .eml file in
+// the directory given. It is meant for local development and tests.
+func File(dir, from string) Mailer {
+	return &fileMailer{dir: dir, from: from}
+}
+
+func (m *fileMailer) Send(msg Message) error {
+	now := time.Now()
+
+	to := strings.NewReplacer("/", "_", "\\", "_").Replace(msg.To)
+	name := filepath.Join(m.dir, fmt.Sprintf("%d-%s.eml", now.UnixNano(), to))
+
+	if err := ioutil.WriteFile(name, encode(m.from, msg, now), 0600); err != nil {
+		return fmt.Errorf("failed to write mail: %w", err)
+	}
+
+	return nil
+}
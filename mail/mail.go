@@ -0,0 +1,65 @@
+package mail
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"strings"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+type logMailer struct{}
+
+// Log returns a mailer that writes messages to the log instead of sending
+// them. It is meant for local development. The bodies carry the reset and
+// sign in links, so they are logged at the debug level only.
+func Log() Mailer {
+	return &logMailer{}
+}
+
+func (m *logMailer) Send(msg Message) error {
+	log.Info().
+		Str("to", msg.To).
+		Str("subject", msg.Subject).
+		Msg("mail")
+	log.Debug().
+		Str("to", msg.To).
+		Str("body", msg.Body).
+		Msg("mail body")
+	return nil
+}
+
+// encode formats the message as a plain text RFC 5322 message.
+func encode(from string, msg Message, date time.Time) []byte {
+	buf := bytes.Buffer{}
+
+	header := func(name, value string) {
+		value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+
+	header("From", from)
+	header("To", msg.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", date.Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+	header("Content-Type", "text/plain; charset=utf-8")
+	buf.WriteString("\r\n")
+
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	return buf.Bytes()
+}
@@ -0,0 +1,167 @@
+package mail
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"github.com/stretchr/testify/require"
+)
+
+var msg = Message{
+	To:      "u0@mail.org",
+	Subject: "Sign in",
+	Body:    "Follow the link:\n\nhttp://app.local/magic?token=xxx\n",
+}
+
+func TestEncode(t *testing.T) {
+	date := time.Date(2021, 3, 1, 10, 0, 0, 0, time.UTC)
+
+	t.Run("Message", func(t *testing.T) {
+		expected := "From: guard@localhost\r\n" +
+			"To: u0@mail.org\r\n" +
+			"Subject: Sign in\r\n" +
+			"Date: Mon, 01 Mar 2021 10:00:00 +0000\r\n" +
+			"MIME-Version: 1.0\r\n" +
+			"Content-Type: text/plain; charset=utf-8\r\n" +
+			"\r\n" +
+			"Follow the link:\r\n\r\nhttp://app.local/magic?token=xxx\r\n"
+
+		require.Equal(t, expected, string(encode("guard@localhost", msg, date)))
+	})
+
+	t.Run("HeaderInjection", func(t *testing.T) {
+		injected := Message{To: "u0@mail.org\r\nBcc: u1@mail.org", Subject: "Sign in"}
+
+		data := string(encode("guard@localhost", injected, date))
+		require.Contains(t, data, "To: u0@mail.orgBcc: u1@mail.org\r\n")
+		require.NotContains(t, data, "\r\nBcc:")
+	})
+
+	t.Run("EncodedSubject", func(t *testing.T) {
+		data := string(encode("guard@localhost", Message{Subject: "Вход"}, date))
+		require.Contains(t, data, "Subject: =?utf-8?q?")
+	})
+}
+
+func TestLog(t *testing.T) {
+	prev := log.Logger
+	t.Cleanup(func() { log.Logger = prev })
+
+	out := &strings.Builder{}
+
+	log.Logger = zerolog.New(out).Level(zerolog.InfoLevel)
+	require.NoError(t, Log().Send(msg))
+	require.Contains(t, out.String(), msg.To)
+	require.NotContains(t, out.String(), "token=xxx")
+
+	out.Reset()
+
+	log.Logger = zerolog.New(out).Level(zerolog.DebugLevel)
+	require.NoError(t, Log().Send(msg))
+	require.Contains(t, out.String(), "token=xxx")
+}
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+
+	require.NoError(t, File(dir, "guard@localhost").Send(msg))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*-u0@mail.org.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	data, err := ioutil.ReadFile(files[0])
+	require.NoError(t, err)
+	require.Contains(t, string(data), "To: u0@mail.org\r\n")
+	require.Contains(t, string(data), "http://app.local/magic?token=xxx")
+}
+
+// serveSMTP accepts a single SMTP session and sends the received message to
+// the channel returned.
+func serveSMTP(ln net.Listener) <-chan string {
+	received := make(chan string, 1)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := textproto.NewReader(bufio.NewReader(conn))
+		w := textproto.NewWriter(bufio.NewWriter(conn))
+
+		w.PrintfLine("220 localhost ready")
+		for {
+			line, err := r.ReadLine()
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.Fields(line)[0]); cmd {
+			case "EHLO", "HELO":
+				w.PrintfLine("250 localhost")
+			case "MAIL", "RCPT":
+				w.PrintfLine("250 ok")
+			case "DATA":
+				w.PrintfLine("354 go ahead")
+				data, err := r.ReadDotBytes()
+				if err != nil {
+					return
+				}
+				received <- string(data)
+				w.PrintfLine("250 ok")
+			case "QUIT":
+				w.PrintfLine("221 bye")
+				return
+			default:
+				w.PrintfLine(fmt.Sprintf("502 %s not implemented", cmd))
+			}
+		}
+	}()
+
+	return received
+}
+
+func TestSMTP(t *testing.T) {
+	t.Run("Success", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer ln.Close()
+
+		received := serveSMTP(ln)
+
+		mailer, err := SMTP(ln.Addr().String(), "guard@localhost", "", "")
+		require.NoError(t, err)
+		require.NoError(t, mailer.Send(msg))
+
+		data := <-received
+		require.Contains(t, data, "To: u0@mail.org\n")
+		require.Contains(t, data, "http://app.local/magic?token=xxx")
+	})
+
+	t.Run("InvalidAddress", func(t *testing.T) {
+		_, err := SMTP("xxx", "guard@localhost", "", "")
+		require.Error(t, err)
+	})
+
+	t.Run("ConnectionFailed", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		require.NoError(t, err)
+		addr := ln.Addr().String()
+		ln.Close()
+
+		mailer, err := SMTP(addr, "guard@localhost", "", "")
+		require.NoError(t, err)
+		require.Error(t, mailer.Send(msg))
+	})
+}
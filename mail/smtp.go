@@ -0,0 +1,40 @@
+package mail
+
+import (
+	"fmt"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+type smtpMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// SMTP returns a mailer that sends messages through the SMTP server at addr.
+// PLAIN authentication is used if the username is given.
+func SMTP(addr, from, username, password string) (Mailer, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp address: %w", err)
+	}
+
+	var auth smtp.Auth
+	if username != "" {
+		auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return &smtpMailer{addr: addr, from: from, auth: auth}, nil
+}
+
+func (m *smtpMailer) Send(msg Message) error {
+	data := encode(m.from, msg, time.Now())
+
+	if err := smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, data); err != nil {
+		return fmt.Errorf("failed to send mail: %w", err)
+	}
+
+	return nil
+}
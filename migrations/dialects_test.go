@@ -0,0 +1,39 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialects(t *testing.T) {
+	latest := map[string]int64{}
+
+	for name, d := range dialects {
+		migrations, err := load(d.dir)
+		require.NoError(t, err, name)
+		require.NotEmpty(t, migrations, name)
+
+		for i, m := range migrations {
+			require.NotEmpty(t, m.Name, "%s %d has no up script", name, m.Version)
+			require.NotEmpty(t, m.down, "%s %d has no down script", name, m.Version)
+			require.NotEmpty(t, statements(m.up), "%s %d has empty up script", name, m.Version)
+			if i > 0 {
+				require.Less(t, migrations[i-1].Version, m.Version)
+			}
+		}
+
+		latest[name] = migrations[len(migrations)-1].Version
+	}
+
+	for name, version := range latest {
+		require.Equal(t, latest["postgres"], version, "%s is behind postgres", name)
+	}
+}
+
+func TestStatements(t *testing.T) {
+	require.Equal(t, []string{
+		"CREATE TABLE a (id INTEGER)",
+		"CREATE INDEX a_idx ON a(id)",
+	}, statements("CREATE TABLE a (id INTEGER);\n\nCREATE INDEX a_idx ON a(id);\n"))
+}
@@ -1,3 +0,0 @@
-#!/bin/sh
-
-exec /migrate -path /src/pg -database ${LOGIND_DSN} up
@@ -0,0 +1,382 @@
+// Package migrations keeps the database schema migrations of every supported
+// dialect and applies them.
+//
+// The Postgres migrations are the full history of the schema. The MySQL and
+// SQLite migrations start with the baseline of version 140 and follow the
+// Postgres versions after it. The applied version is kept in the
+// schema_migrations table in the golang-migrate format, so the databases
+// migrated by golang-migrate are picked up as is.
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed pg/*.sql mysql/*.sql sqlite/*.sql
+var files embed.FS
+
+// lockID is the key of the Postgres advisory lock and the name of the MySQL
+// named lock held while migrating.
+const lockID = 7414636574
+
+var ErrDirty = errors.New("database is dirty, fix the schema and the schema_migrations table manually")
+
+// Migration is a schema change. The version is the number prefix of the
+// migration file names.
+type Migration struct {
+	Version int64
+	Name    string
+	up      string
+	down    string
+}
+
+// Status is the applied version and the migrations known to the binary.
+type Status struct {
+	Version    int64
+	Dirty      bool
+	Migrations []Migration
+}
+
+// Pending returns the migrations not applied yet.
+func (s Status) Pending() []Migration {
+	pending := []Migration{}
+	for _, m := range s.Migrations {
+		if m.Version > s.Version {
+			pending = append(pending, m)
+		}
+	}
+	return pending
+}
+
+type Migrator interface {
+	// Up applies all the pending migrations and returns them.
+	Up() ([]Migration, error)
+	// Down reverts up to steps applied migrations and returns them.
+	Down(steps int) ([]Migration, error)
+	Status() (Status, error)
+}
+
+type dialect struct {
+	dir    string
+	lock   string
+	unlock string
+	// table counts the schema_migrations tables of the current schema.
+	table string
+}
+
+// dialects are keyed by the gorm dialector names. SQLite databases are not
+// shared by replicas, so they are not locked.
+var dialects = map[string]dialect{
+	"postgres": {
+		dir:    "pg",
+		lock:   fmt.Sprintf("SELECT pg_advisory_lock(%d)", lockID),
+		unlock: fmt.Sprintf("SELECT pg_advisory_unlock(%d)", lockID),
+		table:  "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'schema_migrations'",
+	},
+	"mysql": {
+		dir:    "mysql",
+		lock:   fmt.Sprintf("SELECT GET_LOCK('%d', -1)", lockID),
+		unlock: fmt.Sprintf("SELECT RELEASE_LOCK('%d')", lockID),
+		table:  "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_migrations'",
+	},
+	"sqlite": {
+		dir:   "sqlite",
+		table: "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'",
+	},
+}
+
+type migrator struct {
+	db         *sql.DB
+	dialect    dialect
+	migrations []Migration
+}
+
+// New returns the migrator of the database of the dialect given.
+func New(db *sql.DB, name string) (Migrator, error) {
+	d, ok := dialects[name]
+	if !ok {
+		return nil, fmt.Errorf("migrations are not supported for %v", name)
+	}
+
+	migrations, err := load(d.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &migrator{db: db, dialect: d, migrations: migrations}, nil
+}
+
+// load reads the migrations of the directory sorted by version.
+func load(dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]*Migration{}
+
+	for _, entry := range entries {
+		name := entry.Name()
+
+		parts := strings.SplitN(strings.TrimSuffix(name, ".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name: %v", name)
+		}
+
+		version, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name: %v", name)
+		}
+
+		data, err := files.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version}
+			byVersion[version] = m
+		}
+
+		switch {
+		case strings.HasSuffix(parts[1], ".up"):
+			m.Name = strings.TrimSuffix(parts[1], ".up")
+			m.up = string(data)
+		case strings.HasSuffix(parts[1], ".down"):
+			m.down = string(data)
+		default:
+			return nil, fmt.Errorf("invalid migration file name: %v", name)
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// statements splits the script by semicolons, the scripts have no semicolons
+// inside the statements.
+func statements(script string) []string {
+	stmts := []string{}
+	for _, stmt := range strings.Split(script, ";") {
+		if stmt = strings.TrimSpace(stmt); stmt != "" {
+			stmts = append(stmts, stmt)
+		}
+	}
+	return stmts
+}
+
+func (m *migrator) Up() ([]Migration, error) {
+	applied := []Migration{}
+
+	err := m.locked(func(conn *sql.Conn) error {
+		version, err := m.version(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			if mg.Version <= version {
+				continue
+			}
+
+			if err := m.apply(conn, mg.up, mg.Version); err != nil {
+				return fmt.Errorf("failed to apply migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+
+			applied = append(applied, mg)
+		}
+
+		return nil
+	})
+
+	return applied, err
+}
+
+func (m *migrator) Down(steps int) ([]Migration, error) {
+	reverted := []Migration{}
+
+	err := m.locked(func(conn *sql.Conn) error {
+		version, err := m.version(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			mg := m.migrations[i]
+			if mg.Version > version {
+				continue
+			}
+
+			var prev int64
+			if i > 0 {
+				prev = m.migrations[i-1].Version
+			}
+
+			if err := m.apply(conn, mg.down, prev); err != nil {
+				return fmt.Errorf("failed to revert migration %d_%s: %w", mg.Version, mg.Name, err)
+			}
+
+			reverted = append(reverted, mg)
+		}
+
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status reads the applied version without changing the database, a database
+// without the schema_migrations table is at version 0.
+func (m *migrator) Status() (Status, error) {
+	status := Status{Migrations: m.migrations}
+
+	ctx := context.Background()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return status, err
+	}
+	defer conn.Close()
+
+	var tables int
+	if err := conn.QueryRowContext(ctx, m.dialect.table).Scan(&tables); err != nil {
+		return status, err
+	}
+
+	if tables == 0 {
+		return status, nil
+	}
+
+	status.Version, status.Dirty, err = m.read(conn)
+	return status, err
+}
+
+// locked runs fn holding the migration lock. The lock belongs to the database
+// session, so everything runs on a single connection.
+func (m *migrator) locked(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if m.dialect.lock != "" {
+		if _, err := conn.ExecContext(ctx, m.dialect.lock); err != nil {
+			return fmt.Errorf("failed to lock migrations: %w", err)
+		}
+		defer conn.ExecContext(ctx, m.dialect.unlock)
+	}
+
+	if err := m.ensureTable(conn); err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func (m *migrator) ensureTable(conn *sql.Conn) error {
+	_, err := conn.ExecContext(
+		context.Background(),
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version BIGINT NOT NULL PRIMARY KEY, dirty BOOLEAN NOT NULL)",
+	)
+	return err
+}
+
+func (m *migrator) read(conn *sql.Conn) (int64, bool, error) {
+	var version int64
+	var dirty bool
+
+	err := conn.QueryRowContext(context.Background(), "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+
+	return version, dirty, err
+}
+
+// version returns the applied version of the clean database.
+func (m *migrator) version(conn *sql.Conn) (int64, error) {
+	version, dirty, err := m.read(conn)
+	if err != nil {
+		return 0, err
+	}
+
+	if dirty {
+		return version, fmt.Errorf("%w: version %d", ErrDirty, version)
+	}
+
+	return version, nil
+}
+
+// execer is either the connection or the transaction of the migration.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// setVersion replaces the version stored, the version 0 is kept only while
+// dirty.
+func setVersion(ctx context.Context, db execer, version int64, dirty bool) error {
+	if _, err := db.ExecContext(ctx, "DELETE FROM schema_migrations"); err != nil {
+		return err
+	}
+
+	if version > 0 || dirty {
+		stmt := fmt.Sprintf("INSERT INTO schema_migrations (version, dirty) VALUES (%d, %s)", version, strings.ToUpper(strconv.FormatBool(dirty)))
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// apply marks the database dirty at the version given, then runs the script
+// and stores the clean version in a single transaction, as golang-migrate
+// does. A failed migration leaves the database dirty until it is fixed
+// manually, MySQL commits the schema changes implicitly, so a part of them
+// may be applied.
+func (m *migrator) apply(conn *sql.Conn, script string, version int64) error {
+	ctx := context.Background()
+
+	if err := setVersion(ctx, conn, version, true); err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range statements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	if err := setVersion(ctx, tx, version, false); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
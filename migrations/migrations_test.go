@@ -0,0 +1,229 @@
+package migrations_test
+
+import (
+	"database/sql"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/markbates/goth"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/vbogretsov/guard/auth"
+	"github.com/vbogretsov/guard/migrations"
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+func openSQLite(t *testing.T) (*gorm.DB, *sql.DB) {
+	dsn := filepath.Join(t.TempDir(), "guard.db")
+
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+
+	sqldb, err := db.DB()
+	require.NoError(t, err)
+
+	return db, sqldb
+}
+
+func TestMigrator(t *testing.T) {
+	db, sqldb := openSQLite(t)
+
+	m, err := migrations.New(sqldb, "sqlite")
+	require.NoError(t, err)
+
+	t.Run("Status", func(t *testing.T) {
+		status, err := m.Status()
+		require.NoError(t, err)
+		require.Equal(t, int64(0), status.Version)
+		require.False(t, status.Dirty)
+		require.NotEmpty(t, status.Migrations)
+		require.Equal(t, status.Migrations, status.Pending())
+		require.False(t, db.Migrator().HasTable("schema_migrations"), "status changed the database")
+	})
+
+	t.Run("Up", func(t *testing.T) {
+		applied, err := m.Up()
+		require.NoError(t, err)
+		require.NotEmpty(t, applied)
+
+		status, err := m.Status()
+		require.NoError(t, err)
+		require.Equal(t, applied[len(applied)-1].Version, status.Version)
+		require.Empty(t, status.Pending())
+
+		applied, err = m.Up()
+		require.NoError(t, err)
+		require.Empty(t, applied)
+	})
+
+	t.Run("Schema", func(t *testing.T) {
+		user := model.User{ID: "123", Name: "u0@mail.org", Created: 1000000000}
+		require.NoError(t, db.Create(&user).Error)
+
+		for _, value := range []interface{}{
+			&model.RefreshToken{ID: "rt.123", UserID: user.ID, Family: "rt.123", Used: true},
+			&model.Session{ID: "session.123", Value: "value"},
+			&model.Client{ID: "client.123", Scopes: "read"},
+			&model.Credential{UserID: user.ID, PasswordHash: "hash"},
+			&model.TOTP{UserID: user.ID, Secret: "secret", Enabled: true},
+			&model.Passkey{ID: "passkey.123", UserID: user.ID, PublicKey: []byte{1}},
+			&model.UserIdentity{Provider: "google", Subject: "google.123", UserID: user.ID},
+			&model.Profile{Provider: "google", Subject: "google.123", UserID: user.ID, EmailVerified: true},
+			&model.WebhookEvent{ID: "webhook.123", URL: "http://a.example.org", Payload: "{}"},
+			&model.ProviderToken{Provider: "google", Subject: "google.123", UserID: user.ID, AccessToken: "access"},
+		} {
+			require.NoError(t, db.Create(value).Error, "failed to create %T", value)
+		}
+	})
+
+	t.Run("Down", func(t *testing.T) {
+		status, err := m.Status()
+		require.NoError(t, err)
+
+		reverted, err := m.Down(1)
+		require.NoError(t, err)
+		require.Len(t, reverted, 1)
+		require.Equal(t, status.Version, reverted[0].Version)
+
+		_, err = m.Down(len(status.Migrations))
+		require.NoError(t, err)
+
+		status, err = m.Status()
+		require.NoError(t, err)
+		require.Equal(t, int64(0), status.Version)
+		require.False(t, db.Migrator().HasTable(&model.User{}))
+	})
+
+	t.Run("Dirty", func(t *testing.T) {
+		_, err := sqldb.Exec("INSERT INTO schema_migrations (version, dirty) VALUES (140, TRUE)")
+		require.NoError(t, err)
+
+		_, err = m.Up()
+		require.ErrorIs(t, err, migrations.ErrDirty)
+
+		status, err := m.Status()
+		require.NoError(t, err)
+		require.True(t, status.Dirty)
+	})
+}
+
+func TestMigratorFailed(t *testing.T) {
+	db, sqldb := openSQLite(t)
+
+	// The initial migration fails creating the existing table.
+	require.NoError(t, db.Exec("CREATE TABLE users (id TEXT)").Error)
+
+	m, err := migrations.New(sqldb, "sqlite")
+	require.NoError(t, err)
+
+	_, err = m.Up()
+	require.Error(t, err)
+
+	status, err := m.Status()
+	require.NoError(t, err)
+	require.True(t, status.Dirty)
+	require.Equal(t, status.Migrations[0].Version, status.Version)
+
+	_, err = m.Up()
+	require.ErrorIs(t, err, migrations.ErrDirty)
+}
+
+func TestMigratorConcurrent(t *testing.T) {
+	_, sqldb := openSQLite(t)
+
+	// The single connection serializes the migrators as the lock of the
+	// database servers does.
+	sqldb.SetMaxOpenConns(1)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4)
+
+	for i := 0; i < cap(errs); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			m, err := migrations.New(sqldb, "sqlite")
+			if err == nil {
+				_, err = m.Up()
+			}
+			errs <- err
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		require.NoError(t, err)
+	}
+}
+
+func TestMigratorVerifiedBackfill(t *testing.T) {
+	db, sqldb := openSQLite(t)
+
+	m, err := migrations.New(sqldb, "sqlite")
+	require.NoError(t, err)
+
+	_, err = m.Up()
+	require.NoError(t, err)
+
+	status, err := m.Status()
+	require.NoError(t, err)
+
+	// Downgrade below 170_user_verified to create the users of an old release.
+	steps := 0
+	for _, mg := range status.Migrations {
+		if mg.Version >= 170 {
+			steps++
+		}
+	}
+	_, err = m.Down(steps)
+	require.NoError(t, err)
+
+	for _, stmt := range []string{
+		"INSERT INTO users (id, name, created) VALUES ('provider', 'u0@mail.org', 1)",
+		"INSERT INTO users (id, name, created) VALUES ('password', 'u1@mail.org', 1)",
+		"INSERT INTO credentials (user_id, password_hash) VALUES ('password', 'hash')",
+		"INSERT INTO users (id, name, created) VALUES ('linked', 'u2@mail.org', 1)",
+		"INSERT INTO credentials (user_id, password_hash) VALUES ('linked', 'hash')",
+		"INSERT INTO profiles (provider, subject, user_id, email_verified) VALUES ('google', 'google.2', 'linked', TRUE)",
+	} {
+		_, err := sqldb.Exec(stmt)
+		require.NoError(t, err)
+	}
+
+	_, err = m.Up()
+	require.NoError(t, err)
+
+	for id, verified := range map[string]bool{"provider": true, "password": false, "linked": true} {
+		var user model.User
+		require.NoError(t, db.First(&user, "id = ?", id).Error)
+		require.Equal(t, verified, user.Verified, id)
+	}
+
+	// The provider user created before the identities has no identity row,
+	// the sign in links the identity to the user.
+	conn := repo.NewConn(db)
+	resolver := auth.NewIdentityResolver(conn, &auth.RealTimer{}, repo.NewUsers(conn), repo.NewIdentities(conn), auth.LinkVerified)
+
+	user, err := resolver.Resolve("google", goth.User{
+		UserID:  "google.0",
+		Email:   "u0@mail.org",
+		RawData: map[string]interface{}{"email_verified": true},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "provider", user.ID)
+}
+
+func TestUnsupportedDialect(t *testing.T) {
+	_, err := migrations.New(nil, "xxx")
+	require.Error(t, err)
+}
@@ -0,0 +1,11 @@
+DROP TABLE provider_tokens;
+DROP TABLE webhook_events;
+DROP TABLE profiles;
+DROP TABLE user_identities;
+DROP TABLE passkeys;
+DROP TABLE totps;
+DROP TABLE credentials;
+DROP TABLE clients;
+DROP TABLE sessions;
+DROP TABLE refresh_tokens;
+DROP TABLE users;
@@ -0,0 +1,119 @@
+CREATE TABLE users (
+    id       VARCHAR(32) PRIMARY KEY NOT NULL,
+    name     VARCHAR(255) UNIQUE NOT NULL,
+    created  INTEGER
+);
+
+CREATE TABLE refresh_tokens (
+    id          VARCHAR(64) PRIMARY KEY NOT NULL,
+    user_id     VARCHAR(64) NOT NULL REFERENCES users(id),
+    family      VARCHAR(64) NOT NULL DEFAULT '',
+    provider    VARCHAR(64) NOT NULL DEFAULT '',
+    client_id   VARCHAR(64) NOT NULL DEFAULT '',
+    methods     TEXT NOT NULL,
+    used        BOOLEAN NOT NULL DEFAULT FALSE,
+    created     INTEGER,
+    expires     INTEGER
+);
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens(family);
+CREATE INDEX refresh_tokens_expires_idx ON refresh_tokens(expires);
+
+CREATE TABLE sessions (
+    id          VARCHAR(128) PRIMARY KEY NOT NULL,
+    value       TEXT NOT NULL,
+    created     INTEGER,
+    expires     INTEGER
+);
+
+CREATE INDEX sessions_expires_idx ON sessions(expires);
+
+CREATE TABLE clients (
+    id          VARCHAR(64) PRIMARY KEY NOT NULL,
+    secret_hash VARCHAR(255) NOT NULL DEFAULT '',
+    redirects   TEXT NOT NULL,
+    providers   TEXT NOT NULL,
+    scopes      TEXT NOT NULL,
+    access_ttl  INTEGER NOT NULL DEFAULT 0,
+    refresh_ttl INTEGER NOT NULL DEFAULT 0,
+    created     INTEGER
+);
+
+CREATE TABLE credentials (
+    user_id       VARCHAR(64) PRIMARY KEY NOT NULL REFERENCES users(id),
+    password_hash VARCHAR(255) NOT NULL,
+    updated       INTEGER
+);
+
+CREATE TABLE totps (
+    user_id        VARCHAR(64) PRIMARY KEY NOT NULL REFERENCES users(id),
+    secret         VARCHAR(255) NOT NULL,
+    recovery_codes TEXT NOT NULL,
+    enabled        BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step      BIGINT NOT NULL DEFAULT 0,
+    created        INTEGER
+);
+
+CREATE TABLE passkeys (
+    id         VARCHAR(255) PRIMARY KEY NOT NULL,
+    user_id    VARCHAR(64) NOT NULL REFERENCES users(id),
+    public_key BLOB NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    created    INTEGER,
+    last_used  INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX passkeys_user_id_idx ON passkeys(user_id);
+
+CREATE TABLE user_identities (
+    provider VARCHAR(64) NOT NULL,
+    subject  VARCHAR(255) NOT NULL,
+    user_id  VARCHAR(64) NOT NULL REFERENCES users(id),
+    email    VARCHAR(255) NOT NULL DEFAULT '',
+    created  INTEGER,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities(user_id);
+
+CREATE TABLE profiles (
+    provider       VARCHAR(64) NOT NULL,
+    subject        VARCHAR(255) NOT NULL,
+    user_id        VARCHAR(64) NOT NULL REFERENCES users(id),
+    name           VARCHAR(255) NOT NULL DEFAULT '',
+    avatar_url     TEXT NOT NULL,
+    locale         VARCHAR(32) NOT NULL DEFAULT '',
+    email          VARCHAR(255) NOT NULL DEFAULT '',
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    raw_data       TEXT NOT NULL,
+    updated        INTEGER,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX profiles_user_id_idx ON profiles(user_id);
+
+CREATE TABLE webhook_events (
+    id           VARCHAR(64) PRIMARY KEY NOT NULL,
+    url          TEXT NOT NULL,
+    payload      TEXT NOT NULL,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    next_attempt INTEGER NOT NULL,
+    last_error   TEXT NOT NULL,
+    failed       BOOLEAN NOT NULL DEFAULT FALSE,
+    created      INTEGER
+);
+
+CREATE INDEX webhook_events_next_attempt_idx ON webhook_events(next_attempt);
+
+CREATE TABLE provider_tokens (
+    provider      VARCHAR(64) NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    user_id       VARCHAR(64) NOT NULL REFERENCES users(id),
+    access_token  TEXT NOT NULL,
+    refresh_token TEXT NOT NULL,
+    expires       INTEGER NOT NULL DEFAULT 0,
+    updated       INTEGER,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX provider_tokens_user_id_idx ON provider_tokens(user_id);
@@ -0,0 +1 @@
+ALTER TABLE sessions ADD COLUMN used BOOLEAN NOT NULL DEFAULT FALSE;
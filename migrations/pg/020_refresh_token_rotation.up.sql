@@ -0,0 +1,6 @@
+ALTER TABLE refresh_tokens ADD COLUMN family VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN used BOOLEAN NOT NULL DEFAULT FALSE;
+
+UPDATE refresh_tokens SET family = id;
+
+CREATE INDEX refresh_tokens_family_idx ON refresh_tokens(family);
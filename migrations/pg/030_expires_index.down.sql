@@ -0,0 +1,2 @@
+DROP INDEX refresh_tokens_expires_idx;
+DROP INDEX sessions_expires_idx;
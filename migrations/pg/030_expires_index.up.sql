@@ -0,0 +1,2 @@
+CREATE INDEX sessions_expires_idx ON sessions(expires);
+CREATE INDEX refresh_tokens_expires_idx ON refresh_tokens(expires);
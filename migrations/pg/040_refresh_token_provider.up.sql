@@ -0,0 +1 @@
+ALTER TABLE refresh_tokens ADD COLUMN provider VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,2 @@
+DELETE FROM sessions WHERE LENGTH(id) > 64;
+ALTER TABLE sessions ALTER COLUMN id TYPE VARCHAR(64);
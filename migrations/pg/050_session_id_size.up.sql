@@ -0,0 +1 @@
+ALTER TABLE sessions ALTER COLUMN id TYPE VARCHAR(128);
@@ -0,0 +1,11 @@
+CREATE TABLE clients (
+    id          VARCHAR(64) PRIMARY KEY NOT NULL,
+    secret_hash VARCHAR(255) NOT NULL DEFAULT '',
+    redirects   TEXT NOT NULL DEFAULT '',
+    providers   TEXT NOT NULL DEFAULT '',
+    access_ttl  INTEGER NOT NULL DEFAULT 0,
+    refresh_ttl INTEGER NOT NULL DEFAULT 0,
+    created     INTEGER
+);
+
+ALTER TABLE refresh_tokens ADD COLUMN client_id VARCHAR(64) NOT NULL DEFAULT '';
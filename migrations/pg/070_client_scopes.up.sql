@@ -0,0 +1 @@
+ALTER TABLE clients ADD COLUMN scopes TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+CREATE TABLE credentials (
+    user_id       VARCHAR(64) PRIMARY KEY NOT NULL REFERENCES users(id),
+    password_hash VARCHAR(255) NOT NULL,
+    updated       INTEGER
+);
@@ -0,0 +1,3 @@
+ALTER TABLE refresh_tokens DROP COLUMN methods;
+
+DROP TABLE totps;
@@ -0,0 +1,10 @@
+CREATE TABLE totps (
+    user_id        VARCHAR(64) PRIMARY KEY NOT NULL REFERENCES users(id),
+    secret         VARCHAR(255) NOT NULL,
+    recovery_codes TEXT NOT NULL DEFAULT '',
+    enabled        BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step      BIGINT NOT NULL DEFAULT 0,
+    created        INTEGER
+);
+
+ALTER TABLE refresh_tokens ADD COLUMN methods TEXT NOT NULL DEFAULT '';
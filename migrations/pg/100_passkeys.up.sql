@@ -0,0 +1,10 @@
+CREATE TABLE passkeys (
+    id         VARCHAR(255) PRIMARY KEY NOT NULL,
+    user_id    VARCHAR(64) NOT NULL REFERENCES users(id),
+    public_key BYTEA NOT NULL,
+    sign_count BIGINT NOT NULL DEFAULT 0,
+    created    INTEGER,
+    last_used  INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE INDEX passkeys_user_id_idx ON passkeys(user_id);
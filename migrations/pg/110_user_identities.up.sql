@@ -0,0 +1,10 @@
+CREATE TABLE user_identities (
+    provider VARCHAR(64) NOT NULL,
+    subject  VARCHAR(255) NOT NULL,
+    user_id  VARCHAR(64) NOT NULL REFERENCES users(id),
+    email    VARCHAR(255) NOT NULL DEFAULT '',
+    created  INTEGER,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX user_identities_user_id_idx ON user_identities(user_id);
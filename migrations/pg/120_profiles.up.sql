@@ -0,0 +1,15 @@
+CREATE TABLE profiles (
+    provider       VARCHAR(64) NOT NULL,
+    subject        VARCHAR(255) NOT NULL,
+    user_id        VARCHAR(64) NOT NULL REFERENCES users(id),
+    name           VARCHAR(255) NOT NULL DEFAULT '',
+    avatar_url     TEXT NOT NULL DEFAULT '',
+    locale         VARCHAR(32) NOT NULL DEFAULT '',
+    email          VARCHAR(255) NOT NULL DEFAULT '',
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    raw_data       TEXT NOT NULL DEFAULT '',
+    updated        INTEGER,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX profiles_user_id_idx ON profiles(user_id);
@@ -0,0 +1,12 @@
+CREATE TABLE webhook_events (
+    id           VARCHAR(64) PRIMARY KEY NOT NULL,
+    url          TEXT NOT NULL,
+    payload      TEXT NOT NULL,
+    attempts     INTEGER NOT NULL DEFAULT 0,
+    next_attempt INTEGER NOT NULL,
+    last_error   TEXT NOT NULL DEFAULT '',
+    failed       BOOLEAN NOT NULL DEFAULT FALSE,
+    created      INTEGER
+);
+
+CREATE INDEX webhook_events_next_attempt_idx ON webhook_events(next_attempt);
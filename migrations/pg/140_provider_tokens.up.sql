@@ -0,0 +1,12 @@
+CREATE TABLE provider_tokens (
+    provider      VARCHAR(64) NOT NULL,
+    subject       VARCHAR(255) NOT NULL,
+    user_id       VARCHAR(64) NOT NULL REFERENCES users(id),
+    access_token  TEXT NOT NULL,
+    refresh_token TEXT NOT NULL DEFAULT '',
+    expires       INTEGER NOT NULL DEFAULT 0,
+    updated       INTEGER,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX provider_tokens_user_id_idx ON provider_tokens(user_id);
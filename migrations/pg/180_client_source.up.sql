@@ -0,0 +1 @@
+ALTER TABLE clients ADD COLUMN source VARCHAR(16) NOT NULL DEFAULT '';
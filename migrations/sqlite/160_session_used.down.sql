@@ -0,0 +1,15 @@
+-- SQLite before 3.35 has no DROP COLUMN, the table is rebuilt.
+CREATE TABLE sessions_down (
+    id          VARCHAR(128) PRIMARY KEY NOT NULL,
+    value       TEXT NOT NULL,
+    created     INTEGER,
+    expires     INTEGER
+);
+
+INSERT INTO sessions_down (id, value, created, expires) SELECT id, value, created, expires FROM sessions;
+
+DROP TABLE sessions;
+
+ALTER TABLE sessions_down RENAME TO sessions;
+
+CREATE INDEX sessions_expires_idx ON sessions(expires);
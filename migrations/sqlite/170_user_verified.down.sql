@@ -0,0 +1,13 @@
+-- SQLite before 3.35 has no DROP COLUMN, the table is rebuilt.
+CREATE TABLE users_down (
+    id       VARCHAR(32) PRIMARY KEY NOT NULL,
+    name     VARCHAR(255) UNIQUE NOT NULL,
+    created  INTEGER,
+    disabled BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+INSERT INTO users_down (id, name, created, disabled) SELECT id, name, created, disabled FROM users;
+
+DROP TABLE users;
+
+ALTER TABLE users_down RENAME TO users;
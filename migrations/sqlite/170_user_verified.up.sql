@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Users without a password were created by a provider or a magic link, and
+-- the providers have reported the emails they verified.
+UPDATE users SET verified = TRUE
+WHERE id NOT IN (SELECT user_id FROM credentials)
+   OR id IN (SELECT user_id FROM profiles WHERE email_verified = TRUE);
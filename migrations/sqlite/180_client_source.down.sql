@@ -0,0 +1,18 @@
+-- SQLite before 3.35 has no DROP COLUMN, the table is rebuilt.
+CREATE TABLE clients_down (
+    id          VARCHAR(64) PRIMARY KEY NOT NULL,
+    secret_hash VARCHAR(255) NOT NULL DEFAULT '',
+    redirects   TEXT NOT NULL DEFAULT '',
+    providers   TEXT NOT NULL DEFAULT '',
+    scopes      TEXT NOT NULL DEFAULT '',
+    access_ttl  INTEGER NOT NULL DEFAULT 0,
+    refresh_ttl INTEGER NOT NULL DEFAULT 0,
+    created     INTEGER
+);
+
+INSERT INTO clients_down (id, secret_hash, redirects, providers, scopes, access_ttl, refresh_ttl, created)
+SELECT id, secret_hash, redirects, providers, scopes, access_ttl, refresh_ttl, created FROM clients;
+
+DROP TABLE clients;
+
+ALTER TABLE clients_down RENAME TO clients;
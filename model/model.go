@@ -1,17 +1,24 @@
 package model
 
 type User struct {
-	ID      string
-	Name    string
-	Created int64
+	ID       string
+	Name     string
+	Disabled bool
+	Verified bool
+	Created  int64
 }
 
 type RefreshToken struct {
-	ID      string
-	UserID  string
-	User    User
-	Created int64
-	Expires int64
+	ID       string
+	UserID   string
+	User     User
+	Family   string
+	Provider string
+	ClientID string
+	Methods  string
+	Used     bool
+	Created  int64
+	Expires  int64
 }
 
 type Session struct {
@@ -19,4 +26,93 @@ type Session struct {
 	Value   string
 	Created int64
 	Expires int64
+	Used    bool
+}
+
+type Client struct {
+	ID         string
+	SecretHash string
+	Redirects  string
+	Providers  string
+	Scopes     string
+	AccessTTL  int64
+	RefreshTTL int64
+	Source     string
+	Created    int64
+}
+
+type Credential struct {
+	UserID       string `gorm:"primaryKey"`
+	PasswordHash string
+	Updated      int64
+}
+
+type TOTP struct {
+	UserID        string `gorm:"primaryKey"`
+	Secret        string
+	RecoveryCodes string
+	Enabled       bool
+	LastStep      int64
+	Created       int64
+}
+
+// Passkey is a WebAuthn credential of the user. The ID is the base64url
+// encoded credential ID and the public key is COSE encoded.
+type Passkey struct {
+	ID        string `gorm:"primaryKey"`
+	UserID    string
+	PublicKey []byte
+	SignCount int64
+	Created   int64
+	LastUsed  int64
+}
+
+// UserIdentity links the account of an external provider to the user. The
+// subject is the user ID given by the provider.
+type UserIdentity struct {
+	Provider string `gorm:"primaryKey"`
+	Subject  string `gorm:"primaryKey"`
+	UserID   string
+	Email    string
+	Created  int64
+}
+
+// Profile is the user profile given by the provider of the identity. The raw
+// data is the JSON encoded provider payload.
+type Profile struct {
+	Provider      string `gorm:"primaryKey"`
+	Subject       string `gorm:"primaryKey"`
+	UserID        string
+	Name          string
+	AvatarURL     string
+	Locale        string
+	Email         string
+	EmailVerified bool
+	RawData       string
+	Updated       int64
+}
+
+// ProviderToken is the OAuth token given by the provider of the identity.
+// The access and the refresh tokens are encrypted.
+type ProviderToken struct {
+	Provider     string `gorm:"primaryKey"`
+	Subject      string `gorm:"primaryKey"`
+	UserID       string
+	AccessToken  string
+	RefreshToken string
+	Expires      int64
+	Updated      int64
+}
+
+// WebhookEvent is an event waiting to be delivered to a webhook URL. The
+// payload is the JSON encoded event body. A failed event is not retried.
+type WebhookEvent struct {
+	ID          string `gorm:"primaryKey"`
+	URL         string
+	Payload     string
+	Attempts    int
+	NextAttempt int64
+	LastError   string
+	Failed      bool
+	Created     int64
 }
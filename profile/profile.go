@@ -1,7 +1,19 @@
 package profile
 
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/markbates/goth"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
 type Updater interface {
-	Update(userID string, data map[string]interface{}) error
+	Update(userID, provider string, user goth.User) error
 }
 
 type empty struct{}
@@ -10,6 +22,93 @@ func Empty() Updater {
 	return &empty{}
 }
 
-func (e *empty) Update(userID string, data map[string]interface{}) error {
+func (e *empty) Update(userID, provider string, user goth.User) error {
+	return nil
+}
+
+// EmailVerified reports whether the provider claims the user owns the email.
+// OpenID Connect providers use email_verified, Google v2 API verified_email.
+func EmailVerified(user goth.User) bool {
+	for _, key := range []string{"email_verified", "verified_email"} {
+		switch value := user.RawData[key].(type) {
+		case bool:
+			if value {
+				return true
+			}
+		case string:
+			if value == "true" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func rawString(data map[string]interface{}, keys ...string) string {
+	for _, key := range keys {
+		if value, ok := data[key].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// Normalize returns the profile of the provider user. The fields missing in
+// the goth user are looked up in the provider payload.
+func Normalize(userID, provider string, user goth.User) (model.Profile, error) {
+	raw, err := json.Marshal(user.RawData)
+	if err != nil {
+		return model.Profile{}, fmt.Errorf("failed to encode raw data: %w", err)
+	}
+
+	name := user.Name
+	if name == "" {
+		name = strings.TrimSpace(user.FirstName + " " + user.LastName)
+	}
+	if name == "" {
+		name = user.NickName
+	}
+
+	return model.Profile{
+		Provider:      provider,
+		Subject:       user.UserID,
+		UserID:        userID,
+		Name:          name,
+		AvatarURL:     user.AvatarURL,
+		Locale:        rawString(user.RawData, "locale", "lang", "language"),
+		Email:         user.Email,
+		EmailVerified: user.Email != "" && EmailVerified(user),
+		RawData:       string(raw),
+	}, nil
+}
+
+// Timer gives the current time. It is auth.Timer, the package can not import
+// auth which depends on it.
+type Timer interface {
+	Now() time.Time
+}
+
+type db struct {
+	timer    Timer
+	profiles repo.Profiles
+}
+
+// New returns the updater storing the provider profiles in the database.
+func New(timer Timer, profiles repo.Profiles) Updater {
+	return &db{timer: timer, profiles: profiles}
+}
+
+func (u *db) Update(userID, provider string, user goth.User) error {
+	value, err := Normalize(userID, provider, user)
+	if err != nil {
+		return err
+	}
+
+	value.Updated = u.timer.Now().Unix()
+
+	if err := u.profiles.Save(value); err != nil {
+		return fmt.Errorf("failed to save profile: %w", err)
+	}
+
 	return nil
 }
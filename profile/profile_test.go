@@ -0,0 +1,145 @@
+package profile_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/profile"
+)
+
+type profilesMock struct {
+	mock.Mock
+}
+
+func (m *profilesMock) FindByUser(userID string) ([]model.Profile, error) {
+	args := m.Called(userID)
+	return args.Get(0).([]model.Profile), args.Error(1)
+}
+
+func (m *profilesMock) Save(profile model.Profile) error {
+	return m.Called(profile).Error(0)
+}
+
+func (m *profilesMock) Delete(userID, provider string) error {
+	return m.Called(userID, provider).Error(0)
+}
+
+func TestEmailVerified(t *testing.T) {
+	testCases := []struct {
+		name     string
+		data     map[string]interface{}
+		expected bool
+	}{
+		{name: "Missing", data: nil, expected: false},
+		{name: "Bool", data: map[string]interface{}{"email_verified": true}, expected: true},
+		{name: "String", data: map[string]interface{}{"email_verified": "true"}, expected: true},
+		{name: "False", data: map[string]interface{}{"email_verified": false}, expected: false},
+		{name: "Google", data: map[string]interface{}{"verified_email": true}, expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			user := goth.User{Email: "u0@mail.org", RawData: tc.data}
+			require.Equal(t, tc.expected, profile.EmailVerified(user))
+		})
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	t.Run("Full", func(t *testing.T) {
+		user := goth.User{
+			UserID:    "google.123",
+			Name:      "User Zero",
+			Email:     "u0@mail.org",
+			AvatarURL: "https://example.org/u0.png",
+			RawData: map[string]interface{}{
+				"locale":         "en",
+				"email_verified": true,
+			},
+		}
+
+		value, err := profile.Normalize("user.123", "google", user)
+		require.NoError(t, err)
+		require.Equal(t, model.Profile{
+			Provider:      "google",
+			Subject:       "google.123",
+			UserID:        "user.123",
+			Name:          "User Zero",
+			AvatarURL:     "https://example.org/u0.png",
+			Locale:        "en",
+			Email:         "u0@mail.org",
+			EmailVerified: true,
+			RawData:       `{"email_verified":true,"locale":"en"}`,
+		}, value)
+	})
+
+	t.Run("Fallbacks", func(t *testing.T) {
+		value, err := profile.Normalize("user.123", "vk", goth.User{
+			UserID:    "vk.123",
+			FirstName: "User",
+			LastName:  "Zero",
+			RawData:   map[string]interface{}{"lang": "ru", "email_verified": true},
+		})
+		require.NoError(t, err)
+		require.Equal(t, "User Zero", value.Name)
+		require.Equal(t, "ru", value.Locale)
+		require.False(t, value.EmailVerified)
+
+		value, err = profile.Normalize("user.123", "github", goth.User{
+			UserID:   "github.123",
+			NickName: "u0",
+		})
+		require.NoError(t, err)
+		require.Equal(t, "u0", value.Name)
+		require.Equal(t, "null", value.RawData)
+	})
+
+	t.Run("FailOnRawData", func(t *testing.T) {
+		_, err := profile.Normalize("user.123", "google", goth.User{
+			UserID:  "google.123",
+			RawData: map[string]interface{}{"xxx": func() {}},
+		})
+		require.Error(t, err)
+	})
+}
+
+type timerMock struct {
+	value time.Time
+}
+
+func (m *timerMock) Now() time.Time {
+	return m.value
+}
+
+func TestUpdater(t *testing.T) {
+	user := goth.User{UserID: "google.123", Email: "u0@mail.org"}
+	timer := &timerMock{value: time.Unix(1600000000, 0)}
+
+	t.Run("Success", func(t *testing.T) {
+		profiles := &profilesMock{}
+		profiles.On("Save", mock.MatchedBy(func(value model.Profile) bool {
+			return value.Provider == "google" &&
+				value.Subject == "google.123" &&
+				value.UserID == "user.123" &&
+				value.Updated == 1600000000
+		})).Return(nil)
+
+		require.NoError(t, profile.New(timer, profiles).Update("user.123", "google", user))
+		profiles.AssertExpectations(t)
+	})
+
+	t.Run("FailOnSave", func(t *testing.T) {
+		fail := errors.New("xxx")
+
+		profiles := &profilesMock{}
+		profiles.On("Save", mock.Anything).Return(fail)
+
+		require.ErrorIs(t, profile.New(timer, profiles).Update("user.123", "google", user), fail)
+	})
+}
@@ -0,0 +1,254 @@
+package profile
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/markbates/goth"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+const (
+	EventProfileUpdated = "profile.updated"
+
+	HeaderEventID   = "X-Guard-Event"
+	HeaderTimestamp = "X-Guard-Timestamp"
+	HeaderSignature = "X-Guard-Signature"
+
+	maxBackoff = time.Hour
+)
+
+// Event is the body of the webhook requests.
+type Event struct {
+	ID            string          `json:"id"`
+	Type          string          `json:"type"`
+	Created       int64           `json:"created"`
+	UserID        string          `json:"user_id"`
+	Provider      string          `json:"provider"`
+	Subject       string          `json:"subject"`
+	Name          string          `json:"name,omitempty"`
+	AvatarURL     string          `json:"avatar_url,omitempty"`
+	Locale        string          `json:"locale,omitempty"`
+	Email         string          `json:"email,omitempty"`
+	EmailVerified bool            `json:"email_verified"`
+	RawData       json.RawMessage `json:"raw_data,omitempty"`
+}
+
+func newID() string {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// Sign returns the value of the signature header of the request body sent at
+// the time given. It is the hex encoded HMAC-SHA256 of the unix time and the
+// body joined by a dot.
+func Sign(secret []byte, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+type webhook struct {
+	events repo.WebhookEvents
+	urls   []string
+}
+
+// Webhook returns the updater putting a profile event for each URL into the
+// outbox, the events are sent by the Deliverer.
+func Webhook(events repo.WebhookEvents, urls []string) Updater {
+	return &webhook{events: events, urls: urls}
+}
+
+func (u *webhook) Update(userID, provider string, user goth.User) error {
+	value, err := Normalize(userID, provider, user)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().Unix()
+
+	event := Event{
+		ID:            newID(),
+		Type:          EventProfileUpdated,
+		Created:       now,
+		UserID:        value.UserID,
+		Provider:      value.Provider,
+		Subject:       value.Subject,
+		Name:          value.Name,
+		AvatarURL:     value.AvatarURL,
+		Locale:        value.Locale,
+		Email:         value.Email,
+		EmailVerified: value.EmailVerified,
+		RawData:       json.RawMessage(value.RawData),
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+
+	for _, url := range u.urls {
+		err := u.events.Create(model.WebhookEvent{
+			ID:          newID(),
+			URL:         url,
+			Payload:     string(payload),
+			NextAttempt: now,
+			Created:     now,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create webhook event: %w", err)
+		}
+	}
+
+	return nil
+}
+
+type chain struct {
+	updaters []Updater
+}
+
+// Chain returns the updater calling the updaters given one by one.
+func Chain(updaters ...Updater) Updater {
+	return &chain{updaters: updaters}
+}
+
+func (c *chain) Update(userID, provider string, user goth.User) error {
+	for _, u := range c.updaters {
+		if err := u.Update(userID, provider, user); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type Deliverer interface {
+	Deliver() (int, error)
+}
+
+type deliverer struct {
+	events   repo.WebhookEvents
+	client   *http.Client
+	secret   []byte
+	attempts int
+	backoff  time.Duration
+	batch    int
+}
+
+func NewDeliverer(events repo.WebhookEvents, client *http.Client, secret []byte, attempts int, backoff time.Duration, batch int) Deliverer {
+	return &deliverer{
+		events:   events,
+		client:   client,
+		secret:   secret,
+		attempts: attempts,
+		backoff:  backoff,
+		batch:    batch,
+	}
+}
+
+// Deliver sends the due events and returns the number of events delivered.
+// A failed delivery is retried with exponential backoff, the event is marked
+// failed once the attempts are out.
+func (d *deliverer) Deliver() (int, error) {
+	now := time.Now()
+
+	items, err := d.events.FindDue(now.Unix(), d.batch)
+	if err != nil {
+		return 0, fmt.Errorf("failed to find webhook events: %w", err)
+	}
+
+	delivered := 0
+	for _, item := range items {
+		if err := d.send(item, now); err != nil {
+			if err := d.retry(item, err, now); err != nil {
+				return delivered, err
+			}
+			continue
+		}
+
+		if err := d.events.Delete(item.ID); err != nil {
+			return delivered, fmt.Errorf("failed to delete webhook event: %w", err)
+		}
+		delivered++
+	}
+
+	return delivered, nil
+}
+
+func (d *deliverer) send(item model.WebhookEvent, now time.Time) error {
+	body := []byte(item.Payload)
+
+	req, err := http.NewRequest(http.MethodPost, item.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	var event Event
+	if err := json.Unmarshal(body, &event); err == nil {
+		req.Header.Set(HeaderEventID, event.ID)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(d.secret, now.Unix(), body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return nil
+}
+
+func (d *deliverer) retry(item model.WebhookEvent, cause error, now time.Time) error {
+	item.Attempts++
+	item.LastError = cause.Error()
+
+	if item.Attempts >= d.attempts {
+		item.Failed = true
+	} else {
+		item.NextAttempt = now.Add(d.delay(item.Attempts)).Unix()
+	}
+
+	if err := d.events.Update(item); err != nil {
+		return fmt.Errorf("failed to update webhook event: %w", err)
+	}
+
+	return nil
+}
+
+// delay is the backoff doubled after each failed attempt up to an hour.
+func (d *deliverer) delay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+
+	if delay > maxBackoff {
+		return maxBackoff
+	}
+
+	return delay
+}
@@ -0,0 +1,258 @@
+package profile_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/markbates/goth"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/profile"
+)
+
+type webhookEventsMock struct {
+	mock.Mock
+}
+
+func (m *webhookEventsMock) Create(event model.WebhookEvent) error {
+	return m.Called(event).Error(0)
+}
+
+func (m *webhookEventsMock) FindDue(before int64, limit int) ([]model.WebhookEvent, error) {
+	args := m.Called(before, limit)
+
+	value := args.Get(0)
+	if value == nil {
+		return nil, args.Error(1)
+	}
+
+	return value.([]model.WebhookEvent), args.Error(1)
+}
+
+func (m *webhookEventsMock) Update(event model.WebhookEvent) error {
+	return m.Called(event).Error(0)
+}
+
+func (m *webhookEventsMock) Delete(id string) error {
+	return m.Called(id).Error(0)
+}
+
+type updaterMock struct {
+	mock.Mock
+}
+
+func (m *updaterMock) Update(userID, provider string, user goth.User) error {
+	return m.Called(userID, provider, user).Error(0)
+}
+
+func TestWebhook(t *testing.T) {
+	user := goth.User{
+		UserID:  "google.123",
+		Name:    "User Zero",
+		Email:   "u0@mail.org",
+		RawData: map[string]interface{}{"email_verified": true},
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		events := &webhookEventsMock{}
+		events.On("Create", mock.Anything).Return(nil)
+
+		urls := []string{"http://a.example.org/hook", "http://b.example.org/hook"}
+		require.NoError(t, profile.Webhook(events, urls).Update("user.123", "google", user))
+
+		events.AssertNumberOfCalls(t, "Create", 2)
+
+		first := events.Calls[0].Arguments.Get(0).(model.WebhookEvent)
+		second := events.Calls[1].Arguments.Get(0).(model.WebhookEvent)
+
+		require.Equal(t, urls[0], first.URL)
+		require.Equal(t, urls[1], second.URL)
+		require.NotEqual(t, first.ID, second.ID)
+		require.Equal(t, first.Payload, second.Payload)
+		require.Equal(t, first.Created, first.NextAttempt)
+
+		var event profile.Event
+		require.NoError(t, json.Unmarshal([]byte(first.Payload), &event))
+		require.NotEmpty(t, event.ID)
+		require.Equal(t, profile.EventProfileUpdated, event.Type)
+		require.Equal(t, "user.123", event.UserID)
+		require.Equal(t, "google", event.Provider)
+		require.Equal(t, "google.123", event.Subject)
+		require.Equal(t, "User Zero", event.Name)
+		require.Equal(t, "u0@mail.org", event.Email)
+		require.True(t, event.EmailVerified)
+		require.JSONEq(t, `{"email_verified":true}`, string(event.RawData))
+	})
+
+	t.Run("FailOnCreate", func(t *testing.T) {
+		fail := errors.New("xxx")
+
+		events := &webhookEventsMock{}
+		events.On("Create", mock.Anything).Return(fail)
+
+		err := profile.Webhook(events, []string{"http://a.example.org/hook"}).Update("user.123", "google", user)
+		require.ErrorIs(t, err, fail)
+	})
+}
+
+func TestChain(t *testing.T) {
+	user := goth.User{UserID: "google.123"}
+
+	t.Run("Success", func(t *testing.T) {
+		first := &updaterMock{}
+		second := &updaterMock{}
+
+		first.On("Update", "user.123", "google", user).Return(nil)
+		second.On("Update", "user.123", "google", user).Return(nil)
+
+		require.NoError(t, profile.Chain(first, second).Update("user.123", "google", user))
+		first.AssertExpectations(t)
+		second.AssertExpectations(t)
+	})
+
+	t.Run("Fail", func(t *testing.T) {
+		fail := errors.New("xxx")
+
+		first := &updaterMock{}
+		second := &updaterMock{}
+
+		first.On("Update", "user.123", "google", user).Return(fail)
+
+		require.ErrorIs(t, profile.Chain(first, second).Update("user.123", "google", user), fail)
+		second.AssertNotCalled(t, "Update", mock.Anything, mock.Anything, mock.Anything)
+	})
+}
+
+func TestDeliverer(t *testing.T) {
+	secret := []byte("secret")
+	payload := `{"id":"event.123","type":"profile.updated"}`
+
+	newServer := func(status int, requests chan *http.Request, bodies chan []byte) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := ioutil.ReadAll(r.Body)
+			requests <- r
+			bodies <- body
+			w.WriteHeader(status)
+		}))
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		bodies := make(chan []byte, 1)
+
+		srv := newServer(http.StatusNoContent, requests, bodies)
+		defer srv.Close()
+
+		events := &webhookEventsMock{}
+		events.On("FindDue", mock.Anything, 10).Return([]model.WebhookEvent{
+			{ID: "webhook.123", URL: srv.URL, Payload: payload},
+		}, nil)
+		events.On("Delete", "webhook.123").Return(nil)
+
+		cmd := profile.NewDeliverer(events, srv.Client(), secret, 3, time.Second, 10)
+
+		n, err := cmd.Deliver()
+		require.NoError(t, err)
+		require.Equal(t, 1, n)
+
+		req := <-requests
+		body := <-bodies
+
+		require.Equal(t, http.MethodPost, req.Method)
+		require.Equal(t, "application/json", req.Header.Get("Content-Type"))
+		require.Equal(t, "event.123", req.Header.Get(profile.HeaderEventID))
+		require.Equal(t, payload, string(body))
+
+		ts, err := strconv.ParseInt(req.Header.Get(profile.HeaderTimestamp), 10, 64)
+		require.NoError(t, err)
+		require.Equal(t, profile.Sign(secret, ts, body), req.Header.Get(profile.HeaderSignature))
+		require.NotEqual(t, profile.Sign([]byte("xxx"), ts, body), req.Header.Get(profile.HeaderSignature))
+
+		events.AssertExpectations(t)
+	})
+
+	t.Run("Retry", func(t *testing.T) {
+		requests := make(chan *http.Request, 1)
+		bodies := make(chan []byte, 1)
+
+		srv := newServer(http.StatusInternalServerError, requests, bodies)
+		defer srv.Close()
+
+		events := &webhookEventsMock{}
+		events.On("FindDue", mock.Anything, 10).Return([]model.WebhookEvent{
+			{ID: "webhook.123", URL: srv.URL, Payload: payload, Attempts: 1},
+		}, nil)
+		events.On("Update", mock.Anything).Return(nil)
+
+		cmd := profile.NewDeliverer(events, srv.Client(), secret, 3, time.Minute, 10)
+
+		now := time.Now().Unix()
+
+		n, err := cmd.Deliver()
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+
+		updated := events.Calls[1].Arguments.Get(0).(model.WebhookEvent)
+		require.Equal(t, 2, updated.Attempts)
+		require.False(t, updated.Failed)
+		require.Contains(t, updated.LastError, "500")
+		require.InDelta(t, now+120, updated.NextAttempt, 2)
+
+		events.AssertNotCalled(t, "Delete", mock.Anything)
+	})
+
+	t.Run("Failed", func(t *testing.T) {
+		events := &webhookEventsMock{}
+		events.On("FindDue", mock.Anything, 10).Return([]model.WebhookEvent{
+			{ID: "webhook.123", URL: "http://127.0.0.1:0/hook", Payload: payload, Attempts: 2, NextAttempt: 1600000000},
+		}, nil)
+		events.On("Update", mock.Anything).Return(nil)
+
+		cmd := profile.NewDeliverer(events, http.DefaultClient, secret, 3, time.Minute, 10)
+
+		n, err := cmd.Deliver()
+		require.NoError(t, err)
+		require.Equal(t, 0, n)
+
+		updated := events.Calls[1].Arguments.Get(0).(model.WebhookEvent)
+		require.Equal(t, 3, updated.Attempts)
+		require.True(t, updated.Failed)
+		require.NotEmpty(t, updated.LastError)
+		require.Equal(t, int64(1600000000), updated.NextAttempt)
+	})
+
+	t.Run("FailOnFindDue", func(t *testing.T) {
+		fail := errors.New("xxx")
+
+		events := &webhookEventsMock{}
+		events.On("FindDue", mock.Anything, 10).Return(nil, fail)
+
+		cmd := profile.NewDeliverer(events, http.DefaultClient, secret, 3, time.Minute, 10)
+
+		_, err := cmd.Deliver()
+		require.ErrorIs(t, err, fail)
+	})
+
+	t.Run("FailOnUpdate", func(t *testing.T) {
+		fail := errors.New("xxx")
+
+		events := &webhookEventsMock{}
+		events.On("FindDue", mock.Anything, 10).Return([]model.WebhookEvent{
+			{ID: "webhook.123", URL: "http://127.0.0.1:0/hook", Payload: payload},
+		}, nil)
+		events.On("Update", mock.Anything).Return(fail)
+
+		cmd := profile.NewDeliverer(events, http.DefaultClient, secret, 3, time.Minute, 10)
+
+		_, err := cmd.Deliver()
+		require.ErrorIs(t, err, fail)
+	})
+}
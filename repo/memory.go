@@ -0,0 +1,502 @@
+package repo
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/vbogretsov/guard/model"
+)
+
+var errExists = errors.New("record already exists")
+
+// Memory keeps the users, the refresh tokens and the sessions in the process
+// memory. It is meant for tests and a single node development setup, the
+// records are lost on restart.
+type Memory struct {
+	mu       sync.Mutex
+	users    map[string]model.User
+	tokens   map[string]model.RefreshToken
+	sessions map[string]model.Session
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		users:    map[string]model.User{},
+		tokens:   map[string]model.RefreshToken{},
+		sessions: map[string]model.Session{},
+	}
+}
+
+// MemoryConn is the Memory handle shared by the repositories of a single
+// scope. While a transaction is open the changes are applied immediately and
+// reverted if the transaction is closed without commit, so other scopes can
+// see the uncommitted changes.
+type MemoryConn struct {
+	store *Memory
+	tx    bool
+	undo  []func(m *Memory)
+}
+
+func NewMemoryConn(store *Memory) *MemoryConn {
+	return &MemoryConn{store: store}
+}
+
+func (c *MemoryConn) Begin() error {
+	if c.tx {
+		return errTxStarted
+	}
+
+	c.tx = true
+	return nil
+}
+
+func (c *MemoryConn) Commit() error {
+	if !c.tx {
+		return errTxNotStarted
+	}
+
+	c.tx = false
+	c.undo = nil
+
+	return nil
+}
+
+// Close reverts the changes of the transaction if it was not committed.
+func (c *MemoryConn) Close() error {
+	if !c.tx {
+		return nil
+	}
+
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	for i := len(c.undo) - 1; i >= 0; i-- {
+		c.undo[i](c.store)
+	}
+
+	c.tx = false
+	c.undo = nil
+
+	return nil
+}
+
+// read runs fn with the store locked.
+func (c *MemoryConn) read(fn func(m *Memory)) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	fn(c.store)
+}
+
+// write runs fn with the store locked and remembers the function reverting
+// the change if a transaction is open.
+func (c *MemoryConn) write(fn func(m *Memory) (func(m *Memory), error)) error {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+
+	undo, err := fn(c.store)
+	if err != nil {
+		return err
+	}
+
+	if c.tx && undo != nil {
+		c.undo = append(c.undo, undo)
+	}
+
+	return nil
+}
+
+type memoryUsers struct {
+	conn *MemoryConn
+}
+
+func NewMemoryUsers(conn *MemoryConn) Users {
+	return &memoryUsers{conn: conn}
+}
+
+func (u *memoryUsers) Create(user model.User) error {
+	return u.conn.write(func(m *Memory) (func(m *Memory), error) {
+		if _, ok := m.users[user.ID]; ok {
+			return nil, errExists
+		}
+
+		for _, v := range m.users {
+			if v.Name == user.Name {
+				return nil, errExists
+			}
+		}
+
+		m.users[user.ID] = user
+
+		return func(m *Memory) { delete(m.users, user.ID) }, nil
+	})
+}
+
+func (u *memoryUsers) Find(name string) (model.User, error) {
+	var user model.User
+	err := ErrorNotFound
+
+	u.conn.read(func(m *Memory) {
+		for _, v := range m.users {
+			if v.Name == name {
+				user, err = v, nil
+				return
+			}
+		}
+	})
+
+	return user, err
+}
+
+func (u *memoryUsers) FindByID(id string) (model.User, error) {
+	var user model.User
+	var ok bool
+
+	u.conn.read(func(m *Memory) {
+		user, ok = m.users[id]
+	})
+
+	if !ok {
+		return user, ErrorNotFound
+	}
+
+	return user, nil
+}
+
+// List returns the users ordered by the creation time.
+func (u *memoryUsers) List(offset, limit int) ([]model.User, error) {
+	values := []model.User{}
+
+	u.conn.read(func(m *Memory) {
+		for _, user := range m.users {
+			values = append(values, user)
+		}
+	})
+
+	sort.Slice(values, func(i, j int) bool {
+		if values[i].Created != values[j].Created {
+			return values[i].Created < values[j].Created
+		}
+		return values[i].ID < values[j].ID
+	})
+
+	if offset > len(values) {
+		offset = len(values)
+	}
+	values = values[offset:]
+
+	if limit >= 0 && limit < len(values) {
+		values = values[:limit]
+	}
+
+	return values, nil
+}
+
+func (u *memoryUsers) SetDisabled(id string, disabled bool) error {
+	return u.conn.write(func(m *Memory) (func(m *Memory), error) {
+		user, ok := m.users[id]
+		if !ok {
+			return nil, ErrorNotFound
+		}
+
+		prev := user
+		user.Disabled = disabled
+		m.users[id] = user
+
+		return func(m *Memory) { m.users[id] = prev }, nil
+	})
+}
+
+// SetVerified marks the user as the proven owner of the email.
+func (u *memoryUsers) SetVerified(id string) error {
+	return u.conn.write(func(m *Memory) (func(m *Memory), error) {
+		user, ok := m.users[id]
+		if !ok {
+			return nil, nil
+		}
+
+		prev := user
+		user.Verified = true
+		m.users[id] = user
+
+		return func(m *Memory) { m.users[id] = prev }, nil
+	})
+}
+
+// Delete deletes the user together with the refresh tokens of the user.
+func (u *memoryUsers) Delete(id string) error {
+	return u.conn.write(func(m *Memory) (func(m *Memory), error) {
+		user, ok := m.users[id]
+		if !ok {
+			return nil, ErrorNotFound
+		}
+
+		_, undo := m.deleteTokens(func(token model.RefreshToken) bool {
+			return token.UserID == id
+		}, -1)
+
+		delete(m.users, id)
+
+		return func(m *Memory) {
+			m.users[id] = user
+			undo(m)
+		}, nil
+	})
+}
+
+type memoryRefreshTokens struct {
+	conn *MemoryConn
+}
+
+func NewMemoryRefreshTokens(conn *MemoryConn) RefreshTokens {
+	return &memoryRefreshTokens{conn: conn}
+}
+
+// withUser returns the token with the user loaded as the SQL join does.
+func (m *Memory) withUser(token model.RefreshToken) model.RefreshToken {
+	token.User = m.users[token.UserID]
+	return token
+}
+
+// deleteTokens deletes the tokens matching the filter and returns the
+// function restoring them.
+func (m *Memory) deleteTokens(match func(model.RefreshToken) bool, limit int) (int64, func(m *Memory)) {
+	deleted := []model.RefreshToken{}
+
+	for id, token := range m.tokens {
+		if limit >= 0 && len(deleted) >= limit {
+			break
+		}
+		if match(token) {
+			deleted = append(deleted, token)
+			delete(m.tokens, id)
+		}
+	}
+
+	return int64(len(deleted)), func(m *Memory) {
+		for _, token := range deleted {
+			m.tokens[token.ID] = token
+		}
+	}
+}
+
+func (rt *memoryRefreshTokens) Create(token model.RefreshToken) error {
+	token.User = model.User{}
+
+	return rt.conn.write(func(m *Memory) (func(m *Memory), error) {
+		if _, ok := m.tokens[token.ID]; ok {
+			return nil, errExists
+		}
+
+		m.tokens[token.ID] = token
+
+		return func(m *Memory) { delete(m.tokens, token.ID) }, nil
+	})
+}
+
+func (rt *memoryRefreshTokens) Find(id string) (model.RefreshToken, error) {
+	var token model.RefreshToken
+	var ok bool
+
+	rt.conn.read(func(m *Memory) {
+		token, ok = m.tokens[id]
+		if ok {
+			token = m.withUser(token)
+		}
+	})
+
+	if !ok {
+		return token, ErrorNotFound
+	}
+
+	return token, nil
+}
+
+func (rt *memoryRefreshTokens) Delete(id string) error {
+	return rt.conn.write(func(m *Memory) (func(m *Memory), error) {
+		token, ok := m.tokens[id]
+		if !ok {
+			return nil, nil
+		}
+
+		delete(m.tokens, id)
+
+		return func(m *Memory) { m.tokens[id] = token }, nil
+	})
+}
+
+// Consume marks the token as used. If the token has already been used the
+// token is returned together with ErrorConsumed.
+func (rt *memoryRefreshTokens) Consume(id string) (model.RefreshToken, error) {
+	var token model.RefreshToken
+
+	err := rt.conn.write(func(m *Memory) (func(m *Memory), error) {
+		value, ok := m.tokens[id]
+		if !ok {
+			return nil, ErrorNotFound
+		}
+
+		token = m.withUser(value)
+		token.Used = true
+
+		if value.Used {
+			return nil, ErrorConsumed
+		}
+
+		value.Used = true
+		m.tokens[id] = value
+
+		return func(m *Memory) {
+			if value, ok := m.tokens[id]; ok {
+				value.Used = false
+				m.tokens[id] = value
+			}
+		}, nil
+	})
+
+	return token, err
+}
+
+func (rt *memoryRefreshTokens) DeleteFamily(family string) error {
+	return rt.conn.write(func(m *Memory) (func(m *Memory), error) {
+		_, undo := m.deleteTokens(func(token model.RefreshToken) bool {
+			return token.Family == family
+		}, -1)
+		return undo, nil
+	})
+}
+
+func (rt *memoryRefreshTokens) DeleteByUser(userID string) error {
+	return rt.conn.write(func(m *Memory) (func(m *Memory), error) {
+		_, undo := m.deleteTokens(func(token model.RefreshToken) bool {
+			return token.UserID == userID
+		}, -1)
+		return undo, nil
+	})
+}
+
+func (rt *memoryRefreshTokens) DeleteExpired(before int64, limit int) (int64, error) {
+	var n int64
+
+	err := rt.conn.write(func(m *Memory) (func(m *Memory), error) {
+		var undo func(m *Memory)
+		n, undo = m.deleteTokens(func(token model.RefreshToken) bool {
+			return token.Expires < before
+		}, limit)
+		return undo, nil
+	})
+
+	return n, err
+}
+
+type memorySessions struct {
+	conn *MemoryConn
+}
+
+func NewMemorySessions(conn *MemoryConn) Sessions {
+	return &memorySessions{conn: conn}
+}
+
+// deleteSessions deletes the sessions matching the filter and returns the
+// function restoring them.
+func (m *Memory) deleteSessions(match func(model.Session) bool, limit int) ([]model.Session, func(m *Memory)) {
+	deleted := []model.Session{}
+
+	for id, sess := range m.sessions {
+		if limit >= 0 && len(deleted) >= limit {
+			break
+		}
+		if match(sess) {
+			deleted = append(deleted, sess)
+			delete(m.sessions, id)
+		}
+	}
+
+	return deleted, func(m *Memory) {
+		for _, sess := range deleted {
+			m.sessions[sess.ID] = sess
+		}
+	}
+}
+
+func (s *memorySessions) Find(code string) (model.Session, error) {
+	var sess model.Session
+	var ok bool
+
+	s.conn.read(func(m *Memory) {
+		sess, ok = m.sessions[code]
+	})
+
+	if !ok {
+		return sess, ErrorNotFound
+	}
+
+	return sess, nil
+}
+
+func (s *memorySessions) Create(sess model.Session) error {
+	return s.conn.write(func(m *Memory) (func(m *Memory), error) {
+		if _, ok := m.sessions[sess.ID]; ok {
+			return nil, errExists
+		}
+
+		m.sessions[sess.ID] = sess
+
+		return func(m *Memory) { delete(m.sessions, sess.ID) }, nil
+	})
+}
+
+func (s *memorySessions) Delete(code string) error {
+	return s.conn.write(func(m *Memory) (func(m *Memory), error) {
+		sess, ok := m.sessions[code]
+		if !ok {
+			return nil, nil
+		}
+
+		delete(m.sessions, code)
+
+		return func(m *Memory) { m.sessions[code] = sess }, nil
+	})
+}
+
+// Consume marks the session as used. If the session has already been used
+// the session is returned together with ErrorConsumed.
+func (s *memorySessions) Consume(code string) (model.Session, error) {
+	var sess model.Session
+
+	err := s.conn.write(func(m *Memory) (func(m *Memory), error) {
+		var ok bool
+
+		sess, ok = m.sessions[code]
+		if !ok {
+			return nil, ErrorNotFound
+		}
+
+		if sess.Used {
+			return nil, ErrorConsumed
+		}
+
+		prev := sess
+		sess.Used = true
+		m.sessions[code] = sess
+
+		return func(m *Memory) { m.sessions[code] = prev }, nil
+	})
+
+	return sess, err
+}
+
+func (s *memorySessions) DeleteExpired(before int64, limit int) (int64, error) {
+	var n int64
+
+	err := s.conn.write(func(m *Memory) (func(m *Memory), error) {
+		deleted, undo := m.deleteSessions(func(sess model.Session) bool {
+			return sess.Expires < before
+		}, limit)
+		n = int64(len(deleted))
+		return undo, nil
+	})
+
+	return n, err
+}
@@ -0,0 +1,24 @@
+package repo_test
+
+import (
+	"testing"
+
+	"github.com/vbogretsov/guard/repo"
+	"github.com/vbogretsov/guard/repo/repotest"
+)
+
+func TestOnMemory(t *testing.T) {
+	store := repo.NewMemory()
+
+	repotest.Run(t, repotest.Backend{
+		NewScope: func() repotest.Scope {
+			conn := repo.NewMemoryConn(store)
+			return repotest.Scope{
+				Tx:            conn,
+				Users:         repo.NewMemoryUsers(conn),
+				RefreshTokens: repo.NewMemoryRefreshTokens(conn),
+				Sessions:      repo.NewMemorySessions(conn),
+			}
+		},
+	})
+}
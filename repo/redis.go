@@ -0,0 +1,281 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/vbogretsov/guard/model"
+)
+
+// Keys of the records stored in Redis. The records expire at their Expires
+// time, so DeleteExpired has nothing to do.
+const (
+	redisSessionKey      = "guard:session:"
+	redisRefreshTokenKey = "guard:refresh:"
+	redisFamilyKey       = "guard:refresh_family:"
+	redisUserKey         = "guard:refresh_user:"
+)
+
+// createRefreshToken stores the token hash and adds the token to the family
+// and the user indexes. An index lives as long as its longest living token.
+var createRefreshToken = redis.NewScript(`
+local now = tonumber(redis.call('TIME')[1])
+redis.call('HSET', KEYS[1], 'data', ARGV[1])
+redis.call('EXPIREAT', KEYS[1], ARGV[2])
+for i = 2, 3 do
+	redis.call('SADD', KEYS[i], ARGV[3])
+	local ttl = redis.call('TTL', KEYS[i])
+	if ttl < 0 or now + ttl < tonumber(ARGV[2]) then
+		redis.call('EXPIREAT', KEYS[i], ARGV[2])
+	end
+end
+return 1
+`)
+
+// consumeRecord sets the used flag of the token or the session and returns
+// the record data and whether the flag has been set by this call.
+var consumeRecord = redis.NewScript(`
+local data = redis.call('HGET', KEYS[1], 'data')
+if not data then
+	return false
+end
+return {redis.call('HSETNX', KEYS[1], 'used', '1'), data}
+`)
+
+// deleteRefreshTokens deletes the tokens of the index and the index.
+var deleteRefreshTokens = redis.NewScript(`
+local ids = redis.call('SMEMBERS', KEYS[1])
+for _, id in ipairs(ids) do
+	redis.call('DEL', ARGV[1] .. id)
+end
+return redis.call('DEL', KEYS[1])
+`)
+
+type redisSessions struct {
+	client redis.UniversalClient
+}
+
+// NewRedisSessions returns the sessions stored in Redis. A session is kept
+// as a hash of the session data and the used flag, like the refresh tokens.
+func NewRedisSessions(client redis.UniversalClient) Sessions {
+	return &redisSessions{client: client}
+}
+
+func (s *redisSessions) decode(data string, used bool) (model.Session, error) {
+	var sess model.Session
+
+	if err := json.Unmarshal([]byte(data), &sess); err != nil {
+		return sess, err
+	}
+
+	sess.Used = used
+	return sess, nil
+}
+
+func (s *redisSessions) Find(code string) (model.Session, error) {
+	values, err := s.client.HGetAll(context.Background(), redisSessionKey+code).Result()
+	if err != nil {
+		return model.Session{}, err
+	}
+
+	data, ok := values["data"]
+	if !ok {
+		return model.Session{}, ErrorNotFound
+	}
+
+	return s.decode(data, values["used"] == "1")
+}
+
+func (s *redisSessions) Create(sess model.Session) error {
+	sess.Used = false
+
+	data, err := json.Marshal(sess)
+	if err != nil {
+		return err
+	}
+
+	key := redisSessionKey + sess.ID
+	ctx := context.Background()
+
+	_, err = s.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, key)
+		pipe.HSet(ctx, key, "data", data)
+		pipe.ExpireAt(ctx, key, time.Unix(sess.Expires, 0))
+		return nil
+	})
+
+	return err
+}
+
+func (s *redisSessions) Delete(code string) error {
+	return s.client.Del(context.Background(), redisSessionKey+code).Err()
+}
+
+// Consume marks the session as used. If the session has already been used
+// the session is returned together with ErrorConsumed.
+func (s *redisSessions) Consume(code string) (model.Session, error) {
+	value, err := consumeRecord.Run(context.Background(), s.client, []string{redisSessionKey + code}).Result()
+	if err != nil {
+		return model.Session{}, redisError(err)
+	}
+
+	result, ok := value.([]interface{})
+	if !ok || len(result) != 2 {
+		return model.Session{}, fmt.Errorf("unexpected consume result: %v", value)
+	}
+
+	first, _ := result[0].(int64)
+	data, _ := result[1].(string)
+
+	sess, err := s.decode(data, true)
+	if err != nil {
+		return sess, err
+	}
+
+	if first == 0 {
+		return sess, ErrorConsumed
+	}
+
+	return sess, nil
+}
+
+func (s *redisSessions) DeleteExpired(before int64, limit int) (int64, error) {
+	return 0, nil
+}
+
+type redisRefreshTokens struct {
+	client redis.UniversalClient
+	users  Users
+}
+
+// NewRedisRefreshTokens returns the refresh tokens stored in Redis. The users
+// of the tokens are loaded from the users repository.
+//
+// Unlike the SQL tokens the Redis tokens do not take part in transactions, a
+// token consumed by a failed refresh stays consumed.
+func NewRedisRefreshTokens(client redis.UniversalClient, users Users) RefreshTokens {
+	return &redisRefreshTokens{client: client, users: users}
+}
+
+func (rt *redisRefreshTokens) decode(data string, used bool) (model.RefreshToken, error) {
+	var token model.RefreshToken
+
+	if err := json.Unmarshal([]byte(data), &token); err != nil {
+		return token, err
+	}
+
+	user, err := rt.users.FindByID(token.UserID)
+	if err != nil {
+		return token, err
+	}
+
+	token.User = user
+	token.Used = used
+
+	return token, nil
+}
+
+func (rt *redisRefreshTokens) Find(id string) (model.RefreshToken, error) {
+	values, err := rt.client.HGetAll(context.Background(), redisRefreshTokenKey+id).Result()
+	if err != nil {
+		return model.RefreshToken{}, err
+	}
+
+	data, ok := values["data"]
+	if !ok {
+		return model.RefreshToken{}, ErrorNotFound
+	}
+
+	return rt.decode(data, values["used"] == "1")
+}
+
+func (rt *redisRefreshTokens) Create(token model.RefreshToken) error {
+	token.User = model.User{}
+	token.Used = false
+
+	data, err := json.Marshal(token)
+	if err != nil {
+		return err
+	}
+
+	keys := []string{
+		redisRefreshTokenKey + token.ID,
+		redisFamilyKey + token.Family,
+		redisUserKey + token.UserID,
+	}
+
+	return createRefreshToken.Run(
+		context.Background(),
+		rt.client,
+		keys,
+		string(data),
+		token.Expires,
+		token.ID,
+	).Err()
+}
+
+func (rt *redisRefreshTokens) Delete(id string) error {
+	return rt.client.Del(context.Background(), redisRefreshTokenKey+id).Err()
+}
+
+// Consume marks the token as used. If the token has already been used the
+// token is returned together with ErrorConsumed.
+func (rt *redisRefreshTokens) Consume(id string) (model.RefreshToken, error) {
+	value, err := consumeRecord.Run(context.Background(), rt.client, []string{redisRefreshTokenKey + id}).Result()
+	if err != nil {
+		return model.RefreshToken{}, redisError(err)
+	}
+
+	result, ok := value.([]interface{})
+	if !ok || len(result) != 2 {
+		return model.RefreshToken{}, fmt.Errorf("unexpected consume result: %v", value)
+	}
+
+	first, _ := result[0].(int64)
+	data, _ := result[1].(string)
+
+	token, err := rt.decode(data, true)
+	if err != nil {
+		return token, err
+	}
+
+	if first == 0 {
+		return token, ErrorConsumed
+	}
+
+	return token, nil
+}
+
+func (rt *redisRefreshTokens) DeleteFamily(family string) error {
+	return deleteRefreshTokens.Run(
+		context.Background(),
+		rt.client,
+		[]string{redisFamilyKey + family},
+		redisRefreshTokenKey,
+	).Err()
+}
+
+func (rt *redisRefreshTokens) DeleteByUser(userID string) error {
+	return deleteRefreshTokens.Run(
+		context.Background(),
+		rt.client,
+		[]string{redisUserKey + userID},
+		redisRefreshTokenKey,
+	).Err()
+}
+
+func (rt *redisRefreshTokens) DeleteExpired(before int64, limit int) (int64, error) {
+	return 0, nil
+}
+
+// redisError maps a missing key to ErrorNotFound.
+func redisError(err error) error {
+	if err == redis.Nil {
+		return ErrorNotFound
+	}
+	return err
+}
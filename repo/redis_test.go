@@ -0,0 +1,105 @@
+package repo_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+	"github.com/vbogretsov/guard/repo/repotest"
+)
+
+func TestOnRedis(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.SetTime(time.Unix(1000000000, 0))
+
+	client := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	defer client.Close()
+
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err, "unable to create SQLite database")
+	require.NoError(t, db.AutoMigrate(
+		&model.User{},
+		&model.RefreshToken{},
+		&model.Credential{},
+		&model.TOTP{},
+		&model.Passkey{},
+		&model.UserIdentity{},
+		&model.Profile{},
+		&model.ProviderToken{},
+	), "failed to auto migrate users")
+
+	ur := repo.NewUsers(repo.NewConn(db))
+
+	now := time.Unix(1000000000, 0)
+
+	repotest.Run(t, repotest.Backend{
+		NewScope: func() repotest.Scope {
+			return repotest.Scope{
+				Users:         ur,
+				RefreshTokens: repo.NewRedisRefreshTokens(client, ur),
+				Sessions:      repo.NewRedisSessions(client),
+			}
+		},
+		Expire: func(before int64) {
+			srv.FastForward(time.Unix(before, 0).Sub(now))
+			now = time.Unix(before, 0)
+			srv.SetTime(now)
+		},
+	})
+
+	srv.FlushAll()
+	srv.SetTime(time.Unix(1000000000, 0))
+
+	t.Run("Indexes", func(t *testing.T) {
+		rr := repo.NewRedisRefreshTokens(client, ur)
+		users := repotest.Users
+
+		first := model.RefreshToken{ID: "abc123", UserID: "123", User: users[0], Family: "abc123", Expires: 1000000010}
+		next := model.RefreshToken{ID: "abc789", UserID: "123", User: users[0], Family: "abc123", Expires: 1000000100}
+		require.NoError(t, rr.Create(first))
+		require.NoError(t, rr.Create(next))
+
+		t.Run("Extend", func(t *testing.T) {
+			// The family outlives its first token.
+			srv.FastForward(20 * time.Second)
+
+			_, err := rr.Find(first.ID)
+			require.ErrorIs(t, err, repo.ErrorNotFound)
+			require.True(t, srv.Exists("guard:refresh_family:abc123"))
+			require.True(t, srv.Exists("guard:refresh_user:123"))
+
+			_, err = rr.Find(next.ID)
+			require.NoError(t, err)
+		})
+
+		t.Run("DeleteFamily", func(t *testing.T) {
+			require.NoError(t, rr.DeleteFamily(next.Family))
+
+			_, err := rr.Find(next.ID)
+			require.ErrorIs(t, err, repo.ErrorNotFound)
+			require.False(t, srv.Exists("guard:refresh_family:abc123"))
+		})
+
+		t.Run("Expire", func(t *testing.T) {
+			token := model.RefreshToken{ID: "expire.1", UserID: "456", User: users[1], Family: "expire.1", Expires: 1000000200}
+			require.NoError(t, rr.Create(token))
+
+			srv.FastForward(200 * time.Second)
+
+			_, err := rr.Find(token.ID)
+			require.ErrorIs(t, err, repo.ErrorNotFound)
+			require.False(t, srv.Exists("guard:refresh_family:expire.1"))
+			require.False(t, srv.Exists("guard:refresh_user:456"))
+		})
+	})
+}
@@ -1,6 +1,8 @@
 package repo
 
 import (
+	"errors"
+
 	"gorm.io/gorm"
 
 	"github.com/vbogretsov/guard/model"
@@ -8,6 +10,12 @@ import (
 
 var ErrorNotFound = gorm.ErrRecordNotFound
 
+var ErrorConsumed = errors.New("record already consumed")
+
+var errTxStarted = errors.New("transaction already started")
+
+var errTxNotStarted = errors.New("transaction not started")
+
 type Transaction interface {
 	Begin() error
 	Commit() error
@@ -16,37 +24,226 @@ type Transaction interface {
 
 type Users interface {
 	Find(name string) (model.User, error)
+	FindByID(id string) (model.User, error)
 	Create(user model.User) error
+	List(offset, limit int) ([]model.User, error)
+	SetDisabled(id string, disabled bool) error
+	SetVerified(id string) error
+	Delete(id string) error
 }
 
 type RefreshTokens interface {
 	Find(value string) (model.RefreshToken, error)
 	Create(token model.RefreshToken) error
 	Delete(value string) error
+	Consume(value string) (model.RefreshToken, error)
+	DeleteFamily(family string) error
+	DeleteByUser(userID string) error
+	DeleteExpired(before int64, limit int) (int64, error)
 }
 
 type Sessions interface {
 	Find(code string) (model.Session, error)
 	Create(sess model.Session) error
 	Delete(code string) error
+	Consume(code string) (model.Session, error)
+	DeleteExpired(before int64, limit int) (int64, error)
 }
 
-type users struct {
+type Clients interface {
+	Find(id string) (model.Client, error)
+	Create(client model.Client) error
+	Save(client model.Client) error
+	Delete(id string) error
+}
+
+type Credentials interface {
+	Find(userID string) (model.Credential, error)
+	Save(credential model.Credential) error
+}
+
+type TOTPs interface {
+	Find(userID string) (model.TOTP, error)
+	Save(totp model.TOTP) error
+	Use(prev, totp model.TOTP) error
+	Delete(userID string) error
+}
+
+type Passkeys interface {
+	Find(id string) (model.Passkey, error)
+	FindByUser(userID string) ([]model.Passkey, error)
+	Create(passkey model.Passkey) error
+	Update(passkey model.Passkey) error
+	Delete(userID, id string) error
+}
+
+type Identities interface {
+	Find(provider, subject string) (model.UserIdentity, error)
+	FindByUser(userID string) ([]model.UserIdentity, error)
+	Create(identity model.UserIdentity) error
+	Delete(userID, provider string) error
+}
+
+type Profiles interface {
+	FindByUser(userID string) ([]model.Profile, error)
+	Save(profile model.Profile) error
+	Delete(userID, provider string) error
+}
+
+type ProviderTokens interface {
+	Find(provider, subject string) (model.ProviderToken, error)
+	FindByUser(userID, provider string) (model.ProviderToken, error)
+	Save(token model.ProviderToken) error
+	Delete(userID, provider string) error
+}
+
+type WebhookEvents interface {
+	Create(event model.WebhookEvent) error
+	FindDue(before int64, limit int) ([]model.WebhookEvent, error)
+	Update(event model.WebhookEvent) error
+	Delete(id string) error
+}
+
+// Conn is a database handle shared by the repositories of a single scope.
+// While a transaction is open all the repositories created from the same
+// Conn run their queries inside it.
+type Conn struct {
 	db *gorm.DB
+	tx *gorm.DB
+}
+
+func NewConn(db *gorm.DB) *Conn {
+	return &Conn{db: db}
+}
+
+func (c *Conn) DB() *gorm.DB {
+	if c.tx != nil {
+		return c.tx
+	}
+	return c.db
+}
+
+func (c *Conn) Begin() error {
+	if c.tx != nil {
+		return errTxStarted
+	}
+
+	tx := c.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+
+	c.tx = tx
+	return nil
+}
+
+func (c *Conn) Commit() error {
+	if c.tx == nil {
+		return errTxNotStarted
+	}
+
+	tx := c.tx
+	c.tx = nil
+
+	return tx.Commit().Error
+}
+
+// Close rolls back the transaction if it was not committed.
+func (c *Conn) Close() error {
+	if c.tx == nil {
+		return nil
+	}
+
+	tx := c.tx
+	c.tx = nil
+
+	return tx.Rollback().Error
+}
+
+type transactions []Transaction
+
+// Transactions returns the transaction spanning the transactions given. They
+// are begun and committed in order, a failed commit leaves the rest to be
+// rolled back by Close.
+func Transactions(txs ...Transaction) Transaction {
+	return transactions(txs)
+}
+
+func (t transactions) Begin() error {
+	for i, tx := range t {
+		if err := tx.Begin(); err != nil {
+			t[:i].Close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (t transactions) Commit() error {
+	for _, tx := range t {
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t transactions) Close() error {
+	var first error
+	for _, tx := range t {
+		if err := tx.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
 }
 
-func NewUsers(db *gorm.DB) Users {
-	return &users{db: db}
+// deleteExpired deletes up to limit records which expired before the time
+// given. Deleting by the selected IDs is idempotent, so several processes can
+// sweep the same table concurrently.
+func deleteExpired(db *gorm.DB, value interface{}, before int64, limit int) (int64, error) {
+	var ids []string
+
+	r := db.Model(value).Where("expires < ?", before).Limit(limit).Pluck("id", &ids)
+	if r.Error != nil {
+		return 0, r.Error
+	}
+
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	r = db.Where("id IN ?", ids).Delete(value)
+	return r.RowsAffected, r.Error
+}
+
+type users struct {
+	conn *Conn
+}
+
+func NewUsers(conn *Conn) Users {
+	return &users{conn: conn}
 }
 
 func (u *users) Create(user model.User) error {
-	return u.db.Create(&user).Error
+	return u.conn.DB().Create(&user).Error
 }
 
 func (u *users) Find(name string) (model.User, error) {
 	var user model.User
 
-	r := u.db.First(&user, "name = ?", name)
+	r := u.conn.DB().First(&user, "name = ?", name)
+	if r.Error != nil {
+		return user, r.Error
+	}
+
+	return user, nil
+}
+
+func (u *users) FindByID(id string) (model.User, error) {
+	var user model.User
+
+	r := u.conn.DB().First(&user, "id = ?", id)
 	if r.Error != nil {
 		return user, r.Error
 	}
@@ -54,22 +251,85 @@ func (u *users) Find(name string) (model.User, error) {
 	return user, nil
 }
 
+// List returns the users ordered by the creation time.
+func (u *users) List(offset, limit int) ([]model.User, error) {
+	values := []model.User{}
+
+	r := u.conn.DB().Order("created, id").Offset(offset).Limit(limit).Find(&values)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return values, nil
+}
+
+func (u *users) SetDisabled(id string, disabled bool) error {
+	r := u.conn.DB().Model(&model.User{}).Where("id = ?", id).Update("disabled", disabled)
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
+// SetVerified marks the user as the proven owner of the email.
+func (u *users) SetVerified(id string) error {
+	return u.conn.DB().Model(&model.User{}).Where("id = ?", id).Update("verified", true).Error
+}
+
+// userRecords are the records deleted together with their user.
+var userRecords = []interface{}{
+	&model.RefreshToken{},
+	&model.Credential{},
+	&model.TOTP{},
+	&model.Passkey{},
+	&model.UserIdentity{},
+	&model.Profile{},
+	&model.ProviderToken{},
+}
+
+// Delete deletes the user together with the records referencing it.
+func (u *users) Delete(id string) error {
+	db := u.conn.DB()
+
+	for _, value := range userRecords {
+		if err := db.Where("user_id = ?", id).Delete(value).Error; err != nil {
+			return err
+		}
+	}
+
+	r := db.Delete(&model.User{ID: id})
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
 type refreshTokens struct {
-	db *gorm.DB
+	conn *Conn
 }
 
-func NewRefreshTokens(db *gorm.DB) RefreshTokens {
-	return &refreshTokens{db: db}
+func NewRefreshTokens(conn *Conn) RefreshTokens {
+	return &refreshTokens{conn: conn}
 }
 
 func (rt *refreshTokens) Create(token model.RefreshToken) error {
-	return rt.db.Create(&token).Error
+	return rt.conn.DB().Create(&token).Error
 }
 
 func (rt *refreshTokens) Find(id string) (model.RefreshToken, error) {
 	var token model.RefreshToken
 
-	r := rt.db.Joins("User").First(&token, "refresh_tokens.id = ?", id)
+	r := rt.conn.DB().Joins("User").First(&token, "refresh_tokens.id = ?", id)
 	if r.Error != nil {
 		return token, r.Error
 	}
@@ -79,21 +339,63 @@ func (rt *refreshTokens) Find(id string) (model.RefreshToken, error) {
 
 func (rt *refreshTokens) Delete(id string) error {
 	token := model.RefreshToken{ID: id}
-	return rt.db.Delete(&token).Error
+	return rt.conn.DB().Delete(&token).Error
+}
+
+// Consume marks the token as used. If the token has already been used the
+// token is returned together with ErrorConsumed.
+func (rt *refreshTokens) Consume(id string) (model.RefreshToken, error) {
+	token, err := rt.Find(id)
+	if err != nil {
+		return token, err
+	}
+
+	r := rt.conn.DB().
+		Model(&model.RefreshToken{}).
+		Where("id = ? AND used = ?", id, false).
+		Update("used", true)
+	if r.Error != nil {
+		return token, r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return token, ErrorConsumed
+	}
+
+	token.Used = true
+	return token, nil
+}
+
+func (rt *refreshTokens) DeleteFamily(family string) error {
+	return rt.conn.DB().
+		Where("family = ?", family).
+		Delete(&model.RefreshToken{}).
+		Error
+}
+
+func (rt *refreshTokens) DeleteByUser(userID string) error {
+	return rt.conn.DB().
+		Where("user_id = ?", userID).
+		Delete(&model.RefreshToken{}).
+		Error
+}
+
+func (rt *refreshTokens) DeleteExpired(before int64, limit int) (int64, error) {
+	return deleteExpired(rt.conn.DB(), &model.RefreshToken{}, before, limit)
 }
 
 type sessions struct {
-	db *gorm.DB
+	conn *Conn
 }
 
-func NewSessions(db *gorm.DB) Sessions {
-	return &sessions{db: db}
+func NewSessions(conn *Conn) Sessions {
+	return &sessions{conn: conn}
 }
 
 func (s *sessions) Find(value string) (model.Session, error) {
 	var sess model.Session
 
-	r := s.db.First(&sess, "id = ?", value)
+	r := s.conn.DB().First(&sess, "id = ?", value)
 	if r.Error != nil {
 		return sess, r.Error
 	}
@@ -102,10 +404,363 @@ func (s *sessions) Find(value string) (model.Session, error) {
 }
 
 func (s *sessions) Create(sess model.Session) error {
-	return s.db.Create(&sess).Error
+	return s.conn.DB().Create(&sess).Error
 }
 
 func (s *sessions) Delete(code string) error {
 	sess := model.Session{ID: code}
-	return s.db.Delete(&sess).Error
+	return s.conn.DB().Delete(&sess).Error
+}
+
+// Consume marks the session as used. If the session has already been used
+// the session is returned together with ErrorConsumed. The used sessions are
+// deleted by the sweeper once expired.
+func (s *sessions) Consume(code string) (model.Session, error) {
+	sess, err := s.Find(code)
+	if err != nil {
+		return sess, err
+	}
+
+	r := s.conn.DB().
+		Model(&model.Session{}).
+		Where("id = ? AND used = ?", code, false).
+		Update("used", true)
+	if r.Error != nil {
+		return sess, r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return sess, ErrorConsumed
+	}
+
+	sess.Used = true
+	return sess, nil
+}
+
+func (s *sessions) DeleteExpired(before int64, limit int) (int64, error) {
+	return deleteExpired(s.conn.DB(), &model.Session{}, before, limit)
+}
+
+type clients struct {
+	conn *Conn
+}
+
+func NewClients(conn *Conn) Clients {
+	return &clients{conn: conn}
+}
+
+func (c *clients) Find(id string) (model.Client, error) {
+	var client model.Client
+
+	r := c.conn.DB().First(&client, "id = ?", id)
+	if r.Error != nil {
+		return client, r.Error
+	}
+
+	return client, nil
+}
+
+func (c *clients) Create(client model.Client) error {
+	return c.conn.DB().Create(&client).Error
+}
+
+// Save creates the client or replaces the existing one.
+func (c *clients) Save(client model.Client) error {
+	return c.conn.DB().Save(&client).Error
+}
+
+func (c *clients) Delete(id string) error {
+	return c.conn.DB().Delete(&model.Client{ID: id}).Error
+}
+
+type credentials struct {
+	conn *Conn
+}
+
+func NewCredentials(conn *Conn) Credentials {
+	return &credentials{conn: conn}
+}
+
+func (c *credentials) Find(userID string) (model.Credential, error) {
+	var credential model.Credential
+
+	r := c.conn.DB().First(&credential, "user_id = ?", userID)
+	if r.Error != nil {
+		return credential, r.Error
+	}
+
+	return credential, nil
+}
+
+// Save creates the credential or replaces the existing one of the user.
+func (c *credentials) Save(credential model.Credential) error {
+	return c.conn.DB().Save(&credential).Error
+}
+
+type totps struct {
+	conn *Conn
+}
+
+func NewTOTPs(conn *Conn) TOTPs {
+	return &totps{conn: conn}
+}
+
+func (t *totps) Find(userID string) (model.TOTP, error) {
+	var totp model.TOTP
+
+	r := t.conn.DB().First(&totp, "user_id = ?", userID)
+	if r.Error != nil {
+		return totp, r.Error
+	}
+
+	return totp, nil
+}
+
+func (t *totps) Save(totp model.TOTP) error {
+	return t.conn.DB().Save(&totp).Error
+}
+
+// Use saves the totp verified by a code. The record is updated only if it is
+// unchanged since prev was read, otherwise the code was used concurrently and
+// ErrorConsumed is returned.
+func (t *totps) Use(prev, totp model.TOTP) error {
+	r := t.conn.DB().
+		Model(&model.TOTP{}).
+		Where("user_id = ? AND last_step = ? AND recovery_codes = ?", prev.UserID, prev.LastStep, prev.RecoveryCodes).
+		Updates(map[string]interface{}{
+			"last_step":      totp.LastStep,
+			"recovery_codes": totp.RecoveryCodes,
+			"enabled":        totp.Enabled,
+		})
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return ErrorConsumed
+	}
+
+	return nil
+}
+
+func (t *totps) Delete(userID string) error {
+	return t.conn.DB().Delete(&model.TOTP{UserID: userID}).Error
+}
+
+type passkeys struct {
+	conn *Conn
+}
+
+func NewPasskeys(conn *Conn) Passkeys {
+	return &passkeys{conn: conn}
+}
+
+func (p *passkeys) Find(id string) (model.Passkey, error) {
+	var passkey model.Passkey
+
+	r := p.conn.DB().First(&passkey, "id = ?", id)
+	if r.Error != nil {
+		return passkey, r.Error
+	}
+
+	return passkey, nil
+}
+
+func (p *passkeys) FindByUser(userID string) ([]model.Passkey, error) {
+	var items []model.Passkey
+
+	r := p.conn.DB().Where("user_id = ?", userID).Order("created").Find(&items)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return items, nil
+}
+
+func (p *passkeys) Create(passkey model.Passkey) error {
+	return p.conn.DB().Create(&passkey).Error
+}
+
+func (p *passkeys) Update(passkey model.Passkey) error {
+	return p.conn.DB().Model(&passkey).Updates(map[string]interface{}{
+		"sign_count": passkey.SignCount,
+		"last_used":  passkey.LastUsed,
+	}).Error
+}
+
+// Delete removes the passkey if it belongs to the user.
+func (p *passkeys) Delete(userID, id string) error {
+	r := p.conn.DB().Where("id = ? AND user_id = ?", id, userID).Delete(&model.Passkey{})
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
+type identities struct {
+	conn *Conn
+}
+
+func NewIdentities(conn *Conn) Identities {
+	return &identities{conn: conn}
+}
+
+func (i *identities) Find(provider, subject string) (model.UserIdentity, error) {
+	var identity model.UserIdentity
+
+	r := i.conn.DB().First(&identity, "provider = ? AND subject = ?", provider, subject)
+	if r.Error != nil {
+		return identity, r.Error
+	}
+
+	return identity, nil
+}
+
+func (i *identities) FindByUser(userID string) ([]model.UserIdentity, error) {
+	var items []model.UserIdentity
+
+	r := i.conn.DB().Where("user_id = ?", userID).Order("created").Find(&items)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return items, nil
+}
+
+func (i *identities) Create(identity model.UserIdentity) error {
+	return i.conn.DB().Create(&identity).Error
+}
+
+// Delete removes the identities of the provider linked to the user.
+func (i *identities) Delete(userID, provider string) error {
+	r := i.conn.DB().Where("user_id = ? AND provider = ?", userID, provider).Delete(&model.UserIdentity{})
+	if r.Error != nil {
+		return r.Error
+	}
+
+	if r.RowsAffected == 0 {
+		return ErrorNotFound
+	}
+
+	return nil
+}
+
+type profiles struct {
+	conn *Conn
+}
+
+func NewProfiles(conn *Conn) Profiles {
+	return &profiles{conn: conn}
+}
+
+func (p *profiles) FindByUser(userID string) ([]model.Profile, error) {
+	var items []model.Profile
+
+	r := p.conn.DB().Where("user_id = ?", userID).Order("provider").Find(&items)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return items, nil
+}
+
+func (p *profiles) Save(profile model.Profile) error {
+	return p.conn.DB().Save(&profile).Error
+}
+
+// Delete removes the profiles of the provider identities of the user.
+func (p *profiles) Delete(userID, provider string) error {
+	return p.conn.DB().Where("user_id = ? AND provider = ?", userID, provider).Delete(&model.Profile{}).Error
+}
+
+type providerTokens struct {
+	conn *Conn
+}
+
+func NewProviderTokens(conn *Conn) ProviderTokens {
+	return &providerTokens{conn: conn}
+}
+
+func (p *providerTokens) Find(provider, subject string) (model.ProviderToken, error) {
+	var token model.ProviderToken
+
+	r := p.conn.DB().First(&token, "provider = ? AND subject = ?", provider, subject)
+	if r.Error != nil {
+		return token, r.Error
+	}
+
+	return token, nil
+}
+
+// FindByUser returns the most recently updated token of the provider
+// identities of the user.
+func (p *providerTokens) FindByUser(userID, provider string) (model.ProviderToken, error) {
+	var token model.ProviderToken
+
+	r := p.conn.DB().
+		Where("user_id = ? AND provider = ?", userID, provider).
+		Order("updated DESC").
+		First(&token)
+	if r.Error != nil {
+		return token, r.Error
+	}
+
+	return token, nil
+}
+
+func (p *providerTokens) Save(token model.ProviderToken) error {
+	return p.conn.DB().Save(&token).Error
+}
+
+// Delete removes the tokens of the provider identities of the user.
+func (p *providerTokens) Delete(userID, provider string) error {
+	return p.conn.DB().Where("user_id = ? AND provider = ?", userID, provider).Delete(&model.ProviderToken{}).Error
+}
+
+type webhookEvents struct {
+	conn *Conn
+}
+
+func NewWebhookEvents(conn *Conn) WebhookEvents {
+	return &webhookEvents{conn: conn}
+}
+
+func (w *webhookEvents) Create(event model.WebhookEvent) error {
+	return w.conn.DB().Create(&event).Error
+}
+
+// FindDue returns the events which are not failed and whose next attempt is
+// before the time given, the most overdue first.
+func (w *webhookEvents) FindDue(before int64, limit int) ([]model.WebhookEvent, error) {
+	var items []model.WebhookEvent
+
+	r := w.conn.DB().
+		Where("failed = ? AND next_attempt <= ?", false, before).
+		Order("next_attempt").
+		Limit(limit).
+		Find(&items)
+	if r.Error != nil {
+		return nil, r.Error
+	}
+
+	return items, nil
+}
+
+// Update saves the result of a delivery attempt.
+func (w *webhookEvents) Update(event model.WebhookEvent) error {
+	return w.conn.DB().Model(&event).Updates(map[string]interface{}{
+		"attempts":     event.Attempts,
+		"next_attempt": event.NextAttempt,
+		"last_error":   event.LastError,
+		"failed":       event.Failed,
+	}).Error
+}
+
+func (w *webhookEvents) Delete(id string) error {
+	return w.conn.DB().Delete(&model.WebhookEvent{}, "id = ?", id).Error
 }
@@ -10,53 +10,9 @@ import (
 
 	"github.com/vbogretsov/guard/model"
 	"github.com/vbogretsov/guard/repo"
+	"github.com/vbogretsov/guard/repo/repotest"
 )
 
-var users = []model.User{
-	{
-		ID:      "123",
-		Name:    "u0@mail.org",
-		Created: 1000000000,
-	},
-	{
-		ID:      "456",
-		Name:    "u1@mail.org",
-		Created: 1000000000,
-	},
-}
-
-var refreshTokens = []model.RefreshToken{
-	{
-		ID:      "abc123",
-		UserID:  "123",
-		User:    users[0],
-		Created: 1000000000,
-		Expires: 1000000010,
-	},
-	{
-		ID:      "abc456",
-		UserID:  "456",
-		User:    users[1],
-		Created: 1000000000,
-		Expires: 1000000010,
-	},
-}
-
-var sessions = []model.Session{
-	{
-		ID:      "123",
-		Value:   "session.123",
-		Created: 1000000000,
-		Expires: 1000000010,
-	},
-	{
-		ID:      "456",
-		Value:   "session.123",
-		Created: 1000000010,
-		Expires: 1000000020,
-	},
-}
-
 func TestOnSQLite(t *testing.T) {
 	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
 		Logger: logger.Default.LogMode(logger.Silent),
@@ -66,103 +22,413 @@ func TestOnSQLite(t *testing.T) {
 	require.NoError(t, db.AutoMigrate(&model.User{}), "failed to auto migrate users")
 	require.NoError(t, db.AutoMigrate(&model.RefreshToken{}), "failed to auto migrate refresh_tokens")
 	require.NoError(t, db.AutoMigrate(&model.Session{}), "failed to auto migrate sessions")
+	require.NoError(t, db.AutoMigrate(&model.Client{}), "failed to auto migrate clients")
+	require.NoError(t, db.AutoMigrate(&model.Credential{}), "failed to auto migrate credentials")
+	require.NoError(t, db.AutoMigrate(&model.TOTP{}), "failed to auto migrate totps")
+	require.NoError(t, db.AutoMigrate(&model.Passkey{}), "failed to auto migrate passkeys")
+	require.NoError(t, db.AutoMigrate(&model.UserIdentity{}), "failed to auto migrate user_identities")
+	require.NoError(t, db.AutoMigrate(&model.Profile{}), "failed to auto migrate profiles")
+	require.NoError(t, db.AutoMigrate(&model.WebhookEvent{}), "failed to auto migrate webhook_events")
+	require.NoError(t, db.AutoMigrate(&model.ProviderToken{}), "failed to auto migrate provider_tokens")
 
-	t.Run("Users", func(t *testing.T) {
-		ur := repo.NewUsers(db)
+	sqldb, err := db.DB()
+	require.NoError(t, err)
 
-		for _, u := range users {
-			t.Run("Create", func(t *testing.T) {
-				require.NoError(t, ur.Create(u), "failed to create user(%s)", u.ID)
-			})
-		}
+	// Every connection to :memory: opens a new database.
+	sqldb.SetMaxOpenConns(1)
+
+	repotest.Run(t, repotest.Backend{
+		NewScope: func() repotest.Scope {
+			conn := repo.NewConn(db)
+			return repotest.Scope{
+				Tx:            conn,
+				Users:         repo.NewUsers(conn),
+				RefreshTokens: repo.NewRefreshTokens(conn),
+				Sessions:      repo.NewSessions(conn),
+			}
+		},
+	})
+
+	conn := repo.NewConn(db)
 
-		for _, u1 := range users {
-			t.Run("Find", func(t *testing.T) {
-				u2, err := ur.Find(u1.Name)
-				require.NoError(t, err, "failed to find user(%s)", u1.ID)
-				require.Equal(t, u1, u2, "the user found does not match expected one")
-			})
+	t.Run("Clients", func(t *testing.T) {
+		cr := repo.NewClients(conn)
+
+		client := model.Client{
+			ID:         "client.123",
+			SecretHash: "hash.123",
+			Redirects:  "http://app.local/callback",
+			Providers:  "google github",
+			Scopes:     "users:read",
+			AccessTTL:  60,
+			RefreshTTL: 3600,
+			Created:    1600000000,
 		}
 
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, cr.Create(client))
+		})
+
+		t.Run("Find", func(t *testing.T) {
+			value, err := cr.Find(client.ID)
+			require.NoError(t, err)
+			require.Equal(t, client, value)
+		})
+
 		t.Run("NotFind", func(t *testing.T) {
-			u, err := ur.Find("xxx")
-			require.Error(t, err, "found user(%s)", u.Name)
+			_, err := cr.Find("xxx")
+			require.ErrorIs(t, err, repo.ErrorNotFound)
+		})
+
+		t.Run("Save", func(t *testing.T) {
+			client.Scopes = "users:read users:write"
+			require.NoError(t, cr.Save(client))
+
+			value, err := cr.Find(client.ID)
+			require.NoError(t, err)
+			require.Equal(t, client, value)
+
+			created := model.Client{ID: "client.456", Redirects: "http://app.local/callback", Created: 1600000000}
+			require.NoError(t, cr.Save(created))
+
+			value, err = cr.Find(created.ID)
+			require.NoError(t, err)
+			require.Equal(t, created, value)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			require.NoError(t, cr.Delete(client.ID))
+
+			_, err := cr.Find(client.ID)
 			require.ErrorIs(t, err, repo.ErrorNotFound)
 		})
 	})
 
-	t.Run("RefreshTokens", func(t *testing.T) {
-		rr := repo.NewRefreshTokens(db)
+	t.Run("Credentials", func(t *testing.T) {
+		cr := repo.NewCredentials(conn)
 
-		for _, rt := range refreshTokens {
-			t.Run("Create", func(t *testing.T) {
-				require.NoError(t, rr.Create(rt), "failed to create refreshToken(%s)", rt.ID)
-			})
-		}
+		credential := model.Credential{UserID: "user.123", PasswordHash: "hash.123", Updated: 1600000000}
 
-		for i, rt1 := range refreshTokens {
-			t.Run("Find", func(t *testing.T) {
-				rt2, err := rr.Find(rt1.ID)
-				require.NoError(t, err, "failed to find refreshToken(%s)", rt1.ID)
-				require.Equal(t, rt1, rt2, "the refreshToken found does not match expected one")
-				require.Equal(t, users[i], rt2.User)
-			})
-		}
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, cr.Save(credential))
+
+			value, err := cr.Find(credential.UserID)
+			require.NoError(t, err)
+			require.Equal(t, credential, value)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			credential.PasswordHash = "hash.456"
+			credential.Updated = 1600000010
+			require.NoError(t, cr.Save(credential))
+
+			value, err := cr.Find(credential.UserID)
+			require.NoError(t, err)
+			require.Equal(t, credential, value)
+		})
 
 		t.Run("NotFind", func(t *testing.T) {
-			rt, err := rr.Find("xxx")
-			require.Error(t, err, "found refreshToken(%s)", rt.ID)
+			_, err := cr.Find("xxx")
 			require.ErrorIs(t, err, repo.ErrorNotFound)
 		})
+	})
+	t.Run("TOTPs", func(t *testing.T) {
+		tr := repo.NewTOTPs(conn)
+
+		totp := model.TOTP{UserID: "user.123", Secret: "secret.123", RecoveryCodes: "hash1 hash2", Created: 1600000000}
+
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, tr.Save(totp))
+
+			value, err := tr.Find(totp.UserID)
+			require.NoError(t, err)
+			require.Equal(t, totp, value)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			totp.Enabled = true
+			totp.LastStep = 53333333
+			totp.RecoveryCodes = "hash2"
+			require.NoError(t, tr.Save(totp))
+
+			value, err := tr.Find(totp.UserID)
+			require.NoError(t, err)
+			require.Equal(t, totp, value)
+		})
+
+		t.Run("Use", func(t *testing.T) {
+			next := totp
+			next.LastStep = 53333334
+			next.RecoveryCodes = ""
+			require.NoError(t, tr.Use(totp, next))
+
+			value, err := tr.Find(totp.UserID)
+			require.NoError(t, err)
+			require.Equal(t, next, value)
+
+			stale := totp
+			stale.LastStep = 53333335
+			require.ErrorIs(t, tr.Use(totp, stale), repo.ErrorConsumed)
+
+			value, err = tr.Find(totp.UserID)
+			require.NoError(t, err)
+			require.Equal(t, next, value)
+		})
 
 		t.Run("Delete", func(t *testing.T) {
-			id0 := refreshTokens[0].ID
-			require.NoError(t, rr.Delete(id0), "failed to delete refreshToken(%s)", id0)
+			require.NoError(t, tr.Delete(totp.UserID))
 
-			_, err := rr.Find(id0)
-			require.Error(t, err)
+			_, err := tr.Find(totp.UserID)
 			require.ErrorIs(t, err, repo.ErrorNotFound)
+		})
+	})
+
+	t.Run("Passkeys", func(t *testing.T) {
+		pr := repo.NewPasskeys(conn)
 
-			id1 := refreshTokens[1].ID
-			_, err = rr.Find(id1)
+		first := model.Passkey{ID: "key.1", UserID: "user.123", PublicKey: []byte("key1"), SignCount: 1, Created: 1600000000}
+		second := model.Passkey{ID: "key.2", UserID: "user.123", PublicKey: []byte("key2"), Created: 1600000010}
+
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, pr.Create(second))
+			require.NoError(t, pr.Create(first))
+			require.Error(t, pr.Create(first))
+
+			value, err := pr.Find(first.ID)
+			require.NoError(t, err)
+			require.Equal(t, first, value)
+
+			_, err = pr.Find("key.xxx")
+			require.ErrorIs(t, err, repo.ErrorNotFound)
+		})
+
+		t.Run("FindByUser", func(t *testing.T) {
+			items, err := pr.FindByUser("user.123")
+			require.NoError(t, err)
+			require.Equal(t, []model.Passkey{first, second}, items)
+
+			items, err = pr.FindByUser("user.456")
 			require.NoError(t, err)
+			require.Empty(t, items)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			first.SignCount = 2
+			first.LastUsed = 1600000100
+			require.NoError(t, pr.Update(model.Passkey{ID: first.ID, SignCount: 2, LastUsed: 1600000100}))
+
+			value, err := pr.Find(first.ID)
+			require.NoError(t, err)
+			require.Equal(t, first, value)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			require.ErrorIs(t, pr.Delete("user.456", first.ID), repo.ErrorNotFound)
+			require.NoError(t, pr.Delete("user.123", first.ID))
+			require.ErrorIs(t, pr.Delete("user.123", first.ID), repo.ErrorNotFound)
+
+			_, err := pr.Find(first.ID)
+			require.ErrorIs(t, err, repo.ErrorNotFound)
 		})
 	})
 
-	t.Run("Sessions", func(t *testing.T) {
-		sr := repo.NewSessions(db)
+	t.Run("Identities", func(t *testing.T) {
+		ir := repo.NewIdentities(conn)
 
-		for _, s := range sessions {
-			t.Run("Create", func(t *testing.T) {
-				require.NoError(t, sr.Create(s), "failed to create session(%s)", s.ID)
-			})
-		}
+		google := model.UserIdentity{Provider: "google", Subject: "google.123", UserID: "user.123", Email: "u0@mail.org", Created: 1600000010}
+		github := model.UserIdentity{Provider: "github", Subject: "github.123", UserID: "user.123", Created: 1600000000}
 
-		for _, s1 := range sessions {
-			t.Run("Find", func(t *testing.T) {
-				s2, err := sr.Find(s1.ID)
-				require.NoError(t, err, "failed to find session(%s)", s1.ID)
-				require.Equal(t, s1, s2, "the xsrfToken found does not match expected one")
-			})
-		}
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, ir.Create(google))
+			require.NoError(t, ir.Create(github))
+			require.Error(t, ir.Create(google))
 
-		t.Run("NotFind", func(t *testing.T) {
-			xt, err := sr.Find("xxx")
-			require.Error(t, err, "found xsrfToken(%s)", xt.ID)
+			value, err := ir.Find(google.Provider, google.Subject)
+			require.NoError(t, err)
+			require.Equal(t, google, value)
+
+			_, err = ir.Find("github", google.Subject)
 			require.ErrorIs(t, err, repo.ErrorNotFound)
 		})
 
+		t.Run("FindByUser", func(t *testing.T) {
+			items, err := ir.FindByUser("user.123")
+			require.NoError(t, err)
+			require.Equal(t, []model.UserIdentity{github, google}, items)
+
+			items, err = ir.FindByUser("user.456")
+			require.NoError(t, err)
+			require.Empty(t, items)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			require.ErrorIs(t, ir.Delete("user.456", "google"), repo.ErrorNotFound)
+			require.NoError(t, ir.Delete("user.123", "google"))
+			require.ErrorIs(t, ir.Delete("user.123", "google"), repo.ErrorNotFound)
+
+			_, err := ir.Find(google.Provider, google.Subject)
+			require.ErrorIs(t, err, repo.ErrorNotFound)
+
+			_, err = ir.Find(github.Provider, github.Subject)
+			require.NoError(t, err)
+		})
+	})
+
+	t.Run("Profiles", func(t *testing.T) {
+		pr := repo.NewProfiles(conn)
+
+		google := model.Profile{
+			Provider:      "google",
+			Subject:       "google.123",
+			UserID:        "user.123",
+			Name:          "User Zero",
+			Email:         "u0@mail.org",
+			EmailVerified: true,
+			RawData:       `{"email":"u0@mail.org"}`,
+			Updated:       1600000010,
+		}
+		github := model.Profile{
+			Provider: "github",
+			Subject:  "github.123",
+			UserID:   "user.123",
+			RawData:  `{}`,
+			Updated:  1600000000,
+		}
+
+		t.Run("Save", func(t *testing.T) {
+			require.NoError(t, pr.Save(google))
+			require.NoError(t, pr.Save(github))
+
+			google.Name = "User 0"
+			google.Updated = 1600000020
+			require.NoError(t, pr.Save(google))
+		})
+
+		t.Run("FindByUser", func(t *testing.T) {
+			items, err := pr.FindByUser("user.123")
+			require.NoError(t, err)
+			require.Equal(t, []model.Profile{github, google}, items)
+
+			items, err = pr.FindByUser("user.456")
+			require.NoError(t, err)
+			require.Empty(t, items)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			require.NoError(t, pr.Delete("user.456", "google"))
+			require.NoError(t, pr.Delete("user.123", "google"))
+
+			items, err := pr.FindByUser("user.123")
+			require.NoError(t, err)
+			require.Equal(t, []model.Profile{github}, items)
+		})
+	})
+
+	t.Run("WebhookEvents", func(t *testing.T) {
+		wr := repo.NewWebhookEvents(conn)
+
+		first := model.WebhookEvent{ID: "webhook.1", URL: "http://a.example.org", Payload: "{}", NextAttempt: 1600000020, Created: 1600000000}
+		second := model.WebhookEvent{ID: "webhook.2", URL: "http://b.example.org", Payload: "{}", NextAttempt: 1600000010, Created: 1600000000}
+		third := model.WebhookEvent{ID: "webhook.3", URL: "http://c.example.org", Payload: "{}", NextAttempt: 1600000100, Created: 1600000000}
+
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, wr.Create(first))
+			require.NoError(t, wr.Create(second))
+			require.NoError(t, wr.Create(third))
+			require.Error(t, wr.Create(first))
+		})
+
+		t.Run("FindDue", func(t *testing.T) {
+			items, err := wr.FindDue(1600000050, 10)
+			require.NoError(t, err)
+			require.Equal(t, []model.WebhookEvent{second, first}, items)
+
+			items, err = wr.FindDue(1600000050, 1)
+			require.NoError(t, err)
+			require.Equal(t, []model.WebhookEvent{second}, items)
+		})
+
+		t.Run("Update", func(t *testing.T) {
+			second.Attempts = 1
+			second.NextAttempt = 1600000200
+			second.LastError = "unexpected status: 500"
+			require.NoError(t, wr.Update(second))
+
+			first.Attempts = 3
+			first.LastError = "unexpected status: 500"
+			first.Failed = true
+			require.NoError(t, wr.Update(first))
+
+			items, err := wr.FindDue(1600000300, 10)
+			require.NoError(t, err)
+			require.Equal(t, []model.WebhookEvent{third, second}, items)
+		})
+
 		t.Run("Delete", func(t *testing.T) {
-			id0 := sessions[0].ID
-			require.NoError(t, sr.Delete(id0), "failed to delete session(%s)", id0)
+			require.NoError(t, wr.Delete(third.ID))
+
+			items, err := wr.FindDue(1600000300, 10)
+			require.NoError(t, err)
+			require.Equal(t, []model.WebhookEvent{second}, items)
+		})
+	})
+
+	t.Run("ProviderTokens", func(t *testing.T) {
+		tr := repo.NewProviderTokens(conn)
+
+		first := model.ProviderToken{Provider: "google", Subject: "google.1", UserID: "user.123", AccessToken: "a1", RefreshToken: "r1", Expires: 1600003600, Updated: 1600000000}
+		second := model.ProviderToken{Provider: "google", Subject: "google.2", UserID: "user.123", AccessToken: "a2", Updated: 1600000010}
 
-			_, err := sr.Find(id0)
-			require.Error(t, err)
+		t.Run("Save", func(t *testing.T) {
+			require.NoError(t, tr.Save(first))
+			require.NoError(t, tr.Save(second))
+
+			first.AccessToken = "a1.new"
+			first.Updated = 1600000020
+			require.NoError(t, tr.Save(first))
+
+			value, err := tr.Find("google", "google.1")
+			require.NoError(t, err)
+			require.Equal(t, first, value)
+
+			_, err = tr.Find("github", "google.1")
 			require.ErrorIs(t, err, repo.ErrorNotFound)
+		})
 
-			id1 := sessions[1].ID
-			_, err = sr.Find(id1)
+		t.Run("FindByUser", func(t *testing.T) {
+			value, err := tr.FindByUser("user.123", "google")
 			require.NoError(t, err)
+			require.Equal(t, first, value)
+
+			_, err = tr.FindByUser("user.456", "google")
+			require.ErrorIs(t, err, repo.ErrorNotFound)
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			require.NoError(t, tr.Delete("user.456", "google"))
+			require.NoError(t, tr.Delete("user.123", "google"))
+
+			_, err := tr.FindByUser("user.123", "google")
+			require.ErrorIs(t, err, repo.ErrorNotFound)
 		})
 	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		ur := repo.NewUsers(conn)
+		cr := repo.NewCredentials(conn)
+		tr := repo.NewProviderTokens(conn)
+
+		user := model.User{ID: "user.999", Name: "u999@mail.org", Created: 1600000000}
+		require.NoError(t, ur.Create(user))
+		require.NoError(t, cr.Save(model.Credential{UserID: user.ID, PasswordHash: "hash", Updated: 1600000000}))
+		require.NoError(t, tr.Save(model.ProviderToken{Provider: "google", Subject: "google.999", UserID: user.ID, AccessToken: "a"}))
+
+		require.NoError(t, ur.Delete(user.ID))
+
+		_, err := cr.Find(user.ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+
+		_, err = tr.FindByUser(user.ID, "google")
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
 }
@@ -0,0 +1,508 @@
+// Package repotest is the conformance suite of the users, the refresh tokens
+// and the sessions repositories. Every repository backend has to pass it.
+package repotest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/model"
+	"github.com/vbogretsov/guard/repo"
+)
+
+// Scope is a set of repositories sharing the transaction.
+type Scope struct {
+	// Tx is nil if the backend does not support transactions.
+	Tx            repo.Transaction
+	Users         repo.Users
+	RefreshTokens repo.RefreshTokens
+	Sessions      repo.Sessions
+}
+
+// Backend is the repository backend under test. The scopes of a backend share
+// the storage, which has to be empty when the suite starts.
+type Backend struct {
+	NewScope func() Scope
+	// Expire makes the backend drop the records expired before the time
+	// given. The backends expiring the records natively leave DeleteExpired
+	// to do nothing, nil means DeleteExpired deletes the records.
+	Expire func(before int64)
+}
+
+var Users = []model.User{
+	{
+		ID:      "123",
+		Name:    "u0@mail.org",
+		Created: 1000000000,
+	},
+	{
+		ID:      "456",
+		Name:    "u1@mail.org",
+		Created: 1000000000,
+	},
+}
+
+var RefreshTokens = []model.RefreshToken{
+	{
+		ID:      "abc123",
+		UserID:  "123",
+		User:    Users[0],
+		Family:  "abc123",
+		Created: 1000000000,
+		Expires: 1000000010,
+	},
+	{
+		ID:      "abc456",
+		UserID:  "456",
+		User:    Users[1],
+		Family:  "abc456",
+		Created: 1000000000,
+		Expires: 1000000010,
+	},
+}
+
+var Sessions = []model.Session{
+	{
+		ID:      "123",
+		Value:   "session.123",
+		Created: 1000000000,
+		Expires: 1000000010,
+	},
+	{
+		ID:      "456",
+		Value:   "session.123",
+		Created: 1000000010,
+		Expires: 1000000020,
+	},
+}
+
+// Run runs the suite against the backend. The records expire later than
+// 1000000020 unless the test is about the expiration, so a backend expiring
+// the records natively should start its clock at 1000000000.
+func Run(t *testing.T, b Backend) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, b) })
+	t.Run("RefreshTokens", func(t *testing.T) { testRefreshTokens(t, b) })
+	t.Run("Transaction", func(t *testing.T) { testTransaction(t, b) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, b) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, b) })
+	t.Run("Expiration", func(t *testing.T) { testExpiration(t, b) })
+}
+
+func testUsers(t *testing.T, b Backend) {
+	ur := b.NewScope().Users
+
+	for _, u := range Users {
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, ur.Create(u), "failed to create user(%s)", u.ID)
+		})
+	}
+
+	for _, u1 := range Users {
+		t.Run("Find", func(t *testing.T) {
+			u2, err := ur.Find(u1.Name)
+			require.NoError(t, err, "failed to find user(%s)", u1.ID)
+			require.Equal(t, u1, u2, "the user found does not match expected one")
+		})
+	}
+
+	t.Run("FindByID", func(t *testing.T) {
+		u, err := ur.FindByID(Users[1].ID)
+		require.NoError(t, err)
+		require.Equal(t, Users[1], u)
+
+		_, err = ur.FindByID("xxx")
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
+
+	t.Run("NotFind", func(t *testing.T) {
+		u, err := ur.Find("xxx")
+		require.Error(t, err, "found user(%s)", u.Name)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
+
+	t.Run("Duplicate", func(t *testing.T) {
+		require.Error(t, ur.Create(Users[0]))
+	})
+
+	t.Run("List", func(t *testing.T) {
+		users, err := ur.List(0, 10)
+		require.NoError(t, err)
+		require.Equal(t, Users, users)
+
+		users, err = ur.List(1, 10)
+		require.NoError(t, err)
+		require.Equal(t, Users[1:], users)
+
+		users, err = ur.List(0, 1)
+		require.NoError(t, err)
+		require.Equal(t, Users[:1], users)
+	})
+
+	t.Run("SetDisabled", func(t *testing.T) {
+		require.NoError(t, ur.SetDisabled(Users[0].ID, true))
+
+		u, err := ur.FindByID(Users[0].ID)
+		require.NoError(t, err)
+		require.True(t, u.Disabled)
+
+		require.NoError(t, ur.SetDisabled(Users[0].ID, false))
+
+		u, err = ur.FindByID(Users[0].ID)
+		require.NoError(t, err)
+		require.Equal(t, Users[0], u)
+
+		require.ErrorIs(t, ur.SetDisabled("xxx", true), repo.ErrorNotFound)
+	})
+
+	t.Run("SetVerified", func(t *testing.T) {
+		u := model.User{ID: "790", Name: "u3@mail.org", Created: 1000000020}
+		require.NoError(t, ur.Create(u))
+
+		require.NoError(t, ur.SetVerified(u.ID))
+		require.NoError(t, ur.SetVerified(u.ID), "already verified")
+
+		found, err := ur.FindByID(u.ID)
+		require.NoError(t, err)
+		require.True(t, found.Verified)
+
+		require.NoError(t, ur.SetVerified("xxx"))
+		require.NoError(t, ur.Delete(u.ID))
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		u := model.User{ID: "789", Name: "u2@mail.org", Created: 1000000010}
+		require.NoError(t, ur.Create(u))
+		require.NoError(t, ur.Delete(u.ID))
+
+		_, err := ur.FindByID(u.ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+
+		require.ErrorIs(t, ur.Delete(u.ID), repo.ErrorNotFound)
+	})
+}
+
+func testRefreshTokens(t *testing.T, b Backend) {
+	rr := b.NewScope().RefreshTokens
+
+	for _, rt := range RefreshTokens {
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, rr.Create(rt), "failed to create refreshToken(%s)", rt.ID)
+		})
+	}
+
+	for i, rt1 := range RefreshTokens {
+		t.Run("Find", func(t *testing.T) {
+			rt2, err := rr.Find(rt1.ID)
+			require.NoError(t, err, "failed to find refreshToken(%s)", rt1.ID)
+			require.Equal(t, rt1, rt2, "the refreshToken found does not match expected one")
+			require.Equal(t, Users[i], rt2.User)
+		})
+	}
+
+	t.Run("NotFind", func(t *testing.T) {
+		rt, err := rr.Find("xxx")
+		require.Error(t, err, "found refreshToken(%s)", rt.ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		id0 := RefreshTokens[0].ID
+		require.NoError(t, rr.Delete(id0), "failed to delete refreshToken(%s)", id0)
+
+		_, err := rr.Find(id0)
+		require.Error(t, err)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+
+		id1 := RefreshTokens[1].ID
+		_, err = rr.Find(id1)
+		require.NoError(t, err)
+	})
+
+	t.Run("Consume", func(t *testing.T) {
+		rt1 := RefreshTokens[1]
+
+		rt2, err := rr.Consume(rt1.ID)
+		require.NoError(t, err)
+		require.True(t, rt2.Used)
+		require.Equal(t, rt1.User, rt2.User)
+
+		rt3, err := rr.Consume(rt1.ID)
+		require.ErrorIs(t, err, repo.ErrorConsumed)
+		require.Equal(t, rt1.Family, rt3.Family)
+
+		rt4, err := rr.Find(rt1.ID)
+		require.NoError(t, err)
+		require.True(t, rt4.Used)
+
+		_, err = rr.Consume("xxx")
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
+
+	t.Run("DeleteFamily", func(t *testing.T) {
+		family := RefreshTokens[1].Family
+
+		next := model.RefreshToken{
+			ID:      "abc789",
+			UserID:  "456",
+			User:    Users[1],
+			Family:  family,
+			Created: 1000000010,
+			Expires: 1000000020,
+		}
+		require.NoError(t, rr.Create(next))
+
+		require.NoError(t, rr.DeleteFamily(family))
+
+		_, err := rr.Find(RefreshTokens[1].ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+
+		_, err = rr.Find(next.ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
+
+	t.Run("DeleteByUser", func(t *testing.T) {
+		tokens := []model.RefreshToken{
+			{ID: "user123.1", UserID: "123", User: Users[0], Family: "user123.1", Expires: 1000000100},
+			{ID: "user123.2", UserID: "123", User: Users[0], Family: "user123.2", Expires: 1000000100},
+			{ID: "user456.1", UserID: "456", User: Users[1], Family: "user456.1", Expires: 1000000100},
+		}
+		for _, token := range tokens {
+			require.NoError(t, rr.Create(token))
+		}
+
+		require.NoError(t, rr.DeleteByUser("123"))
+
+		_, err := rr.Find(tokens[0].ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+
+		_, err = rr.Find(tokens[1].ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+
+		_, err = rr.Find(tokens[2].ID)
+		require.NoError(t, err)
+	})
+}
+
+func testTransaction(t *testing.T, b Backend) {
+	s := b.NewScope()
+	if s.Tx == nil {
+		t.Skip("the backend does not support transactions")
+	}
+
+	conn, rr, sr := s.Tx, s.RefreshTokens, s.Sessions
+
+	token := model.RefreshToken{
+		ID:      "tx123",
+		UserID:  "123",
+		User:    Users[0],
+		Family:  "tx123",
+		Created: 1000000000,
+		Expires: 1000000100,
+	}
+
+	t.Run("Rollback", func(t *testing.T) {
+		require.NoError(t, conn.Begin())
+		require.Error(t, conn.Begin())
+		require.NoError(t, rr.Create(token))
+		require.NoError(t, conn.Close())
+
+		_, err := rr.Find(token.ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
+
+	t.Run("Commit", func(t *testing.T) {
+		require.NoError(t, conn.Begin())
+		require.NoError(t, rr.Create(token))
+		require.NoError(t, conn.Commit())
+		require.NoError(t, conn.Close())
+
+		_, err := rr.Find(token.ID)
+		require.NoError(t, err)
+	})
+
+	t.Run("RollbackChanges", func(t *testing.T) {
+		sess := model.Session{ID: "tx.session", Expires: 1000000100}
+		require.NoError(t, sr.Create(sess))
+
+		require.NoError(t, conn.Begin())
+
+		_, err := rr.Consume(token.ID)
+		require.NoError(t, err)
+		_, err = sr.Consume(sess.ID)
+		require.NoError(t, err)
+		require.NoError(t, rr.DeleteByUser(token.UserID))
+
+		require.NoError(t, conn.Close())
+
+		value, err := rr.Find(token.ID)
+		require.NoError(t, err)
+		require.False(t, value.Used)
+
+		_, err = sr.Find(sess.ID)
+		require.NoError(t, err)
+
+		require.NoError(t, rr.Delete(token.ID))
+		require.NoError(t, sr.Delete(sess.ID))
+	})
+
+	t.Run("CommitNotStarted", func(t *testing.T) {
+		require.Error(t, conn.Commit())
+	})
+}
+
+func testSessions(t *testing.T, b Backend) {
+	sr := b.NewScope().Sessions
+
+	for _, s := range Sessions {
+		t.Run("Create", func(t *testing.T) {
+			require.NoError(t, sr.Create(s), "failed to create session(%s)", s.ID)
+		})
+	}
+
+	for _, s1 := range Sessions {
+		t.Run("Find", func(t *testing.T) {
+			s2, err := sr.Find(s1.ID)
+			require.NoError(t, err, "failed to find session(%s)", s1.ID)
+			require.Equal(t, s1, s2, "the session found does not match expected one")
+		})
+	}
+
+	t.Run("NotFind", func(t *testing.T) {
+		s, err := sr.Find("xxx")
+		require.Error(t, err, "found session(%s)", s.ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		id0 := Sessions[0].ID
+		require.NoError(t, sr.Delete(id0), "failed to delete session(%s)", id0)
+
+		_, err := sr.Find(id0)
+		require.Error(t, err)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+
+		id1 := Sessions[1].ID
+		_, err = sr.Find(id1)
+		require.NoError(t, err)
+	})
+
+	t.Run("Consume", func(t *testing.T) {
+		s1 := Sessions[1]
+
+		s2, err := sr.Consume(s1.ID)
+		require.NoError(t, err)
+		require.True(t, s2.Used)
+		s2.Used = false
+		require.Equal(t, s1, s2)
+
+		s3, err := sr.Find(s1.ID)
+		require.NoError(t, err)
+		require.True(t, s3.Used)
+
+		s4, err := sr.Consume(s1.ID)
+		require.ErrorIs(t, err, repo.ErrorConsumed)
+		require.Equal(t, s1.Value, s4.Value)
+
+		_, err = sr.Consume("xxx")
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	})
+}
+
+// testConcurrency checks only one of the concurrent callers consumes a token
+// or a session. Every goroutine uses its own scope.
+func testConcurrency(t *testing.T, b Backend) {
+	const n = 8
+
+	consume := func(fn func(s Scope) error) int {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		succeeded := 0
+
+		wg.Add(n)
+		for i := 0; i < n; i++ {
+			s := b.NewScope()
+			go func() {
+				defer wg.Done()
+				if fn(s) == nil {
+					mu.Lock()
+					succeeded++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+
+		return succeeded
+	}
+
+	t.Run("RefreshTokens", func(t *testing.T) {
+		token := model.RefreshToken{ID: "race.1", UserID: "123", Family: "race.1", Expires: 1000000100}
+		require.NoError(t, b.NewScope().RefreshTokens.Create(token))
+
+		require.Equal(t, 1, consume(func(s Scope) error {
+			_, err := s.RefreshTokens.Consume(token.ID)
+			return err
+		}))
+	})
+
+	t.Run("Sessions", func(t *testing.T) {
+		sess := model.Session{ID: "race.1", Expires: 1000000100}
+		require.NoError(t, b.NewScope().Sessions.Create(sess))
+
+		require.Equal(t, 1, consume(func(s Scope) error {
+			_, err := s.Sessions.Consume(sess.ID)
+			return err
+		}))
+	})
+}
+
+func testExpiration(t *testing.T, b Backend) {
+	s := b.NewScope()
+	rr, sr := s.RefreshTokens, s.Sessions
+
+	tokens := []model.RefreshToken{
+		{ID: "expired.1", UserID: "123", User: Users[0], Family: "expired.1", Expires: 2000000000},
+		{ID: "expired.2", UserID: "123", User: Users[0], Family: "expired.2", Expires: 2000000000},
+		{ID: "expired.3", UserID: "123", User: Users[0], Family: "expired.3", Expires: 2000000000},
+		{ID: "alive.1", UserID: "123", User: Users[0], Family: "alive.1", Expires: 2000000020},
+	}
+	for _, token := range tokens {
+		require.NoError(t, rr.Create(token))
+	}
+
+	require.NoError(t, sr.Create(model.Session{ID: "expired", Expires: 2000000000}))
+	require.NoError(t, sr.Create(model.Session{ID: "alive", Expires: 2000000020}))
+
+	if b.Expire != nil {
+		b.Expire(2000000010)
+	} else {
+		n, err := rr.DeleteExpired(2000000010, 2)
+		require.NoError(t, err)
+		require.Equal(t, int64(2), n)
+	}
+
+	for n := int64(1); n > 0; {
+		var err error
+		n, err = rr.DeleteExpired(2000000010, 2)
+		require.NoError(t, err)
+	}
+
+	_, err := sr.DeleteExpired(2000000010, 10)
+	require.NoError(t, err)
+
+	for _, token := range tokens[:3] {
+		_, err = rr.Find(token.ID)
+		require.ErrorIs(t, err, repo.ErrorNotFound)
+	}
+
+	_, err = rr.Find("alive.1")
+	require.NoError(t, err)
+
+	_, err = sr.Find("expired")
+	require.ErrorIs(t, err, repo.ErrorNotFound)
+
+	_, err = sr.Find("alive")
+	require.NoError(t, err)
+}
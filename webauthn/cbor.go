@@ -0,0 +1,214 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// maxCBORDepth limits the nesting of the decoded items. Attestation objects
+// and COSE keys are at most a few levels deep.
+const maxCBORDepth = 16
+
+var errCBOR = errors.New("malformed cbor")
+
+// decodeCBOR decodes the first RFC 8949 item of the data and returns the rest.
+// Only the definite length encoding used by authenticators is supported.
+// Integers are decoded as int64, maps as map[interface{}]interface{}, tags
+// are dropped.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	d := cborDecoder{data: data}
+
+	value, err := d.decode(0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return value, d.data[d.pos:], nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errCBOR
+	}
+
+	value := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+
+	return value, nil
+}
+
+// head reads the major type and the argument of the item.
+func (d *cborDecoder) head() (byte, uint64, error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	major := b[0] >> 5
+	info := b[0] & 0x1f
+
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		v, err := d.next(1)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(v[0]), nil
+	case info == 25:
+		v, err := d.next(2)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint16(v)), nil
+	case info == 26:
+		v, err := d.next(4)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, uint64(binary.BigEndian.Uint32(v)), nil
+	case info == 27:
+		v, err := d.next(8)
+		if err != nil {
+			return 0, 0, err
+		}
+		return major, binary.BigEndian.Uint64(v), nil
+	}
+
+	return 0, 0, errCBOR
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errCBOR
+	}
+
+	start := d.pos
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, errCBOR
+		}
+		return -1 - int64(arg), nil
+	case 2:
+		v, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), v...), nil
+	case 3:
+		v, err := d.next(arg)
+		if err != nil {
+			return nil, err
+		}
+		return string(v), nil
+	case 4:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errCBOR
+			}
+
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			items[key] = value
+		}
+
+		return items, nil
+	case 6:
+		return d.decode(depth + 1)
+	}
+
+	return d.simple(start, arg)
+}
+
+// simple decodes the major type 7 item starting at the given position.
+func (d *cborDecoder) simple(start int, arg uint64) (interface{}, error) {
+	switch d.data[start] & 0x1f {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		return halfFloat(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+
+	return nil, errCBOR
+}
+
+func halfFloat(bits uint16) float64 {
+	exp := int(bits>>10) & 0x1f
+	mant := float64(bits & 0x3ff)
+
+	var value float64
+	switch exp {
+	case 0:
+		value = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			value = math.Inf(1)
+		} else {
+			value = math.NaN()
+		}
+	default:
+		value = math.Ldexp(mant+1024, exp-25)
+	}
+
+	if bits&0x8000 != 0 {
+		return -value
+	}
+	return value
+}
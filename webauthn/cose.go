@@ -0,0 +1,138 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms accepted for the passkeys.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// RFC 8152 key parameters.
+const (
+	coseKty = 1
+	coseAlg = 3
+
+	coseCrv = -1
+	coseX   = -2
+	coseY   = -3
+	coseN   = -1
+	coseE   = -2
+
+	ktyOKP = 1
+	ktyEC2 = 2
+	ktyRSA = 3
+
+	crvP256    = 1
+	crvEd25519 = 6
+)
+
+var errUnsupportedKey = errors.New("unsupported public key")
+
+// publicKey is a credential public key decoded from the COSE encoding.
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func parsePublicKey(data []byte) (publicKey, error) {
+	var empty publicKey
+
+	value, rest, err := decodeCBOR(data)
+	if err != nil {
+		return empty, err
+	}
+
+	if len(rest) != 0 {
+		return empty, errCBOR
+	}
+
+	return newPublicKey(value)
+}
+
+func newPublicKey(value interface{}) (publicKey, error) {
+	var empty publicKey
+
+	params, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return empty, errUnsupportedKey
+	}
+
+	kty, _ := params[int64(coseKty)].(int64)
+	alg, _ := params[int64(coseAlg)].(int64)
+
+	switch {
+	case kty == ktyEC2 && alg == AlgES256:
+		crv, _ := params[int64(coseCrv)].(int64)
+		x, _ := params[int64(coseX)].([]byte)
+		y, _ := params[int64(coseY)].([]byte)
+
+		if crv != crvP256 || len(x) != 32 || len(y) != 32 {
+			return empty, errUnsupportedKey
+		}
+
+		curve := elliptic.P256()
+		key := &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return empty, errUnsupportedKey
+		}
+
+		return publicKey{alg: alg, key: key}, nil
+	case kty == ktyOKP && alg == AlgEdDSA:
+		crv, _ := params[int64(coseCrv)].(int64)
+		x, _ := params[int64(coseX)].([]byte)
+
+		if crv != crvEd25519 || len(x) != ed25519.PublicKeySize {
+			return empty, errUnsupportedKey
+		}
+
+		return publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+	case kty == ktyRSA && alg == AlgRS256:
+		n, _ := params[int64(coseN)].([]byte)
+		e, _ := params[int64(coseE)].([]byte)
+
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return empty, errUnsupportedKey
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+
+		return publicKey{alg: alg, key: key}, nil
+	}
+
+	return empty, fmt.Errorf("%w: kty %d alg %d", errUnsupportedKey, kty, alg)
+}
+
+func (k publicKey) verify(data, sig []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], sig)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, sig)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	}
+
+	return false
+}
@@ -0,0 +1,380 @@
+// Package webauthn implements the relying party checks of the W3C Web
+// Authentication registration and authentication ceremonies. Attestation is
+// not requested, so the attestation statements are not verified.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	UVRequired  = "required"
+	UVPreferred = "preferred"
+
+	credentialType = "public-key"
+
+	flagUP = 0x01
+	flagUV = 0x04
+	flagAT = 0x40
+)
+
+var ErrInvalidResponse = errors.New("invalid webauthn response")
+
+// Bytes is binary data encoded as base64url in JSON.
+type Bytes []byte
+
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = raw
+	return nil
+}
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          Bytes  `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey,omitempty"`
+	UserVerification string `json:"userVerification,omitempty"`
+}
+
+// CreationOptions is the JSON form of PublicKeyCredentialCreationOptions
+// passed to navigator.credentials.create.
+type CreationOptions struct {
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	Challenge              Bytes                  `json:"challenge"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout,omitempty"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials,omitempty"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions is the JSON form of PublicKeyCredentialRequestOptions passed
+// to navigator.credentials.get.
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int64                  `json:"timeout,omitempty"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials,omitempty"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+type AttestationResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AttestationObject Bytes `json:"attestationObject"`
+}
+
+// RegistrationResponse is the JSON form of the credential returned by
+// navigator.credentials.create.
+type RegistrationResponse struct {
+	ID       string              `json:"id"`
+	RawID    Bytes               `json:"rawId"`
+	Type     string              `json:"type"`
+	Response AttestationResponse `json:"response"`
+}
+
+type AssertionResponse struct {
+	ClientDataJSON    Bytes `json:"clientDataJSON"`
+	AuthenticatorData Bytes `json:"authenticatorData"`
+	Signature         Bytes `json:"signature"`
+	UserHandle        Bytes `json:"userHandle,omitempty"`
+}
+
+// AuthenticationResponse is the JSON form of the credential returned by
+// navigator.credentials.get.
+type AuthenticationResponse struct {
+	ID       string            `json:"id"`
+	RawID    Bytes             `json:"rawId"`
+	Type     string            `json:"type"`
+	Response AssertionResponse `json:"response"`
+}
+
+// Registration is the verified new credential. The challenge has to be
+// checked by the caller.
+type Registration struct {
+	Challenge    string
+	CredentialID []byte
+	PublicKey    []byte
+	SignCount    uint32
+	UserVerified bool
+}
+
+// Assertion is the verified authentication. The challenge has to be checked
+// by the caller.
+type Assertion struct {
+	Challenge    string
+	SignCount    uint32
+	UserVerified bool
+}
+
+type RelyingParty interface {
+	CreationOptions(user UserEntity, challenge []byte, exclude [][]byte) CreationOptions
+	RequestOptions(challenge []byte, allow [][]byte, userVerification string) RequestOptions
+	VerifyRegistration(resp RegistrationResponse) (Registration, error)
+	VerifyAssertion(resp AuthenticationResponse, publicKey []byte) (Assertion, error)
+}
+
+type relyingParty struct {
+	id      string
+	name    string
+	origins []string
+	timeout time.Duration
+	idHash  [32]byte
+}
+
+// New returns the relying party identified by the domain id. Responses are
+// accepted only from the origins given.
+func New(id, name string, origins []string, timeout time.Duration) RelyingParty {
+	return &relyingParty{
+		id:      id,
+		name:    name,
+		origins: origins,
+		timeout: timeout,
+		idHash:  sha256.Sum256([]byte(id)),
+	}
+}
+
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	var items []CredentialDescriptor
+	for _, id := range ids {
+		items = append(items, CredentialDescriptor{Type: credentialType, ID: id})
+	}
+	return items
+}
+
+func (rp *relyingParty) CreationOptions(user UserEntity, challenge []byte, exclude [][]byte) CreationOptions {
+	return CreationOptions{
+		RP:        RelyingPartyEntity{ID: rp.id, Name: rp.name},
+		User:      user,
+		Challenge: challenge,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: credentialType, Alg: AlgES256},
+			{Type: credentialType, Alg: AlgEdDSA},
+			{Type: credentialType, Alg: AlgRS256},
+		},
+		Timeout:            rp.timeout.Milliseconds(),
+		ExcludeCredentials: descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:      "preferred",
+			UserVerification: UVPreferred,
+		},
+		Attestation: "none",
+	}
+}
+
+func (rp *relyingParty) RequestOptions(challenge []byte, allow [][]byte, userVerification string) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.timeout.Milliseconds(),
+		RPID:             rp.id,
+		AllowCredentials: descriptors(allow),
+		UserVerification: userVerification,
+	}
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp *relyingParty) parseClientData(data []byte, typ string) (clientData, error) {
+	var value clientData
+
+	if err := json.Unmarshal(data, &value); err != nil {
+		return value, fmt.Errorf("%w: client data: %v", ErrInvalidResponse, err)
+	}
+
+	if value.Type != typ {
+		return value, fmt.Errorf("%w: unexpected type %q", ErrInvalidResponse, value.Type)
+	}
+
+	if value.Challenge == "" {
+		return value, fmt.Errorf("%w: missing challenge", ErrInvalidResponse)
+	}
+
+	for _, origin := range rp.origins {
+		if value.Origin == origin {
+			return value, nil
+		}
+	}
+
+	return value, fmt.Errorf("%w: unexpected origin %q", ErrInvalidResponse, value.Origin)
+}
+
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData parses the authenticator data and checks it was
+// created for the relying party with the user present.
+func (rp *relyingParty) parseAuthenticatorData(data []byte) (authenticatorData, error) {
+	var value authenticatorData
+
+	if len(data) < 37 {
+		return value, fmt.Errorf("%w: authenticator data too short", ErrInvalidResponse)
+	}
+
+	if !bytes.Equal(data[:32], rp.idHash[:]) {
+		return value, fmt.Errorf("%w: unexpected relying party", ErrInvalidResponse)
+	}
+
+	value.flags = data[32]
+	value.signCount = binary.BigEndian.Uint32(data[33:37])
+
+	if value.flags&flagUP == 0 {
+		return value, fmt.Errorf("%w: user not present", ErrInvalidResponse)
+	}
+
+	if value.flags&flagAT == 0 {
+		return value, nil
+	}
+
+	rest := data[37:]
+	if len(rest) < 18 {
+		return value, fmt.Errorf("%w: attested credential data too short", ErrInvalidResponse)
+	}
+
+	size := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+
+	if size == 0 || len(rest) < size {
+		return value, fmt.Errorf("%w: invalid credential id", ErrInvalidResponse)
+	}
+
+	value.credentialID = rest[:size]
+	rest = rest[size:]
+
+	_, ext, err := decodeCBOR(rest)
+	if err != nil {
+		return value, fmt.Errorf("%w: credential public key: %v", ErrInvalidResponse, err)
+	}
+
+	value.publicKey = rest[:len(rest)-len(ext)]
+	return value, nil
+}
+
+func (rp *relyingParty) VerifyRegistration(resp RegistrationResponse) (Registration, error) {
+	var empty Registration
+
+	if resp.Type != credentialType {
+		return empty, fmt.Errorf("%w: unexpected credential type %q", ErrInvalidResponse, resp.Type)
+	}
+
+	client, err := rp.parseClientData(resp.Response.ClientDataJSON, "webauthn.create")
+	if err != nil {
+		return empty, err
+	}
+
+	value, _, err := decodeCBOR(resp.Response.AttestationObject)
+	if err != nil {
+		return empty, fmt.Errorf("%w: attestation object: %v", ErrInvalidResponse, err)
+	}
+
+	attestation, _ := value.(map[interface{}]interface{})
+	authData, _ := attestation["authData"].([]byte)
+
+	data, err := rp.parseAuthenticatorData(authData)
+	if err != nil {
+		return empty, err
+	}
+
+	if data.credentialID == nil {
+		return empty, fmt.Errorf("%w: missing attested credential data", ErrInvalidResponse)
+	}
+
+	if !bytes.Equal(data.credentialID, resp.RawID) {
+		return empty, fmt.Errorf("%w: credential id mismatch", ErrInvalidResponse)
+	}
+
+	if _, err := parsePublicKey(data.publicKey); err != nil {
+		return empty, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	return Registration{
+		Challenge:    client.Challenge,
+		CredentialID: data.credentialID,
+		PublicKey:    data.publicKey,
+		SignCount:    data.signCount,
+		UserVerified: data.flags&flagUV != 0,
+	}, nil
+}
+
+func (rp *relyingParty) VerifyAssertion(resp AuthenticationResponse, publicKey []byte) (Assertion, error) {
+	var empty Assertion
+
+	if resp.Type != credentialType {
+		return empty, fmt.Errorf("%w: unexpected credential type %q", ErrInvalidResponse, resp.Type)
+	}
+
+	client, err := rp.parseClientData(resp.Response.ClientDataJSON, "webauthn.get")
+	if err != nil {
+		return empty, err
+	}
+
+	data, err := rp.parseAuthenticatorData(resp.Response.AuthenticatorData)
+	if err != nil {
+		return empty, err
+	}
+
+	key, err := parsePublicKey(publicKey)
+	if err != nil {
+		return empty, fmt.Errorf("%w: %v", ErrInvalidResponse, err)
+	}
+
+	hash := sha256.Sum256(resp.Response.ClientDataJSON)
+	signed := append(append([]byte(nil), resp.Response.AuthenticatorData...), hash[:]...)
+
+	if !key.verify(signed, resp.Response.Signature) {
+		return empty, fmt.Errorf("%w: invalid signature", ErrInvalidResponse)
+	}
+
+	return Assertion{
+		Challenge:    client.Challenge,
+		SignCount:    data.signCount,
+		UserVerified: data.flags&flagUV != 0,
+	}, nil
+}
@@ -0,0 +1,375 @@
+package webauthn_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/vbogretsov/guard/webauthn"
+)
+
+const (
+	rpID   = "example.org"
+	origin = "https://example.org"
+)
+
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+// cborMap keeps the order of the encoded keys.
+type cborMap []cborPair
+
+func cborHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= 0xff:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= 0xffff:
+		buf.WriteByte(major<<5 | 25)
+		binary.Write(buf, binary.BigEndian, uint16(n))
+	default:
+		buf.WriteByte(major<<5 | 26)
+		binary.Write(buf, binary.BigEndian, uint32(n))
+	}
+}
+
+func encodeCBOR(buf *bytes.Buffer, value interface{}) {
+	switch v := value.(type) {
+	case int:
+		if v < 0 {
+			cborHead(buf, 1, uint64(-1-v))
+		} else {
+			cborHead(buf, 0, uint64(v))
+		}
+	case []byte:
+		cborHead(buf, 2, uint64(len(v)))
+		buf.Write(v)
+	case string:
+		cborHead(buf, 3, uint64(len(v)))
+		buf.WriteString(v)
+	case cborMap:
+		cborHead(buf, 5, uint64(len(v)))
+		for _, p := range v {
+			encodeCBOR(buf, p.key)
+			encodeCBOR(buf, p.value)
+		}
+	default:
+		panic("unsupported cbor value")
+	}
+}
+
+func cbor(value interface{}) []byte {
+	var buf bytes.Buffer
+	encodeCBOR(&buf, value)
+	return buf.Bytes()
+}
+
+type authenticator struct {
+	id    []byte
+	key   crypto.Signer
+	cose  []byte
+	count uint32
+}
+
+func newAuthenticator(t *testing.T, alg int) *authenticator {
+	a := &authenticator{id: []byte("credential." + big.NewInt(int64(-alg)).String())}
+
+	switch alg {
+	case webauthn.AlgES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+
+		x := make([]byte, 32)
+		y := make([]byte, 32)
+		key.X.FillBytes(x)
+		key.Y.FillBytes(y)
+
+		a.key = key
+		a.cose = cbor(cborMap{{1, 2}, {3, alg}, {-1, 1}, {-2, x}, {-3, y}})
+	case webauthn.AlgEdDSA:
+		pub, key, err := ed25519.GenerateKey(rand.Reader)
+		require.NoError(t, err)
+
+		a.key = key
+		a.cose = cbor(cborMap{{1, 1}, {3, alg}, {-1, 6}, {-2, []byte(pub)}})
+	case webauthn.AlgRS256:
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.NoError(t, err)
+
+		a.key = key
+		a.cose = cbor(cborMap{{1, 3}, {3, alg}, {-1, key.N.Bytes()}, {-2, big.NewInt(int64(key.E)).Bytes()}})
+	}
+
+	return a
+}
+
+func (a *authenticator) authData(rp string, flags byte, attested bool) []byte {
+	hash := sha256.Sum256([]byte(rp))
+
+	var buf bytes.Buffer
+	buf.Write(hash[:])
+
+	if attested {
+		flags |= 0x40
+	}
+	buf.WriteByte(flags)
+	binary.Write(&buf, binary.BigEndian, a.count)
+
+	if attested {
+		buf.Write(make([]byte, 16))
+		binary.Write(&buf, binary.BigEndian, uint16(len(a.id)))
+		buf.Write(a.id)
+		buf.Write(a.cose)
+	}
+
+	return buf.Bytes()
+}
+
+func clientDataJSON(typ, challenge, origin string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":      typ,
+		"challenge": challenge,
+		"origin":    origin,
+	})
+	return data
+}
+
+func (a *authenticator) register(challenge string) webauthn.RegistrationResponse {
+	return webauthn.RegistrationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.id),
+		RawID: a.id,
+		Type:  "public-key",
+		Response: webauthn.AttestationResponse{
+			ClientDataJSON: clientDataJSON("webauthn.create", challenge, origin),
+			AttestationObject: cbor(cborMap{
+				{"fmt", "none"},
+				{"attStmt", cborMap{}},
+				{"authData", a.authData(rpID, 0x05, true)},
+			}),
+		},
+	}
+}
+
+func (a *authenticator) sign(t *testing.T, data []byte) []byte {
+	var opts crypto.SignerOpts = crypto.Hash(0)
+
+	if _, ok := a.key.(ed25519.PrivateKey); !ok {
+		digest := sha256.Sum256(data)
+		data = digest[:]
+		opts = crypto.SHA256
+	}
+
+	sig, err := a.key.Sign(rand.Reader, data, opts)
+	require.NoError(t, err)
+
+	return sig
+}
+
+func (a *authenticator) assert(t *testing.T, challenge string, flags byte) webauthn.AuthenticationResponse {
+	a.count++
+
+	authData := a.authData(rpID, flags, false)
+	clientData := clientDataJSON("webauthn.get", challenge, origin)
+	hash := sha256.Sum256(clientData)
+
+	return webauthn.AuthenticationResponse{
+		ID:    base64.RawURLEncoding.EncodeToString(a.id),
+		RawID: a.id,
+		Type:  "public-key",
+		Response: webauthn.AssertionResponse{
+			ClientDataJSON:    clientData,
+			AuthenticatorData: authData,
+			Signature:         a.sign(t, append(append([]byte(nil), authData...), hash[:]...)),
+			UserHandle:        []byte("user.123"),
+		},
+	}
+}
+
+func TestBytes(t *testing.T) {
+	data, err := json.Marshal(webauthn.Bytes{0xfb, 0xff})
+	require.NoError(t, err)
+	require.Equal(t, `"-_8"`, string(data))
+
+	var value webauthn.Bytes
+	require.NoError(t, json.Unmarshal([]byte(`"-_8="`), &value))
+	require.Equal(t, webauthn.Bytes{0xfb, 0xff}, value)
+
+	require.Error(t, json.Unmarshal([]byte(`"+/8"`), &value))
+}
+
+func TestOptions(t *testing.T) {
+	rp := webauthn.New(rpID, "Example", []string{origin}, time.Minute)
+
+	user := webauthn.UserEntity{ID: []byte("user.123"), Name: "u0@mail.org", DisplayName: "u0@mail.org"}
+
+	creation := rp.CreationOptions(user, []byte("challenge"), [][]byte{[]byte("credential.1")})
+	require.Equal(t, webauthn.RelyingPartyEntity{ID: rpID, Name: "Example"}, creation.RP)
+	require.Equal(t, user, creation.User)
+	require.Equal(t, int64(60000), creation.Timeout)
+	require.Equal(t, "none", creation.Attestation)
+	require.Equal(t, []webauthn.CredentialDescriptor{{Type: "public-key", ID: []byte("credential.1")}}, creation.ExcludeCredentials)
+	require.Equal(t, webauthn.AlgES256, creation.PubKeyCredParams[0].Alg)
+
+	request := rp.RequestOptions([]byte("challenge"), nil, webauthn.UVRequired)
+	require.Equal(t, rpID, request.RPID)
+	require.Equal(t, webauthn.UVRequired, request.UserVerification)
+	require.Empty(t, request.AllowCredentials)
+
+	data, err := json.Marshal(request)
+	require.NoError(t, err)
+	require.NotContains(t, string(data), "allowCredentials")
+}
+
+func TestCeremonies(t *testing.T) {
+	rp := webauthn.New(rpID, "Example", []string{"https://other.org", origin}, time.Minute)
+
+	for name, alg := range map[string]int{"ES256": webauthn.AlgES256, "EdDSA": webauthn.AlgEdDSA, "RS256": webauthn.AlgRS256} {
+		t.Run(name, func(t *testing.T) {
+			a := newAuthenticator(t, alg)
+
+			reg, err := rp.VerifyRegistration(a.register("challenge.1"))
+			require.NoError(t, err)
+			require.Equal(t, "challenge.1", reg.Challenge)
+			require.Equal(t, a.id, reg.CredentialID)
+			require.Equal(t, a.cose, reg.PublicKey)
+			require.True(t, reg.UserVerified)
+
+			assertion, err := rp.VerifyAssertion(a.assert(t, "challenge.2", 0x01), reg.PublicKey)
+			require.NoError(t, err)
+			require.Equal(t, "challenge.2", assertion.Challenge)
+			require.Equal(t, uint32(1), assertion.SignCount)
+			require.False(t, assertion.UserVerified)
+
+			assertion, err = rp.VerifyAssertion(a.assert(t, "challenge.3", 0x05), reg.PublicKey)
+			require.NoError(t, err)
+			require.Equal(t, uint32(2), assertion.SignCount)
+			require.True(t, assertion.UserVerified)
+		})
+	}
+}
+
+func TestVerifyRegistration(t *testing.T) {
+	rp := webauthn.New(rpID, "Example", []string{origin}, time.Minute)
+	a := newAuthenticator(t, webauthn.AlgES256)
+
+	tests := map[string]func(resp *webauthn.RegistrationResponse){
+		"Type": func(resp *webauthn.RegistrationResponse) {
+			resp.Type = "password"
+		},
+		"ClientDataType": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.ClientDataJSON = clientDataJSON("webauthn.get", "challenge", origin)
+		},
+		"Origin": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.ClientDataJSON = clientDataJSON("webauthn.create", "challenge", "https://evil.org")
+		},
+		"MissingChallenge": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.ClientDataJSON = clientDataJSON("webauthn.create", "", origin)
+		},
+		"ClientDataJSON": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.ClientDataJSON = []byte("xxx")
+		},
+		"AttestationObject": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.AttestationObject = []byte{0xbf, 0x00}
+		},
+		"TruncatedAttestationObject": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.AttestationObject = resp.Response.AttestationObject[:40]
+		},
+		"RelyingParty": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.AttestationObject = cbor(cborMap{{"fmt", "none"}, {"authData", a.authData("evil.org", 0x05, true)}})
+		},
+		"UserNotPresent": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.AttestationObject = cbor(cborMap{{"fmt", "none"}, {"authData", a.authData(rpID, 0x04, true)}})
+		},
+		"NoCredential": func(resp *webauthn.RegistrationResponse) {
+			resp.Response.AttestationObject = cbor(cborMap{{"fmt", "none"}, {"authData", a.authData(rpID, 0x05, false)}})
+		},
+		"CredentialID": func(resp *webauthn.RegistrationResponse) {
+			resp.RawID = []byte("xxx")
+		},
+		"UnsupportedKey": func(resp *webauthn.RegistrationResponse) {
+			b := *a
+			b.cose = cbor(cborMap{{1, 2}, {3, -36}})
+			resp.Response.AttestationObject = cbor(cborMap{{"fmt", "none"}, {"authData", b.authData(rpID, 0x05, true)}})
+		},
+		"PointNotOnCurve": func(resp *webauthn.RegistrationResponse) {
+			b := *a
+			b.cose = cbor(cborMap{{1, 2}, {3, -7}, {-1, 1}, {-2, make([]byte, 32)}, {-3, make([]byte, 32)}})
+			resp.Response.AttestationObject = cbor(cborMap{{"fmt", "none"}, {"authData", b.authData(rpID, 0x05, true)}})
+		},
+	}
+
+	for name, update := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp := a.register("challenge")
+			update(&resp)
+
+			_, err := rp.VerifyRegistration(resp)
+			require.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+		})
+	}
+}
+
+func TestVerifyAssertion(t *testing.T) {
+	rp := webauthn.New(rpID, "Example", []string{origin}, time.Minute)
+	a := newAuthenticator(t, webauthn.AlgES256)
+	other := newAuthenticator(t, webauthn.AlgES256)
+
+	tests := map[string]func(resp *webauthn.AuthenticationResponse){
+		"Type": func(resp *webauthn.AuthenticationResponse) {
+			resp.Type = "password"
+		},
+		"ClientDataType": func(resp *webauthn.AuthenticationResponse) {
+			resp.Response.ClientDataJSON = clientDataJSON("webauthn.create", "challenge", origin)
+		},
+		"Origin": func(resp *webauthn.AuthenticationResponse) {
+			resp.Response.ClientDataJSON = clientDataJSON("webauthn.get", "challenge", "https://evil.org")
+		},
+		"ChallengeChanged": func(resp *webauthn.AuthenticationResponse) {
+			resp.Response.ClientDataJSON = clientDataJSON("webauthn.get", "challenge.2", origin)
+		},
+		"RelyingParty": func(resp *webauthn.AuthenticationResponse) {
+			resp.Response.AuthenticatorData = a.authData("evil.org", 0x05, false)
+		},
+		"UserNotPresent": func(resp *webauthn.AuthenticationResponse) {
+			resp.Response.AuthenticatorData = a.authData(rpID, 0x04, false)
+		},
+		"AuthenticatorData": func(resp *webauthn.AuthenticationResponse) {
+			resp.Response.AuthenticatorData = resp.Response.AuthenticatorData[:36]
+		},
+		"Signature": func(resp *webauthn.AuthenticationResponse) {
+			resp.Response.Signature = other.assert(t, "challenge", 0x05).Response.Signature
+		},
+	}
+
+	for name, update := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp := a.assert(t, "challenge", 0x05)
+			update(&resp)
+
+			_, err := rp.VerifyAssertion(resp, a.cose)
+			require.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+		})
+	}
+
+	t.Run("InvalidPublicKey", func(t *testing.T) {
+		_, err := rp.VerifyAssertion(a.assert(t, "challenge", 0x05), []byte{0xa1})
+		require.ErrorIs(t, err, webauthn.ErrInvalidResponse)
+	})
+}